@@ -6,8 +6,10 @@ import (
 	"AI_Chat/internal/middleware"
 	"AI_Chat/internal/model"
 	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
 	"AI_Chat/pkg/db"
 	"AI_Chat/pkg/utils"
+	"context"
 	"os"
 
 	"github.com/gin-gonic/gin"
@@ -21,7 +23,9 @@ type app struct {
 	chatHandler        *handler.ChatHandler
 	personaHandler     *handler.PersonaHandler
 	memoryHandler      *handler.MemoryHandler
+	adminHandler       *handler.AdminHandler
 	privateInterceptor []gin.HandlerFunc
+	adminToken         string // 为空时不注册管理接口
 }
 
 var App app
@@ -48,25 +52,64 @@ func InitRouter() *gin.Engine {
 			{
 				chatGroup.POST("/create-conversation", App.chatHandler.CreateConversation)
 				chatGroup.POST("/chat-with-persona", App.chatHandler.ChatWithPersona)
+				chatGroup.POST("/chat-with-persona/stream", App.chatHandler.ChatWithPersonaStream)
 				chatGroup.GET("/conversations", App.chatHandler.GetConversations)
 				chatGroup.POST("/conversation-messages", App.chatHandler.GetConversationMessages)
+				chatGroup.GET("/conversation/:conversationId/summary", App.chatHandler.GetConversationSummary)
+				chatGroup.PUT("/conversation/:conversationId/summary", App.chatHandler.UpdateConversationSummary)
 			}
 			personaGroup := private.Group("/persona")
 			{
 				personaGroup.POST("/create", App.personaHandler.CreatePersona)
 				personaGroup.GET("/list", App.personaHandler.GetPersonas)
+				personaGroup.POST("/import", App.personaHandler.ImportPersona)
+				personaGroup.POST("/simulate", App.personaHandler.SimulatePersona)
+				personaGroup.POST("/simulate/speakers", App.personaHandler.GetChatLogSpeakers)
+				personaGroup.GET("/deletions/:jobId", App.personaHandler.GetPersonaDeletion)
+				personaGroup.PUT("/:personaId", App.personaHandler.UpdatePersona)
+				personaGroup.DELETE("/:personaId", App.personaHandler.DeletePersona)
+				personaGroup.PUT("/:personaId/settings", App.personaHandler.UpdatePersonaSettings)
+				personaGroup.GET("/:personaId/export", App.personaHandler.ExportPersona)
+				personaGroup.GET("/:personaId/avatar", App.personaHandler.GetPersonaAvatar)
+				personaGroup.GET("/:personaId/versions", App.personaHandler.GetPersonaVersions)
+				personaGroup.GET("/:personaId/versions/diff", App.personaHandler.DiffPersonaVersions)
+				personaGroup.POST("/:personaId/versions/:versionId/rollback", App.personaHandler.RollbackPersonaVersion)
 				
 				// 记忆管理路由
 				memoryGroup := personaGroup.Group("/:personaId/memory")
 				{
 					memoryGroup.POST("/create", App.memoryHandler.CreateMemory)
 					memoryGroup.GET("/list", App.memoryHandler.GetMemories)
+					memoryGroup.POST("/search", App.memoryHandler.SearchMemories)
+					memoryGroup.POST("/extract", App.memoryHandler.ExtractMemories)
+					memoryGroup.POST("/consolidate", App.memoryHandler.ConsolidateMemories)
+					memoryGroup.POST("/reflect", App.memoryHandler.ReflectMemories)
+					memoryGroup.GET("/jobs", App.memoryHandler.GetMemoryJobs)
+					memoryGroup.GET("/jobs/:jobId", App.memoryHandler.GetMemoryJob)
+					memoryGroup.POST("/jobs/:jobId/retry", App.memoryHandler.RetryMemoryJob)
+					memoryGroup.GET("/review", App.memoryHandler.GetReviewMemories)
+					memoryGroup.POST("/review/approve", App.memoryHandler.ApproveMemories)
+					memoryGroup.POST("/review/reject", App.memoryHandler.RejectMemories)
+					memoryGroup.PUT("/review/:memoryId", App.memoryHandler.EditAndApproveMemory)
+					memoryGroup.GET("/:memoryId/history", App.memoryHandler.GetMemoryHistory)
+					memoryGroup.GET("/:memoryId/sources", App.memoryHandler.GetMemorySources)
+					memoryGroup.GET("/:memoryId/evidence", App.memoryHandler.GetReflectionEvidence)
+					memoryGroup.GET("/:memoryId/diff", App.memoryHandler.DiffMemoryVersions)
+					memoryGroup.POST("/:memoryId/rollback", App.memoryHandler.RollbackMemory)
 					memoryGroup.PUT("/:memoryId", App.memoryHandler.UpdateMemory)
 					memoryGroup.DELETE("/:memoryId", App.memoryHandler.DeleteMemory)
 				}
 			}
 		}
 
+		// 管理接口，需在 chat.yaml 中配置 admin.token 并通过 X-Admin-Token 请求头传入
+		if App.adminToken != "" {
+			admin := root.Group("/admin", middleware.AdminAuth(App.adminToken))
+			{
+				admin.POST("/memory/reindex", App.adminHandler.ReindexMemories)
+				admin.POST("/memory/consolidate", App.adminHandler.ConsolidateMemories)
+			}
+		}
 	}
 	return router
 }
@@ -80,75 +123,134 @@ func Init() {
 	// 确保程序退出时日志缓冲区已刷新
 	defer utils.Log.Sync()
 
+	// 2~4. 初始化配置、存储和雪花算法
+	if err := initStorage(); err != nil {
+		return
+	}
+	//初始化Handler
+
+	userBaseRepository := repository.NewUserBaseRepository(db.DB)
+	userSessionRepository := repository.NewUserSessionRepository(db.RedisClient)
+	conversationRepository := repository.NewConversationRepository(db.DB)
+	personaRepository := repository.NewPersonaRepository(db.DB)
+	memoryRepository := repository.NewMemoryRepository(db.DB)
+	memoryJobRepository := repository.NewMemoryJobRepository(db.DB)
+
+	memoryConfig := utils.Config_Instance.GetMemoryConfig()
+	memoryService, err := newMemoryService(memoryRepository, memoryJobRepository, personaRepository)
+	if err != nil {
+		return
+	}
+	memoryService.SetReflectionThreshold(memoryConfig.ReflectionThreshold)
+	memoryService.StartExtractionWorkers(context.Background(), memory.ExtractWorkerCount)
+	memoryService.StartIdleExtractionScheduler(context.Background(), memoryConfig.IdleExtractAfter, memoryConfig.IdleScanInterval)
+	memoryService.StartConsolidationScheduler(context.Background(), memoryConfig.ConsolidateInterval, memoryConfig.ConsolidateThreshold)
+	summaryService := memory.NewSummaryService(conversationRepository)
+
+	authHandler := handler.NewAuthHandler(userBaseRepository, userSessionRepository)
+	testHandler := handler.NewTestHandler()
+	chatHandler := handler.NewChatHandler(conversationRepository, personaRepository, memoryService, summaryService)
+	personaHandler := handler.NewPersonaHandler(personaRepository, memoryService)
+	memoryHandler := handler.NewMemoryHandler(memoryRepository, personaRepository, conversationRepository, memoryService)
+	adminHandler := handler.NewAdminHandler(memoryService)
+	
+	App.authHandler = authHandler
+	App.testHandler = testHandler
+	App.chatHandler = chatHandler
+	App.personaHandler = personaHandler
+	App.memoryHandler = memoryHandler
+	App.adminHandler = adminHandler
+	//初始化Interceptor
+
+	privateInterceptor := []gin.HandlerFunc{
+		middleware.Auth(userSessionRepository, userBaseRepository),
+	}
+	App.privateInterceptor = privateInterceptor
+	App.adminToken = utils.Config_Instance.GetAdminConfig().Token
+
+}
+
+// initStorage 初始化配置、MySQL、Redis、Milvus 和雪花算法，失败时已记录日志
+func initStorage() error {
 	// 2. 初始化配置
 	if err := utils.InitConfig(); err != nil {
 		utils.Log.Error("初始化配置失败", zap.Error(err))
-		return
+		return err
 	}
 
 	// 3. 初始化数据库
 	_, err := db.InitDB(utils.Config_Instance.GetMysqlConfig())
 	if err != nil {
 		utils.Log.Error("初始化Mysql连接失败", zap.Error(err))
-		return
+		return err
 	}
 	// 数据库迁移
-	db.DB.AutoMigrate(&model.Memory{})
+	db.DB.AutoMigrate(&model.Memory{}, &model.Persona{}, &model.Message{}, &model.ConversationSummary{}, &model.MemoryJob{}, &model.MemorySourceMessage{}, &model.MemoryEvidence{}, &model.PersonaVersion{}, &model.PersonaAvatar{})
 	// 假设 InitDB 返回了 *gorm.DB 或 *sql.DB，这里可以处理关闭逻辑
 	// sqlDB, _ := database.DB()
 	// defer sqlDB.Close()
 	err = db.InitRedis(utils.Config_Instance.GetRedisConfig())
 	if err != nil {
 		utils.Log.Error("初始化Redis连接失败", zap.Error(err))
-		return
+		return err
 	}
-	err = db.InitMilvus(utils.Config_Instance.GetMilvusConfig())
-	if err != nil {
-		utils.Log.Error("初始化Milvus连接失败", zap.Error(err))
-		return
+	if utils.Config_Instance.GetMemoryConfig().VectorStore == memory.VectorStoreMilvus {
+		err = db.InitMilvus(utils.Config_Instance.GetMilvusConfig())
+		if err != nil {
+			utils.Log.Error("初始化Milvus连接失败", zap.Error(err))
+			return err
+		}
 	}
 	// 4. 初始化雪花算法
 	if err := utils.InitSnowflake(1); err != nil {
 		utils.Log.Error("初始化雪花算法失败", zap.Error(err))
-		return
+		return err
 	}
-	//初始化Handler
+	return nil
+}
 
-	userBaseRepository := repository.NewUserBaseRepository(db.DB)
-	userSessionRepository := repository.NewUserSessionRepository(db.RedisClient)
-	conversationRepository := repository.NewConversationRepository(db.DB)
-	personaRepository := repository.NewPersonaRepository(db.DB)
-	memoryRepository := repository.NewMemoryRepository(db.DB)
+// newMemoryService 按配置创建向量化、向量存储和重排序，检查维度后创建记忆服务（不启动后台任务）
+func newMemoryService(memoryRepository *repository.MemoryRepository, memoryJobRepository *repository.MemoryJobRepository, personaRepository *repository.PersonaRepository) (*memory.MemoryService, error) {
+	embedder, err := memory.NewEmbedder(ai_config.EmbeddingConfig)
+	if err != nil {
+		utils.Log.Error("初始化向量化失败", zap.Error(err))
+		return nil, err
+	}
+	vectorStore, err := newVectorStore(utils.Config_Instance.GetMemoryConfig(), ai_config.EmbeddingConfig.GetDimension())
+	if err != nil {
+		utils.Log.Error("初始化向量存储失败", zap.Error(err))
+		return nil, err
+	}
+	if err := memory.CheckEmbeddingDimension(context.Background(), embedder, vectorStore); err != nil {
+		utils.Log.Error("向量维度检查失败", zap.Error(err))
+		return nil, err
+	}
+	reranker := memory.NewReranker(ai_config.RetrievalConfig.Rerank)
+	return memory.NewMemoryService(memoryRepository, memoryJobRepository, personaRepository, db.RedisClient, embedder, vectorStore, reranker), nil
+}
+func Run() {
+	Init()
+	App.router = InitRouter()
+	App.router.Run(":8001")
+}
 
+// newVectorStore 按配置创建向量存储，Milvus 未配置地址时返回 nil（检索回退到数据库）
+// dimension 为向量化配置的维度：local 后端按它建库，Milvus 未配置 dimension 时也使用它
+func newVectorStore(memoryConfig utils.MemoryConfig, dimension int) (memory.VectorStore, error) {
+	if memoryConfig.VectorStore == memory.VectorStoreLocal {
+		return memory.NewLocalVectorStore(memoryConfig.VectorStorePath, dimension)
+	}
 	milvusConfig := utils.Config_Instance.GetMilvusConfig()
+	if milvusConfig.Dimension == 0 {
+		milvusConfig.Dimension = dimension
+	}
 	milvusStore := memory.NewMilvusStore(db.MilvusClient, memory.MilvusStoreConfig{
 		Collection: milvusConfig.Collection,
 		Dimension:  milvusConfig.Dimension,
 		MetricType: milvusConfig.MetricType,
 	})
-	memoryService := memory.NewMemoryService(memoryRepository, db.RedisClient, milvusStore)
-
-	authHandler := handler.NewAuthHandler(userBaseRepository, userSessionRepository)
-	testHandler := handler.NewTestHandler()
-	chatHandler := handler.NewChatHandler(conversationRepository, personaRepository, memoryService)
-	personaHandler := handler.NewPersonaHandler(personaRepository)
-	memoryHandler := handler.NewMemoryHandler(memoryRepository, personaRepository, memoryService)
-	
-	App.authHandler = authHandler
-	App.testHandler = testHandler
-	App.chatHandler = chatHandler
-	App.personaHandler = personaHandler
-	App.memoryHandler = memoryHandler
-	//初始化Interceptor
-
-	privateInterceptor := []gin.HandlerFunc{
-		middleware.Auth(userSessionRepository, userBaseRepository),
+	if milvusStore == nil {
+		return nil, nil
 	}
-	App.privateInterceptor = privateInterceptor
-
-}
-func Run() {
-	Init()
-	App.router = InitRouter()
-	App.router.Run(":8001")
+	return milvusStore, nil
 }
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"AI_Chat/internal/memory"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/db"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// RunConsolidate 命令行整理记忆：go run . consolidate [--persona id] [--user id] [--threshold 0.85] [--dry-run]
+// 报告以 JSON 输出到标准输出，整理失败或部分合并出错时返回非 0 退出码
+func RunConsolidate(args []string) int {
+	fs := flag.NewFlagSet("consolidate", flag.ContinueOnError)
+	personaId := fs.String("persona", "", "只整理该人格的记忆")
+	userId := fs.Int64("user", 0, "只整理该用户的记忆")
+	threshold := fs.Float64("threshold", 0, "相似度阈值，默认使用 memory.consolidate_threshold")
+	dryRun := fs.Bool("dry-run", false, "只输出合并方案，不修改记忆")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := utils.InitLogger(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to initialize logger:", err.Error())
+		return 1
+	}
+	defer utils.Log.Sync()
+	if err := initStorage(); err != nil {
+		return 1
+	}
+	memoryService, err := newMemoryService(repository.NewMemoryRepository(db.DB), repository.NewMemoryJobRepository(db.DB), repository.NewPersonaRepository(db.DB))
+	if err != nil {
+		return 1
+	}
+	if *threshold <= 0 {
+		*threshold = utils.Config_Instance.GetMemoryConfig().ConsolidateThreshold
+	}
+
+	report, err := memoryService.Consolidate(context.Background(), memory.ConsolidateOptions{
+		PersonaID: *personaId,
+		UserID:    *userId,
+		DryRun:    *dryRun,
+		Threshold: *threshold,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "consolidate failed:", err.Error())
+		return 1
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
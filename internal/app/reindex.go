@@ -0,0 +1,54 @@
+package app
+
+import (
+	"AI_Chat/internal/memory"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/db"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// RunReindex 命令行核对数据库与向量存储：go run . reindex [--persona id] [--user id] [--dry-run]
+// 报告以 JSON 输出到标准输出，核对失败或部分修复出错时返回非 0 退出码
+func RunReindex(args []string) int {
+	fs := flag.NewFlagSet("reindex", flag.ContinueOnError)
+	personaId := fs.String("persona", "", "只核对该人格的记忆")
+	userId := fs.Int64("user", 0, "只核对该用户的记忆")
+	dryRun := fs.Bool("dry-run", false, "只报告差异，不修改向量存储")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := utils.InitLogger(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to initialize logger:", err.Error())
+		return 1
+	}
+	defer utils.Log.Sync()
+	if err := initStorage(); err != nil {
+		return 1
+	}
+	memoryService, err := newMemoryService(repository.NewMemoryRepository(db.DB), repository.NewMemoryJobRepository(db.DB), repository.NewPersonaRepository(db.DB))
+	if err != nil {
+		return 1
+	}
+
+	report, err := memoryService.Reindex(context.Background(), memory.ReindexOptions{
+		PersonaID: *personaId,
+		UserID:    *userId,
+		DryRun:    *dryRun,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reindex failed:", err.Error())
+		return 1
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,305 @@
+package character_card
+
+import (
+	"AI_Chat/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	SpecV2        = "chara_card_v2"
+	SpecVersionV2 = "2.0"
+)
+
+// ErrInvalidCard 角色卡格式错误或缺少角色名
+var ErrInvalidCard = errors.New("invalid character card")
+
+// 角色卡 data 中映射到人格字段的键，键名见 Character Card V2 规范；alternate_greetings 单独处理
+var cardFields = []string{"name", "description", "personality", "scenario", "first_mes", "mes_example", "system_prompt"}
+
+// v2Defaults V2 规范要求但人格没有对应字段的键，导出时缺少则补上默认值
+var v2Defaults = map[string]json.RawMessage{
+	"creator_notes":             json.RawMessage(`""`),
+	"post_history_instructions": json.RawMessage(`""`),
+	"alternate_greetings":       json.RawMessage(`[]`),
+	"tags":                      json.RawMessage(`[]`),
+	"creator":                   json.RawMessage(`""`),
+	"character_version":         json.RawMessage(`""`),
+	"extensions":                json.RawMessage(`{}`),
+}
+
+// cardExtras 角色卡中人格没有对应字段的内容，JSON 序列化后保存在 Persona.CardExtensions。
+// 值保留原始 JSON，导出时原样写回，保证导入再导出不丢信息
+type cardExtras struct {
+	Spec        string                     `json:"spec"`
+	SpecVersion string                     `json:"spec_version,omitempty"`
+	Data        map[string]json.RawMessage `json:"data,omitempty"` // data 下的其他字段，如 creator_notes、alternate_greetings、character_book、extensions
+	Root        map[string]json.RawMessage `json:"root,omitempty"` // 顶层除 spec、spec_version、data 以外的字段，通常是兼容 V1 的重复字段
+}
+
+// ParseJSON 解析角色卡 JSON，返回对应的人格（未保存）。支持 V2 和没有 spec 的 V1 角色卡
+func ParseJSON(raw []byte) (*model.Persona, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &top); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCard, err)
+	}
+	extras := cardExtras{}
+	data := top
+	if rawData, ok := top["data"]; ok {
+		data = nil
+		if err := json.Unmarshal(rawData, &data); err != nil || data == nil {
+			return nil, fmt.Errorf("%w: data is not an object", ErrInvalidCard)
+		}
+		_ = json.Unmarshal(top["spec"], &extras.Spec)
+		_ = json.Unmarshal(top["spec_version"], &extras.SpecVersion)
+		for key, value := range top {
+			if key == "spec" || key == "spec_version" || key == "data" {
+				continue
+			}
+			if extras.Root == nil {
+				extras.Root = make(map[string]json.RawMessage)
+			}
+			extras.Root[key] = value
+		}
+	}
+
+	values := make(map[string]string, len(cardFields))
+	for _, key := range cardFields {
+		value, ok := data[key]
+		if !ok || string(value) == "null" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(value, &text); err != nil {
+			return nil, fmt.Errorf("%w: %s is not a string", ErrInvalidCard, key)
+		}
+		values[key] = text
+	}
+	// alternate_greetings 是字符串数组时与 first_mes 一起作为人格的开场白，否则原样保留
+	var alternates []string
+	if value, ok := data["alternate_greetings"]; ok && json.Unmarshal(value, &alternates) != nil {
+		alternates = nil
+	}
+	for key, value := range data {
+		if _, mapped := values[key]; mapped || (key == "alternate_greetings" && alternates != nil) {
+			continue
+		}
+		if extras.Data == nil {
+			extras.Data = make(map[string]json.RawMessage)
+		}
+		extras.Data[key] = value
+	}
+	if strings.TrimSpace(values["name"]) == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidCard)
+	}
+
+	extensions, err := json.Marshal(extras)
+	if err != nil {
+		return nil, err
+	}
+	var greetings []string
+	if values["first_mes"] != "" || len(alternates) > 0 {
+		greetings = append([]string{values["first_mes"]}, alternates...)
+	}
+	return &model.Persona{
+		Name:            values["name"],
+		Description:     values["description"],
+		Personality:     values["personality"],
+		Scenario:        values["scenario"],
+		Greetings:       greetings,
+		MessageExamples: values["mes_example"],
+		SystemPrompt:    values["system_prompt"],
+		Mode:            1,
+		CardExtensions:  string(extensions),
+	}, nil
+}
+
+// MarshalJSON 把人格导出为 V2 角色卡 JSON，导入时保存的其他字段原样写回
+func MarshalJSON(persona *model.Persona) ([]byte, error) {
+	var extras cardExtras
+	if persona.CardExtensions != "" {
+		if err := json.Unmarshal([]byte(persona.CardExtensions), &extras); err != nil {
+			return nil, fmt.Errorf("解析角色卡扩展字段失败: %w", err)
+		}
+	}
+	values := map[string]string{
+		"name":          persona.Name,
+		"description":   persona.Description,
+		"personality":   persona.Personality,
+		"scenario":      persona.Scenario,
+		"first_mes":     "",
+		"mes_example":   persona.MessageExamples,
+		"system_prompt": persona.SystemPrompt,
+	}
+	alternates := []string{}
+	if len(persona.Greetings) > 0 {
+		values["first_mes"] = persona.Greetings[0]
+		alternates = persona.Greetings[1:]
+	}
+
+	data := make(map[string]json.RawMessage, len(extras.Data)+len(values)+len(v2Defaults))
+	for key, value := range v2Defaults {
+		data[key] = value
+	}
+	for key, value := range extras.Data {
+		data[key] = value
+	}
+	top := make(map[string]json.RawMessage, len(extras.Root)+3)
+	for key, value := range extras.Root {
+		top[key] = value
+	}
+	for key, text := range values {
+		value, err := json.Marshal(text)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = value
+		// 兼容 V1 的顶层重复字段同步为当前值，避免与 data 不一致
+		if _, ok := top[key]; ok {
+			top[key] = value
+		}
+	}
+	// 导入时无法识别的 alternate_greetings 保留在扩展字段中，原样写回
+	if _, kept := extras.Data["alternate_greetings"]; !kept || len(alternates) > 0 {
+		value, err := json.Marshal(alternates)
+		if err != nil {
+			return nil, err
+		}
+		data["alternate_greetings"] = value
+	}
+
+	// V1 角色卡导出时升级为 V2，其他版本保留原来的 spec
+	spec, specVersion := extras.Spec, extras.SpecVersion
+	if spec == "" {
+		spec, specVersion = SpecV2, SpecVersionV2
+	}
+	var err error
+	if top["spec"], err = json.Marshal(spec); err != nil {
+		return nil, err
+	}
+	if top["spec_version"], err = json.Marshal(specVersion); err != nil {
+		return nil, err
+	}
+	if top["data"], err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(top)
+}
+
+// ComposePrompt 组合人格的系统提示词：SystemPrompt 之后附上角色卡的角色描述、性格和场景，并替换 {{char}}、{{user}} 占位符。
+// 手动创建的人格 Description 只用于展示，不拼入提示词
+func ComposePrompt(persona *model.Persona) string {
+	var sections []string
+	if persona.SystemPrompt != "" {
+		sections = append(sections, persona.SystemPrompt)
+	}
+	if persona.CardExtensions != "" && persona.Description != "" {
+		sections = append(sections, "角色描述：\n"+persona.Description)
+	}
+	if persona.Personality != "" {
+		sections = append(sections, "性格：\n"+persona.Personality)
+	}
+	if persona.Scenario != "" {
+		sections = append(sections, "场景：\n"+persona.Scenario)
+	}
+	return ReplaceMacros(strings.Join(sections, "\n\n"), persona.Name)
+}
+
+// ReplaceMacros 替换角色卡文本中的 {{char}}、{{user}} 占位符
+func ReplaceMacros(text, charName string) string {
+	replacer := strings.NewReplacer(
+		"{{char}}", charName, "{{Char}}", charName, "<BOT>", charName,
+		"{{user}}", "用户", "{{User}}", "用户", "<USER>", "用户",
+	)
+	return replacer.Replace(text)
+}
+
+// Exchange 一段示例对话：用户说的话和角色的回复
+type Exchange struct {
+	User string `json:"user"`
+	Char string `json:"char"`
+}
+
+// FormatExamples 把示例对话写成角色卡 mes_example 的格式，每段以 <START> 开头
+func FormatExamples(exchanges []Exchange) string {
+	var b strings.Builder
+	for _, exchange := range exchanges {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("<START>\n{{user}}: " + exchange.User + "\n{{char}}: " + exchange.Char)
+	}
+	return b.String()
+}
+
+// 示例对话中标记用户和角色发言的行首，角色也可以直接写角色名
+var (
+	userPrefixes = []string{"{{user}}", "{{User}}", "<USER>"}
+	charPrefixes = []string{"{{char}}", "{{Char}}", "<BOT>"}
+)
+
+// ParseExamples 解析 mes_example 格式的示例对话：<START> 分隔多段，每句以 {{user}}: 或 {{char}}:（也可以是角色名）开头，
+// 不以发言人开头的行接在上一句之后。每段中相邻的用户发言和角色回复组成一组示例，缺少一方的跳过；返回的内容已替换占位符
+func ParseExamples(text, charName string) []Exchange {
+	var exchanges []Exchange
+	for _, block := range strings.Split(strings.ReplaceAll(text, "<start>", "<START>"), "<START>") {
+		type turn struct {
+			user    bool
+			content string
+		}
+		var turns []turn
+		for _, line := range strings.Split(strings.ReplaceAll(block, "\r\n", "\n"), "\n") {
+			isUser, content, ok := exampleSpeaker(line, charName)
+			if !ok {
+				if n := len(turns); n > 0 {
+					turns[n-1].content += "\n" + line
+				}
+				continue
+			}
+			if n := len(turns); n > 0 && turns[n-1].user == isUser {
+				turns[n-1].content += "\n" + content
+				continue
+			}
+			turns = append(turns, turn{user: isUser, content: content})
+		}
+		for i := 1; i < len(turns); i++ {
+			if !turns[i-1].user || turns[i].user {
+				continue
+			}
+			user := strings.TrimSpace(ReplaceMacros(turns[i-1].content, charName))
+			reply := strings.TrimSpace(ReplaceMacros(turns[i].content, charName))
+			if user != "" && reply != "" {
+				exchanges = append(exchanges, Exchange{User: user, Char: reply})
+			}
+		}
+	}
+	return exchanges
+}
+
+// exampleSpeaker 识别示例对话中一行的发言人，返回是否为用户和冒号之后的内容
+func exampleSpeaker(line, charName string) (isUser bool, content string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	match := func(prefixes []string) (string, bool) {
+		for _, prefix := range prefixes {
+			if prefix == "" || !strings.HasPrefix(trimmed, prefix) {
+				continue
+			}
+			rest := strings.TrimSpace(trimmed[len(prefix):])
+			for _, colon := range []string{":", "："} {
+				if strings.HasPrefix(rest, colon) {
+					return strings.TrimSpace(rest[len(colon):]), true
+				}
+			}
+		}
+		return "", false
+	}
+	if content, ok := match(userPrefixes); ok {
+		return true, content, true
+	}
+	if content, ok := match(append([]string{charName}, charPrefixes...)); ok {
+		return false, content, true
+	}
+	return false, "", false
+}
@@ -0,0 +1,166 @@
+package character_card
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"AI_Chat/internal/model"
+)
+
+const testCard = `{
+	"spec": "chara_card_v2",
+	"spec_version": "2.0",
+	"name": "小雪",
+	"description": "旧的描述",
+	"data": {
+		"name": "小雪",
+		"description": "{{char}}是一名高中地理老师",
+		"personality": "温柔，有点唠叨",
+		"scenario": "{{user}}是她的大学同学",
+		"first_mes": "好久不见！",
+		"mes_example": "<START>\n{{user}}: 最近忙吗\n{{char}}: 忙着布置考场呢",
+		"creator_notes": "",
+		"system_prompt": "",
+		"post_history_instructions": "保持简短",
+		"alternate_greetings": ["早啊", "在吗"],
+		"character_book": {"entries": [{"keys": ["地理"], "content": "她教高二"}]},
+		"tags": ["老师"],
+		"creator": "someone",
+		"character_version": "1.2",
+		"extensions": {"talkativeness": "0.5", "depth_prompt": {"depth": 4}}
+	}
+}`
+
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+func TestCardJSONRoundTrip(t *testing.T) {
+	persona, err := ParseJSON([]byte(testCard))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if persona.Name != "小雪" || !reflect.DeepEqual(persona.Greetings, []string{"好久不见！", "早啊", "在吗"}) || persona.Personality != "温柔，有点唠叨" || !strings.HasPrefix(persona.MessageExamples, "<START>") {
+		t.Fatalf("unexpected persona: %+v", persona)
+	}
+	exported, err := MarshalJSON(persona)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	// 顶层的 V1 兼容字段同步为 data 中的值，其余内容原样保留
+	expected := strings.Replace(testCard, `"description": "旧的描述"`, `"description": "{{char}}是一名高中地理老师"`, 1)
+	if !equalJSON(t, exported, []byte(expected)) {
+		t.Fatalf("round trip lost data:\n%s", exported)
+	}
+
+	// 再导入一次结果不变
+	again, err := ParseJSON(exported)
+	if err != nil {
+		t.Fatalf("reparse failed: %v", err)
+	}
+	if reexported, _ := MarshalJSON(again); !bytes.Equal(reexported, exported) {
+		t.Fatalf("second round trip changed the card:\n%s\n%s", exported, reexported)
+	}
+
+	prompt := ComposePrompt(persona)
+	if !strings.Contains(prompt, "小雪是一名高中地理老师") || !strings.Contains(prompt, "用户是她的大学同学") {
+		t.Fatalf("unexpected prompt:\n%s", prompt)
+	}
+}
+
+func TestParseExamples(t *testing.T) {
+	text := "<START>\n{{user}}: 最近忙吗\n{{char}}: 忙着布置考场呢\n还要监考\n{{user}}: 辛苦了\n小雪：没事，{{user}}呢？\n<START>\n{{char}}: 只有角色的一句\n"
+	got := ParseExamples(text, "小雪")
+	want := []Exchange{
+		{User: "最近忙吗", Char: "忙着布置考场呢\n还要监考"},
+		{User: "辛苦了", Char: "没事，用户呢？"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if again := ParseExamples(FormatExamples(want), "小雪"); !reflect.DeepEqual(again, want) {
+		t.Fatalf("format/parse round trip changed examples: %+v", again)
+	}
+}
+
+func TestComposePromptKeepsBraces(t *testing.T) {
+	persona := &model.Persona{
+		Name:           "小雪",
+		CardExtensions: "{}",
+		Description:    "{{char}}喜欢用 {name} 和 { 当作暗号",
+		Scenario:       "{{user}}会回复 {\"ok\": true}",
+	}
+	want := "角色描述：\n小雪喜欢用 {name} 和 { 当作暗号\n\n场景：\n用户会回复 {\"ok\": true}"
+	if got := ComposePrompt(persona); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestCardV1Upgrade(t *testing.T) {
+	persona, err := ParseJSON([]byte(`{"name": "阿明", "description": "程序员", "first_mes": "hi", "avatar": "none"}`))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	exported, err := MarshalJSON(persona)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	var card struct {
+		Spec string                 `json:"spec"`
+		Data map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(exported, &card)
+	if card.Spec != SpecV2 || card.Data["name"] != "阿明" || card.Data["avatar"] != "none" || card.Data["tags"] == nil {
+		t.Fatalf("unexpected upgraded card: %s", exported)
+	}
+
+	if _, err := ParseJSON([]byte(`{"data": {"description": "没有名字"}}`)); !errors.Is(err, ErrInvalidCard) {
+		t.Fatalf("card without name should be rejected, got %v", err)
+	}
+}
+
+func TestCardPNGRoundTrip(t *testing.T) {
+	avatar, err := PlaceholderAvatar()
+	if err != nil {
+		t.Fatalf("placeholder failed: %v", err)
+	}
+	card, err := EmbedPNG(avatar, []byte(testCard))
+	if err != nil {
+		t.Fatalf("embed failed: %v", err)
+	}
+	cardJSON, stripped, err := ExtractPNG(card)
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if string(cardJSON) != testCard || !bytes.Equal(stripped, avatar) {
+		t.Fatalf("png round trip changed the card or image")
+	}
+
+	// 重新导出时替换旧的角色卡数据块，而不是追加
+	updated, err := EmbedPNG(card, []byte(`{"name": "新"}`))
+	if err != nil {
+		t.Fatalf("re-embed failed: %v", err)
+	}
+	if cardJSON, _, _ := ExtractPNG(updated); string(cardJSON) != `{"name": "新"}` {
+		t.Fatalf("unexpected card after re-embed: %s", cardJSON)
+	}
+	if bytes.Count(updated, []byte("tEXtchara")) != 1 {
+		t.Fatalf("expected exactly one chara chunk")
+	}
+
+	if _, _, err := ExtractPNG(avatar); !errors.Is(err, ErrInvalidCard) {
+		t.Fatalf("png without card should be rejected, got %v", err)
+	}
+}
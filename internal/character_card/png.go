@@ -0,0 +1,140 @@
+package character_card
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// cardKeyword PNG 角色卡把 base64 编码的角色卡 JSON 存放在关键字为 chara 的 tEXt 块中
+const cardKeyword = "chara"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// IsPNG 判断数据是否为 PNG 图片
+func IsPNG(raw []byte) bool {
+	return bytes.HasPrefix(raw, pngSignature)
+}
+
+// pngChunk PNG 数据块，raw 为包括长度、类型、数据和 CRC 的完整字节
+type pngChunk struct {
+	typ  string
+	data []byte
+	raw  []byte
+}
+
+// readChunks 按顺序读取 PNG 的全部数据块
+func readChunks(raw []byte) ([]pngChunk, error) {
+	if !IsPNG(raw) {
+		return nil, fmt.Errorf("%w: not a png file", ErrInvalidCard)
+	}
+	var chunks []pngChunk
+	for pos := len(pngSignature); pos < len(raw); {
+		if pos+8 > len(raw) {
+			return nil, fmt.Errorf("%w: truncated png chunk", ErrInvalidCard)
+		}
+		length := int(binary.BigEndian.Uint32(raw[pos : pos+4]))
+		end := pos + 12 + length
+		if length < 0 || end > len(raw) {
+			return nil, fmt.Errorf("%w: truncated png chunk", ErrInvalidCard)
+		}
+		chunks = append(chunks, pngChunk{
+			typ:  string(raw[pos+4 : pos+8]),
+			data: raw[pos+8 : pos+8+length],
+			raw:  raw[pos:end],
+		})
+		pos = end
+	}
+	if len(chunks) == 0 || chunks[len(chunks)-1].typ != "IEND" {
+		return nil, fmt.Errorf("%w: missing IEND chunk", ErrInvalidCard)
+	}
+	return chunks, nil
+}
+
+// isCardChunk 是否为存放角色卡的 tEXt 块
+func isCardChunk(chunk pngChunk) bool {
+	return chunk.typ == "tEXt" && bytes.HasPrefix(chunk.data, []byte(cardKeyword+"\x00"))
+}
+
+// ExtractPNG 从 PNG 角色卡中取出角色卡 JSON，并返回去掉角色卡数据块后的头像图片
+func ExtractPNG(raw []byte) (cardJSON []byte, avatar []byte, err error) {
+	chunks, err := readChunks(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var encoded []byte
+	stripped := bytes.NewBuffer(make([]byte, 0, len(raw)))
+	stripped.Write(pngSignature)
+	for _, chunk := range chunks {
+		if isCardChunk(chunk) {
+			encoded = chunk.data[len(cardKeyword)+1:]
+			continue
+		}
+		stripped.Write(chunk.raw)
+	}
+	if encoded == nil {
+		return nil, nil, fmt.Errorf("%w: png has no chara chunk", ErrInvalidCard)
+	}
+	cardJSON, err = base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		// 部分工具写入时去掉了填充
+		if cardJSON, err = base64.RawStdEncoding.DecodeString(string(encoded)); err != nil {
+			return nil, nil, fmt.Errorf("%w: chara chunk is not base64", ErrInvalidCard)
+		}
+	}
+	return cardJSON, stripped.Bytes(), nil
+}
+
+// EmbedPNG 把角色卡 JSON 写入头像图片的 tEXt 块（放在 IEND 之前），图片中已有的角色卡数据块会被替换
+func EmbedPNG(avatar []byte, cardJSON []byte) ([]byte, error) {
+	chunks, err := readChunks(avatar)
+	if err != nil {
+		return nil, err
+	}
+	text := append([]byte(cardKeyword+"\x00"), base64.StdEncoding.EncodeToString(cardJSON)...)
+	out := bytes.NewBuffer(make([]byte, 0, len(avatar)+len(text)+12))
+	out.Write(pngSignature)
+	for _, chunk := range chunks {
+		if isCardChunk(chunk) {
+			continue
+		}
+		if chunk.typ == "IEND" {
+			writeChunk(out, "tEXt", text)
+		}
+		out.Write(chunk.raw)
+	}
+	return out.Bytes(), nil
+}
+
+// writeChunk 写入一个 PNG 数据块，CRC 覆盖类型和数据
+func writeChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], typ)
+	buf.Write(header[:])
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
+
+// PlaceholderAvatar 没有头像的人格导出 PNG 时使用的纯色图片，尺寸与常见角色卡一致
+func PlaceholderAvatar() ([]byte, error) {
+	img := image.NewGray(image.Rect(0, 0, 400, 600))
+	for i := range img.Pix {
+		img.Pix[i] = color.Gray{Y: 0xd0}.Y
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -4,23 +4,41 @@ import (
 	_ "AI_Chat/internal/chat_core/llm_tools"
 	"AI_Chat/internal/model"
 	"AI_Chat/pkg/ai_config"
+	"context"
 
-	"github.com/cloudwego/eino-ext/components/model/deepseek"
-	"github.com/cloudwego/eino/components/prompt"
+	einomodel "github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/tool"
 	_ "github.com/cloudwego/eino/components/tool"
 	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/flow/agent"
 	"github.com/cloudwego/eino/flow/agent/react"
 	"github.com/cloudwego/eino/schema"
 	"github.com/gin-gonic/gin"
 )
 
-func Chat(c *gin.Context, query string, history []model.Message, system_prompt string, tools ...tool.BaseTool) (string, error) {
-	cm, err := deepseek.NewChatModel(c, &deepseek.ChatModelConfig{
-		APIKey:  ai_config.DeepSeekChatConfig.APIKey,
-		Model:   ai_config.DeepSeekChatConfig.Model,
-		BaseURL: ai_config.DeepSeekChatConfig.BaseURL,
-	})
+func Chat(c *gin.Context, query string, history []model.Message, examples []model.Message, system_prompt string, summary string, settings model.PersonaModelSettings, tools ...tool.BaseTool) (string, TokenUsage, error) {
+	reactAgent, err := newReactAgent(c, settings, tools)
+	if err != nil {
+		return "Agent创建出错", TokenUsage{}, err
+	}
+	messages, promptTokens := buildContextMessages(c, query, history, examples, system_prompt, summary, settings, tools)
+	usage := &tokenUsageCollector{}
+	resp, err := reactAgent.Generate(c, messages,
+		react.WithChatModelOptions(modelOptions(settings)...),
+		agent.WithComposeOptions(compose.WithCallbacks(usage.handler())),
+	)
+	if err != nil {
+		return "生成出错，请检查配置文件或网络", TokenUsage{}, err
+	}
+	return resp.Content, usage.result(promptTokens, query, resp.Content), nil
+}
+
+// newReactAgent 按人格的生成参数创建带工具的 ReAct Agent
+func newReactAgent(ctx context.Context, settings model.PersonaModelSettings, tools []tool.BaseTool) (*react.Agent, error) {
+	cm, err := ai_config.NewChatModel(ctx, settings.ModelProvider)
+	if err != nil {
+		return nil, err
+	}
 	// mytool, err := llm_tools.NewGetSecretTool()
 	// tools := compose.ToolsNodeConfig{
 	// 	Tools: []tool.BaseTool{mytool},
@@ -29,19 +47,59 @@ func Chat(c *gin.Context, query string, history []model.Message, system_prompt s
 	if len(tools) > 0 {
 		toolsConfig.Tools = tools
 	}
-	reactAgent, err := react.NewAgent(c, &react.AgentConfig{
+	return react.NewAgent(ctx, &react.AgentConfig{
 		ToolCallingModel: cm,
-		MaxStep:          5,
+		MaxStep:          settings.GetMaxSteps(),
 		ToolsConfig:      toolsConfig,
 	})
-	if err != nil {
-		return "Agent创建出错", err
+}
+
+// modelOptions 把人格的生成参数转换为模型调用选项，未设置的参数沿用模型默认值
+func modelOptions(settings model.PersonaModelSettings) []einomodel.Option {
+	opts := make([]einomodel.Option, 0, 4)
+	if settings.ModelName != "" {
+		opts = append(opts, einomodel.WithModel(settings.ModelName))
+	}
+	if settings.Temperature != nil {
+		opts = append(opts, einomodel.WithTemperature(*settings.Temperature))
+	}
+	if settings.TopP != nil {
+		opts = append(opts, einomodel.WithTopP(*settings.TopP))
+	}
+	if settings.MaxTokens > 0 {
+		opts = append(opts, einomodel.WithMaxTokens(settings.MaxTokens))
 	}
+	return opts
+}
+
+// SummaryPromptPrefix 注入会话摘要时使用的前缀
+const SummaryPromptPrefix = "以下是你们更早之前对话的摘要，请在回复时参考：\n"
+
+// ExamplePrompt 注入示例对话前的说明，避免模型把示例当成真实发生过的对话
+const ExamplePrompt = "以下是示例对话，只用于参考你的说话方式，并非真实发生过的对话："
+
+// buildChatMessages 拼接系统提示词、示例对话、会话摘要、历史记录和本轮用户消息。
+// 所有内容都原样放入消息，不走模板格式化：角色卡文本和用户消息中的花括号会被当作占位符，导致格式化失败
+func buildChatMessages(query string, history []model.Message, examples []model.Message, system_prompt string, summary string) []*schema.Message {
 	// sort.Slice(history, func(i, j int) bool {
 	// 	return history[i].CreatedAt.Before(history[j].CreatedAt)
 	// })
 	//带历史记录的聊天
 	historyMessages := make([]*schema.Message, 0)
+	// 示例对话作为 few-shot 轮次放在真实历史之前
+	if len(examples) > 0 {
+		historyMessages = append(historyMessages, schema.SystemMessage(ExamplePrompt))
+		for _, example := range examples {
+			role := schema.User
+			if example.Role == "assistant" {
+				role = schema.Assistant
+			}
+			historyMessages = append(historyMessages, &schema.Message{Role: role, Content: example.Content})
+		}
+	}
+	if summary != "" {
+		historyMessages = append(historyMessages, schema.SystemMessage(SummaryPromptPrefix+summary))
+	}
 	for _, message := range history {
 		switch message.Role {
 		case "user":
@@ -61,25 +119,8 @@ func Chat(c *gin.Context, query string, history []model.Message, system_prompt s
 			})
 		}
 	}
-	template := prompt.FromMessages(schema.FString,
-		// 系统消息模板
-		schema.SystemMessage(system_prompt),
-
-		// 插入需要的对话历史（新对话的话这里不填）
-		schema.MessagesPlaceholder("chat_history", true),
-
-		// 用户消息模板
-		schema.UserMessage(query),
-	)
-	messages, err := template.Format(c, map[string]any{
-		"chat_history": historyMessages,
-	})
-	if err != nil {
-		return "格式化出错，请检查格式", err
-	}
-	resp, err := reactAgent.Generate(c, messages)
-	if err != nil {
-		return "生成出错，请检查配置文件或网络", err
-	}
-	return resp.Content, nil
+	messages := make([]*schema.Message, 0, len(historyMessages)+2)
+	messages = append(messages, schema.SystemMessage(system_prompt))
+	messages = append(messages, historyMessages...)
+	return append(messages, schema.UserMessage(query))
 }
@@ -0,0 +1,213 @@
+package chat_core
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/cloudwego/eino/callbacks"
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	template "github.com/cloudwego/eino/utils/callbacks"
+)
+
+const (
+	// DefaultReservedOutputTokens 人格未设置 max_tokens 时为回复预留的 token
+	DefaultReservedOutputTokens = 4096
+	// messageOverheadTokens 每条消息的角色、分隔符等额外开销
+	messageOverheadTokens = 4
+)
+
+// TokenUsage 一次聊天的 token 统计
+type TokenUsage struct {
+	PromptTokens     int // 本轮所有模型调用（含工具循环）的输入 token 之和
+	CompletionTokens int // 本轮所有模型调用的输出 token 之和
+	QueryTokens      int // 用户消息内容的 token 数
+	ReplyTokens      int // 回复内容的 token 数
+}
+
+// buildContextMessages 在 token 预算内组装上下文：
+// 系统提示词、会话摘要、工具定义和本轮用户消息必须保留，剩余预算从最新的历史消息开始向前填充，
+// 再有剩余时放入示例对话；预算紧张时示例对话最先被丢弃。
+func buildContextMessages(ctx context.Context, query string, history []model.Message, examples []model.Message, system_prompt string, summary string, settings model.PersonaModelSettings, tools []tool.BaseTool) ([]*schema.Message, int) {
+	budget := PromptBudget(settings)
+	used := messageOverheadTokens + utils.CountTokens(system_prompt)
+	if summary != "" {
+		used += messageOverheadTokens + utils.CountTokens(SummaryPromptPrefix+summary)
+	}
+	used += messageOverheadTokens + utils.CountTokens(query)
+	used += countToolTokens(ctx, tools)
+
+	history = fitHistoryToBudget(history, budget-used)
+	for i := range history {
+		used += messageTokens(&history[i])
+	}
+	examples = fitExamplesToBudget(examples, budget-used)
+	if len(examples) > 0 {
+		used += messageOverheadTokens + utils.CountTokens(ExamplePrompt)
+		for i := range examples {
+			used += messageTokens(&examples[i])
+		}
+	}
+	return buildChatMessages(query, history, examples, system_prompt, summary), used
+}
+
+// PromptBudget 计算输入可用的 token：模型上下文窗口减去为回复预留的部分
+func PromptBudget(settings model.PersonaModelSettings) int {
+	contextWindow := ai_config.DefaultContextWindow
+	if providerConfig, err := ai_config.GetChatProvider(settings.ModelProvider); err == nil {
+		contextWindow = providerConfig.GetContextWindow()
+	}
+	reserved := DefaultReservedOutputTokens
+	if settings.MaxTokens > 0 {
+		reserved = settings.MaxTokens
+	}
+	return contextWindow - reserved
+}
+
+// fitHistoryToBudget 从最新的消息开始保留历史，直到超出预算。
+// 裁剪后不会以 assistant 消息开头，避免出现没有提问的回复；整段历史都放得下时保留开头的 assistant 消息，
+// 它是会话的开场白，用户的第一条消息就是在回复它。
+func fitHistoryToBudget(history []model.Message, budget int) []model.Message {
+	if budget <= 0 || len(history) == 0 {
+		return []model.Message{}
+	}
+	start := len(history)
+	used := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := messageTokens(&history[i])
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = i
+	}
+	if start == 0 {
+		return history
+	}
+	for start < len(history) && history[start].Role == "assistant" {
+		start++
+	}
+	return history[start:]
+}
+
+// fitExamplesToBudget 按顺序保留完整的示例轮次（用户发言和角色回复各一条），直到超出预算；
+// 预算包括示例前的说明，一轮都放不下时不注入示例
+func fitExamplesToBudget(examples []model.Message, budget int) []model.Message {
+	used := messageOverheadTokens + utils.CountTokens(ExamplePrompt)
+	end := 0
+	for i := 0; i+1 < len(examples); i += 2 {
+		cost := messageTokens(&examples[i]) + messageTokens(&examples[i+1])
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		end = i + 2
+	}
+	return examples[:end]
+}
+
+// messageTokens 历史消息的 token 数，优先使用入库时记录的值
+func messageTokens(message *model.Message) int {
+	if message.TokenCount > 0 {
+		return message.TokenCount + messageOverheadTokens
+	}
+	return utils.CountTokens(message.Content) + messageOverheadTokens
+}
+
+// countToolTokens 估算工具定义（名称、描述、参数 JSON Schema）占用的 token
+func countToolTokens(ctx context.Context, tools []tool.BaseTool) int {
+	total := 0
+	for _, t := range tools {
+		info, err := t.Info(ctx)
+		if err != nil || info == nil {
+			continue
+		}
+		total += utils.CountTokens(info.Name) + utils.CountTokens(info.Desc)
+		if info.ParamsOneOf == nil {
+			continue
+		}
+		paramsSchema, err := info.ParamsOneOf.ToJSONSchema()
+		if err != nil {
+			continue
+		}
+		if data, err := json.Marshal(paramsSchema); err == nil {
+			total += utils.CountTokens(string(data))
+		}
+	}
+	return total
+}
+
+// tokenUsageCollector 通过模型回调累计一轮 ReAct 中所有模型调用的用量
+type tokenUsageCollector struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	prompt  int
+	output  int
+	reports int
+}
+
+func (u *tokenUsageCollector) add(usage *einomodel.TokenUsage) {
+	if usage == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.prompt += usage.PromptTokens
+	u.output += usage.CompletionTokens
+	u.reports++
+}
+
+func (u *tokenUsageCollector) handler() callbacks.Handler {
+	modelHandler := &template.ModelCallbackHandler{
+		OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *einomodel.CallbackOutput) context.Context {
+			u.add(output.TokenUsage)
+			return ctx
+		},
+		OnEndWithStreamOutput: func(ctx context.Context, info *callbacks.RunInfo, output *schema.StreamReader[*einomodel.CallbackOutput]) context.Context {
+			u.wg.Add(1)
+			go func() {
+				defer u.wg.Done()
+				defer output.Close()
+				// 流式输出通常只在最后一个分片携带用量
+				var last *einomodel.TokenUsage
+				for {
+					chunk, err := output.Recv()
+					if err != nil {
+						break
+					}
+					if chunk != nil && chunk.TokenUsage != nil {
+						last = chunk.TokenUsage
+					}
+				}
+				u.add(last)
+			}()
+			return ctx
+		},
+	}
+	return template.NewHandlerHelper().ChatModel(modelHandler).Handler()
+}
+
+// result 汇总用量；模型没有返回用量时使用本地 Tokenizer 的估算值
+func (u *tokenUsageCollector) result(estimatedPrompt int, query, reply string) TokenUsage {
+	u.wg.Wait()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	usage := TokenUsage{
+		PromptTokens:     u.prompt,
+		CompletionTokens: u.output,
+		QueryTokens:      utils.CountTokens(query),
+		ReplyTokens:      utils.CountTokens(reply),
+	}
+	if u.reports == 0 || usage.PromptTokens == 0 {
+		usage.PromptTokens = estimatedPrompt
+	}
+	if u.reports == 0 || usage.CompletionTokens == 0 {
+		usage.CompletionTokens = usage.ReplyTokens
+	}
+	return usage
+}
@@ -0,0 +1,99 @@
+package chat_core
+
+import (
+	"AI_Chat/internal/character_card"
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"reflect"
+	"testing"
+)
+
+func TestFitHistoryToBudget(t *testing.T) {
+	history := []model.Message{
+		{Role: "user", Content: "a", TokenCount: 10},
+		{Role: "assistant", Content: "b", TokenCount: 10},
+		{Role: "user", Content: "c", TokenCount: 10},
+		{Role: "assistant", Content: "d", TokenCount: 10},
+	}
+	perMessage := 10 + messageOverheadTokens
+
+	if got := fitHistoryToBudget(history, perMessage*4); len(got) != 4 {
+		t.Fatalf("expected whole history, got %d messages", len(got))
+	}
+	// 预算只够 3 条时，会留下以 assistant 开头的残缺轮次，需要再丢掉一条
+	if got := fitHistoryToBudget(history, perMessage*3); len(got) != 2 || got[0].Content != "c" {
+		t.Fatalf("expected last round only, got %+v", got)
+	}
+	if got := fitHistoryToBudget(history, 0); len(got) != 0 {
+		t.Fatalf("expected empty history, got %d messages", len(got))
+	}
+
+	// 以开场白开头的会话：放得下时保留开场白，放不下时与其他残缺轮次一样丢弃
+	greeted := append([]model.Message{{Role: "assistant", Content: "greeting", TokenCount: 10}}, history...)
+	if got := fitHistoryToBudget(greeted, perMessage*5); len(got) != 5 || got[0].Content != "greeting" {
+		t.Fatalf("expected greeting to be kept, got %+v", got)
+	}
+	if got := fitHistoryToBudget(greeted[:1], perMessage); len(got) != 1 || got[0].Content != "greeting" {
+		t.Fatalf("expected greeting on the first turn, got %+v", got)
+	}
+	if got := fitHistoryToBudget(greeted, perMessage*4); len(got) != 4 || got[0].Content != "a" {
+		t.Fatalf("expected rounds without greeting, got %+v", got)
+	}
+}
+
+func TestFitExamplesToBudget(t *testing.T) {
+	examples := []model.Message{
+		{Role: "user", Content: "a", TokenCount: 10},
+		{Role: "assistant", Content: "b", TokenCount: 10},
+		{Role: "user", Content: "c", TokenCount: 10},
+		{Role: "assistant", Content: "d", TokenCount: 10},
+	}
+	prompt := messageOverheadTokens + utils.CountTokens(ExamplePrompt)
+	perRound := 2 * (10 + messageOverheadTokens)
+
+	if got := fitExamplesToBudget(examples, prompt+perRound*2); len(got) != 4 {
+		t.Fatalf("expected all examples, got %d messages", len(got))
+	}
+	// 只保留完整的示例轮次
+	if got := fitExamplesToBudget(examples, prompt+perRound*2-1); len(got) != 2 || got[1].Content != "b" {
+		t.Fatalf("expected first round only, got %+v", got)
+	}
+	if got := fitExamplesToBudget(examples, prompt+perRound-1); len(got) != 0 {
+		t.Fatalf("expected no examples, got %d messages", len(got))
+	}
+}
+
+func TestBuildChatMessagesKeepsCardBraces(t *testing.T) {
+	// 导入的角色卡常带花括号，系统提示词和用户消息都原样传给模型
+	persona := &model.Persona{
+		Name:           "小雪",
+		CardExtensions: "{}",
+		Description:    "{{char}}喜欢用 {name} 和 { 当作暗号",
+		Scenario:       "{{user}}会回复 {\"ok\": true}",
+	}
+	systemPrompt := character_card.ComposePrompt(persona)
+	history := []model.Message{{Role: "user", Content: "{a}"}, {Role: "assistant", Content: "}"}}
+	messages := buildChatMessages("说说 {name} 是什么", history, nil, systemPrompt, "")
+	want := []string{systemPrompt, "{a}", "}", "说说 {name} 是什么"}
+	if len(messages) != len(want) {
+		t.Fatalf("expected %d messages, got %+v", len(want), messages)
+	}
+	for i, content := range want {
+		if messages[i].Content != content {
+			t.Fatalf("message %d: got %q, want %q", i, messages[i].Content, content)
+		}
+	}
+}
+
+func TestChunkSplitter(t *testing.T) {
+	splitter := &chunkSplitter{}
+	var chunks []string
+	for _, delta := range []string{"早", "啊\n今天", "又是\n\n忙碌的", "一天"} {
+		chunks = append(chunks, splitter.Write(delta)...)
+	}
+	chunks = append(chunks, splitter.Flush())
+	want := []string{"早啊", "今天又是", "忙碌的一天"}
+	if !reflect.DeepEqual(chunks, want) {
+		t.Fatalf("got %q, want %q", chunks, want)
+	}
+}
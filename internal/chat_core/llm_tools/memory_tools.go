@@ -19,7 +19,8 @@ type RetrieveMemoriesParams struct {
 	TopK      int    `json:"topK,omitempty" jsonschema:"返回的记忆数量，默认5"`
 }
 
-func NewRetrieveMemoriesTool(memoryService *memory.MemoryService, defaultPersonaID string, userId int64) (tool.InvokableTool, error) {
+// NewRetrieveMemoriesTool 创建记忆检索工具，options 为本次聊天请求指定的检索参数（权重、最低得分、是否重排序）
+func NewRetrieveMemoriesTool(memoryService *memory.MemoryService, defaultPersonaID string, userId int64, options memory.RetrievalOptions) (tool.InvokableTool, error) {
 	return toolutils.InferTool(
 		"RetrieveMemories",
 		"根据personaId和query检索相关记忆，返回格式化结果",
@@ -37,20 +38,25 @@ func NewRetrieveMemoriesTool(memoryService *memory.MemoryService, defaultPersona
 			if personaID == "" {
 				return "", fmt.Errorf("personaId is required")
 			}
-			topK := params.TopK
-			if topK <= 0 {
-				topK = 5
+			opts := options
+			if params.TopK > 0 {
+				opts.TopK = params.TopK
 			}
+			if opts.TopK <= 0 {
+				opts.TopK = memory.DefaultRetrieveTopK
+			}
+			topK := opts.TopK
 			utils.Log.Info("记忆检索输入",
 				zap.String("personaId", personaID),
 				zap.Int64("userId", userId),
 				zap.Int("topK", topK),
 				zap.String("query", params.Query),
 			)
-			memories, err := memoryService.RetrieveMemories(ctx, personaID, userId, params.Query, topK)
+			scored, err := memoryService.RecallMemories(ctx, personaID, userId, params.Query, opts)
 			if err != nil {
 				return "", err
 			}
+			memories := memory.ScoredMemoriesToMemories(scored)
 			formatted := strings.TrimSpace(memoryService.FormatMemoriesForPrompt(memories))
 			utils.Log.Info("记忆检索结果",
 				zap.String("personaId", personaID),
@@ -65,3 +71,59 @@ func NewRetrieveMemoriesTool(memoryService *memory.MemoryService, defaultPersona
 		},
 	)
 }
+
+type ForgetMemoriesParams struct {
+	Description string   `json:"description,omitempty" jsonschema:"用户要求忘记的内容，用于查找相关记忆"`
+	MemoryIDs   []string `json:"memoryIds,omitempty" jsonschema:"确认要忘记的记忆ID，来自上一次调用列出的候选"`
+}
+
+// NewForgetMemoriesTool 创建遗忘工具，仅在用户明确要求忘记某些事情时使用。
+// 分两步：先按描述列出候选记忆及其 ID，确认后再带上 memoryIds 调用删除；只能删除当前人格和用户的记忆。
+func NewForgetMemoriesTool(memoryService *memory.MemoryService, personaID string, userId int64) (tool.InvokableTool, error) {
+	return toolutils.InferTool(
+		"ForgetMemories",
+		"仅当用户明确要求你忘记某件事时使用。只填 description 时返回可能相关的记忆及其ID；确认后填写 memoryIds 删除这些记忆",
+		func(ctx context.Context, params *ForgetMemoriesParams) (string, error) {
+			if memoryService == nil {
+				return "", fmt.Errorf("memory service is nil")
+			}
+			if params == nil {
+				return "", fmt.Errorf("params is nil")
+			}
+			if len(params.MemoryIDs) > 0 {
+				deleted, err := memoryService.ForgetMemories(ctx, personaID, userId, params.MemoryIDs)
+				if err != nil {
+					return "", err
+				}
+				utils.Log.Info("遗忘工具删除记忆",
+					zap.String("personaId", personaID),
+					zap.Int64("userId", userId),
+					zap.Strings("requested", params.MemoryIDs),
+					zap.Strings("deleted", deleted),
+				)
+				if len(deleted) == 0 {
+					return "没有可删除的记忆，ID 不存在或已被删除。", nil
+				}
+				return fmt.Sprintf("已忘记 %d 条记忆。", len(deleted)), nil
+			}
+
+			description := strings.TrimSpace(params.Description)
+			if description == "" {
+				return "", fmt.Errorf("description or memoryIds is required")
+			}
+			candidates, err := memoryService.FindForgetCandidates(ctx, personaID, userId, description)
+			if err != nil {
+				return "", err
+			}
+			if len(candidates) == 0 {
+				return "没有找到相关记忆。", nil
+			}
+			var builder strings.Builder
+			builder.WriteString("找到以下可能相关的记忆，请只挑选用户要求忘记的，带上 memoryIds 再次调用：\n")
+			for _, mem := range candidates {
+				builder.WriteString(fmt.Sprintf("- [%s] %s\n", mem.ID, mem.Content))
+			}
+			return strings.TrimSpace(builder.String()), nil
+		},
+	)
+}
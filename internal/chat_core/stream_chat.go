@@ -0,0 +1,135 @@
+package chat_core
+
+import (
+	"AI_Chat/internal/model"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/flow/agent"
+	"github.com/cloudwego/eino/flow/agent/react"
+	template "github.com/cloudwego/eino/utils/callbacks"
+	"github.com/gin-gonic/gin"
+)
+
+// StreamEvent 类型常量
+const (
+	StreamEventChunk     = "chunk"      // 一条以 \n 分割的微信风格短消息
+	StreamEventToolStart = "tool_start" // 工具开始调用
+	StreamEventToolEnd   = "tool_end"   // 工具调用结束
+	StreamEventToolError = "tool_error" // 工具调用失败
+)
+
+// StreamEvent 流式聊天过程中推送给调用方的事件
+type StreamEvent struct {
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	Tool    string `json:"tool,omitempty"`
+}
+
+// ChatStream 以流式方式调用 Agent，每凑齐一条 \n 分割的消息就通过 onEvent 推送一次，
+// 工具调用进度也会通过 onEvent 推送。返回值为完整回复（与 Chat 的返回格式一致）。
+func ChatStream(c *gin.Context, query string, history []model.Message, examples []model.Message, system_prompt string, summary string, settings model.PersonaModelSettings, onEvent func(StreamEvent), tools ...tool.BaseTool) (string, TokenUsage, error) {
+	reactAgent, err := newReactAgent(c, settings, tools)
+	if err != nil {
+		return "Agent创建出错", TokenUsage{}, err
+	}
+	messages, promptTokens := buildContextMessages(c, query, history, examples, system_prompt, summary, settings, tools)
+
+	// 工具回调与流读取不在同一个 goroutine，推送时需要串行化
+	var mu sync.Mutex
+	emit := func(event StreamEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		onEvent(event)
+	}
+
+	usage := &tokenUsageCollector{}
+	stream, err := reactAgent.Stream(c, messages,
+		react.WithChatModelOptions(modelOptions(settings)...),
+		agent.WithComposeOptions(compose.WithCallbacks(newToolProgressHandler(emit), usage.handler())),
+	)
+	if err != nil {
+		return "生成出错，请检查配置文件或网络", TokenUsage{}, err
+	}
+	defer stream.Close()
+
+	var full strings.Builder
+	splitter := &chunkSplitter{}
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return full.String(), TokenUsage{}, err
+		}
+		if msg == nil || msg.Content == "" {
+			continue
+		}
+		full.WriteString(msg.Content)
+		for _, chunk := range splitter.Write(msg.Content) {
+			emit(StreamEvent{Type: StreamEventChunk, Content: chunk})
+		}
+	}
+	if chunk := splitter.Flush(); chunk != "" {
+		emit(StreamEvent{Type: StreamEventChunk, Content: chunk})
+	}
+	return full.String(), usage.result(promptTokens, query, full.String()), nil
+}
+
+// newToolProgressHandler 把工具调用的开始/结束/失败转换为 StreamEvent
+func newToolProgressHandler(emit func(StreamEvent)) callbacks.Handler {
+	toolHandler := &template.ToolCallbackHandler{
+		OnStart: func(ctx context.Context, info *callbacks.RunInfo, input *tool.CallbackInput) context.Context {
+			emit(StreamEvent{Type: StreamEventToolStart, Tool: info.Name})
+			return ctx
+		},
+		OnEnd: func(ctx context.Context, info *callbacks.RunInfo, output *tool.CallbackOutput) context.Context {
+			emit(StreamEvent{Type: StreamEventToolEnd, Tool: info.Name})
+			return ctx
+		},
+		OnError: func(ctx context.Context, info *callbacks.RunInfo, err error) context.Context {
+			emit(StreamEvent{Type: StreamEventToolError, Tool: info.Name, Content: err.Error()})
+			return ctx
+		},
+	}
+	return template.NewHandlerHelper().Tool(toolHandler).Handler()
+}
+
+// chunkSplitter 把模型输出的增量文本按 \n 切分为完整的短消息
+type chunkSplitter struct {
+	buf strings.Builder
+}
+
+// Write 追加增量文本，返回已经完整的短消息（空行会被丢弃）
+func (s *chunkSplitter) Write(delta string) []string {
+	s.buf.WriteString(delta)
+	text := s.buf.String()
+	idx := strings.LastIndex(text, "\n")
+	if idx < 0 {
+		return nil
+	}
+	s.buf.Reset()
+	s.buf.WriteString(text[idx+1:])
+
+	chunks := make([]string, 0)
+	for _, line := range strings.Split(text[:idx], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			chunks = append(chunks, line)
+		}
+	}
+	return chunks
+}
+
+// Flush 返回缓冲区中剩余的最后一条消息
+func (s *chunkSplitter) Flush() string {
+	rest := strings.TrimSpace(s.buf.String())
+	s.buf.Reset()
+	return rest
+}
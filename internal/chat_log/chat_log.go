@@ -0,0 +1,343 @@
+package chat_log
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的聊天记录格式
+const (
+	FormatText = "txt"
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// ErrInvalidLog 聊天记录无法解析或没有任何消息
+var ErrInvalidLog = errors.New("invalid chat log")
+
+// Utterance 聊天记录中的一条消息
+type Utterance struct {
+	Speaker string    `json:"speaker"`
+	Content string    `json:"content"`
+	Time    time.Time `json:"time,omitempty"`
+}
+
+// SpeakerCount 发言人及其消息数
+type SpeakerCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Parse 解析聊天记录导出文件，format 为空时根据内容判断格式。消息按文件中的顺序返回
+func Parse(raw []byte, format string) ([]Utterance, error) {
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	if format == "" {
+		format = detectFormat(raw)
+	}
+	var utterances []Utterance
+	var err error
+	switch format {
+	case FormatText:
+		utterances = parseText(string(raw))
+	case FormatJSON:
+		utterances, err = parseJSON(raw)
+	case FormatCSV:
+		utterances, err = parseCSV(raw)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidLog, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(utterances) == 0 {
+		return nil, fmt.Errorf("%w: no messages found", ErrInvalidLog)
+	}
+	return utterances, nil
+}
+
+// detectFormat 以 [ 或 { 开头且是合法 JSON 的按 JSON 处理（"[2024-01-02 10:00] 张三：内容" 这样的纯文本也以 [ 开头），
+// 首行是可识别的 CSV 表头时按 CSV 处理，其余按纯文本处理
+func detectFormat(raw []byte) string {
+	trimmed := bytes.TrimSpace(raw)
+	if (bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{"))) && json.Valid(trimmed) {
+		return FormatJSON
+	}
+	firstLine, _, _ := bytes.Cut(trimmed, []byte("\n"))
+	if header, err := csv.NewReader(bytes.NewReader(firstLine)).Read(); err == nil && len(header) > 1 {
+		if csvColumn(header, contentKeys) >= 0 {
+			return FormatCSV
+		}
+	}
+	return FormatText
+}
+
+// Speakers 统计各发言人的消息数，按消息数从多到少排列
+func Speakers(utterances []Utterance) []SpeakerCount {
+	counts := make(map[string]int)
+	for _, u := range utterances {
+		counts[u.Speaker]++
+	}
+	speakers := make([]SpeakerCount, 0, len(counts))
+	for name, count := range counts {
+		speakers = append(speakers, SpeakerCount{Name: name, Count: count})
+	}
+	sort.Slice(speakers, func(i, j int) bool {
+		if speakers[i].Count != speakers[j].Count {
+			return speakers[i].Count > speakers[j].Count
+		}
+		return speakers[i].Name < speakers[j].Name
+	})
+	return speakers
+}
+
+// 常见导出工具使用的字段名，按优先级排列，匹配时忽略大小写
+var (
+	speakerKeys = []string{"sender", "speaker", "from", "author", "name", "nickname", "talker", "remark", "发送人", "发送者", "昵称"}
+	contentKeys = []string{"content", "strcontent", "text", "message", "msg", "消息内容", "内容"}
+	timeKeys    = []string{"time", "strtime", "timestamp", "createtime", "date", "时间"}
+)
+
+var (
+	// headerLine 微信、QQ 等导出的纯文本记录中单独一行的消息头，如 "2024-01-02 10:00:00 张三"，内容在后续行。
+	// 发言人不含冒号，带冒号的是 "[2024-01-02 10:00] 张三：内容" 这样的单行消息，交给 inlineLine 识别
+	headerLine = regexp.MustCompile(`^\[?(\d{4}[-/.]\d{1,2}[-/.]\d{1,2}[ T]\d{1,2}:\d{2}(?::\d{2})?)\]?\s+([^:：]{1,40}?)\s*$`)
+	// inlineLine 同一行的消息，如 "张三: 内容" 或 "[2024-01-02 10:00] 张三：内容"
+	inlineLine = regexp.MustCompile(`^(?:\[?(\d{4}[-/.]\d{1,2}[-/.]\d{1,2}[ T]\d{1,2}:\d{2}(?::\d{2})?)\]?\s*)?([^:：\s][^:：]{0,29})[:：]\s*(.*)$`)
+)
+
+// parseText 解析纯文本记录：支持消息头独占一行的导出格式和 "发言人: 内容" 格式，无法识别的行作为上一条消息的续行。
+// 出现过独占一行的消息头后不再按 "发言人: 内容" 识别，避免把内容中带冒号的行当成新消息
+func parseText(text string) []Utterance {
+	var utterances []Utterance
+	var current *Utterance
+	headerStyle := false
+	flush := func() {
+		if current != nil {
+			current.Content = strings.TrimSpace(current.Content)
+			if current.Content != "" {
+				utterances = append(utterances, *current)
+			}
+		}
+		current = nil
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if m := headerLine.FindStringSubmatch(trimmed); m != nil {
+			flush()
+			current = &Utterance{Speaker: m[2], Time: parseTime(m[1])}
+			headerStyle = true
+			continue
+		}
+		if m := inlineLine.FindStringSubmatch(trimmed); m != nil && !headerStyle {
+			flush()
+			current = &Utterance{Speaker: strings.TrimSpace(m[2]), Content: m[3], Time: parseTime(m[1])}
+			continue
+		}
+		if current == nil || trimmed == "" {
+			continue
+		}
+		if current.Content != "" {
+			current.Content += "\n"
+		}
+		current.Content += trimmed
+	}
+	flush()
+	return utterances
+}
+
+// parseJSON 解析 JSON 记录：消息数组，或包含 messages / data 数组的对象
+func parseJSON(raw []byte) ([]Utterance, error) {
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		var wrapped map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLog, err)
+		}
+		for _, key := range []string{"messages", "data", "chats"} {
+			if list, ok := lookup(wrapped, key); ok && json.Unmarshal(list, &items) == nil {
+				break
+			}
+		}
+		if items == nil {
+			return nil, fmt.Errorf("%w: no message array", ErrInvalidLog)
+		}
+	}
+
+	utterances := make([]Utterance, 0, len(items))
+	for _, item := range items {
+		var u Utterance
+		for _, key := range speakerKeys {
+			if value, ok := lookup(item, key); ok && jsonText(value) != "" {
+				u.Speaker = jsonText(value)
+				break
+			}
+		}
+		for _, key := range contentKeys {
+			if value, ok := lookup(item, key); ok {
+				u.Content = strings.TrimSpace(jsonText(value))
+				break
+			}
+		}
+		for _, key := range timeKeys {
+			if value, ok := lookup(item, key); ok {
+				u.Time = parseTime(jsonText(value))
+				break
+			}
+		}
+		if u.Speaker != "" && u.Content != "" {
+			utterances = append(utterances, u)
+		}
+	}
+	return utterances, nil
+}
+
+// lookup 忽略大小写取 JSON 对象的字段
+func lookup(item map[string]json.RawMessage, key string) (json.RawMessage, bool) {
+	if value, ok := item[key]; ok {
+		return value, true
+	}
+	for k, value := range item {
+		if strings.EqualFold(k, key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// jsonText 把 JSON 值转为文本：字符串原样返回，数字转为字符串，
+// 数组拼接其中的字符串和对象的 text 字段（部分导出工具把带格式的消息存为片段数组）
+func jsonText(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(value, &n) == nil {
+		return n.String()
+	}
+	var parts []json.RawMessage
+	if json.Unmarshal(value, &parts) != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		if json.Unmarshal(part, &s) == nil {
+			b.WriteString(s)
+			continue
+		}
+		var obj struct {
+			Text string `json:"text"`
+		}
+		if json.Unmarshal(part, &obj) == nil {
+			b.WriteString(obj.Text)
+		}
+	}
+	return b.String()
+}
+
+// parseCSV 解析微信聊天记录导出工具生成的 CSV：按表头识别发言人、内容和时间列。
+// 只保留文本消息（有 Type 列时 Type 为 1）；没有发言人列时根据 IsSender 区分“我”和对方（Remark / NickName）
+func parseCSV(raw []byte) ([]Utterance, error) {
+	reader := csv.NewReader(bytes.NewReader(raw))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidLog, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%w: empty csv", ErrInvalidLog)
+	}
+	header := records[0]
+	contentCol := csvColumn(header, contentKeys)
+	if contentCol < 0 {
+		return nil, fmt.Errorf("%w: no content column", ErrInvalidLog)
+	}
+	speakerCol := csvColumn(header, []string{"sender", "speaker", "from", "author", "发送人", "发送者"})
+	nameCol := csvColumn(header, []string{"remark", "nickname", "talker", "name", "昵称"})
+	isSenderCol := csvColumn(header, []string{"issender", "is_sender"})
+	typeCol := csvColumn(header, []string{"type", "类型"})
+	timeCol := csvColumn(header, timeKeys)
+
+	cell := func(record []string, col int) string {
+		if col < 0 || col >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[col])
+	}
+	utterances := make([]Utterance, 0, len(records)-1)
+	for _, record := range records[1:] {
+		if t := cell(record, typeCol); t != "" && t != "1" && t != "文本" {
+			continue
+		}
+		u := Utterance{
+			Speaker: cell(record, speakerCol),
+			Content: cell(record, contentCol),
+			Time:    parseTime(cell(record, timeCol)),
+		}
+		if u.Speaker == "" {
+			switch {
+			case cell(record, isSenderCol) == "1":
+				u.Speaker = "我"
+			case cell(record, nameCol) != "":
+				u.Speaker = cell(record, nameCol)
+			case isSenderCol >= 0:
+				u.Speaker = "对方"
+			}
+		}
+		if u.Speaker != "" && u.Content != "" {
+			utterances = append(utterances, u)
+		}
+	}
+	return utterances, nil
+}
+
+// csvColumn 按候选名称的优先级查找列，找不到返回 -1
+func csvColumn(header []string, keys []string) int {
+	for _, key := range keys {
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), key) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var timeLayouts = []string{
+	"2006-01-02 15:04:05", "2006-01-02 15:04", "2006/01/02 15:04:05", "2006/01/02 15:04",
+	"2006-1-2 15:04:05", "2006-1-2 15:04", "2006/1/2 15:04:05", "2006/1/2 15:04",
+	"2006.01.02 15:04:05", "2006.01.02 15:04",
+}
+
+// parseTime 解析常见的时间格式和 Unix 时间戳（秒或毫秒），无法解析时返回零值
+func parseTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n)
+		}
+		return time.Unix(n, 0)
+	}
+	value = strings.Replace(value, "T", " ", 1)
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, strings.Replace(value, " ", "T", 1)); err == nil {
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,63 @@
+package chat_log
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFormats(t *testing.T) {
+	cases := []struct {
+		name   string
+		raw    string
+		format string
+		want   []Utterance
+	}{
+		{
+			name: "text with header lines",
+			raw:  "2024-01-02 10:00:00 小雪\n早啊\n今天又要监考\n\n2024-01-02 10:01:00 我\n注意：别迟到\n",
+			want: []Utterance{{Speaker: "小雪", Content: "早啊\n今天又要监考"}, {Speaker: "我", Content: "注意：别迟到"}},
+		},
+		{
+			name: "inline text",
+			raw:  "小雪：早啊\n继续上一条\n我: 早\n",
+			want: []Utterance{{Speaker: "小雪", Content: "早啊\n继续上一条"}, {Speaker: "我", Content: "早"}},
+		},
+		{
+			name: "inline text with timestamps",
+			raw:  "[2024-01-02 10:00] 小雪：早啊\n[2024-01-02 10:01:30] 我: 早，几点到？\n继续上一条\n",
+			want: []Utterance{{Speaker: "小雪", Content: "早啊"}, {Speaker: "我", Content: "早，几点到？\n继续上一条"}},
+		},
+		{
+			name: "json",
+			raw:  `{"messages": [{"sender": "小雪", "text": ["早", {"type": "bold", "text": "啊"}], "timestamp": 1704160800}, {"from": "我", "content": "早"}]}`,
+			want: []Utterance{{Speaker: "小雪", Content: "早啊"}, {Speaker: "我", Content: "早"}},
+		},
+		{
+			name: "wechat csv",
+			raw:  "\xef\xbb\xbflocalId,Type,IsSender,StrContent,StrTime,Remark\n1,1,0,早啊,2024-01-02 10:00:00,小雪\n2,3,0,[图片],2024-01-02 10:00:05,小雪\n3,1,1,\"早,吃了吗\",2024-01-02 10:01:00,小雪\n",
+			want: []Utterance{{Speaker: "小雪", Content: "早啊"}, {Speaker: "我", Content: "早,吃了吗"}},
+		},
+	}
+	for _, tc := range cases {
+		got, err := Parse([]byte(tc.raw), tc.format)
+		if err != nil {
+			t.Fatalf("%s: parse failed: %v", tc.name, err)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: unexpected utterances: %+v", tc.name, got)
+		}
+		for i := range got {
+			if got[i].Speaker != tc.want[i].Speaker || got[i].Content != tc.want[i].Content {
+				t.Fatalf("%s: #%d got %+v, want %+v", tc.name, i, got[i], tc.want[i])
+			}
+		}
+	}
+
+	if _, err := Parse([]byte("   "), ""); !errors.Is(err, ErrInvalidLog) {
+		t.Fatalf("empty log should be rejected, got %v", err)
+	}
+	speakers := Speakers([]Utterance{{Speaker: "a"}, {Speaker: "b"}, {Speaker: "b"}})
+	if len(speakers) != 2 || speakers[0].Name != "b" || speakers[0].Count != 2 {
+		t.Fatalf("unexpected speakers: %+v", speakers)
+	}
+}
@@ -16,6 +16,7 @@ const (
 	SessionExpiredCode = 1005
 	ChatFailedCode     = 1006
 	UserNotFoundCode   = 1007
+	AdminDeniedCode    = 1008
 )
 
 func GetMessage(code int) string {
@@ -38,6 +39,8 @@ func GetMessage(code int) string {
 		return "聊天失败，请检查配置文件或网络"
 	case UserNotFoundCode:
 		return "用户不存在或已被注销"
+	case AdminDeniedCode:
+		return "管理令牌无效"
 	}
 	return "未知错误"
 }
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"AI_Chat/internal/common"
+	"AI_Chat/internal/memory"
+	"AI_Chat/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type AdminHandler struct {
+	memoryService *memory.MemoryService
+}
+
+func NewAdminHandler(memoryService *memory.MemoryService) *AdminHandler {
+	return &AdminHandler{
+		memoryService: memoryService,
+	}
+}
+
+// ReindexMemories 核对数据库与向量存储，补齐缺失或过期的向量并清理多余的向量
+func (h *AdminHandler) ReindexMemories(c *gin.Context) {
+	var req memory.ReindexOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Fail(c, common.FailedCode)
+			return
+		}
+	}
+
+	report, err := h.memoryService.Reindex(c.Request.Context(), req)
+	if err != nil {
+		utils.Log.Error("记忆向量核对失败", zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, report)
+}
+
+// ConsolidateMemories 合并相近的重复记忆，dryRun 时只返回合并方案
+func (h *AdminHandler) ConsolidateMemories(c *gin.Context) {
+	var req memory.ConsolidateOptions
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Fail(c, common.FailedCode)
+			return
+		}
+	}
+
+	if req.Threshold <= 0 {
+		req.Threshold = utils.Config_Instance.GetMemoryConfig().ConsolidateThreshold
+	}
+	report, err := h.memoryService.Consolidate(c.Request.Context(), req)
+	if err != nil {
+		utils.Log.Error("记忆整理失败", zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, report)
+}
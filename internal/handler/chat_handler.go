@@ -1,15 +1,18 @@
 package handler
 
 import (
+	"AI_Chat/internal/character_card"
 	"AI_Chat/internal/chat_core"
 	"AI_Chat/internal/chat_core/llm_tools"
 	"AI_Chat/internal/common"
 	"AI_Chat/internal/memory"
 	"AI_Chat/internal/model"
 	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
 	"AI_Chat/pkg/utils"
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/cloudwego/eino/components/tool"
@@ -22,22 +25,27 @@ type ChatHandler struct {
 	conversationRepository *repository.ConversationRepository
 	personaRepository      *repository.PersonaRepository
 	memoryService          *memory.MemoryService
+	summaryService         *memory.SummaryService
 }
 
-func NewChatHandler(conversationRepository *repository.ConversationRepository, personaRepository *repository.PersonaRepository, memoryService *memory.MemoryService) *ChatHandler {
+func NewChatHandler(conversationRepository *repository.ConversationRepository, personaRepository *repository.PersonaRepository, memoryService *memory.MemoryService, summaryService *memory.SummaryService) *ChatHandler {
 	return &ChatHandler{
 		conversationRepository: conversationRepository,
 		personaRepository:      personaRepository,
 		memoryService:          memoryService,
+		summaryService:         summaryService,
 	}
 }
 func (h *ChatHandler) CreateConversation(c *gin.Context) {
 	var req struct {
 		Title     string `json:"title" binding:"required"`
 		PersonaId string `json:"personaId" binding:"required"`
+		// GreetingIndex 使用人格的第几条开场白（从 0 开始），不传时使用第一条非空的开场白
+		GreetingIndex *int `json:"greetingIndex" binding:"omitempty,gte=0"`
 	}
 	var res struct {
-		ConversationId string `json:"conversationId"`
+		ConversationId string         `json:"conversationId"`
+		Greeting       *model.Message `json:"greeting,omitempty"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		common.Fail(c, common.FailedCode)
@@ -58,26 +66,95 @@ func (h *ChatHandler) CreateConversation(c *gin.Context) {
 		common.Fail(c, common.DataBaseFailedCode)
 		return
 	}
+	persona, err := h.personaRepository.GetPersonaById(req.PersonaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	greeting, ok := pickGreeting(persona, req.GreetingIndex)
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
 	conversation := &model.Conversation{
 		UserID:    userId,
 		PersonaID: req.PersonaId,
 		Title:     req.Title,
 	}
-	err = h.conversationRepository.CreateConversation(conversation)
+	var greetingMsg *model.Message
+	if greeting != "" {
+		greetingMsg = &model.Message{
+			Role:       "assistant",
+			Content:    greeting,
+			TokenCount: utils.CountTokens(greeting),
+			CreatedAt:  time.Now(),
+		}
+	}
+	// 开场白作为会话的第一条 assistant 消息，与会话在同一事务中写入
+	err = h.conversationRepository.CreateConversationWithGreeting(conversation, greetingMsg)
 	if err != nil {
 		common.Fail(c, common.DataBaseFailedCode)
 		return
 	}
 	res.ConversationId = conversation.ID
+	res.Greeting = greetingMsg
 	common.Success(c, res)
 }
 
-func (h *ChatHandler) ChatWithPersona(c *gin.Context) {
-	var req struct {
-		Query          string `json:"query" binding:"required"`
-		ConversationId string `json:"conversationId" binding:"required"`
-		PersonaId      string `json:"personaId" binding:"required"`
+// pickGreeting 选出创建会话时使用的开场白并替换占位符：指定 index 时使用该条（必须存在且不为空），
+// 否则使用第一条非空的开场白；人格没有开场白时返回空字符串
+func pickGreeting(persona *model.Persona, index *int) (string, bool) {
+	if index != nil {
+		if *index >= len(persona.Greetings) || strings.TrimSpace(persona.Greetings[*index]) == "" {
+			return "", false
+		}
+		return character_card.ReplaceMacros(persona.Greetings[*index], persona.Name), true
+	}
+	for _, greeting := range persona.Greetings {
+		if strings.TrimSpace(greeting) != "" {
+			return character_card.ReplaceMacros(greeting, persona.Name), true
+		}
 	}
+	return "", true
+}
+
+// exampleMessages 把人格的示例对话转换为交替的用户/assistant 消息，作为 few-shot 轮次注入上下文
+func exampleMessages(persona *model.Persona) []model.Message {
+	exchanges := character_card.ParseExamples(persona.MessageExamples, persona.Name)
+	messages := make([]model.Message, 0, len(exchanges)*2)
+	for _, exchange := range exchanges {
+		messages = append(messages,
+			model.Message{Role: "user", Content: exchange.User},
+			model.Message{Role: "assistant", Content: exchange.Char},
+		)
+	}
+	return messages
+}
+
+type chatWithPersonaRequest struct {
+	Query          string `json:"query" binding:"required"`
+	ConversationId string `json:"conversationId" binding:"required"`
+	PersonaId      string `json:"personaId" binding:"required"`
+	// Retrieval 本次聊天的记忆检索参数，不传使用配置文件中的默认值
+	Retrieval *memory.RetrievalOptions `json:"retrieval"`
+}
+
+// personaChatContext 一次人格聊天所需的上下文
+type personaChatContext struct {
+	userId       int64
+	history      []model.Message
+	examples     []model.Message
+	systemPrompt string
+	summary      string
+	tools        []tool.BaseTool
+	settings     model.PersonaModelSettings
+	modelName    string
+
+	personaVersionId string // 本轮使用的人格提示词版本
+}
+
+func (h *ChatHandler) ChatWithPersona(c *gin.Context) {
+	var req chatWithPersonaRequest
 	var res struct {
 		Message string `json:"message"`
 	}
@@ -85,121 +162,235 @@ func (h *ChatHandler) ChatWithPersona(c *gin.Context) {
 		common.Fail(c, common.FailedCode)
 		return
 	}
-	persona, err := h.personaRepository.GetPersonaById(req.PersonaId)
+	chatCtx, code := h.preparePersonaChat(c, &req)
+	if code != common.SuccessCode {
+		common.Fail(c, code)
+		return
+	}
+	resp, usage, err := chat_core.Chat(c, req.Query, chatCtx.history, chatCtx.examples, chatCtx.systemPrompt, chatCtx.summary, chatCtx.settings, chatCtx.tools...)
+	res.Message = resp
 	if err != nil {
-		common.Fail(c, common.DataBaseFailedCode)
+		utils.Log.Error("聊天失败", zap.Error(err))
+		common.Fail(c, common.ChatFailedCode)
 		return
 	}
+	h.saveChatRound(&req, chatCtx, resp, usage)
 
-	userId, err := utils.GetUserIdFromSession(c)
-	if err != nil {
+	common.Success(c, res)
+}
+
+// ChatWithPersonaStream 以 SSE 流式返回人格回复，每条 \n 分割的短消息为一个事件
+func (h *ChatHandler) ChatWithPersonaStream(c *gin.Context) {
+	var req chatWithPersonaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		common.Fail(c, common.FailedCode)
 		return
 	}
-	if persona.UserID != userId {
-		common.Fail(c, common.FailedCode)
+	chatCtx, code := h.preparePersonaChat(c, &req)
+	if code != common.SuccessCode {
+		common.Fail(c, code)
 		return
 	}
 
-	conversation, err := h.conversationRepository.GetConversationById(req.ConversationId)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	onEvent := func(event chat_core.StreamEvent) {
+		c.SSEvent(event.Type, event)
+		c.Writer.Flush()
+	}
+	resp, usage, err := chat_core.ChatStream(c, req.Query, chatCtx.history, chatCtx.examples, chatCtx.systemPrompt, chatCtx.summary, chatCtx.settings, onEvent, chatCtx.tools...)
 	if err != nil {
-		common.Fail(c, common.DataBaseFailedCode)
+		utils.Log.Error("流式聊天失败", zap.Error(err))
+		c.SSEvent("error", common.Response{
+			Code:    common.ChatFailedCode,
+			Message: common.GetMessage(common.ChatFailedCode),
+		})
+		c.Writer.Flush()
 		return
 	}
+	h.saveChatRound(&req, chatCtx, resp, usage)
+
+	c.SSEvent("done", gin.H{"message": resp})
+	c.Writer.Flush()
+}
+
+// preparePersonaChat 校验人格与会话归属，并构建历史记录、系统提示词和工具
+func (h *ChatHandler) preparePersonaChat(c *gin.Context, req *chatWithPersonaRequest) (*personaChatContext, int) {
+	if err := req.Retrieval.Validate(); err != nil {
+		return nil, common.FailedCode
+	}
+	persona, err := h.personaRepository.GetPersonaById(req.PersonaId)
+	if err != nil {
+		return nil, common.DataBaseFailedCode
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		return nil, common.FailedCode
+	}
+	if persona.UserID != userId {
+		return nil, common.FailedCode
+	}
+
+	conversation, err := h.conversationRepository.GetConversationById(req.ConversationId)
+	if err != nil {
+		return nil, common.DataBaseFailedCode
+	}
 
 	if conversation.UserID != userId || (conversation.PersonaID != "" && conversation.PersonaID != req.PersonaId) {
-		common.Fail(c, common.FailedCode)
-		return
+		return nil, common.FailedCode
 	}
 
 	if conversation.PersonaID == "" {
 		existing, err := h.conversationRepository.GetConversationByPersonaAndUser(req.PersonaId, userId)
 		if err == nil && existing != nil && existing.ID != conversation.ID {
-			common.Fail(c, common.FailedCode)
-			return
+			return nil, common.FailedCode
 		}
 		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			common.Fail(c, common.DataBaseFailedCode)
-			return
+			return nil, common.DataBaseFailedCode
 		}
 		conversation.PersonaID = req.PersonaId
 		if err := h.conversationRepository.UpdateConversation(conversation); err != nil {
-			common.Fail(c, common.DataBaseFailedCode)
-			return
+			return nil, common.DataBaseFailedCode
 		}
 	}
 
 	conversation_messages, err := h.conversationRepository.GetMessagesByConversationId(req.ConversationId)
 	if err != nil {
-		common.Fail(c, common.DataBaseFailedCode)
-		return
+		return nil, common.DataBaseFailedCode
+	}
+	// 已被滚动摘要覆盖的历史不再原文携带
+	summary, err := h.summaryService.GetSummary(req.ConversationId)
+	if err != nil {
+		return nil, common.DataBaseFailedCode
+	}
+	conversation_messages = memory.UnsummarizedMessages(conversation_messages, summary)
+	summaryText := ""
+	if summary != nil {
+		summaryText = summary.Summary
+	}
+	// 历史按 token 预算在 chat_core 中裁剪，这里只处理人格设置的轮数上限
+	if persona.HistoryRounds > 0 {
+		conversation_messages = trimConversationRounds(conversation_messages, persona.HistoryRounds)
+	}
+
+	// 回复记录生成时的提示词版本，早期创建的人格在这里补建第一个版本
+	personaVersionId, err := h.personaRepository.EnsurePersonaVersion(persona)
+	if err != nil {
+		utils.Log.Warn("获取人格提示词版本失败", zap.String("personaId", persona.ID), zap.Error(err))
 	}
-	conversation_messages = trimConversationRounds(conversation_messages, 30)
 
 	// 构建增强的 System Prompt
 	gsp := "回复时，你需要模拟微信聊天的回复风格，人们通常不会说完一大段话，而是一小段一小段的发送，请根据上下文和需求，合理分割回复内容，以\n分割。比如早啊，今天又是忙碌的一天。学生们要考地理生物，我还得布置考场，想想就头疼。你那边怎么样？，你需要以\n分割。早啊\n今天又是忙碌的一天n学生们要考地理生物\n我还得布置考场\n想想就头疼\n你那边怎么样？"
-	enhancedSystemPrompt := persona.SystemPrompt + gsp
+	enhancedSystemPrompt := character_card.ComposePrompt(persona) + gsp
 	enhancedSystemPrompt += "\n\n当前 personaId: " + req.PersonaId + "\n如需检索记忆，请调用 RetrieveMemories 工具，并填写 query。"
+	enhancedSystemPrompt += "\n如果用户明确要求你忘记某件事，请调用 ForgetMemories 工具：先用 description 查找，再用 memoryIds 删除。"
 
-	tools := make([]tool.BaseTool, 0, 1)
-	memoryTool, err := llm_tools.NewRetrieveMemoriesTool(h.memoryService, req.PersonaId, userId)
+	tools := make([]tool.BaseTool, 0, 2)
+	var retrievalOptions memory.RetrievalOptions
+	if req.Retrieval != nil {
+		retrievalOptions = *req.Retrieval
+	}
+	memoryTool, err := llm_tools.NewRetrieveMemoriesTool(h.memoryService, req.PersonaId, userId, retrievalOptions)
 	if err != nil {
 		utils.Log.Warn("创建记忆检索工具失败", zap.Error(err))
 	} else {
 		tools = append(tools, memoryTool)
 	}
-	resp, err := chat_core.Chat(c, req.Query, conversation_messages, enhancedSystemPrompt, tools...)
-	res.Message = resp
+	forgetTool, err := llm_tools.NewForgetMemoriesTool(h.memoryService, req.PersonaId, userId)
 	if err != nil {
-		utils.Log.Error("聊天失败", zap.Error(err))
-		common.Fail(c, common.ChatFailedCode)
-		return
+		utils.Log.Warn("创建遗忘工具失败", zap.Error(err))
+	} else {
+		tools = append(tools, forgetTool)
+	}
+	modelName := persona.ModelName
+	if modelName == "" {
+		if providerConfig, err := ai_config.GetChatProvider(persona.ModelProvider); err == nil {
+			modelName = providerConfig.Model
+			if modelName == "" {
+				modelName = providerConfig.Name
+			}
+		}
 	}
+	return &personaChatContext{
+		userId:       userId,
+		history:      conversation_messages,
+		examples:     exampleMessages(persona),
+		systemPrompt: enhancedSystemPrompt,
+		summary:      summaryText,
+		tools:        tools,
+		settings:     persona.PersonaModelSettings,
+		modelName:    modelName,
 
-	// 异步累积消息用于记忆提取
-	go h.memoryService.AccumulateMessage(
-		context.Background(),
-		req.ConversationId,
-		req.PersonaId,
-		userId,
-		req.Query,
-		resp,
-	)
+		personaVersionId: personaVersionId,
+	}, common.SuccessCode
+}
 
+// saveChatRound 保存本轮的用户/AI 消息，累积用于记忆提取，并触发摘要刷新
+func (h *ChatHandler) saveChatRound(req *chatWithPersonaRequest, chatCtx *personaChatContext, resp string, usage chat_core.TokenUsage) {
 	//存放历史消息到mysql，这一步不放在Chat里面，可以根据实际业务灵活操作。
-	h.conversationRepository.AddMessageToConversation(
-		&model.Message{
-			ConversationID: req.ConversationId,
-			Role:           "user",
-			Content:        req.Query,
-			Model:          "deepseek-chat",
-			TokenCount:     0,
-			CreatedAt:      time.Now(),
-		},
-	)
-	h.conversationRepository.AddMessageToConversation(
-		&model.Message{
-			ConversationID: req.ConversationId,
-			Role:           "assistant",
-			Content:        resp,
-			Model:          "deepseek-chat",
-			TokenCount:     0,
-			CreatedAt:      time.Now(),
-		},
-	)
+	userMsg := &model.Message{
+		ConversationID: req.ConversationId,
+		Role:           "user",
+		Content:        req.Query,
+		Model:          chatCtx.modelName,
+		TokenCount:     usage.QueryTokens,
+		CreatedAt:      time.Now(),
+	}
+	aiMsg := &model.Message{
+		ConversationID:   req.ConversationId,
+		Role:             "assistant",
+		Content:          resp,
+		Model:            chatCtx.modelName,
+		TokenCount:       usage.ReplyTokens,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CreatedAt:        time.Now(),
+		PersonaVersionID: chatCtx.personaVersionId,
+	}
+	for _, msg := range []*model.Message{userMsg, aiMsg} {
+		if err := h.conversationRepository.AddMessageToConversation(msg); err != nil {
+			utils.Log.Error("保存消息失败", zap.String("conversationId", req.ConversationId), zap.String("role", msg.Role), zap.Error(err))
+			// 未保存的消息不能作为记忆来源
+			msg.ID = ""
+		}
+	}
 
-	common.Success(c, res)
+	// 异步累积消息用于记忆提取，消息 ID 作为提取出的记忆的来源
+	go func() {
+		err := h.memoryService.AccumulateMessage(
+			context.Background(),
+			req.ConversationId,
+			req.PersonaId,
+			chatCtx.userId,
+			userMsg,
+			aiMsg,
+			chatCtx.settings.MemoryExtractThreshold,
+		)
+		if err != nil {
+			utils.Log.Error("累积记忆消息失败", zap.String("conversationId", req.ConversationId), zap.Error(err))
+		}
+	}()
+
+	// 后台把即将放不进上下文窗口的旧轮次合并进滚动摘要
+	go func(conversationId string, budget int) {
+		if err := h.summaryService.RefreshSummary(context.Background(), conversationId, budget); err != nil {
+			utils.Log.Warn("刷新会话摘要失败", zap.String("conversationId", conversationId), zap.Error(err))
+		}
+	}(req.ConversationId, chat_core.PromptBudget(chatCtx.settings))
 }
 
+// trimConversationRounds 只保留最近 maxRounds 轮（一问一答）；会话以开场白开头时，开场白随回复它的第一轮一起保留
 func trimConversationRounds(messages []model.Message, maxRounds int) []model.Message {
 	if maxRounds <= 0 {
 		return []model.Message{}
 	}
-	limit := maxRounds * 2
-	if len(messages) <= limit {
+	start := len(messages) - maxRounds*2
+	if start <= 0 || (start == 1 && messages[0].Role == "assistant") {
 		return messages
 	}
-	return messages[len(messages)-limit:]
+	return messages[start:]
 }
 
 func (h *ChatHandler) GetConversations(c *gin.Context) {
@@ -240,3 +431,56 @@ func (h *ChatHandler) GetConversationMessages(c *gin.Context) {
 	res.Messages = conversationMessages
 	common.Success(c, res)
 }
+
+// GetConversationSummary 获取会话的滚动摘要
+func (h *ChatHandler) GetConversationSummary(c *gin.Context) {
+	conversationId := c.Param("conversationId")
+	if _, code := h.getOwnedConversation(c, conversationId); code != common.SuccessCode {
+		common.Fail(c, code)
+		return
+	}
+	summary, err := h.summaryService.GetSummary(conversationId)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, gin.H{"summary": summary})
+}
+
+// UpdateConversationSummary 手动修改会话的滚动摘要
+func (h *ChatHandler) UpdateConversationSummary(c *gin.Context) {
+	conversationId := c.Param("conversationId")
+	var req struct {
+		Summary string `json:"summary" binding:"max=8000"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if _, code := h.getOwnedConversation(c, conversationId); code != common.SuccessCode {
+		common.Fail(c, code)
+		return
+	}
+	summary, err := h.summaryService.UpdateSummary(conversationId, req.Summary)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, gin.H{"summary": summary})
+}
+
+// getOwnedConversation 获取会话并校验归属当前用户
+func (h *ChatHandler) getOwnedConversation(c *gin.Context, conversationId string) (*model.Conversation, int) {
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		return nil, common.FailedCode
+	}
+	conversation, err := h.conversationRepository.GetConversationById(conversationId)
+	if err != nil {
+		return nil, common.DataBaseFailedCode
+	}
+	if conversation.UserID != userId {
+		return nil, common.FailedCode
+	}
+	return conversation, common.SuccessCode
+}
@@ -6,21 +6,26 @@ import (
 	"AI_Chat/internal/model"
 	"AI_Chat/internal/repository"
 	"AI_Chat/pkg/utils"
+	"errors"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type MemoryHandler struct {
-	memoryRepository  *repository.MemoryRepository
-	personaRepository *repository.PersonaRepository
-	memoryService     *memory.MemoryService
+	memoryRepository       *repository.MemoryRepository
+	personaRepository      *repository.PersonaRepository
+	conversationRepository *repository.ConversationRepository
+	memoryService          *memory.MemoryService
 }
 
-func NewMemoryHandler(memoryRepo *repository.MemoryRepository, personaRepo *repository.PersonaRepository, memoryService *memory.MemoryService) *MemoryHandler {
+func NewMemoryHandler(memoryRepo *repository.MemoryRepository, personaRepo *repository.PersonaRepository, conversationRepo *repository.ConversationRepository, memoryService *memory.MemoryService) *MemoryHandler {
 	return &MemoryHandler{
-		memoryRepository:  memoryRepo,
-		personaRepository: personaRepo,
-		memoryService:     memoryService,
+		memoryRepository:       memoryRepo,
+		personaRepository:      personaRepo,
+		conversationRepository: conversationRepo,
+		memoryService:          memoryService,
 	}
 }
 
@@ -29,10 +34,11 @@ func (h *MemoryHandler) CreateMemory(c *gin.Context) {
 	personaId := c.Param("personaId")
 	
 	var req struct {
-		Type    string `json:"type" binding:"required"`
-		Content string `json:"content" binding:"required"`
+		Type       string `json:"type" binding:"required"`
+		Content    string `json:"content" binding:"required"`
+		Importance int    `json:"importance" binding:"omitempty,min=1,max=10"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil || !model.IsValidMemoryType(req.Type) {
 		common.Fail(c, common.FailedCode)
 		return
 	}
@@ -53,10 +59,11 @@ func (h *MemoryHandler) CreateMemory(c *gin.Context) {
 	memory := &model.Memory{
 		PersonaID: personaId,
 		UserID:    userId,
-		Type:      req.Type,
-		Content:   req.Content,
-		Source:    model.MemorySourceManual,
-		Status:    model.MemoryStatusActive,
+		Type:       req.Type,
+		Content:    req.Content,
+		Importance: model.ClampImportance(req.Importance),
+		Source:     model.MemorySourceManual,
+		Status:     model.MemoryStatusActive,
 	}
 	h.memoryService.PrepareMemoryEmbedding(c.Request.Context(), memory)
 
@@ -64,7 +71,7 @@ func (h *MemoryHandler) CreateMemory(c *gin.Context) {
 		common.Fail(c, common.DataBaseFailedCode)
 		return
 	}
-	h.memoryService.UpsertMilvusEmbedding(c.Request.Context(), memory)
+	h.memoryService.UpsertMemoryVector(c.Request.Context(), memory)
 
 	common.Success(c, memory)
 }
@@ -93,7 +100,8 @@ func (h *MemoryHandler) UpdateMemory(c *gin.Context) {
 	memoryId := c.Param("memoryId")
 
 	var req struct {
-		Content string `json:"content" binding:"required"`
+		Content    string `json:"content" binding:"required"`
+		Importance *int   `json:"importance" binding:"omitempty,min=1,max=10"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		common.Fail(c, common.FailedCode)
@@ -113,12 +121,21 @@ func (h *MemoryHandler) UpdateMemory(c *gin.Context) {
 	}
 
 	memory.Content = req.Content
-	h.memoryService.PrepareMemoryEmbedding(c.Request.Context(), memory)
+	if req.Importance != nil {
+		memory.Importance = *req.Importance
+	}
+	// 只有活跃记忆进入向量存储，待审核的候选在批准时再生成向量
+	active := memory.Status == model.MemoryStatusActive
+	if active {
+		h.memoryService.PrepareMemoryEmbedding(c.Request.Context(), memory)
+	}
 	if err := h.memoryRepository.UpdateMemory(memory); err != nil {
 		common.Fail(c, common.DataBaseFailedCode)
 		return
 	}
-	h.memoryService.UpsertMilvusEmbedding(c.Request.Context(), memory)
+	if active {
+		h.memoryService.UpsertMemoryVector(c.Request.Context(), memory)
+	}
 
 	common.Success(c, memory)
 }
@@ -143,7 +160,542 @@ func (h *MemoryHandler) DeleteMemory(c *gin.Context) {
 		common.Fail(c, common.DataBaseFailedCode)
 		return
 	}
-	h.memoryService.DeleteMilvusMemory(c.Request.Context(), memoryId)
+	h.memoryService.DeleteMemoryVector(c.Request.Context(), memoryId)
 
 	common.Success(c, nil)
 }
+
+// GetMemoryHistory 获取记忆的完整版本链（包括已被取代的旧版本）
+func (h *MemoryHandler) GetMemoryHistory(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	lineage, err := h.memoryService.GetMemoryLineage(personaId, userId, memoryId)
+	if errors.Is(err, memory.ErrMemoryNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, lineage)
+}
+
+// GetMemorySources 获取支撑该记忆的原始对话消息
+func (h *MemoryHandler) GetMemorySources(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	sources, err := h.memoryService.GetMemorySources(personaId, userId, memoryId)
+	if errors.Is(err, memory.ErrMemoryNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"sources": sources})
+}
+
+// GetReflectionEvidence 获取支撑反思记忆的原始记忆
+func (h *MemoryHandler) GetReflectionEvidence(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	evidence, err := h.memoryService.GetReflectionEvidence(personaId, userId, memoryId)
+	if errors.Is(err, memory.ErrMemoryNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"evidence": evidence})
+}
+
+// DiffMemoryVersions 比较同一版本链中的两个版本，from 不传时与上一个版本比较
+func (h *MemoryHandler) DiffMemoryVersions(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+	fromId := c.Query("from")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	if fromId == "" {
+		lineage, err := h.memoryService.GetMemoryLineage(personaId, userId, memoryId)
+		if err != nil {
+			common.Fail(c, common.FailedCode)
+			return
+		}
+		fromId = memoryId
+		for i, version := range lineage.Versions {
+			if version.ID == memoryId && i > 0 {
+				fromId = lineage.Versions[i-1].ID
+			}
+		}
+	}
+
+	diff, err := h.memoryService.DiffMemoryVersions(personaId, userId, fromId, memoryId)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	common.Success(c, diff)
+}
+
+// RollbackMemory 回滚到指定的旧版本：以该版本内容生成新的当前版本，并同步向量存储
+func (h *MemoryHandler) RollbackMemory(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	result, err := h.memoryService.RollbackMemory(c.Request.Context(), personaId, userId, memoryId)
+	if errors.Is(err, memory.ErrMemoryNotFound) || errors.Is(err, memory.ErrAlreadyCurrent) ||
+		errors.Is(err, repository.ErrMemoryNotActive) || errors.Is(err, repository.ErrLineageChanged) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		utils.Log.Error("回滚记忆失败", zap.String("memoryId", memoryId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, result)
+}
+
+// SearchMemories 按查询混合检索记忆，返回每条记忆的各阶段得分，用于调试检索参数
+func (h *MemoryHandler) SearchMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req struct {
+		Query string `json:"query" binding:"required"`
+		memory.RetrievalOptions
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err := req.RetrievalOptions.Validate(); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	results, err := h.memoryService.SearchMemories(c.Request.Context(), personaId, userId, req.Query, req.RetrievalOptions)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"memories": results})
+}
+
+// ExtractMemories 立即提取该人格会话中尚未提取的轮次，不必等到达到阈值或会话空闲
+func (h *MemoryHandler) ExtractMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	conversation, err := h.conversationRepository.GetConversationByPersonaAndUser(personaId, userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		common.Success(c, gin.H{"job": nil})
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	job, err := h.memoryService.FlushPendingMessages(c.Request.Context(), conversation.ID)
+	if err != nil {
+		utils.Log.Error("手动提取记忆失败", zap.String("conversationId", conversation.ID), zap.Error(err))
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"job": job})
+}
+
+// ReflectMemories 立即安排一次反思，从上次反思之后的新记忆中归纳更高层的认识
+func (h *MemoryHandler) ReflectMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	job, err := h.memoryService.RequestReflection(personaId, userId)
+	if err != nil {
+		utils.Log.Error("安排记忆反思失败", zap.String("personaId", personaId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"job": job})
+}
+
+// ConsolidateMemories 安排一次整理任务，合并该人格下相近的重复记忆；dryRun 时只给出合并方案，整理报告在任务完成后查询
+func (h *MemoryHandler) ConsolidateMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req struct {
+		DryRun    bool    `json:"dryRun"`
+		Threshold float64 `json:"threshold" binding:"omitempty,gt=0,lte=1"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Fail(c, common.FailedCode)
+			return
+		}
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	if req.Threshold == 0 {
+		req.Threshold = utils.Config_Instance.GetMemoryConfig().ConsolidateThreshold
+	}
+	job, err := h.memoryService.RequestConsolidation(personaId, userId, memory.ConsolidationPayload{
+		DryRun:    req.DryRun,
+		Threshold: req.Threshold,
+	})
+	if err != nil {
+		utils.Log.Error("安排记忆整理失败", zap.String("personaId", personaId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"job": job})
+}
+
+// GetMemoryJobs 查看记忆提取任务的状态，可按 status 过滤（pending/running/succeeded/dead）
+func (h *MemoryHandler) GetMemoryJobs(c *gin.Context) {
+	personaId := c.Param("personaId")
+	status := c.Query("status")
+	switch status {
+	case "", model.MemoryJobStatusPending, model.MemoryJobStatusRunning, model.MemoryJobStatusSucceeded, model.MemoryJobStatusDead:
+	default:
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	jobs, err := h.memoryService.ListExtractionJobs(personaId, userId, status, 100)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"jobs": jobs})
+}
+
+// GetMemoryJob 查看单个记忆任务，整理任务成功后 result 为整理报告
+func (h *MemoryHandler) GetMemoryJob(c *gin.Context) {
+	personaId := c.Param("personaId")
+	jobId := c.Param("jobId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	job, err := h.memoryService.GetMemoryJob(jobId, personaId, userId)
+	if errors.Is(err, memory.ErrJobNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"job": job})
+}
+
+// RetryMemoryJob 重新执行进入死信的提取任务
+func (h *MemoryHandler) RetryMemoryJob(c *gin.Context) {
+	personaId := c.Param("personaId")
+	jobId := c.Param("jobId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	job, err := h.memoryService.RetryExtractionJob(jobId, personaId, userId)
+	if err != nil {
+		utils.Log.Warn("重试记忆任务失败", zap.String("jobId", jobId), zap.Error(err))
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	common.Success(c, job)
+}
+
+// memoryIdsRequest 批量审核请求
+type memoryIdsRequest struct {
+	MemoryIds []string `json:"memoryIds" binding:"required,min=1,max=200"`
+}
+
+// GetReviewMemories 获取待审核的候选记忆
+func (h *MemoryHandler) GetReviewMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	memories, err := h.memoryService.ListPendingReview(personaId, userId)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"memories": memories})
+}
+
+// ApproveMemories 批量批准候选记忆
+func (h *MemoryHandler) ApproveMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req memoryIdsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	result, err := h.memoryService.ApproveMemories(c.Request.Context(), personaId, userId, req.MemoryIds)
+	if err != nil {
+		utils.Log.Error("批准候选记忆失败", zap.String("personaId", personaId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, result)
+}
+
+// EditAndApproveMemory 修改候选记忆后批准
+func (h *MemoryHandler) EditAndApproveMemory(c *gin.Context) {
+	personaId := c.Param("personaId")
+	memoryId := c.Param("memoryId")
+
+	var req struct {
+		Type       string  `json:"type" binding:"omitempty,oneof=fact preference event emotion relationship"`
+		Content    string  `json:"content"`
+		Keywords   *string `json:"keywords" binding:"omitempty,max=500"`
+		Importance *int    `json:"importance" binding:"omitempty,min=1,max=10"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	approved, err := h.memoryService.EditAndApproveMemory(c.Request.Context(), personaId, userId, memoryId, memory.MemoryReviewEdit{
+		Type:       req.Type,
+		Content:    req.Content,
+		Keywords:   req.Keywords,
+		Importance: req.Importance,
+	})
+	if errors.Is(err, memory.ErrMemoryNotFound) || errors.Is(err, memory.ErrMemoryNotPending) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		utils.Log.Error("批准候选记忆失败", zap.String("memoryId", memoryId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, approved)
+}
+
+// RejectMemories 批量拒绝候选记忆
+func (h *MemoryHandler) RejectMemories(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req memoryIdsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	rejected, err := h.memoryService.RejectMemories(personaId, userId, req.MemoryIds)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+
+	common.Success(c, gin.H{"rejected": rejected})
+}
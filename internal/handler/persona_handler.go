@@ -1,20 +1,66 @@
 package handler
 
 import (
+	"AI_Chat/internal/character_card"
+	"AI_Chat/internal/chat_log"
 	"AI_Chat/internal/common"
+	"AI_Chat/internal/memory"
 	"AI_Chat/internal/model"
 	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
 	"AI_Chat/pkg/utils"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
 
 	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type PersonaHandler struct {
 	personaRepository *repository.PersonaRepository
+	memoryService     *memory.MemoryService
 }
 
-func NewPersonaHandler(personaRepository *repository.PersonaRepository) *PersonaHandler {
-	return &PersonaHandler{personaRepository: personaRepository}
+func NewPersonaHandler(personaRepository *repository.PersonaRepository, memoryService *memory.MemoryService) *PersonaHandler {
+	return &PersonaHandler{personaRepository: personaRepository, memoryService: memoryService}
+}
+
+// personaModelSettingsRequest 人格生成参数，未填写的参数使用全局默认
+type personaModelSettingsRequest struct {
+	ModelProvider string   `json:"modelProvider" binding:"omitempty,max=50"`
+	ModelName     string   `json:"modelName" binding:"omitempty,max=100"`
+	Temperature   *float32 `json:"temperature" binding:"omitempty,gte=0,lte=2"`
+	TopP          *float32 `json:"topP" binding:"omitempty,gt=0,lte=1"`
+	MaxTokens     int      `json:"maxTokens" binding:"omitempty,gte=1,lte=32768"`
+	MaxSteps      int      `json:"maxSteps" binding:"omitempty,gte=1,lte=20"`
+	HistoryRounds int      `json:"historyRounds" binding:"omitempty,gte=1,lte=200"`
+
+	MemoryExtractThreshold int  `json:"memoryExtractThreshold" binding:"omitempty,gte=1,lte=100"`
+	MemoryReviewMode       bool `json:"memoryReviewMode"`
+}
+
+// toModelSettings 校验模型实例是否存在，并转换为人格的生成参数
+func (r *personaModelSettingsRequest) toModelSettings() (model.PersonaModelSettings, bool) {
+	if r.ModelProvider != "" {
+		if _, err := ai_config.GetChatProvider(r.ModelProvider); err != nil {
+			return model.PersonaModelSettings{}, false
+		}
+	}
+	return model.PersonaModelSettings{
+		ModelProvider: r.ModelProvider,
+		ModelName:     r.ModelName,
+		Temperature:   r.Temperature,
+		TopP:          r.TopP,
+		MaxTokens:     r.MaxTokens,
+		MaxSteps:      r.MaxSteps,
+		HistoryRounds: r.HistoryRounds,
+
+		MemoryExtractThreshold: r.MemoryExtractThreshold,
+		MemoryReviewMode:       r.MemoryReviewMode,
+	}, true
 }
 
 func (h *PersonaHandler) CreatePersona(c *gin.Context) {
@@ -24,23 +70,39 @@ func (h *PersonaHandler) CreatePersona(c *gin.Context) {
 		SystemPrompt string `json:"systemPrompt" binding:"required"`
 		Mode         int    `json:"mode" binding:"required"`
 		Avatar       string `json:"avatar" binding:"required"`
+
+		Personality     string   `json:"personality"`
+		Scenario        string   `json:"scenario"`
+		Greetings       []string `json:"greetings" binding:"omitempty,max=20"`
+		MessageExamples string   `json:"messageExamples"`
+		personaModelSettingsRequest
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		common.Fail(c, common.FailedCode)
 		return
 	}
+	settings, ok := req.toModelSettings()
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
 	userId, err := utils.GetUserIdFromSession(c)
 	if err != nil {
 		common.Fail(c, common.FailedCode)
 		return
 	}
 	persona := &model.Persona{
-		UserID:       userId,
-		Name:         req.Name,
-		Description:  req.Description,
-		SystemPrompt: req.SystemPrompt,
-		Mode:         req.Mode,
-		Avatar:       req.Avatar,
+		UserID:               userId,
+		Name:                 req.Name,
+		Description:          req.Description,
+		SystemPrompt:         req.SystemPrompt,
+		Mode:                 req.Mode,
+		Avatar:               req.Avatar,
+		Personality:          req.Personality,
+		Scenario:             req.Scenario,
+		Greetings:            req.Greetings,
+		MessageExamples:      req.MessageExamples,
+		PersonaModelSettings: settings,
 	}
 	err = h.personaRepository.CreatePersona(persona)
 	if err != nil {
@@ -50,6 +112,472 @@ func (h *PersonaHandler) CreatePersona(c *gin.Context) {
 	common.Success(c, persona)
 }
 
+// UpdatePersonaSettings 更新人格的生成参数（整体覆盖）
+func (h *PersonaHandler) UpdatePersonaSettings(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req personaModelSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	settings, ok := req.toModelSettings()
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	persona.PersonaModelSettings = settings
+	err = h.personaRepository.UpdatePersona(persona)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 人格已在此期间被删除
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, persona)
+}
+
+// UpdatePersona 修改人格的基本信息，未填写的字段保持不变
+func (h *PersonaHandler) UpdatePersona(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	var req struct {
+		Name         *string `json:"name" binding:"omitempty,min=1,max=100"`
+		Description  *string `json:"description"`
+		SystemPrompt *string `json:"systemPrompt" binding:"omitempty,min=1"`
+		Mode         *int    `json:"mode" binding:"omitempty,oneof=1 2"`
+		Avatar       *string `json:"avatar" binding:"omitempty,max=255"`
+
+		Personality     *string   `json:"personality"`
+		Scenario        *string   `json:"scenario"`
+		Greetings       *[]string `json:"greetings" binding:"omitempty,max=20"`
+		MessageExamples *string   `json:"messageExamples"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	persona, err := h.personaRepository.GetPersonaById(personaId)
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if req.Name != nil {
+		persona.Name = *req.Name
+	}
+	if req.Description != nil {
+		persona.Description = *req.Description
+	}
+	if req.SystemPrompt != nil {
+		persona.SystemPrompt = *req.SystemPrompt
+	}
+	if req.Mode != nil {
+		persona.Mode = *req.Mode
+	}
+	if req.Avatar != nil {
+		persona.Avatar = *req.Avatar
+	}
+	if req.Personality != nil {
+		persona.Personality = *req.Personality
+	}
+	if req.Scenario != nil {
+		persona.Scenario = *req.Scenario
+	}
+	if req.Greetings != nil {
+		persona.Greetings = *req.Greetings
+	}
+	if req.MessageExamples != nil {
+		persona.MessageExamples = *req.MessageExamples
+	}
+	// 影响提示词的字段变化时生成新的不可变版本
+	err = h.personaRepository.UpdatePersona(persona)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 人格已在此期间被删除
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, persona)
+}
+
+// GetPersonaVersions 获取人格的提示词版本列表，以及每个版本生成的回复数量
+func (h *PersonaHandler) GetPersonaVersions(c *gin.Context) {
+	persona, ok := h.ownedPersona(c)
+	if !ok {
+		return
+	}
+	if _, err := h.personaRepository.EnsurePersonaVersion(persona); err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	versions, err := h.personaRepository.GetPersonaVersions(persona.ID)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	ids := make([]string, 0, len(versions))
+	for _, version := range versions {
+		ids = append(ids, version.ID)
+	}
+	counts, err := h.personaRepository.CountMessagesByPersonaVersion(ids)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, gin.H{
+		"currentVersionId": persona.CurrentVersionID,
+		"versions":         versions,
+		"messageCounts":    counts,
+	})
+}
+
+// DiffPersonaVersions 比较两个提示词版本；to 不传时为当前版本，from 不传时为 to 的上一个版本
+func (h *PersonaHandler) DiffPersonaVersions(c *gin.Context) {
+	persona, ok := h.ownedPersona(c)
+	if !ok {
+		return
+	}
+	from, to, err := h.personaRepository.GetPersonaVersionPair(persona.ID, c.Query("from"), c.DefaultQuery("to", persona.CurrentVersionID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	if from == nil {
+		// 第一个版本与空提示词比较
+		from = &model.PersonaVersion{}
+	}
+	// 系统提示词之外的字段只列出有变化的
+	changes := make([]gin.H, 0)
+	for _, field := range []struct {
+		name     string
+		from, to string
+	}{
+		{"name", from.Name, to.Name},
+		{"description", from.Description, to.Description},
+		{"personality", from.Personality, to.Personality},
+		{"scenario", from.Scenario, to.Scenario},
+		{"message_examples", from.MessageExamples, to.MessageExamples},
+	} {
+		if field.from != field.to {
+			changes = append(changes, gin.H{"field": field.name, "content": memory.DiffText(field.from, field.to)})
+		}
+	}
+	common.Success(c, gin.H{
+		"from":    from,
+		"to":      to,
+		"content": memory.DiffText(from.SystemPrompt, to.SystemPrompt),
+		"changes": changes,
+	})
+}
+
+// RollbackPersonaVersion 回滚到旧的提示词版本：恢复该版本的全部提示词字段并生成新的当前版本，版本历史只追加不改写
+func (h *PersonaHandler) RollbackPersonaVersion(c *gin.Context) {
+	persona, ok := h.ownedPersona(c)
+	if !ok {
+		return
+	}
+	target, err := h.personaRepository.GetPersonaVersion(persona.ID, c.Param("versionId"))
+	if err != nil || target.ID == persona.CurrentVersionID {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	target.ApplyTo(persona)
+	version, err := h.personaRepository.SavePersonaPrompt(persona, target.ID)
+	if err != nil {
+		utils.Log.Error("回滚人格提示词失败", zap.String("personaId", persona.ID), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, gin.H{"persona": persona, "version": version})
+}
+
+const (
+	// maxCardSize 导入角色卡文件的大小上限
+	maxCardSize = 10 << 20
+	// maxChatLogSize 导入聊天记录文件的大小上限
+	maxChatLogSize = 20 << 20
+)
+
+// readUpload 读取 multipart 的 file 字段上传的文件，没有该字段时读取整个请求体；超过 limit 时失败
+func readUpload(c *gin.Context, limit int64) ([]byte, bool) {
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > limit {
+			return nil, false
+		}
+		f, err := file.Open()
+		if err != nil {
+			return nil, false
+		}
+		defer f.Close()
+		raw, err := io.ReadAll(f)
+		return raw, err == nil
+	}
+	raw, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+	if err != nil || int64(len(raw)) > limit {
+		return nil, false
+	}
+	return raw, true
+}
+
+// ImportPersona 导入角色卡（Character Card V2 的 JSON 或 PNG）创建人格。
+// 通过 multipart 的 file 字段上传文件，或直接以角色卡 JSON 作为请求体；PNG 图片保存为人格头像
+func (h *PersonaHandler) ImportPersona(c *gin.Context) {
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	raw, ok := readUpload(c, maxCardSize)
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	cardJSON, avatar := raw, []byte(nil)
+	if character_card.IsPNG(raw) {
+		cardJSON, avatar, err = character_card.ExtractPNG(raw)
+		if err != nil {
+			utils.Log.Info("解析 PNG 角色卡失败", zap.Error(err))
+			common.Fail(c, common.FailedCode)
+			return
+		}
+	}
+	persona, err := character_card.ParseJSON(cardJSON)
+	if err != nil {
+		utils.Log.Info("解析角色卡失败", zap.Error(err))
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	persona.UserID = userId
+	if err := h.personaRepository.ImportPersona(persona, avatar); err != nil {
+		utils.Log.Error("导入角色卡失败", zap.Int64("userId", userId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	if avatar != nil {
+		persona.Avatar = "/api/v1/persona/" + persona.ID + "/avatar"
+		if err := h.personaRepository.UpdatePersona(persona); err != nil {
+			utils.Log.Warn("保存人格头像地址失败", zap.String("personaId", persona.ID), zap.Error(err))
+		}
+	}
+	common.Success(c, persona)
+}
+
+// parseChatLog 读取上传的聊天记录并解析，format 为空时自动判断
+func parseChatLog(c *gin.Context) ([]chat_log.Utterance, bool) {
+	raw, ok := readUpload(c, maxChatLogSize)
+	if !ok {
+		return nil, false
+	}
+	utterances, err := chat_log.Parse(raw, c.PostForm("format"))
+	if err != nil {
+		utils.Log.Info("解析聊天记录失败", zap.Error(err))
+		return nil, false
+	}
+	return utterances, true
+}
+
+// GetChatLogSpeakers 解析上传的聊天记录，返回各发言人的消息数，供用户选择要模拟的人
+func (h *PersonaHandler) GetChatLogSpeakers(c *gin.Context) {
+	utterances, ok := parseChatLog(c)
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	common.Success(c, gin.H{"speakers": chat_log.Speakers(utterances)})
+}
+
+// SimulatePersona 由上传的聊天记录生成模拟真人（mode 2）的人格：统计 target 的说话风格、选取示例对话，
+// 由模型生成系统提示词和初始记忆。用户可在之后修改提示词
+func (h *PersonaHandler) SimulatePersona(c *gin.Context) {
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	target := c.PostForm("target")
+	name := c.PostForm("name")
+	if target == "" || len([]rune(name)) > 100 {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	utterances, ok := parseChatLog(c)
+	if !ok {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	result, err := h.memoryService.CreateSimulationPersona(c.Request.Context(), userId, name, target, utterances)
+	if errors.Is(err, memory.ErrSpeakerNotFound) || errors.Is(err, memory.ErrNotEnoughMessages) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		utils.Log.Error("生成模拟人格失败", zap.Int64("userId", userId), zap.Error(err))
+		common.Fail(c, common.ChatFailedCode)
+		return
+	}
+	common.Success(c, result)
+}
+
+// ExportPersona 把人格导出为 Character Card V2 角色卡，format=json（默认）或 png。
+// PNG 使用导入时保存的头像，没有头像时使用纯色占位图
+func (h *PersonaHandler) ExportPersona(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "png" {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	persona, ok := h.ownedPersona(c)
+	if !ok {
+		return
+	}
+	cardJSON, err := character_card.MarshalJSON(persona)
+	if err != nil {
+		utils.Log.Error("导出角色卡失败", zap.String("personaId", persona.ID), zap.Error(err))
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if format == "json" {
+		c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(persona.Name+".json"))
+		c.Data(http.StatusOK, "application/json", cardJSON)
+		return
+	}
+
+	avatar, err := h.personaRepository.GetPersonaAvatar(persona.ID)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	if avatar == nil {
+		if avatar, err = character_card.PlaceholderAvatar(); err != nil {
+			common.Fail(c, common.FailedCode)
+			return
+		}
+	}
+	card, err := character_card.EmbedPNG(avatar, cardJSON)
+	if err != nil {
+		utils.Log.Error("写入 PNG 角色卡失败", zap.String("personaId", persona.ID), zap.Error(err))
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(persona.Name+".png"))
+	c.Data(http.StatusOK, "image/png", card)
+}
+
+// GetPersonaAvatar 获取导入 PNG 角色卡时保存的头像图片
+func (h *PersonaHandler) GetPersonaAvatar(c *gin.Context) {
+	persona, ok := h.ownedPersona(c)
+	if !ok {
+		return
+	}
+	avatar, err := h.personaRepository.GetPersonaAvatar(persona.ID)
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	if avatar == nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	c.Data(http.StatusOK, "image/png", avatar)
+}
+
+// ownedPersona 读取路径中的人格并校验归属，失败时已写入响应
+func (h *PersonaHandler) ownedPersona(c *gin.Context) (*model.Persona, bool) {
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return nil, false
+	}
+	persona, err := h.personaRepository.GetPersonaById(c.Param("personaId"))
+	if err != nil || persona.UserID != userId {
+		common.Fail(c, common.FailedCode)
+		return nil, false
+	}
+	return persona, true
+}
+
+// DeletePersona 删除人格：人格立即隐藏，会话、消息、待提取轮次、记忆和向量由后台任务清理。
+// mode=hard 时物理删除，默认软删除（只标记删除）
+func (h *PersonaHandler) DeletePersona(c *gin.Context) {
+	personaId := c.Param("personaId")
+
+	mode := c.DefaultQuery("mode", "soft")
+	if mode != "soft" && mode != "hard" {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	// 软删除后仍可以再彻底删除，已软删除的人格不重复软删除
+	persona, err := h.personaRepository.GetPersonaIncludingDeleted(personaId)
+	if err != nil || persona.UserID != userId || (persona.IsDeleted && mode != "hard") {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+
+	job, err := h.memoryService.EnqueuePersonaDeletion(personaId, userId, mode == "hard")
+	if err != nil {
+		utils.Log.Error("删除人格任务入队失败", zap.String("personaId", personaId), zap.Error(err))
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	// 后台任务开始时也会标记，这里失败不影响删除
+	if err := h.personaRepository.MarkPersonaDeleted(personaId); err != nil {
+		utils.Log.Warn("标记人格删除失败", zap.String("personaId", personaId), zap.Error(err))
+	}
+	common.Success(c, gin.H{"job": job})
+}
+
+// GetPersonaDeletion 查询删除人格任务的执行状态
+func (h *PersonaHandler) GetPersonaDeletion(c *gin.Context) {
+	jobId := c.Param("jobId")
+
+	userId, err := utils.GetUserIdFromSession(c)
+	if err != nil {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	job, err := h.memoryService.GetPersonaDeletionJob(jobId, userId)
+	if errors.Is(err, memory.ErrJobNotFound) {
+		common.Fail(c, common.FailedCode)
+		return
+	}
+	if err != nil {
+		common.Fail(c, common.DataBaseFailedCode)
+		return
+	}
+	common.Success(c, gin.H{"job": job})
+}
+
 func (h *PersonaHandler) GetPersonas(c *gin.Context) {
 	var res struct {
 		Personas []model.Persona `json:"personas"`
@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 参数
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// keywordBoost 提取时给出的关键词在文档中重复计入的次数，使关键词命中比正文命中权重更高
+	keywordBoost = 2
+)
+
+// tokenize 把文本切分为检索词：中日韩文字按单字和相邻双字切分，其他字母数字按连续片段切分并转为小写
+func tokenize(text string) []string {
+	tokens := make([]string, 0, len(text)/2)
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		for i := range cjk {
+			tokens = append(tokens, string(cjk[i]))
+			if i+1 < len(cjk) {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// memoryTerms 记忆的检索词：正文加上关键词
+func memoryTerms(memory *model.Memory) []string {
+	terms := tokenize(memory.Content)
+	if keywords := tokenize(memory.Keywords); len(keywords) > 0 {
+		for i := 0; i < keywordBoost; i++ {
+			terms = append(terms, keywords...)
+		}
+	}
+	return terms
+}
+
+// bm25Scores 计算每条记忆相对查询的 BM25 得分，与查询没有共同检索词的记忆得分为 0
+func bm25Scores(query string, memories []model.Memory) []float64 {
+	scores := make([]float64, len(memories))
+	queryTerms := uniqueTerms(tokenize(query))
+	if len(queryTerms) == 0 || len(memories) == 0 {
+		return scores
+	}
+
+	termFreqs := make([]map[string]int, len(memories))
+	docLens := make([]int, len(memories))
+	docFreq := make(map[string]int, len(queryTerms))
+	totalLen := 0
+	for i := range memories {
+		terms := memoryTerms(&memories[i])
+		freq := make(map[string]int)
+		for _, term := range terms {
+			freq[term]++
+		}
+		for _, term := range queryTerms {
+			if freq[term] > 0 {
+				docFreq[term]++
+			}
+		}
+		termFreqs[i] = freq
+		docLens[i] = len(terms)
+		totalLen += len(terms)
+	}
+	avgLen := float64(totalLen) / float64(len(memories))
+	if avgLen == 0 {
+		return scores
+	}
+
+	n := float64(len(memories))
+	for _, term := range queryTerms {
+		df := float64(docFreq[term])
+		if df == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for i, freq := range termFreqs {
+			tf := float64(freq[term])
+			if tf == 0 {
+				continue
+			}
+			norm := bm25K1 * (1 - bm25B + bm25B*float64(docLens[i])/avgLen)
+			scores[i] += idf * tf * (bm25K1 + 1) / (tf + norm)
+		}
+	}
+	return scores
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	result := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			result = append(result, term)
+		}
+	}
+	return result
+}
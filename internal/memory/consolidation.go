@@ -0,0 +1,504 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultConsolidateThreshold 两条记忆向量的余弦相似度达到该值才可能被合并
+	DefaultConsolidateThreshold = 0.85
+	// consolidateMaxClusterSize 一次交给模型合并的记忆数量上限
+	consolidateMaxClusterSize = 8
+	// submitMergedMemoryTool 整理时模型通过调用该工具提交合并结果
+	submitMergedMemoryTool = "submit_merged_memory"
+)
+
+// ConsolidateOptions 整理范围；PersonaID 为空或 UserID 为 0 时不过滤该字段
+type ConsolidateOptions struct {
+	PersonaID string  `json:"personaId"`
+	UserID    int64   `json:"userId"`
+	DryRun    bool    `json:"dryRun"`    // 只给出合并方案，不修改记忆
+	Threshold float64 `json:"threshold"` // 相似度阈值，0 时使用 DefaultConsolidateThreshold
+}
+
+// ConsolidationMerge 一组相近记忆的合并方案或结果
+type ConsolidationMerge struct {
+	PersonaID     string        `json:"personaId"`
+	UserID        int64         `json:"userId"`
+	MemoryIDs     []string      `json:"memoryIds"`     // 被合并的记忆
+	Originals     []string      `json:"originals"`     // 被合并记忆的内容，与 MemoryIDs 一一对应
+	MinSimilarity float64       `json:"minSimilarity"` // 组内两两相似度的最小值
+	Merged        *model.Memory `json:"merged"`        // 合并后的记忆，试运行时没有 ID
+	Applied       bool          `json:"applied"`
+	Pending       bool          `json:"pending,omitempty"` // 审核模式下合并结果已作为候选进入审核队列，批准后才取代组内记忆
+	Skipped       string        `json:"skipped,omitempty"` // 模型认为不应合并或合并失败的原因
+}
+
+// ConsolidationReport 整理结果
+type ConsolidationReport struct {
+	DryRun     bool                 `json:"dryRun"`
+	Threshold  float64              `json:"threshold"`
+	Owners     int                  `json:"owners"`     // 处理的人格和用户组合数
+	Scanned    int                  `json:"scanned"`    // 参与聚类的活跃记忆数
+	Clusters   int                  `json:"clusters"`   // 相似度达到阈值的分组数
+	Merged     int                  `json:"merged"`     // 实际合并的分组数
+	Queued     int                  `json:"queued"`     // 审核模式下进入审核队列的分组数
+	Superseded int                  `json:"superseded"` // 被合并取代的记忆数
+	Merges     []ConsolidationMerge `json:"merges"`
+	Errors     []string             `json:"errors"`
+	StartedAt  time.Time            `json:"startedAt"`
+	FinishedAt time.Time            `json:"finishedAt"`
+}
+
+func (r *ConsolidationReport) addError(format string, args ...interface{}) {
+	if len(r.Errors) < reindexSampleLimit {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// memoryCluster 聚类得到的一组相近记忆
+type memoryCluster struct {
+	memories      []model.Memory
+	minSimilarity float64
+}
+
+// mergedMemoryDraft 模型给出的合并结果
+type mergedMemoryDraft struct {
+	Merge      bool   `json:"merge"`
+	Reason     string `json:"reason"`
+	Type       string `json:"type"`
+	Content    string `json:"content"`
+	Keywords   string `json:"keywords"`
+	Importance int    `json:"importance"`
+}
+
+// StartConsolidationScheduler 启动后台定期整理所有人格和用户的记忆；interval <= 0 时不启动
+func (s *MemoryService) StartConsolidationScheduler(ctx context.Context, interval time.Duration, threshold float64) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := s.Consolidate(ctx, ConsolidateOptions{Threshold: threshold}); err != nil {
+					utils.Log.Error("定期整理记忆失败", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// ConsolidationPayload 整理任务的参数
+type ConsolidationPayload struct {
+	DryRun    bool    `json:"dryRun"`
+	Threshold float64 `json:"threshold"`
+}
+
+// RequestConsolidation 安排一次该人格和用户的记忆整理任务，整理报告在任务成功后写入任务的 result；
+// 已有参数相同的未完成整理任务时直接返回该任务
+func (s *MemoryService) RequestConsolidation(personaId string, userId int64, payload ConsolidationPayload) (*model.MemoryJob, error) {
+	if s.jobRepo == nil {
+		return nil, fmt.Errorf("memory job repository is nil")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := s.jobRepo.GetLatestJob(personaId, userId, model.MemoryJobTypeConsolidate)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.Payload == string(data) &&
+		(latest.Status == model.MemoryJobStatusPending || latest.Status == model.MemoryJobStatusRunning) {
+		return latest, nil
+	}
+	job := &model.MemoryJob{
+		Type:        model.MemoryJobTypeConsolidate,
+		PersonaID:   personaId,
+		UserID:      userId,
+		Payload:     string(data),
+		Status:      model.MemoryJobStatusPending,
+		MaxAttempts: ExtractMaxAttempts,
+		NextRunAt:   time.Now(),
+	}
+	if err := s.jobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetMemoryJob 获取属于该人格和用户的记忆任务
+func (s *MemoryService) GetMemoryJob(jobId, personaId string, userId int64) (*model.MemoryJob, error) {
+	job, err := s.jobRepo.GetJobById(jobId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.PersonaID != personaId || job.UserID != userId {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Consolidate 整理记忆：按向量相似度把每个人格和用户的活跃记忆分组，交给模型合并每组，
+// 合并后的记忆取代组内所有记忆，并继承它们的来源消息和命中统计；人格开启审核模式时合并结果先进入审核队列。
+// 试运行只给出合并方案。
+func (s *MemoryService) Consolidate(ctx context.Context, opts ConsolidateOptions) (*ConsolidationReport, error) {
+	if s.embedding == nil {
+		return nil, fmt.Errorf("embedding disabled")
+	}
+	if opts.Threshold <= 0 || opts.Threshold > 1 {
+		opts.Threshold = DefaultConsolidateThreshold
+	}
+	report := &ConsolidationReport{DryRun: opts.DryRun, Threshold: opts.Threshold, Merges: []ConsolidationMerge{}, Errors: []string{}, StartedAt: time.Now()}
+
+	owners, err := s.memoryRepo.GetActiveMemoryOwners(opts.PersonaID, opts.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("读取记忆归属失败: %w", err)
+	}
+	for _, owner := range owners {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		report.Owners++
+		s.consolidateOwner(ctx, owner.PersonaID, owner.UserID, opts, report)
+	}
+
+	report.FinishedAt = time.Now()
+	utils.Log.Info("记忆整理完成",
+		zap.String("personaId", opts.PersonaID),
+		zap.Int64("userId", opts.UserID),
+		zap.Bool("dryRun", opts.DryRun),
+		zap.Int("scanned", report.Scanned),
+		zap.Int("clusters", report.Clusters),
+		zap.Int("merged", report.Merged),
+		zap.Int("queued", report.Queued),
+		zap.Int("superseded", report.Superseded),
+		zap.Int("errors", len(report.Errors)),
+	)
+	return report, nil
+}
+
+// consolidateOwner 整理一个人格和用户的记忆
+func (s *MemoryService) consolidateOwner(ctx context.Context, personaId string, userId int64, opts ConsolidateOptions, report *ConsolidationReport) {
+	memories, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
+	if err != nil {
+		report.addError("load memories of %s/%d: %v", personaId, userId, err)
+		return
+	}
+	memories = withoutReflections(memories)
+	// 已有待审核候选涉及的记忆不再合并，避免审核队列中出现重复的合并方案
+	queued, err := s.memoryRepo.GetMemoriesByStatus(personaId, userId, model.MemoryStatusPendingReview)
+	if err != nil {
+		report.addError("load pending review of %s/%d: %v", personaId, userId, err)
+		return
+	}
+	inReview := make(map[string]bool)
+	for _, mem := range queued {
+		for _, id := range mem.ReplacedIDs() {
+			inReview[id] = true
+		}
+	}
+	review := s.reviewModeEnabled(personaId)
+	vectors, err := s.consolidationVectors(ctx, memories)
+	if err != nil {
+		report.addError("embed memories of %s/%d: %v", personaId, userId, err)
+		return
+	}
+	report.Scanned += len(memories)
+
+	for _, cluster := range clusterMemories(memories, vectors, opts.Threshold) {
+		report.Clusters++
+		merge := ConsolidationMerge{
+			PersonaID:     personaId,
+			UserID:        userId,
+			MinSimilarity: cluster.minSimilarity,
+		}
+		pending := false
+		for _, mem := range cluster.memories {
+			merge.MemoryIDs = append(merge.MemoryIDs, mem.ID)
+			merge.Originals = append(merge.Originals, mem.Content)
+			pending = pending || inReview[mem.ID]
+		}
+		if pending {
+			merge.Skipped = "pending review"
+			report.Merges = append(report.Merges, merge)
+			continue
+		}
+
+		draft, err := s.callLLMMergeMemories(ctx, cluster.memories)
+		if err != nil {
+			merge.Skipped = "merge failed"
+			report.addError("merge %v: %v", merge.MemoryIDs, err)
+			report.Merges = append(report.Merges, merge)
+			continue
+		}
+		if !draft.Merge {
+			merge.Skipped = "not duplicates: " + draft.Reason
+			report.Merges = append(report.Merges, merge)
+			continue
+		}
+		merge.Merged = mergedMemory(personaId, userId, cluster.memories, draft)
+		if !opts.DryRun {
+			if err := s.applyMerge(ctx, merge.Merged, cluster.memories, review); err != nil {
+				merge.Skipped = "apply failed"
+				report.addError("apply merge %v: %v", merge.MemoryIDs, err)
+			} else if review {
+				merge.Pending = true
+				report.Queued++
+			} else {
+				merge.Applied = true
+				report.Merged++
+				report.Superseded += len(cluster.memories)
+			}
+		}
+		report.Merges = append(report.Merges, merge)
+	}
+}
+
+// consolidationVectors 取记忆的向量，缺失或过期的批量重新生成（只用于聚类，不写回）
+func (s *MemoryService) consolidationVectors(ctx context.Context, memories []model.Memory) ([][]float32, error) {
+	vectors := make([][]float32, len(memories))
+	var missing []int
+	for i := range memories {
+		if vec, fresh := s.freshEmbedding(&memories[i]); fresh {
+			vectors[i] = vec
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	for start := 0; start < len(missing); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(missing))
+		inputs := make([]string, 0, end-start)
+		for _, i := range missing[start:end] {
+			inputs = append(inputs, memories[i].Content)
+		}
+		embeddings, err := s.embedding.EmbedBatch(ctx, inputs)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range missing[start:end] {
+			vectors[i] = embeddings[j]
+		}
+	}
+	return vectors, nil
+}
+
+// clusterMemories 全连接聚类：按创建时间依次以未分组的记忆为起点，只吸收与组内每条记忆的相似度都达到阈值的记忆，
+// 避免单连接聚类把话题逐渐漂移的记忆串成一组。只返回至少两条记忆的分组。
+func clusterMemories(memories []model.Memory, vectors [][]float32, threshold float64) []memoryCluster {
+	order := make([]int, len(memories))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ma, mb := memories[order[a]], memories[order[b]]
+		if !ma.CreatedAt.Equal(mb.CreatedAt) {
+			return ma.CreatedAt.Before(mb.CreatedAt)
+		}
+		return ma.ID < mb.ID
+	})
+
+	assigned := make([]bool, len(memories))
+	clusters := make([]memoryCluster, 0)
+	for _, seed := range order {
+		if assigned[seed] {
+			continue
+		}
+		members := []int{seed}
+		minSimilarity := 1.0
+		for _, candidate := range order {
+			if candidate == seed || assigned[candidate] || len(members) >= consolidateMaxClusterSize {
+				continue
+			}
+			lowest := 1.0
+			for _, member := range members {
+				lowest = min(lowest, float64(cosineSimilarity(vectors[member], vectors[candidate])))
+			}
+			if lowest >= threshold {
+				members = append(members, candidate)
+				minSimilarity = min(minSimilarity, lowest)
+			}
+		}
+		if len(members) < 2 {
+			continue
+		}
+		cluster := memoryCluster{minSimilarity: minSimilarity}
+		for _, member := range members {
+			assigned[member] = true
+			cluster.memories = append(cluster.memories, memories[member])
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
+
+// mergedMemory 按模型的合并结果构建新记忆：类型不合法时沿用组内第一条的类型，
+// 重要度未给出时取组内最高，命中统计取组内之和和最近一次命中
+func mergedMemory(personaId string, userId int64, cluster []model.Memory, draft *mergedMemoryDraft) *model.Memory {
+	merged := &model.Memory{
+		PersonaID: personaId,
+		UserID:    userId,
+		Type:      strings.ToLower(strings.TrimSpace(draft.Type)),
+		Content:   strings.TrimSpace(draft.Content),
+		Keywords:  strings.TrimSpace(draft.Keywords),
+		Source:    model.MemorySourceConsolidated,
+		Status:    model.MemoryStatusActive,
+	}
+	if !model.IsValidMemoryType(merged.Type) {
+		merged.Type = cluster[0].Type
+	}
+	if runes := []rune(merged.Keywords); len(runes) > maxKeywordsRunes {
+		merged.Keywords = string(runes[:maxKeywordsRunes])
+	}
+	importance := 0
+	for _, mem := range cluster {
+		importance = max(importance, model.ClampImportance(mem.Importance))
+		merged.HitCount += mem.HitCount
+		if mem.LastHitAt != nil && (merged.LastHitAt == nil || mem.LastHitAt.After(*merged.LastHitAt)) {
+			merged.LastHitAt = mem.LastHitAt
+		}
+	}
+	if draft.Importance != 0 {
+		importance = draft.Importance
+	}
+	merged.Importance = model.ClampImportance(importance)
+	return merged
+}
+
+// applyMerge 写入合并后的记忆并取代组内所有记忆，来源消息取组内所有记忆的来源；提交后同步向量存储。
+// review 为 true 时写为待审核的候选，批准后才取代组内记忆，批准前不写入向量存储
+func (s *MemoryService) applyMerge(ctx context.Context, merged *model.Memory, cluster []model.Memory, review bool) error {
+	ids := make([]string, 0, len(cluster))
+	for _, mem := range cluster {
+		ids = append(ids, mem.ID)
+	}
+	sources, err := s.memoryRepo.GetMemorySources(ids)
+	if err != nil {
+		return fmt.Errorf("读取记忆来源失败: %w", err)
+	}
+	s.PrepareMemoryEmbedding(ctx, merged)
+	if review {
+		merged.Status = model.MemoryStatusPendingReview
+		merged.ReplacesID = ids[0]
+		merged.MergedIDs = ids
+		return s.memoryRepo.CreateMemoryWithSources(merged, sources)
+	}
+	if err := s.memoryRepo.CreateMergedMemory(merged, ids, sources); err != nil {
+		if errors.Is(err, repository.ErrMemoryNotActive) {
+			return fmt.Errorf("组内记忆已被修改: %w", err)
+		}
+		return err
+	}
+	s.UpsertMemoryVector(ctx, merged)
+	s.DeleteMemoryVector(ctx, ids...)
+	return nil
+}
+
+// callLLMMergeMemories 让模型判断一组相近记忆是否重复，重复时给出合并后的内容
+func (s *MemoryService) callLLMMergeMemories(ctx context.Context, cluster []model.Memory) (*mergedMemoryDraft, error) {
+	cm, err := ai_config.NewChatModel(ctx, ai_config.MemoryChatProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	memoriesText := ""
+	for _, mem := range cluster {
+		memoriesText += fmt.Sprintf("- [%s] 类型: %s, 重要度: %d, 内容: %s\n", mem.ID, mem.Type, model.ClampImportance(mem.Importance), mem.Content)
+	}
+	systemPrompt := `你是记忆整理助手。下面是同一用户的几条相近记忆，请判断它们是否描述同一件事或可以合并为一条。
+
+【要求】
+1. 如果它们重复、重叠或互为补充，merge 为 true，并给出合并后的一条精炼记忆：保留所有不冲突的细节；有冲突时以更新、更具体的说法为准。
+2. 如果它们描述的是不同的事情，merge 为 false，并在 reason 中简要说明。
+3. type 只能是 fact、preference、event、emotion、relationship 之一；importance 为 1~10 的整数。
+
+请调用 submit_merged_memory 工具提交结果；无法调用工具时，只输出如下格式的 JSON，不要附加其他文字:
+{"merge": true, "reason": "", "type": "preference", "content": "合并后的内容", "keywords": "...", "importance": 6}`
+
+	messages := []*schema.Message{
+		{Role: schema.System, Content: systemPrompt},
+		{Role: schema.User, Content: "【相近记忆】\n" + memoriesText},
+	}
+	resp, err := generateStructured(ctx, cm, messages, mergedMemoryToolInfo())
+	if err != nil {
+		return nil, err
+	}
+	return parseMergedMemory(resp)
+}
+
+// mergedMemoryToolInfo 提交合并结果的工具定义
+func mergedMemoryToolInfo() *schema.ToolInfo {
+	return &schema.ToolInfo{
+		Name: submitMergedMemoryTool,
+		Desc: "提交一组相近记忆的合并结果",
+		ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+			"merge":      {Type: schema.Boolean, Desc: "是否合并", Required: true},
+			"reason":     {Type: schema.String, Desc: "不合并的原因"},
+			"type":       {Type: schema.String, Enum: model.MemoryTypes, Desc: "合并后的记忆类型"},
+			"content":    {Type: schema.String, Desc: "合并后的记忆内容，merge 为 true 时必填"},
+			"keywords":   {Type: schema.String, Desc: "检索关键词，空格分隔"},
+			"importance": {Type: schema.Integer, Desc: "重要度 1~10"},
+		}),
+	}
+}
+
+// parseMergedMemory 解析合并结果；合并但内容为空时视为失败
+func parseMergedMemory(resp *schema.Message) (*mergedMemoryDraft, error) {
+	if resp == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+	payload := strings.TrimSpace(structuredPayload(resp, submitMergedMemoryTool))
+	for _, candidate := range jsonCandidates(payload) {
+		var loose struct {
+			Merge      interface{} `json:"merge"`
+			Reason     string      `json:"reason"`
+			Type       string      `json:"type"`
+			Content    string      `json:"content"`
+			Keywords   string      `json:"keywords"`
+			Importance interface{} `json:"importance"`
+		}
+		if err := json.Unmarshal([]byte(candidate), &loose); err != nil {
+			continue
+		}
+		draft := &mergedMemoryDraft{
+			Reason:     loose.Reason,
+			Type:       loose.Type,
+			Content:    strings.TrimSpace(loose.Content),
+			Keywords:   loose.Keywords,
+			Importance: looseInt(loose.Importance),
+		}
+		switch merge := loose.Merge.(type) {
+		case bool:
+			draft.Merge = merge
+		case string:
+			draft.Merge = strings.EqualFold(strings.TrimSpace(merge), "true")
+		}
+		if draft.Merge && draft.Content == "" {
+			return nil, fmt.Errorf("merged content is empty")
+		}
+		return draft, nil
+	}
+	return nil, fmt.Errorf("no valid merge JSON in output: %.200s", payload)
+}
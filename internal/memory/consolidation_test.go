@@ -0,0 +1,194 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+)
+
+func TestConsolidateMergesNearDuplicates(t *testing.T) {
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+		Name:   "consolidate-test",
+		Type:   ai_config.ProviderFake,
+		Script: []string{`{"merge": true, "type": "preference", "content": "用户喜欢喝咖啡，尤其是拿铁", "keywords": "咖啡 拿铁", "importance": 6}`},
+	})
+	previous := ai_config.MemoryChatProvider
+	ai_config.MemoryChatProvider = "consolidate-test"
+	t.Cleanup(func() { ai_config.MemoryChatProvider = previous })
+
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(64),
+		vectorStore: store,
+	}
+	create := func(content string, hits int) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypePreference, Content: content, HitCount: hits, Status: model.MemoryStatusActive}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemoryWithSources(mem, []model.MemorySourceMessage{{MessageID: "msg:" + content}}); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		return mem
+	}
+	coffee := create("用户喜欢喝咖啡", 2)
+	latte := create("用户喜欢喝咖啡拿铁", 3)
+	trip := create("下周要去日本大阪出差", 0)
+
+	opts := ConsolidateOptions{PersonaID: "per:a", UserID: 1, Threshold: 0.6, DryRun: true}
+	report, err := s.Consolidate(ctx, opts)
+	if err != nil || report.Clusters != 1 || report.Merged != 0 || len(report.Merges) != 1 {
+		t.Fatalf("unexpected dry run report: %+v, %v", report, err)
+	}
+	proposal := report.Merges[0]
+	if len(proposal.MemoryIDs) != 2 || proposal.Merged == nil || proposal.Merged.ID != "" || proposal.Applied {
+		t.Fatalf("unexpected proposal: %+v", proposal)
+	}
+	if active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1); len(active) != 3 {
+		t.Fatalf("dry run should not modify memories, active = %d", len(active))
+	}
+
+	opts.DryRun = false
+	report, err = s.Consolidate(ctx, opts)
+	if err != nil || report.Merged != 1 || report.Superseded != 2 {
+		t.Fatalf("unexpected report: %+v, %v", report, err)
+	}
+	merged := report.Merges[0].Merged
+	if merged.Source != model.MemorySourceConsolidated || merged.HitCount != 5 || merged.Content != "用户喜欢喝咖啡，尤其是拿铁" {
+		t.Fatalf("unexpected merged memory: %+v", merged)
+	}
+
+	// 被合并的记忆进入版本链，来源消息由合并后的记忆继承
+	lineage, err := s.GetMemoryLineage("per:a", 1, coffee.ID)
+	if err != nil || lineage.CurrentID != merged.ID || len(lineage.Versions) != 3 {
+		t.Fatalf("unexpected lineage: %+v, %v", lineage, err)
+	}
+	sources, _ := s.memoryRepo.GetMemorySources([]string{merged.ID})
+	if len(sources) != 2 {
+		t.Fatalf("expected merged memory to inherit 2 sources, got %+v", sources)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	indexed := map[string]bool{}
+	for _, id := range ids {
+		indexed[id] = true
+	}
+	if len(ids) != 2 || !indexed[merged.ID] || !indexed[trip.ID] || indexed[latte.ID] {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+}
+
+func TestConsolidateJobInReviewMode(t *testing.T) {
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+		Name:   "consolidate-review-test",
+		Type:   ai_config.ProviderFake,
+		Script: []string{`{"merge": true, "type": "preference", "content": "用户喜欢喝咖啡，尤其是拿铁", "keywords": "咖啡 拿铁", "importance": 6}`},
+	})
+	previous := ai_config.MemoryChatProvider
+	ai_config.MemoryChatProvider = "consolidate-review-test"
+	t.Cleanup(func() { ai_config.MemoryChatProvider = previous })
+
+	ctx := context.Background()
+	db := newTestDB(t, &model.Persona{}, &model.PersonaVersion{}, &model.MemoryJob{})
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  repository.NewMemoryRepository(db),
+		jobRepo:     repository.NewMemoryJobRepository(db),
+		personaRepo: repository.NewPersonaRepository(db),
+		embedding:   NewHashEmbedder(64),
+		vectorStore: store,
+	}
+	persona := &model.Persona{UserID: 1, Name: "小雪", PersonaModelSettings: model.PersonaModelSettings{MemoryReviewMode: true}}
+	if err := s.personaRepo.CreatePersona(persona); err != nil {
+		t.Fatalf("create persona failed: %v", err)
+	}
+	create := func(content string) *model.Memory {
+		mem := &model.Memory{PersonaID: persona.ID, UserID: 1, Type: model.MemoryTypePreference, Content: content, Status: model.MemoryStatusActive}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemoryWithSources(mem, []model.MemorySourceMessage{{MessageID: "msg:" + content}}); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		return mem
+	}
+	coffee := create("用户喜欢喝咖啡")
+	latte := create("用户喜欢喝咖啡拿铁")
+	trip := create("下周要去日本大阪出差")
+
+	// 整理以任务形式执行，参数相同的未完成任务不重复安排
+	payload := ConsolidationPayload{Threshold: 0.6}
+	job, err := s.RequestConsolidation(persona.ID, 1, payload)
+	if err != nil || job.Type != model.MemoryJobTypeConsolidate {
+		t.Fatalf("request consolidation failed: %+v, %v", job, err)
+	}
+	if again, _ := s.RequestConsolidation(persona.ID, 1, payload); again.ID != job.ID {
+		t.Fatalf("open consolidation job should be reused")
+	}
+	claimed, err := s.jobRepo.ClaimNextJob("w")
+	if err != nil || claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claim failed: %+v, %v", claimed, err)
+	}
+	s.executeJob(ctx, claimed)
+
+	done, err := s.GetMemoryJob(job.ID, persona.ID, 1)
+	if err != nil || done.Status != model.MemoryJobStatusSucceeded {
+		t.Fatalf("job should succeed: %+v, %v", done, err)
+	}
+	var report ConsolidationReport
+	if err := json.Unmarshal(done.Result, &report); err != nil {
+		t.Fatalf("job result should be the report: %v", err)
+	}
+	if report.Queued != 1 || report.Merged != 0 || report.Superseded != 0 || !report.Merges[0].Pending {
+		t.Fatalf("merge should be queued for review: %+v", report)
+	}
+	if _, err := s.GetMemoryJob(job.ID, "per:other", 1); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("job of another persona should not be found, got %v", err)
+	}
+
+	// 审核模式下合并结果是待审核的候选，原记忆和向量保持不变
+	if active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(persona.ID, 1); len(active) != 3 {
+		t.Fatalf("originals should stay active before review, active = %d", len(active))
+	}
+	pending, _ := s.ListPendingReview(persona.ID, 1)
+	if len(pending) != 1 {
+		t.Fatalf("expected one merge candidate, got %d", len(pending))
+	}
+	candidate := pending[0]
+	if candidate.Source != model.MemorySourceConsolidated || candidate.ReplacesID != coffee.ID ||
+		len(candidate.MergedIDs) != 2 || candidate.MergedIDs[1] != latte.ID {
+		t.Fatalf("unexpected merge candidate: %+v", candidate)
+	}
+	if ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: persona.ID}); len(ids) != 3 {
+		t.Fatalf("candidate should not be indexed before review: %v", ids)
+	}
+
+	// 已在审核中的记忆不重复合并
+	report2, err := s.Consolidate(ctx, ConsolidateOptions{PersonaID: persona.ID, UserID: 1, Threshold: 0.6})
+	if err != nil || report2.Queued != 0 || len(report2.Merges) != 1 || report2.Merges[0].Skipped != "pending review" {
+		t.Fatalf("memories under review should be skipped: %+v, %v", report2, err)
+	}
+
+	// 批准后合并结果取代组内所有记忆
+	result, err := s.ApproveMemories(ctx, persona.ID, 1, []string{candidate.ID})
+	if err != nil || len(result.Approved) != 1 || len(result.Superseded) != 2 {
+		t.Fatalf("unexpected approve result: %+v, %v", result, err)
+	}
+	for _, id := range []string{coffee.ID, latte.ID} {
+		if got, _ := s.memoryRepo.GetMemoryById(id); got.Status != model.MemoryStatusSuperseded || got.SupersededBy != candidate.ID {
+			t.Fatalf("original should be superseded by the merge: %+v", got)
+		}
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: persona.ID})
+	indexed := map[string]bool{}
+	for _, id := range ids {
+		indexed[id] = true
+	}
+	if len(ids) != 2 || !indexed[candidate.ID] || !indexed[trip.ID] {
+		t.Fatalf("unexpected vector ids after approval: %v", ids)
+	}
+}
@@ -0,0 +1,377 @@
+package memory
+
+import (
+	"AI_Chat/pkg/ai_config"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Embedder 文本向量化
+type Embedder interface {
+	// Embed 向量化单条文本
+	Embed(ctx context.Context, input string) ([]float32, error)
+	// EmbedBatch 批量向量化，返回结果与输入一一对应
+	EmbedBatch(ctx context.Context, inputs []string) ([][]float32, error)
+	// Dimension 输出向量的维度
+	Dimension() int
+}
+
+// NewEmbedder 按配置创建向量化实现。OpenAI 兼容接口未配置 api_key 或 model 时返回 nil，记忆检索回退到数据库。
+func NewEmbedder(cfg ai_config.EmbeddingProviderConfig) (Embedder, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", ai_config.EmbeddingOpenAI:
+		client := newOpenAIEmbedder(cfg)
+		if client == nil {
+			return nil, nil
+		}
+		return client, nil
+	case ai_config.EmbeddingOllama:
+		return newOllamaEmbedder(cfg), nil
+	case ai_config.EmbeddingHash:
+		return NewHashEmbedder(cfg.GetDimension()), nil
+	default:
+		return nil, fmt.Errorf("unknown embedding type %q", cfg.Type)
+	}
+}
+
+// CheckEmbeddingDimension 启动时检查向量化维度与向量存储是否一致
+func CheckEmbeddingDimension(ctx context.Context, embedder Embedder, store VectorStore) error {
+	if embedder == nil || store == nil {
+		return nil
+	}
+	if milvusStore, ok := store.(*MilvusStore); ok {
+		if err := milvusStore.CheckDimension(ctx); err != nil {
+			return err
+		}
+	}
+	if dim := store.Dimension(); dim != 0 && dim != embedder.Dimension() {
+		return fmt.Errorf("embedding dimension %d does not match vector store dimension %d", embedder.Dimension(), dim)
+	}
+	return nil
+}
+
+// embedInBatches 按 batchSize 拆分后依次调用 embed
+func embedInBatches(ctx context.Context, inputs []string, batchSize int, embed func(context.Context, []string) ([][]float32, error)) ([][]float32, error) {
+	result := make([][]float32, 0, len(inputs))
+	for start := 0; start < len(inputs); start += batchSize {
+		end := start + batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		vectors, err := embed(ctx, inputs[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(vectors) != end-start {
+			return nil, fmt.Errorf("embedding response count mismatch: %d vs %d", len(vectors), end-start)
+		}
+		result = append(result, vectors...)
+	}
+	return result, nil
+}
+
+func checkEmbeddingInputs(inputs []string) error {
+	for _, input := range inputs {
+		if strings.TrimSpace(input) == "" {
+			return fmt.Errorf("embedding input is empty")
+		}
+	}
+	return nil
+}
+
+// EmbeddingClient OpenAI 兼容的 /v1/embeddings 接口
+type EmbeddingClient struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	dimension  int
+	batchSize  int
+	httpClient *http.Client
+}
+
+// NewEmbeddingClient 使用 DeepSeek 段的 embedding 配置创建客户端，维度为默认值
+func NewEmbeddingClient(config ai_config.DeepSeekEmbeddingModel) *EmbeddingClient {
+	return newOpenAIEmbedder(ai_config.EmbeddingProviderConfig{
+		Type:    ai_config.EmbeddingOpenAI,
+		Model:   config.Model,
+		BaseURL: config.BaseURL,
+		APIKey:  config.APIKey,
+	})
+}
+
+func newOpenAIEmbedder(config ai_config.EmbeddingProviderConfig) *EmbeddingClient {
+	if strings.TrimSpace(config.APIKey) == "" || strings.TrimSpace(config.Model) == "" {
+		return nil
+	}
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = "https://api.deepseek.com"
+	}
+	return &EmbeddingClient{
+		baseURL:   baseURL,
+		apiKey:    config.APIKey,
+		model:     config.Model,
+		dimension: config.GetDimension(),
+		batchSize: config.GetBatchSize(),
+		httpClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+func (c *EmbeddingClient) Dimension() int {
+	return c.dimension
+}
+
+func (c *EmbeddingClient) Embed(ctx context.Context, input string) ([]float32, error) {
+	if c == nil {
+		return nil, fmt.Errorf("embedding client is nil")
+	}
+	vectors, err := c.EmbedBatch(ctx, []string{input})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (c *EmbeddingClient) EmbedBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	if c == nil {
+		return nil, fmt.Errorf("embedding client is nil")
+	}
+	if err := checkEmbeddingInputs(inputs); err != nil {
+		return nil, err
+	}
+	return embedInBatches(ctx, inputs, c.batchSize, c.embedRequest)
+}
+
+func (c *EmbeddingClient) embedRequest(ctx context.Context, inputs []string) ([][]float32, error) {
+	reqBody := map[string]interface{}{
+		"model":      c.model,
+		"input":      inputs,
+		"dimensions": c.dimension,
+	}
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := postModelJSON(ctx, c.httpClient, c.baseURL+"/v1/embeddings", c.apiKey, c.model, reqBody, &parsed); err != nil {
+		return nil, err
+	}
+	if len(parsed.Data) != len(inputs) {
+		return nil, fmt.Errorf("embedding response empty")
+	}
+
+	result := make([][]float32, len(inputs))
+	for i, item := range parsed.Data {
+		// 按 index 放回对应位置，未返回 index 时按顺序
+		pos := i
+		if item.Index >= 0 && item.Index < len(inputs) && result[item.Index] == nil {
+			pos = item.Index
+		}
+		vec, err := toFloat32Vector(item.Embedding, c.dimension)
+		if err != nil {
+			return nil, err
+		}
+		result[pos] = vec
+	}
+	return result, nil
+}
+
+// OllamaEmbedder Ollama 的 /api/embed 接口
+type OllamaEmbedder struct {
+	baseURL    string
+	model      string
+	dimension  int
+	batchSize  int
+	httpClient *http.Client
+}
+
+func newOllamaEmbedder(config ai_config.EmbeddingProviderConfig) *OllamaEmbedder {
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &OllamaEmbedder{
+		baseURL:   baseURL,
+		model:     config.Model,
+		dimension: config.GetDimension(),
+		batchSize: config.GetBatchSize(),
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+func (e *OllamaEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, input string) ([]float32, error) {
+	vectors, err := e.EmbedBatch(ctx, []string{input})
+	if err != nil {
+		return nil, err
+	}
+	return vectors[0], nil
+}
+
+func (e *OllamaEmbedder) EmbedBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	if err := checkEmbeddingInputs(inputs); err != nil {
+		return nil, err
+	}
+	return embedInBatches(ctx, inputs, e.batchSize, e.embedRequest)
+}
+
+func (e *OllamaEmbedder) embedRequest(ctx context.Context, inputs []string) ([][]float32, error) {
+	reqBody := map[string]interface{}{
+		"model": e.model,
+		"input": inputs,
+	}
+	var parsed struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := postModelJSON(ctx, e.httpClient, e.baseURL+"/api/embed", "", e.model, reqBody, &parsed); err != nil {
+		return nil, err
+	}
+	if len(parsed.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("embedding response empty")
+	}
+	result := make([][]float32, 0, len(inputs))
+	for _, embedding := range parsed.Embeddings {
+		vec, err := toFloat32Vector(embedding, e.dimension)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, vec)
+	}
+	return result, nil
+}
+
+// postModelJSON 向模型服务发送 JSON 请求并解析响应，向量化和重排序共用
+func postModelJSON(ctx context.Context, httpClient *http.Client, url, apiKey, model string, reqBody interface{}, out interface{}) error {
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		bodyText := strings.TrimSpace(string(bodyBytes))
+		if len(bodyText) > 1000 {
+			bodyText = bodyText[:1000] + "..."
+		}
+		return fmt.Errorf("request failed: status=%d url=%s model=%s body=%s", resp.StatusCode, url, model, bodyText)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func toFloat32Vector(embedding []float64, dimension int) ([]float32, error) {
+	if len(embedding) == 0 {
+		return nil, fmt.Errorf("embedding response empty")
+	}
+	if dimension > 0 && len(embedding) != dimension {
+		return nil, fmt.Errorf("embedding dim mismatch: configured %d, got %d", dimension, len(embedding))
+	}
+	result := make([]float32, 0, len(embedding))
+	for _, v := range embedding {
+		result = append(result, float32(v))
+	}
+	return result, nil
+}
+
+// HashEmbedder 本地哈希向量：把文本的字符 1-gram 和 2-gram 哈希到固定维度后归一化。
+// 不理解语义，但相同输入总得到相同向量、有字面重叠的文本相似度更高，适合离线开发和测试。
+type HashEmbedder struct {
+	dimension int
+}
+
+// NewHashEmbedder 创建本地哈希向量化，dimension <= 0 时使用默认维度
+func NewHashEmbedder(dimension int) *HashEmbedder {
+	if dimension <= 0 {
+		dimension = ai_config.DefaultEmbeddingDimension
+	}
+	return &HashEmbedder{dimension: dimension}
+}
+
+func (e *HashEmbedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, input string) ([]float32, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, fmt.Errorf("embedding input is empty")
+	}
+	runes := make([]rune, 0, len(input))
+	for _, r := range strings.ToLower(input) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) {
+			continue
+		}
+		runes = append(runes, r)
+	}
+	vec := make([]float32, e.dimension)
+	for i := range runes {
+		e.add(vec, string(runes[i]), 1)
+		if i+1 < len(runes) {
+			e.add(vec, string(runes[i:i+2]), 1.5)
+		}
+	}
+	var norm float64
+	for _, v := range vec {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vec, nil
+	}
+	scale := float32(1 / math.Sqrt(norm))
+	for i := range vec {
+		vec[i] *= scale
+	}
+	return vec, nil
+}
+
+func (e *HashEmbedder) EmbedBatch(ctx context.Context, inputs []string) ([][]float32, error) {
+	result := make([][]float32, 0, len(inputs))
+	for _, input := range inputs {
+		vec, err := e.Embed(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, vec)
+	}
+	return result, nil
+}
+
+// add 把一个 n-gram 累加到向量上，用哈希的最高位决定符号以减小碰撞带来的偏差
+func (e *HashEmbedder) add(vec []float32, gram string, weight float32) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(gram))
+	sum := h.Sum32()
+	if sum&(1<<31) != 0 {
+		weight = -weight
+	}
+	vec[int(sum&0x7fffffff)%e.dimension] += weight
+}
@@ -34,3 +34,24 @@ func TestDeepSeekEmbedding(t *testing.T) {
 		t.Fatalf("embedding vector is empty")
 	}
 }
+
+func TestHashEmbedder(t *testing.T) {
+	ctx := context.Background()
+	embedder := NewHashEmbedder(64)
+
+	vectors, err := embedder.EmbedBatch(ctx, []string{"我养了一只猫", "我养了一只猫", "明天要去跑步"})
+	if err != nil {
+		t.Fatalf("embed failed: %v", err)
+	}
+	if len(vectors) != 3 || len(vectors[0]) != 64 {
+		t.Fatalf("unexpected vectors shape: %d x %d", len(vectors), len(vectors[0]))
+	}
+	if sim := cosineSimilarity(vectors[0], vectors[1]); sim < 0.999 {
+		t.Fatalf("same input should give same vector, similarity %f", sim)
+	}
+
+	query, _ := embedder.Embed(ctx, "我的猫")
+	if cosineSimilarity(query, vectors[0]) <= cosineSimilarity(query, vectors[2]) {
+		t.Fatalf("overlapping text should be more similar")
+	}
+}
@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	ExtractWorkerCount     = 2                // 提取 worker 数量
+	ExtractMaxAttempts     = 5                // 最大尝试次数，超过后进入死信
+	ExtractRetryBaseDelay  = 30 * time.Second // 第一次重试的等待时间，之后指数增长
+	ExtractRetryMaxDelay   = time.Hour        // 重试等待时间上限
+	ExtractJobPollInterval = 2 * time.Second  // 队列为空时的轮询间隔
+	ExtractJobLockTimeout  = 10 * time.Minute // 运行中的任务超过该时间未完成视为 worker 已崩溃
+	ExtractJobTimeout      = 5 * time.Minute  // 单个任务的执行超时
+
+	DefaultIdleExtractAfter = 30 * time.Minute // 会话空闲多久后提取未满阈值的轮次
+	DefaultIdleScanInterval = time.Minute      // 空闲会话的扫描间隔
+	idleExtractBatchSize    = 100              // 每次扫描最多处理的会话数
+)
+
+// EnqueueExtraction 把待提取的消息批次写入持久化任务队列
+func (s *MemoryService) EnqueueExtraction(pending *model.PendingMessages) (*model.MemoryJob, error) {
+	if s.jobRepo == nil {
+		return nil, fmt.Errorf("memory job repository is nil")
+	}
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		return nil, err
+	}
+	job := &model.MemoryJob{
+		Type:           model.MemoryJobTypeExtract,
+		ConversationID: pending.ConversationID,
+		PersonaID:      pending.PersonaID,
+		UserID:         pending.UserID,
+		Payload:        string(payload),
+		Status:         model.MemoryJobStatusPending,
+		MaxAttempts:    ExtractMaxAttempts,
+		NextRunAt:      time.Now(),
+	}
+	if err := s.jobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// StartExtractionWorkers 启动提取 worker，并定期回收锁定超时的任务。ctx 取消后 worker 退出。
+func (s *MemoryService) StartExtractionWorkers(ctx context.Context, workers int) {
+	if s.jobRepo == nil || workers <= 0 {
+		return
+	}
+	hostname, _ := os.Hostname()
+	for i := 0; i < workers; i++ {
+		workerId := fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), uuid.New().String()[:8])
+		go s.runExtractionWorker(ctx, workerId)
+	}
+	go s.runStaleJobReaper(ctx)
+}
+
+func (s *MemoryService) runExtractionWorker(ctx context.Context, workerId string) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job, err := s.jobRepo.ClaimNextJob(workerId)
+		if err != nil {
+			utils.Log.Error("领取记忆任务失败", zap.String("worker", workerId), zap.Error(err))
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ExtractJobPollInterval):
+			}
+			continue
+		}
+		s.executeJob(ctx, job)
+	}
+}
+
+func (s *MemoryService) runStaleJobReaper(ctx context.Context) {
+	ticker := time.NewTicker(ExtractJobLockTimeout / 2)
+	defer ticker.Stop()
+	for {
+		// 启动时先回收一次，恢复上次进程退出时未完成的任务
+		count, err := s.jobRepo.RequeueStaleJobs(ExtractJobLockTimeout)
+		if err != nil {
+			utils.Log.Error("回收超时记忆任务失败", zap.Error(err))
+		} else if count > 0 {
+			utils.Log.Warn("回收超时记忆任务", zap.Int64("count", count))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// executeJob 执行任务并根据结果标记成功、安排重试或进入死信
+func (s *MemoryService) executeJob(ctx context.Context, job *model.MemoryJob) {
+	jobCtx, cancel := context.WithTimeout(ctx, ExtractJobTimeout)
+	defer cancel()
+
+	var (
+		result []byte
+		err    error
+	)
+	switch job.Type {
+	case model.MemoryJobTypeDeletePersona:
+		var payload PersonaDeletionPayload
+		if err = json.Unmarshal([]byte(job.Payload), &payload); err == nil {
+			err = s.DeletePersona(jobCtx, job.ID, job.PersonaID, job.UserID, payload.Hard)
+		}
+	case model.MemoryJobTypeExtract, model.MemoryJobTypeReflect, model.MemoryJobTypeConsolidate:
+		// 人格已删除时不再写入新记忆
+		if s.personaDeleted(job.PersonaID) {
+			utils.Log.Info("人格已删除，跳过记忆任务", zap.String("jobId", job.ID), zap.String("personaId", job.PersonaID))
+			break
+		}
+		result, err = s.executeMemoryJob(jobCtx, job)
+	default:
+		err = fmt.Errorf("unknown memory job type: %s", job.Type)
+	}
+
+	if err == nil {
+		if err := s.jobRepo.MarkSucceeded(job.ID, result); err != nil {
+			utils.Log.Error("标记记忆任务成功失败", zap.String("jobId", job.ID), zap.Error(err))
+		}
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		utils.Log.Error("记忆任务重试耗尽，进入死信",
+			zap.String("jobId", job.ID),
+			zap.Int("attempts", job.Attempts),
+			zap.Error(err),
+		)
+		if markErr := s.jobRepo.MarkDead(job.ID, err.Error()); markErr != nil {
+			utils.Log.Error("标记记忆任务死信失败", zap.String("jobId", job.ID), zap.Error(markErr))
+		}
+		return
+	}
+	delay := retryDelay(job.Attempts)
+	utils.Log.Warn("记忆任务失败，稍后重试",
+		zap.String("jobId", job.ID),
+		zap.Int("attempts", job.Attempts),
+		zap.Duration("delay", delay),
+		zap.Error(err),
+	)
+	if markErr := s.jobRepo.MarkRetry(job.ID, err.Error(), time.Now().Add(delay)); markErr != nil {
+		utils.Log.Error("安排记忆任务重试失败", zap.String("jobId", job.ID), zap.Error(markErr))
+	}
+}
+
+// executeMemoryJob 执行提取、反思和整理任务，返回需要保存的执行结果
+func (s *MemoryService) executeMemoryJob(ctx context.Context, job *model.MemoryJob) ([]byte, error) {
+	switch job.Type {
+	case model.MemoryJobTypeReflect:
+		var payload ReflectionPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return nil, err
+		}
+		_, err := s.Reflect(ctx, job.PersonaID, job.UserID, payload.Since)
+		return nil, err
+	case model.MemoryJobTypeConsolidate:
+		var payload ConsolidationPayload
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return nil, err
+		}
+		report, err := s.Consolidate(ctx, ConsolidateOptions{
+			PersonaID: job.PersonaID,
+			UserID:    job.UserID,
+			DryRun:    payload.DryRun,
+			Threshold: payload.Threshold,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(report)
+	default:
+		var pending model.PendingMessages
+		if err := json.Unmarshal([]byte(job.Payload), &pending); err != nil {
+			return nil, err
+		}
+		return nil, s.ExtractMemoriesFromPending(ctx, &pending)
+	}
+}
+
+// StartIdleExtractionScheduler 定期扫描空闲会话，把未满阈值但已停止聊天的轮次写入提取任务队列。
+// 多个实例同时运行时，取出操作是原子的，同一批轮次只会入队一次。
+func (s *MemoryService) StartIdleExtractionScheduler(ctx context.Context, idleAfter, interval time.Duration) {
+	if s.jobRepo == nil || idleAfter <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultIdleScanInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if count, err := s.extractIdleConversations(ctx, time.Now().Add(-idleAfter)); err != nil {
+				utils.Log.Error("空闲会话记忆提取失败", zap.Error(err))
+			} else if count > 0 {
+				utils.Log.Info("空闲会话已加入记忆提取队列", zap.Int("count", count))
+			}
+		}
+	}()
+}
+
+// extractIdleConversations 处理最后一轮早于 idleBefore 的会话，返回入队的任务数
+func (s *MemoryService) extractIdleConversations(ctx context.Context, idleBefore time.Time) (int, error) {
+	convIds, err := s.listIdleConversations(ctx, idleBefore, idleExtractBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, convId := range convIds {
+		pending, err := s.drainPendingRounds(ctx, convId, idleBefore)
+		if err != nil {
+			utils.Log.Error("取出空闲会话缓存失败", zap.String("conversationId", convId), zap.Error(err))
+			continue
+		}
+		if pending == nil {
+			continue
+		}
+		if _, err := s.enqueuePending(ctx, pending); err != nil {
+			utils.Log.Error("空闲会话提取任务入队失败", zap.String("conversationId", convId), zap.Error(err))
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// retryDelay 第 attempts 次失败后的等待时间：base * 2^(attempts-1)，不超过上限
+func retryDelay(attempts int) time.Duration {
+	delay := ExtractRetryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= ExtractRetryMaxDelay {
+			return ExtractRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+// ListExtractionJobs 查询某人格某用户的提取任务，status 为空时返回全部状态
+func (s *MemoryService) ListExtractionJobs(personaId string, userId int64, status string, limit int) ([]model.MemoryJob, error) {
+	return s.jobRepo.GetJobsByPersonaAndUser(personaId, userId, status, limit)
+}
+
+// RetryExtractionJob 把死信任务重新放回队列，任务必须属于该人格和用户
+func (s *MemoryService) RetryExtractionJob(jobId, personaId string, userId int64) (*model.MemoryJob, error) {
+	job, err := s.jobRepo.GetJobById(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.PersonaID != personaId || job.UserID != userId {
+		return nil, fmt.Errorf("memory job %s not found", jobId)
+	}
+	if job.Status != model.MemoryJobStatusDead {
+		return nil, fmt.Errorf("memory job %s is %s, only dead jobs can be retried", jobId, job.Status)
+	}
+	if err := s.jobRepo.RetryDeadJob(jobId); err != nil {
+		return nil, err
+	}
+	return s.jobRepo.GetJobById(jobId)
+}
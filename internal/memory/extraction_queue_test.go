@@ -0,0 +1,195 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// newTestJobService 创建只带任务队列的记忆服务，任务表使用 sqlite
+func newTestJobService(t *testing.T) (*MemoryService, *gorm.DB) {
+	t.Helper()
+	gdb := newTestDB(t, &model.MemoryJob{})
+	return &MemoryService{jobRepo: repository.NewMemoryJobRepository(gdb)}, gdb
+}
+
+// newFailingJob 创建一个载荷无法解析、执行必然失败的提取任务
+func newFailingJob(t *testing.T, s *MemoryService, maxAttempts int) *model.MemoryJob {
+	t.Helper()
+	job := &model.MemoryJob{
+		Type:        model.MemoryJobTypeExtract,
+		PersonaID:   "per:a",
+		UserID:      1,
+		Payload:     "not json",
+		Status:      model.MemoryJobStatusPending,
+		MaxAttempts: maxAttempts,
+		NextRunAt:   time.Now().Add(-time.Second),
+	}
+	if err := s.jobRepo.CreateJob(job); err != nil {
+		t.Fatalf("create job failed: %v", err)
+	}
+	return job
+}
+
+func TestClaimNextJobIsExclusive(t *testing.T) {
+	s, _ := newTestJobService(t)
+	const jobCount = 20
+	for i := 0; i < jobCount; i++ {
+		newFailingJob(t, s, ExtractMaxAttempts)
+	}
+	// 未到执行时间的任务不会被领取
+	future := newFailingJob(t, s, ExtractMaxAttempts)
+	s.jobRepo.MarkRetry(future.ID, "", time.Now().Add(time.Hour))
+
+	var mu sync.Mutex
+	claimed := make(map[string]string)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(workerId string) {
+			defer wg.Done()
+			for {
+				job, err := s.jobRepo.ClaimNextJob(workerId)
+				if err != nil {
+					t.Errorf("claim failed: %v", err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				mu.Lock()
+				if other, ok := claimed[job.ID]; ok {
+					t.Errorf("job %s claimed by both %s and %s", job.ID, other, workerId)
+				}
+				claimed[job.ID] = workerId
+				mu.Unlock()
+			}
+		}(string(rune('a' + w)))
+	}
+	wg.Wait()
+
+	if len(claimed) != jobCount {
+		t.Fatalf("expected %d claimed jobs, got %d", jobCount, len(claimed))
+	}
+	if _, ok := claimed[future.ID]; ok {
+		t.Fatalf("job scheduled in the future should not be claimed")
+	}
+	for id, workerId := range claimed {
+		job, _ := s.jobRepo.GetJobById(id)
+		if job.Status != model.MemoryJobStatusRunning || job.LockedBy != workerId || job.Attempts != 1 || job.LockedAt == nil {
+			t.Fatalf("unexpected claimed job: %+v", job)
+		}
+	}
+}
+
+func TestExecuteJobBackoffAndDeadLetter(t *testing.T) {
+	s, gdb := newTestJobService(t)
+	ctx := context.Background()
+	job := newFailingJob(t, s, 3)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		claimed, err := s.jobRepo.ClaimNextJob("w")
+		if err != nil || claimed == nil || claimed.ID != job.ID || claimed.Attempts != attempt {
+			t.Fatalf("attempt %d: unexpected claim: %+v, %v", attempt, claimed, err)
+		}
+		before := time.Now()
+		s.executeJob(ctx, claimed)
+		saved, _ := s.jobRepo.GetJobById(job.ID)
+		if saved.LastError == "" {
+			t.Fatalf("attempt %d: last error not recorded", attempt)
+		}
+		if attempt == 3 {
+			// 达到最大尝试次数后进入死信，不再安排重试
+			if saved.Status != model.MemoryJobStatusDead || saved.FinishedAt == nil {
+				t.Fatalf("job should be dead after max attempts: %+v", saved)
+			}
+			break
+		}
+		// 失败后按指数退避安排重试，等待期间不会被领取
+		delay := retryDelay(attempt)
+		if saved.Status != model.MemoryJobStatusPending || saved.LockedBy != "" ||
+			saved.NextRunAt.Before(before.Add(delay-time.Second)) || saved.NextRunAt.After(time.Now().Add(delay+time.Second)) {
+			t.Fatalf("attempt %d: unexpected retry schedule (delay %s): %+v", attempt, delay, saved)
+		}
+		if early, _ := s.jobRepo.ClaimNextJob("w"); early != nil {
+			t.Fatalf("attempt %d: job claimed before its backoff elapsed", attempt)
+		}
+		gdb.Model(&model.MemoryJob{}).Where("id = ?", job.ID).Update("next_run_at", time.Now().Add(-time.Second))
+	}
+	if again, _ := s.jobRepo.ClaimNextJob("w"); again != nil {
+		t.Fatalf("dead job should not be claimed: %+v", again)
+	}
+
+	if retryDelay(1) != ExtractRetryBaseDelay || retryDelay(2) != 2*ExtractRetryBaseDelay || retryDelay(3) != 4*ExtractRetryBaseDelay {
+		t.Fatalf("retry delay should double: %s %s %s", retryDelay(1), retryDelay(2), retryDelay(3))
+	}
+	if retryDelay(20) != ExtractRetryMaxDelay {
+		t.Fatalf("retry delay should be capped, got %s", retryDelay(20))
+	}
+}
+
+func TestRequeueStaleJobs(t *testing.T) {
+	s, gdb := newTestJobService(t)
+	stale := newFailingJob(t, s, ExtractMaxAttempts)
+	fresh := newFailingJob(t, s, ExtractMaxAttempts)
+	for range []int{0, 1} {
+		if job, err := s.jobRepo.ClaimNextJob("crashed"); err != nil || job == nil {
+			t.Fatalf("claim failed: %+v, %v", job, err)
+		}
+	}
+	gdb.Model(&model.MemoryJob{}).Where("id = ?", stale.ID).Update("locked_at", time.Now().Add(-2*ExtractJobLockTimeout))
+
+	// 只回收锁定超时的任务，仍在执行的任务不受影响
+	count, err := s.jobRepo.RequeueStaleJobs(ExtractJobLockTimeout)
+	if err != nil || count != 1 {
+		t.Fatalf("expected one stale job, got %d, %v", count, err)
+	}
+	if saved, _ := s.jobRepo.GetJobById(fresh.ID); saved.Status != model.MemoryJobStatusRunning || saved.LockedBy != "crashed" {
+		t.Fatalf("fresh job should keep running: %+v", saved)
+	}
+	// 回收的任务重新被领取，尝试次数累加
+	job, err := s.jobRepo.ClaimNextJob("w")
+	if err != nil || job == nil || job.ID != stale.ID || job.Attempts != 2 || job.LockedBy != "w" {
+		t.Fatalf("stale job should be claimable again: %+v, %v", job, err)
+	}
+}
+
+func TestRetryExtractionJob(t *testing.T) {
+	s, _ := newTestJobService(t)
+	ctx := context.Background()
+	job := newFailingJob(t, s, 1)
+
+	// 还没进入死信的任务不能手动重试
+	if _, err := s.RetryExtractionJob(job.ID, "per:a", 1); err == nil {
+		t.Fatalf("pending job should not be retried")
+	}
+	claimed, _ := s.jobRepo.ClaimNextJob("w")
+	s.executeJob(ctx, claimed)
+	if saved, _ := s.jobRepo.GetJobById(job.ID); saved.Status != model.MemoryJobStatusDead {
+		t.Fatalf("job should be dead: %+v", saved)
+	}
+
+	// 任务必须属于该人格和用户
+	if _, err := s.RetryExtractionJob(job.ID, "per:b", 1); err == nil {
+		t.Fatalf("job of another persona should not be retried")
+	}
+	if _, err := s.RetryExtractionJob(job.ID, "per:a", 2); err == nil {
+		t.Fatalf("job of another user should not be retried")
+	}
+
+	retried, err := s.RetryExtractionJob(job.ID, "per:a", 1)
+	if err != nil || retried.Status != model.MemoryJobStatusPending || retried.Attempts != 0 || retried.FinishedAt != nil {
+		t.Fatalf("unexpected retried job: %+v, %v", retried, err)
+	}
+	// 重新入队后可以再次领取，尝试次数从头计算
+	again, err := s.jobRepo.ClaimNextJob("w")
+	if err != nil || again == nil || again.ID != job.ID || again.Attempts != 1 {
+		t.Fatalf("retried job should be claimable: %+v, %v", again, err)
+	}
+}
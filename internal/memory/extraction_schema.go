@@ -0,0 +1,314 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	einomodel "github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"go.uber.org/zap"
+)
+
+// submitMemoryActionsTool 提取模型通过调用该工具提交记忆操作，参数按 JSON Schema 约束
+const submitMemoryActionsTool = "submit_memory_actions"
+
+// maxKeywordsRunes 关键词字段的最大长度，与 memories.keywords 列一致
+const maxKeywordsRunes = 500
+
+// memoryActionAliases 模型常见的非标准操作名，修复为标准操作
+var memoryActionAliases = map[string]string{
+	"add":        "add",
+	"create":     "add",
+	"new":        "add",
+	"insert":     "add",
+	"update":     "update",
+	"merge":      "update",
+	"modify":     "update",
+	"invalidate": "invalidate",
+	"expire":     "invalidate",
+	"delete":     "delete",
+	"remove":     "delete",
+	"forget":     "delete",
+	"none":       "none",
+	"skip":       "none",
+}
+
+// memoryActionsToolInfo 提交记忆操作的工具定义
+func memoryActionsToolInfo() *schema.ToolInfo {
+	action := &schema.ParameterInfo{
+		Type: schema.Object,
+		SubParams: map[string]*schema.ParameterInfo{
+			"action": {
+				Type:     schema.String,
+				Enum:     []string{"add", "update", "invalidate", "delete", "none"},
+				Desc:     "操作类型",
+				Required: true,
+			},
+			"old_memory_id": {Type: schema.String, Desc: "update/invalidate/delete 时对应的现有记忆 ID"},
+			"type": {
+				Type: schema.String,
+				Enum: model.MemoryTypes,
+				Desc: "记忆类型，add/update 时必填",
+			},
+			"content":       {Type: schema.String, Desc: "记忆内容，add/update 时必填"},
+			"keywords":      {Type: schema.String, Desc: "检索关键词，空格分隔"},
+			"importance":    {Type: schema.Integer, Desc: "重要度 1~10"},
+			"source_rounds": {Type: schema.Array, ElemInfo: &schema.ParameterInfo{Type: schema.Integer}, Desc: "支撑该记忆的对话轮次编号"},
+		},
+	}
+	return &schema.ToolInfo{
+		Name: submitMemoryActionsTool,
+		Desc: "提交从对话中分析出的记忆操作",
+		ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+			"actions": {Type: schema.Array, ElemInfo: action, Desc: "记忆操作列表，没有需要记录的信息时为空数组", Required: true},
+		}),
+	}
+}
+
+// generateStructured 强制模型调用 toolInfo 描述的工具，以 JSON Schema 约束的参数返回结果；
+// 模型或服务不支持工具调用时退回普通生成，由调用方从正文中解析 JSON
+func generateStructured(ctx context.Context, cm einomodel.ToolCallingChatModel, messages []*schema.Message, toolInfo *schema.ToolInfo) (*schema.Message, error) {
+	if tcm, err := cm.WithTools([]*schema.ToolInfo{toolInfo}); err == nil {
+		resp, err := tcm.Generate(ctx, messages, einomodel.WithToolChoice(schema.ToolChoiceForced, toolInfo.Name))
+		if err == nil {
+			return resp, nil
+		}
+		utils.Log.Warn("结构化输出失败，改为按正文解析", zap.String("tool", toolInfo.Name), zap.Error(err))
+	}
+	return cm.Generate(ctx, messages)
+}
+
+// structuredPayload 取指定工具调用的参数，没有该工具调用时取正文
+func structuredPayload(resp *schema.Message, toolName string) string {
+	for _, call := range resp.ToolCalls {
+		if call.Function.Name == toolName {
+			return call.Function.Arguments
+		}
+	}
+	return resp.Content
+}
+
+// parseMemoryActions 解析模型输出：优先取工具调用参数，否则从正文中找出 JSON。
+// 每个操作单独解析，个别操作格式错误时跳过该操作，不影响其他操作。返回解析出的操作和跳过的数量。
+func parseMemoryActions(resp *schema.Message) ([]MemoryAction, int, error) {
+	if resp == nil {
+		return nil, 0, fmt.Errorf("empty response")
+	}
+	rawActions, err := extractActionsJSON(structuredPayload(resp, submitMemoryActionsTool))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	actions := make([]MemoryAction, 0, len(rawActions))
+	skipped := 0
+	for _, raw := range rawActions {
+		action, ok := decodeMemoryAction(raw)
+		if !ok {
+			skipped++
+			continue
+		}
+		actions = append(actions, action)
+	}
+	return actions, skipped, nil
+}
+
+// extractActionsJSON 从可能夹杂说明文字或代码块的输出中取出操作数组：
+// 支持 {"actions": [...]} 和直接输出的数组
+func extractActionsJSON(text string) ([]json.RawMessage, error) {
+	text = strings.TrimSpace(text)
+	for _, candidate := range jsonCandidates(text) {
+		var wrapped struct {
+			Actions []json.RawMessage `json:"actions"`
+		}
+		if err := json.Unmarshal([]byte(candidate), &wrapped); err == nil && wrapped.Actions != nil {
+			return wrapped.Actions, nil
+		}
+		var list []json.RawMessage
+		if err := json.Unmarshal([]byte(candidate), &list); err == nil {
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("no valid actions JSON in output: %.200s", text)
+}
+
+// jsonCandidates 依次尝试整段文本、最外层花括号和最外层方括号之间的内容
+func jsonCandidates(text string) []string {
+	candidates := []string{text}
+	for _, pair := range [][2]string{{"{", "}"}, {"[", "]"}} {
+		start, end := strings.Index(text, pair[0]), strings.LastIndex(text, pair[1])
+		if start >= 0 && end > start {
+			candidates = append(candidates, text[start:end+1])
+		}
+	}
+	return candidates
+}
+
+// decodeMemoryAction 宽松地解析单个操作：数字字段接受字符串形式
+func decodeMemoryAction(raw json.RawMessage) (MemoryAction, bool) {
+	var loose struct {
+		Action       string        `json:"action"`
+		OldMemoryID  string        `json:"old_memory_id"`
+		Type         string        `json:"type"`
+		Content      string        `json:"content"`
+		Keywords     interface{}   `json:"keywords"`
+		Importance   interface{}   `json:"importance"`
+		SourceRounds []interface{} `json:"source_rounds"`
+	}
+	if err := json.Unmarshal(raw, &loose); err != nil {
+		return MemoryAction{}, false
+	}
+	action := MemoryAction{
+		Action:      loose.Action,
+		OldMemoryID: loose.OldMemoryID,
+		Type:        loose.Type,
+		Content:     loose.Content,
+		Importance:  looseInt(loose.Importance),
+	}
+	switch keywords := loose.Keywords.(type) {
+	case string:
+		action.Keywords = keywords
+	case []interface{}:
+		words := make([]string, 0, len(keywords))
+		for _, word := range keywords {
+			if s, ok := word.(string); ok {
+				words = append(words, s)
+			}
+		}
+		action.Keywords = strings.Join(words, " ")
+	}
+	for _, round := range loose.SourceRounds {
+		if n := looseInt(round); n > 0 {
+			action.SourceRounds = append(action.SourceRounds, n)
+		}
+	}
+	return action, true
+}
+
+func looseInt(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return 0
+		}
+		return i
+	}
+	return 0
+}
+
+// ActionValidation 校验结果统计
+type ActionValidation struct {
+	Repaired int      `json:"repaired"`
+	Skipped  int      `json:"skipped"`
+	Issues   []string `json:"issues"`
+}
+
+func (v *ActionValidation) repair(format string, args ...interface{}) {
+	v.Repaired++
+	v.Issues = append(v.Issues, "repaired: "+fmt.Sprintf(format, args...))
+}
+
+func (v *ActionValidation) skip(format string, args ...interface{}) {
+	v.Skipped++
+	v.Issues = append(v.Issues, "skipped: "+fmt.Sprintf(format, args...))
+}
+
+// validateMemoryActions 逐条校验并修复提取结果：
+//   - 操作名规范化，无法识别的跳过，none 直接丢弃
+//   - old_memory_id 必须是提取时列出的现有记忆（即本人格本用户的活跃记忆）；
+//     update 引用无效时降级为 add，invalidate/delete 引用无效时跳过；同一条旧记忆只处理第一次引用
+//   - add/update 的内容不能为空；类型不在 MemoryType 集合中时，update 沿用旧记忆的类型，add 归为 fact
+//   - 关键词截断到列宽，支撑轮次只保留 1~roundCount
+func validateMemoryActions(actions []MemoryAction, existing []model.Memory, roundCount int) ([]MemoryAction, ActionValidation) {
+	var report ActionValidation
+	existingByID := make(map[string]*model.Memory, len(existing))
+	for i := range existing {
+		existingByID[existing[i].ID] = &existing[i]
+	}
+	referenced := make(map[string]bool)
+	valid := make([]MemoryAction, 0, len(actions))
+
+	for i, action := range actions {
+		name := strings.ToLower(strings.TrimSpace(action.Action))
+		normalized, ok := memoryActionAliases[name]
+		if !ok {
+			report.skip("#%d unknown action %q", i+1, action.Action)
+			continue
+		}
+		if normalized != name {
+			report.repair("#%d action %q -> %q", i+1, action.Action, normalized)
+		}
+		action.Action = normalized
+		if action.Action == "none" {
+			continue
+		}
+		action.OldMemoryID = strings.TrimSpace(action.OldMemoryID)
+		old := existingByID[action.OldMemoryID]
+
+		switch action.Action {
+		case "invalidate", "delete":
+			if old == nil {
+				report.skip("#%d %s references unknown memory %q", i+1, action.Action, action.OldMemoryID)
+				continue
+			}
+			if referenced[old.ID] {
+				report.skip("#%d memory %s already referenced", i+1, old.ID)
+				continue
+			}
+			referenced[old.ID] = true
+			valid = append(valid, MemoryAction{Action: action.Action, OldMemoryID: old.ID})
+			continue
+		case "update":
+			if old == nil || referenced[old.ID] {
+				report.repair("#%d update references unknown or reused memory %q, treated as add", i+1, action.OldMemoryID)
+				action.Action, action.OldMemoryID, old = "add", "", nil
+			}
+		case "add":
+			action.OldMemoryID = ""
+		}
+
+		action.Content = strings.TrimSpace(action.Content)
+		if action.Content == "" {
+			report.skip("#%d %s with empty content", i+1, action.Action)
+			continue
+		}
+		memoryType := strings.ToLower(strings.TrimSpace(action.Type))
+		if !model.IsValidMemoryType(memoryType) {
+			fallback := model.MemoryTypeFact
+			if old != nil && model.IsValidMemoryType(old.Type) {
+				fallback = old.Type
+			}
+			report.repair("#%d type %q -> %q", i+1, action.Type, fallback)
+			memoryType = fallback
+		}
+		action.Type = memoryType
+		action.Keywords = strings.TrimSpace(action.Keywords)
+		if runes := []rune(action.Keywords); len(runes) > maxKeywordsRunes {
+			action.Keywords = string(runes[:maxKeywordsRunes])
+			report.repair("#%d keywords truncated", i+1)
+		}
+		if action.Importance != 0 && action.Importance != model.ClampImportance(action.Importance) {
+			report.repair("#%d importance %d clamped", i+1, action.Importance)
+			action.Importance = model.ClampImportance(action.Importance)
+		}
+		rounds := make([]int, 0, len(action.SourceRounds))
+		for _, round := range action.SourceRounds {
+			if round >= 1 && round <= roundCount {
+				rounds = append(rounds, round)
+			}
+		}
+		action.SourceRounds = rounds
+		if old != nil {
+			referenced[old.ID] = true
+		}
+		valid = append(valid, action)
+	}
+	return valid, report
+}
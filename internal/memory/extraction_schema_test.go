@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"testing"
+
+	"AI_Chat/internal/model"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestParseMemoryActions(t *testing.T) {
+	// 正文夹杂说明文字和代码块，个别操作格式错误
+	content := "好的，以下是结果：\n```json\n" +
+		`{"actions": [{"action": "add", "type": "fact", "content": "用户养猫", "importance": "7", "source_rounds": ["1", 2]}, "broken", {"action": "none"}]}` +
+		"\n```\n希望有帮助"
+	actions, skipped, err := parseMemoryActions(schema.AssistantMessage(content, nil))
+	if err != nil || skipped != 1 || len(actions) != 2 {
+		t.Fatalf("unexpected parse result: %+v, %d, %v", actions, skipped, err)
+	}
+	if actions[0].Importance != 7 || len(actions[0].SourceRounds) != 2 {
+		t.Fatalf("loose fields not decoded: %+v", actions[0])
+	}
+
+	// 工具调用参数优先于正文
+	resp := schema.AssistantMessage("忽略", []schema.ToolCall{{
+		Function: schema.FunctionCall{Name: submitMemoryActionsTool, Arguments: `{"actions": [{"action": "delete", "old_memory_id": "mem:a"}]}`},
+	}})
+	actions, _, err = parseMemoryActions(resp)
+	if err != nil || len(actions) != 1 || actions[0].Action != "delete" {
+		t.Fatalf("unexpected tool call result: %+v, %v", actions, err)
+	}
+
+	if _, _, err := parseMemoryActions(schema.AssistantMessage("没有需要记录的信息", nil)); err == nil {
+		t.Fatalf("expected error for output without JSON")
+	}
+}
+
+func TestValidateMemoryActions(t *testing.T) {
+	existing := []model.Memory{
+		{ID: "mem:job", Type: model.MemoryTypeFact, Content: "用户在字节工作"},
+		{ID: "mem:food", Type: model.MemoryTypePreference, Content: "用户喜欢吃辣"},
+	}
+	actions := []MemoryAction{
+		{Action: "Create", Type: "hobby", Content: " 用户喜欢爬山 ", SourceRounds: []int{1, 9}},
+		{Action: "update", OldMemoryID: "mem:food", Type: "unknown", Content: "用户喜欢吃辣，但最近在减脂", Importance: 15},
+		{Action: "update", OldMemoryID: "mem:other", Type: "event", Content: "用户下周去旅行"},
+		{Action: "delete", OldMemoryID: "mem:other"},
+		{Action: "invalidate", OldMemoryID: "mem:job"},
+		{Action: "delete", OldMemoryID: "mem:job"},
+		{Action: "add", Type: "fact", Content: "   "},
+		{Action: "explode"},
+		{Action: "none"},
+	}
+	valid, report := validateMemoryActions(actions, existing, 3)
+	if len(valid) != 4 {
+		t.Fatalf("expected 4 valid actions, got %+v", valid)
+	}
+	if valid[0].Action != "add" || valid[0].Type != model.MemoryTypeFact || valid[0].Content != "用户喜欢爬山" || len(valid[0].SourceRounds) != 1 {
+		t.Fatalf("add not repaired: %+v", valid[0])
+	}
+	if valid[1].Type != model.MemoryTypePreference || valid[1].Importance != model.MemoryImportanceMax {
+		t.Fatalf("update not repaired: %+v", valid[1])
+	}
+	if valid[2].Action != "add" || valid[2].OldMemoryID != "" {
+		t.Fatalf("update with unknown memory should become add: %+v", valid[2])
+	}
+	if valid[3].Action != "invalidate" || valid[3].OldMemoryID != "mem:job" {
+		t.Fatalf("unexpected invalidate: %+v", valid[3])
+	}
+	// 未知引用的 delete、重复引用、空内容、未知操作被跳过
+	if report.Skipped != 4 {
+		t.Fatalf("expected 4 skipped, got %+v", report)
+	}
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// forgetCandidateLimit 遗忘工具按描述查找时最多列出的候选记忆数量
+const forgetCandidateLimit = 5
+
+// InvalidateMemories 把不再成立的活跃记忆标记为已失效并删除其向量，返回实际失效的 ID。
+// 失效的记忆仍保留在版本历史中，可通过回滚恢复，恢复的版本接在失效版本之后。
+func (s *MemoryService) InvalidateMemories(ctx context.Context, personaId string, userId int64, memoryIds []string) ([]string, error) {
+	invalidated, err := s.memoryRepo.InvalidateMemories(personaId, userId, uniqueTerms(memoryIds))
+	if err != nil {
+		return nil, err
+	}
+	s.DeleteMemoryVector(ctx, invalidated...)
+	if len(invalidated) > 0 {
+		utils.Log.Info("记忆已失效", zap.String("personaId", personaId), zap.Strings("memoryIds", invalidated))
+	}
+	return invalidated, nil
+}
+
+// ForgetMemories 按用户要求软删除活跃记忆并删除其向量，返回实际删除的 ID
+func (s *MemoryService) ForgetMemories(ctx context.Context, personaId string, userId int64, memoryIds []string) ([]string, error) {
+	deleted, err := s.memoryRepo.DeleteActiveMemories(personaId, userId, uniqueTerms(memoryIds))
+	if err != nil {
+		return nil, err
+	}
+	s.DeleteMemoryVector(ctx, deleted...)
+	if len(deleted) > 0 {
+		utils.Log.Info("记忆已遗忘", zap.String("personaId", personaId), zap.Strings("memoryIds", deleted))
+	}
+	return deleted, nil
+}
+
+// FindForgetCandidates 按用户描述查找可能需要遗忘的记忆，只读，不记录命中
+func (s *MemoryService) FindForgetCandidates(ctx context.Context, personaId string, userId int64, description string) ([]model.Memory, error) {
+	scored, err := s.SearchMemories(ctx, personaId, userId, description, RetrievalOptions{TopK: forgetCandidateLimit})
+	if err != nil {
+		return nil, err
+	}
+	return ScoredMemoriesToMemories(scored), nil
+}
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"AI_Chat/internal/model"
+)
+
+func TestApplyRetireActions(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	create := func(personaId, content string) *model.Memory {
+		mem := &model.Memory{PersonaID: personaId, UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: model.MemoryStatusActive}
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		return mem
+	}
+	job := create("per:a", "用户在字节跳动工作")
+	secret := create("per:a", "用户的银行卡密码是 1234")
+	other := create("per:b", "用户喜欢爬山")
+	existing, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1)
+	pending := &model.PendingMessages{PersonaID: "per:a", UserID: 1}
+	actions := []MemoryAction{
+		{Action: "invalidate", OldMemoryID: job.ID},
+		{Action: "delete", OldMemoryID: secret.ID},
+		{Action: "delete", OldMemoryID: other.ID}, // 不在现有记忆中，忽略
+	}
+
+	// 审核模式下不自动执行
+	if err := s.applyRetireActions(ctx, pending, actions, existing, true); err != nil {
+		t.Fatalf("apply in review mode failed: %v", err)
+	}
+	if active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1); len(active) != 2 {
+		t.Fatalf("review mode should not retire memories, active = %d", len(active))
+	}
+
+	if err := s.applyRetireActions(ctx, pending, actions, existing, false); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(job.ID); got.Status != model.MemoryStatusInvalidated {
+		t.Fatalf("job memory should be invalidated: %+v", got)
+	}
+	if _, err := s.memoryRepo.GetMemoryById(secret.ID); err == nil {
+		t.Fatalf("secret memory should be deleted")
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(other.ID); got.Status != model.MemoryStatusActive {
+		t.Fatalf("other persona's memory should be untouched: %+v", got)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{})
+	if len(ids) != 1 || ids[0] != other.ID {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+
+	// 遗忘只作用于当前人格
+	if deleted, err := s.ForgetMemories(ctx, "per:a", 1, []string{other.ID}); err != nil || len(deleted) != 0 {
+		t.Fatalf("forget across personas: %v, %v", deleted, err)
+	}
+}
+
+func TestRollbackInvalidatedMemory(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: "用户在字节跳动工作", Status: model.MemoryStatusActive}
+	s.PrepareMemoryEmbedding(ctx, mem)
+	s.memoryRepo.CreateMemory(mem)
+	s.UpsertMemoryVector(ctx, mem)
+	existing, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1)
+	pending := &model.PendingMessages{PersonaID: "per:a", UserID: 1}
+	if err := s.applyRetireActions(ctx, pending, []MemoryAction{{Action: "invalidate", OldMemoryID: mem.ID}}, existing, false); err != nil {
+		t.Fatalf("invalidate failed: %v", err)
+	}
+	if lineage, _ := s.GetMemoryLineage("per:a", 1, mem.ID); lineage.CurrentID != "" {
+		t.Fatalf("invalidated lineage should have no current version: %+v", lineage)
+	}
+
+	// 失效的记忆可以通过回滚恢复，恢复的版本出现在版本链中并重新写入向量
+	result, err := s.RollbackMemory(ctx, "per:a", 1, mem.ID)
+	if err != nil || result.Memory.Content != mem.Content || !result.VectorSynced {
+		t.Fatalf("rollback failed: %+v, %v", result, err)
+	}
+	lineage, err := s.GetMemoryLineage("per:a", 1, mem.ID)
+	if err != nil || lineage.CurrentID != result.Memory.ID || len(lineage.Versions) != 2 {
+		t.Fatalf("unexpected lineage after rollback: %+v, %v", lineage, err)
+	}
+	if _, err := s.RollbackMemory(ctx, "per:a", 1, result.Memory.ID); err != ErrAlreadyCurrent {
+		t.Fatalf("expected already current, got %v", err)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	if len(ids) != 1 || ids[0] != result.Memory.ID {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+}
+
+func TestExtractedUpdateSkipsForgottenMemory(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	create := func(content string) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: model.MemoryStatusActive}
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		return mem
+	}
+	secret := create("用户的银行卡密码是 1234")
+	city := create("用户住在北京")
+	pending := &model.PendingMessages{PersonaID: "per:a", UserID: 1}
+	update := func(old *model.Memory, content string) extractedMemory {
+		return extractedMemory{
+			memory:      &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: model.MemoryStatusActive},
+			oldMemoryID: old.ID,
+		}
+	}
+	extracted := []extractedMemory{
+		update(secret, "用户的银行卡密码是 1234，开户行在北京"),
+		update(city, "用户搬到了上海"),
+	}
+
+	// 提取任务执行期间，用户要求忘记其中一条旧记忆
+	if _, err := s.ForgetMemories(ctx, "per:a", 1, []string{secret.ID}); err != nil {
+		t.Fatalf("forget failed: %v", err)
+	}
+	attempted, failed, err := s.saveExtractedMemories(ctx, pending, extracted, false)
+	if attempted != 1 || failed != 0 || err != nil {
+		t.Fatalf("forgotten memory should be skipped: %d, %d, %v", attempted, failed, err)
+	}
+
+	// 合并了被忘记内容的更新不写入，另一条更新正常取代旧记忆
+	active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1)
+	if len(active) != 1 || active[0].ID != extracted[1].memory.ID {
+		t.Fatalf("only the city update should be active: %+v", active)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(city.ID); got.Status != model.MemoryStatusSuperseded || got.SupersededBy != active[0].ID {
+		t.Fatalf("old city memory should be superseded: %+v", got)
+	}
+	if _, err := s.memoryRepo.GetMemoryById(secret.ID); err == nil {
+		t.Fatalf("forgotten memory should stay deleted")
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	if len(ids) != 1 || ids[0] != active[0].ID {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+}
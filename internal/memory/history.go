@@ -0,0 +1,366 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxLineageSize 版本链最多包含的记忆数量，防止异常数据形成环时无限遍历
+	maxLineageSize = 200
+	// maxDiffCells 求最长公共子序列时动态规划表的最大规模，按字符超过时改为按行比较
+	maxDiffCells = 4_000_000
+)
+
+var (
+	ErrMemoryNotFound = errors.New("memory not found")
+	ErrNotSameLineage = errors.New("memories are not in the same lineage")
+	ErrAlreadyCurrent = errors.New("memory is already the current version")
+)
+
+// MemoryLineage 一条记忆的完整版本链，按创建时间从旧到新排列
+type MemoryLineage struct {
+	CurrentID string         `json:"current_id"` // 当前生效的版本，整条链都已失效时为空
+	Versions  []model.Memory `json:"versions"`
+
+	head *model.Memory // 链上最新的版本，可能已失效；回滚时新版本接在它之后
+}
+
+// DiffSegment 文本差异片段
+type DiffSegment struct {
+	Op   string `json:"op"` // equal/insert/delete
+	Text string `json:"text"`
+}
+
+// FieldChange 字段变化
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// MemoryDiff 两个版本之间的差异
+type MemoryDiff struct {
+	From    model.Memory  `json:"from"`
+	To      model.Memory  `json:"to"`
+	Content []DiffSegment `json:"content"` // 内容差异，见 DiffText
+	Changes []FieldChange `json:"changes"` // 类型、关键词、重要度的变化
+}
+
+// RollbackResult 回滚结果
+type RollbackResult struct {
+	Memory       *model.Memory `json:"memory"`        // 回滚生成的新版本
+	SupersededID string        `json:"superseded_id"` // 被取代的原当前版本，版本链中没有生效版本时为空
+	VectorSynced bool          `json:"vector_synced"` // 向量存储是否已同步，失败时可通过 reindex 修复
+}
+
+// GetMemoryLineage 获取记忆所在的版本链：沿 superseded_by 向前找到所有旧版本，向后找到当前版本
+func (s *MemoryService) GetMemoryLineage(personaId string, userId int64, memoryId string) (*MemoryLineage, error) {
+	start, err := s.getOwnedMemory(personaId, userId, memoryId)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]model.Memory{start.ID: *start}
+	// 向后：沿 superseded_by 找到最新版本
+	head := *start
+	for head.SupersededBy != "" && len(versions) < maxLineageSize {
+		next, err := s.memoryRepo.GetMemoryById(head.SupersededBy)
+		if err != nil || next.PersonaID != personaId || next.UserID != userId {
+			break
+		}
+		if _, seen := versions[next.ID]; seen {
+			break
+		}
+		versions[next.ID] = *next
+		head = *next
+	}
+	// 向前：找出所有被链上版本取代的旧版本（合并时一个版本可能取代多条）
+	frontier := make([]string, 0, len(versions))
+	for id := range versions {
+		frontier = append(frontier, id)
+	}
+	for len(frontier) > 0 && len(versions) < maxLineageSize {
+		older, err := s.memoryRepo.GetMemoriesSupersededBy(personaId, userId, frontier)
+		if err != nil {
+			return nil, err
+		}
+		frontier = frontier[:0]
+		for _, mem := range older {
+			if _, seen := versions[mem.ID]; !seen {
+				versions[mem.ID] = mem
+				frontier = append(frontier, mem.ID)
+			}
+		}
+	}
+
+	lineage := &MemoryLineage{Versions: make([]model.Memory, 0, len(versions))}
+	for _, mem := range versions {
+		lineage.Versions = append(lineage.Versions, mem)
+	}
+	sort.Slice(lineage.Versions, func(i, j int) bool {
+		if !lineage.Versions[i].CreatedAt.Equal(lineage.Versions[j].CreatedAt) {
+			return lineage.Versions[i].CreatedAt.Before(lineage.Versions[j].CreatedAt)
+		}
+		return lineage.Versions[i].ID < lineage.Versions[j].ID
+	})
+	lineage.head = &head
+	if head.Status == model.MemoryStatusActive {
+		lineage.CurrentID = head.ID
+	}
+	return lineage, nil
+}
+
+// DiffMemoryVersions 比较同一版本链中的两个版本
+func (s *MemoryService) DiffMemoryVersions(personaId string, userId int64, fromId, toId string) (*MemoryDiff, error) {
+	lineage, err := s.GetMemoryLineage(personaId, userId, toId)
+	if err != nil {
+		return nil, err
+	}
+	var from, to *model.Memory
+	for i := range lineage.Versions {
+		switch lineage.Versions[i].ID {
+		case fromId:
+			from = &lineage.Versions[i]
+		case toId:
+			to = &lineage.Versions[i]
+		}
+	}
+	if to == nil {
+		return nil, ErrMemoryNotFound
+	}
+	if from == nil {
+		if fromId == toId {
+			from = to
+		} else {
+			return nil, ErrNotSameLineage
+		}
+	}
+
+	diff := &MemoryDiff{
+		From:    *from,
+		To:      *to,
+		Content: DiffText(from.Content, to.Content),
+		Changes: []FieldChange{},
+	}
+	if from.Type != to.Type {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "type", From: from.Type, To: to.Type})
+	}
+	if from.Keywords != to.Keywords {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "keywords", From: from.Keywords, To: to.Keywords})
+	}
+	if model.ClampImportance(from.Importance) != model.ClampImportance(to.Importance) {
+		diff.Changes = append(diff.Changes, FieldChange{Field: "importance", From: model.ClampImportance(from.Importance), To: model.ClampImportance(to.Importance)})
+	}
+	return diff, nil
+}
+
+// RollbackMemory 回滚到旧版本：复制该版本生成新的当前版本并取代原当前版本，版本链只追加不改写。
+// 整条链都已失效（如被失效或遗忘）时，新版本接在链上最新的版本之后，该版本的状态不变。
+// 数据库提交后同步向量存储：写入新版本的向量，删除被取代版本的向量。
+func (s *MemoryService) RollbackMemory(ctx context.Context, personaId string, userId int64, memoryId string) (*RollbackResult, error) {
+	lineage, err := s.GetMemoryLineage(personaId, userId, memoryId)
+	if err != nil {
+		return nil, err
+	}
+	if lineage.CurrentID == memoryId {
+		return nil, ErrAlreadyCurrent
+	}
+	var target *model.Memory
+	for i := range lineage.Versions {
+		if lineage.Versions[i].ID == memoryId {
+			target = &lineage.Versions[i]
+		}
+	}
+	if target == nil {
+		return nil, ErrMemoryNotFound
+	}
+
+	restored := &model.Memory{
+		PersonaID:          target.PersonaID,
+		UserID:             target.UserID,
+		Type:               target.Type,
+		Content:            target.Content,
+		Keywords:           target.Keywords,
+		Importance:         target.Importance,
+		Embedding:          target.Embedding,
+		EmbeddingChecksum:  target.EmbeddingChecksum,
+		EmbeddingUpdatedAt: target.EmbeddingUpdatedAt,
+		Source:             model.MemorySourceRollback,
+		Status:             model.MemoryStatusActive,
+		RestoredFrom:       target.ID,
+	}
+	if _, fresh := s.freshEmbedding(restored); !fresh {
+		s.PrepareMemoryEmbedding(ctx, restored)
+	}
+	// 回滚版本沿用旧版本的来源消息
+	sources, err := s.memoryRepo.GetMemorySources([]string{target.ID})
+	if err != nil {
+		return nil, fmt.Errorf("读取记忆来源失败: %w", err)
+	}
+	if lineage.CurrentID != "" {
+		err = s.memoryRepo.CreateMemoryVersion(restored, lineage.CurrentID, sources)
+	} else {
+		err = s.memoryRepo.CreateMemoryAfter(restored, lineage.head, sources)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建回滚版本失败: %w", err)
+	}
+
+	result := &RollbackResult{Memory: restored, SupersededID: lineage.CurrentID, VectorSynced: true}
+	if err := s.UpsertMemoryVector(ctx, restored); err != nil {
+		result.VectorSynced = false
+	}
+	if lineage.CurrentID != "" {
+		if err := s.DeleteMemoryVector(ctx, lineage.CurrentID); err != nil {
+			result.VectorSynced = false
+		}
+	}
+	utils.Log.Info("记忆已回滚",
+		zap.String("personaId", personaId),
+		zap.String("restoredFrom", target.ID),
+		zap.String("memoryId", restored.ID),
+		zap.String("supersededId", lineage.CurrentID),
+		zap.Bool("vectorSynced", result.VectorSynced),
+	)
+	return result, nil
+}
+
+// MemorySource 支撑记忆的一条对话消息
+type MemorySource struct {
+	MessageID      string    `json:"message_id"`
+	ConversationID string    `json:"conversation_id"`
+	Role           string    `json:"role"`
+	Content        string    `json:"content"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// GetMemorySources 获取支撑该记忆的对话消息；手动创建的记忆和旧数据没有来源，返回空列表
+func (s *MemoryService) GetMemorySources(personaId string, userId int64, memoryId string) ([]MemorySource, error) {
+	if _, err := s.getOwnedMemory(personaId, userId, memoryId); err != nil {
+		return nil, err
+	}
+	messages, err := s.memoryRepo.GetMemorySourceMessages(memoryId)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]MemorySource, 0, len(messages))
+	for _, msg := range messages {
+		sources = append(sources, MemorySource{
+			MessageID:      msg.ID,
+			ConversationID: msg.ConversationID,
+			Role:           msg.Role,
+			Content:        msg.Content,
+			CreatedAt:      msg.CreatedAt,
+		})
+	}
+	return sources, nil
+}
+
+// getOwnedMemory 获取属于该人格和用户的记忆（包括已被取代的）
+func (s *MemoryService) getOwnedMemory(personaId string, userId int64, memoryId string) (*model.Memory, error) {
+	mem, err := s.memoryRepo.GetMemoryById(memoryId)
+	if err != nil || mem.PersonaID != personaId || mem.UserID != userId {
+		return nil, ErrMemoryNotFound
+	}
+	return mem, nil
+}
+
+// DiffText 计算文本差异，输出相邻合并后的差异片段。先去掉相同的前缀和后缀，中间部分按字符求最长公共子序列；
+// 中间部分过长（如改动分散在长提示词各处）时改为按行比较，按行仍超过上限时整段替换
+func DiffText(from, to string) []DiffSegment {
+	a, b := []rune(from), []rune(to)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	segments := make([]DiffSegment, 0)
+	push := func(op string, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(segments); n > 0 && segments[n-1].Op == op {
+			segments[n-1].Text += text
+			return
+		}
+		segments = append(segments, DiffSegment{Op: op, Text: text})
+	}
+	push("equal", string(a[:prefix]))
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(middleA)*len(middleB) <= maxDiffCells {
+		diffTokens(splitRunes(middleA), splitRunes(middleB), push)
+	} else if linesA, linesB := splitLines(string(middleA)), splitLines(string(middleB)); len(linesA)*len(linesB) <= maxDiffCells {
+		diffTokens(linesA, linesB, push)
+	} else {
+		push("delete", string(middleA))
+		push("insert", string(middleB))
+	}
+	push("equal", string(a[len(a)-suffix:]))
+	return segments
+}
+
+// splitRunes 按字符切分
+func splitRunes(runes []rune) []string {
+	tokens := make([]string, len(runes))
+	for i, r := range runes {
+		tokens[i] = string(r)
+	}
+	return tokens
+}
+
+// splitLines 按行切分，每行保留结尾的换行符
+func splitLines(text string) []string {
+	return strings.SplitAfter(text, "\n")
+}
+
+// diffTokens 按最长公共子序列比较两组片段，依次输出 equal/delete/insert 片段
+func diffTokens(a, b []string, push func(op string, text string)) {
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			push("equal", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			push("delete", a[i])
+			i++
+		default:
+			push("insert", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		push("delete", a[i])
+	}
+	for ; j < len(b); j++ {
+		push("insert", b[j])
+	}
+}
@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+)
+
+func TestDiffText(t *testing.T) {
+	got := DiffText("用户喜欢喝咖啡", "用户喜欢喝绿茶")
+	want := []DiffSegment{{Op: "equal", Text: "用户喜欢喝"}, {Op: "delete", Text: "咖啡"}, {Op: "insert", Text: "绿茶"}}
+	if len(got) != len(want) {
+		t.Fatalf("diff = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("diff = %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestDiffLongText(t *testing.T) {
+	// 超过按字符比较上限的长提示词：只改中间一处时去掉相同前后缀后仍按字符比较
+	lines := make([]string, 0, 300)
+	for i := 0; i < 300; i++ {
+		lines = append(lines, fmt.Sprintf("第%d条规则：回复要简短自然。", i))
+	}
+	from := strings.Join(lines, "\n")
+	to := strings.Replace(from, "第150条规则：回复要简短", "第150条规则：回复要活泼", 1)
+	got := DiffText(from, to)
+	if len(got) != 4 || got[1] != (DiffSegment{Op: "delete", Text: "简短"}) || got[2] != (DiffSegment{Op: "insert", Text: "活泼"}) {
+		t.Fatalf("unexpected diff of a single change: %+v", got[1:len(got)-1])
+	}
+
+	// 改动分散在首尾时按行比较，只有改动的行出现在差异中
+	changed := append([]string{"开头新增的说明"}, lines[1:len(lines)-1]...)
+	changed = append(changed, "结尾改写的规则")
+	got = DiffText(from, strings.Join(changed, "\n"))
+	var deleted, inserted []string
+	for _, segment := range got {
+		switch segment.Op {
+		case "delete":
+			deleted = append(deleted, segment.Text)
+		case "insert":
+			inserted = append(inserted, segment.Text)
+		}
+	}
+	if len(deleted) != 2 || deleted[0] != lines[0]+"\n" || len(inserted) != 2 || inserted[1] != "结尾改写的规则" {
+		t.Fatalf("expected a line diff, got deleted=%q inserted=%q", deleted, inserted)
+	}
+}
+
+func TestMemoryLineageAndRollback(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	newVersion := func(content, supersedeId string) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypePreference, Content: content, Status: model.MemoryStatusActive}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemoryVersion(mem, supersedeId, nil); err != nil {
+			t.Fatalf("create version failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		if supersedeId != "" {
+			s.DeleteMemoryVector(ctx, supersedeId)
+		}
+		return mem
+	}
+	v1 := newVersion("用户喜欢喝咖啡", "")
+	v2 := newVersion("用户喜欢喝绿茶", v1.ID)
+
+	lineage, err := s.GetMemoryLineage("per:a", 1, v1.ID)
+	if err != nil || lineage.CurrentID != v2.ID || len(lineage.Versions) != 2 || lineage.Versions[0].ID != v1.ID {
+		t.Fatalf("unexpected lineage: %+v, %v", lineage, err)
+	}
+	if _, err := s.GetMemoryLineage("per:b", 1, v1.ID); err != ErrMemoryNotFound {
+		t.Fatalf("expected not found for other persona, got %v", err)
+	}
+	if _, err := s.RollbackMemory(ctx, "per:a", 1, v2.ID); err != ErrAlreadyCurrent {
+		t.Fatalf("expected already current, got %v", err)
+	}
+
+	result, err := s.RollbackMemory(ctx, "per:a", 1, v1.ID)
+	if err != nil || !result.VectorSynced || result.SupersededID != v2.ID {
+		t.Fatalf("rollback failed: %+v, %v", result, err)
+	}
+	if result.Memory.Content != v1.Content || result.Memory.RestoredFrom != v1.ID {
+		t.Fatalf("unexpected restored memory: %+v", result.Memory)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	if len(ids) != 1 || ids[0] != result.Memory.ID {
+		t.Fatalf("vector store out of sync: %v", ids)
+	}
+
+	lineage, _ = s.GetMemoryLineage("per:a", 1, v1.ID)
+	if lineage.CurrentID != result.Memory.ID || len(lineage.Versions) != 3 {
+		t.Fatalf("unexpected lineage after rollback: %+v", lineage)
+	}
+	diff, err := s.DiffMemoryVersions("per:a", 1, v2.ID, result.Memory.ID)
+	if err != nil || len(diff.Content) != 3 {
+		t.Fatalf("unexpected diff: %+v, %v", diff, err)
+	}
+}
+
+func TestRollbackInvalidatedLineage(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	v1 := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: "用户在上海工作", Status: model.MemoryStatusActive}
+	s.memoryRepo.CreateMemory(v1)
+	v2 := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: "用户在杭州工作", Status: model.MemoryStatusActive}
+	if err := s.memoryRepo.CreateMemoryVersion(v2, v1.ID, nil); err != nil {
+		t.Fatalf("create version failed: %v", err)
+	}
+	if _, err := s.InvalidateMemories(ctx, "per:a", 1, []string{v2.ID}); err != nil {
+		t.Fatalf("invalidate failed: %v", err)
+	}
+
+	// 整条链失效后回滚，新版本接在失效的最新版本之后
+	first, err := s.RollbackMemory(ctx, "per:a", 1, v1.ID)
+	if err != nil || first.SupersededID != "" {
+		t.Fatalf("rollback failed: %+v, %v", first, err)
+	}
+	lineage, err := s.GetMemoryLineage("per:a", 1, v1.ID)
+	if err != nil || lineage.CurrentID != first.Memory.ID || len(lineage.Versions) != 3 {
+		t.Fatalf("rollback result missing from lineage: %+v, %v", lineage, err)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(v2.ID); got.Status != model.MemoryStatusInvalidated || got.SupersededBy != first.Memory.ID {
+		t.Fatalf("invalidated head should link to the restored version: %+v", got)
+	}
+
+	// 再次回滚取代上一次回滚的结果，不会出现两条生效的版本
+	second, err := s.RollbackMemory(ctx, "per:a", 1, v1.ID)
+	if err != nil || second.SupersededID != first.Memory.ID {
+		t.Fatalf("second rollback failed: %+v, %v", second, err)
+	}
+	lineage, _ = s.GetMemoryLineage("per:a", 1, v2.ID)
+	if lineage.CurrentID != second.Memory.ID || len(lineage.Versions) != 4 {
+		t.Fatalf("unexpected lineage after second rollback: %+v", lineage)
+	}
+	if active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1); len(active) != 1 || active[0].ID != second.Memory.ID {
+		t.Fatalf("expected exactly one active version, got %+v", active)
+	}
+
+	// 基于过期的版本链回滚时失败，不会接出分叉
+	stale := &model.Memory{ID: v2.ID, SupersededBy: ""}
+	if err := s.memoryRepo.CreateMemoryAfter(&model.Memory{PersonaID: "per:a", UserID: 1, Content: "分叉"}, stale, nil); err != repository.ErrLineageChanged {
+		t.Fatalf("expected lineage changed, got %v", err)
+	}
+}
+
+func TestExtractionSources(t *testing.T) {
+	gdb := newTestDB(t)
+	s := &MemoryService{memoryRepo: repository.NewMemoryRepository(gdb)}
+
+	pending := &model.PendingMessages{ConversationID: "conv:a", PersonaID: "per:a", UserID: 1}
+	for i, text := range []string{"我在上海工作", "我养了一只猫"} {
+		userMsg := &model.Message{ConversationID: "conv:a", Role: "user", Content: text}
+		aiMsg := &model.Message{ConversationID: "conv:a", Role: "assistant", Content: "好的"}
+		if err := gdb.Create(userMsg).Error; err != nil {
+			t.Fatalf("create message %d failed: %v", i, err)
+		}
+		gdb.Create(aiMsg)
+		round := newPendingRound(userMsg.Content, aiMsg.Content)
+		round.UserMsgID, round.AssistantMsgID = userMsg.ID, aiMsg.ID
+		pending.Messages = append(pending.Messages, round)
+	}
+
+	// 越界的轮次被忽略
+	add := MemoryAction{Action: "add", Content: "用户养了一只猫", SourceRounds: []int{2, 5}}
+	mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: add.Content, Status: model.MemoryStatusActive}
+	if err := s.memoryRepo.CreateMemoryWithSources(mem, s.extractionSources(pending, add)); err != nil {
+		t.Fatalf("create memory failed: %v", err)
+	}
+	sources, err := s.GetMemorySources("per:a", 1, mem.ID)
+	if err != nil || len(sources) != 2 || sources[0].Content != "我养了一只猫" || sources[1].Role != "assistant" {
+		t.Fatalf("unexpected sources: %+v, %v", sources, err)
+	}
+	if _, err := s.GetMemorySources("per:b", 1, mem.ID); err != ErrMemoryNotFound {
+		t.Fatalf("expected not found for other persona, got %v", err)
+	}
+
+	// 更新时沿用旧记忆的来源
+	update := MemoryAction{Action: "update", OldMemoryID: mem.ID, Content: "用户在上海养了一只猫", SourceRounds: []int{1}}
+	merged := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: update.Content, Status: model.MemoryStatusActive}
+	if err := s.memoryRepo.CreateMemoryVersion(merged, mem.ID, s.extractionSources(pending, update)); err != nil {
+		t.Fatalf("create version failed: %v", err)
+	}
+	sources, _ = s.GetMemorySources("per:a", 1, merged.ID)
+	if len(sources) != 4 || sources[0].Content != "我在上海工作" {
+		t.Fatalf("unexpected merged sources: %+v", sources)
+	}
+}
@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// LocalVectorStore 进程内的向量存储，检索时逐条计算余弦相似度。
+// path 不为空时每次写入后把全部向量保存到该文件，启动时从文件恢复。
+type LocalVectorStore struct {
+	mu        sync.RWMutex
+	path      string
+	dimension int
+	records   map[string]VectorRecord
+}
+
+// localStoreSnapshot 落盘文件格式
+type localStoreSnapshot struct {
+	Dimension int                `json:"dimension"`
+	Records   []localStoreRecord `json:"records"`
+}
+
+type localStoreRecord struct {
+	ID        string    `json:"id"`
+	PersonaID string    `json:"persona_id"`
+	UserID    int64     `json:"user_id"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// NewLocalVectorStore 创建本地向量存储。dimension 为 0 时以第一条写入的向量为准。
+func NewLocalVectorStore(path string, dimension int) (*LocalVectorStore, error) {
+	s := &LocalVectorStore{
+		path:      path,
+		dimension: dimension,
+		records:   map[string]VectorRecord{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot localStoreSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("load local vector store %s: %w", path, err)
+	}
+	if s.dimension == 0 {
+		s.dimension = snapshot.Dimension
+	} else if snapshot.Dimension != 0 && snapshot.Dimension != s.dimension {
+		return nil, fmt.Errorf("local vector store dim mismatch: %d vs %d", s.dimension, snapshot.Dimension)
+	}
+	for _, r := range snapshot.Records {
+		s.records[r.ID] = VectorRecord{ID: r.ID, PersonaID: r.PersonaID, UserID: r.UserID, Embedding: r.Embedding}
+	}
+	return s, nil
+}
+
+func (s *LocalVectorStore) Upsert(ctx context.Context, records ...VectorRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dimension := s.dimension
+	for _, r := range records {
+		if r.ID == "" || r.PersonaID == "" || r.UserID == 0 {
+			return fmt.Errorf("invalid memory identity")
+		}
+		if len(r.Embedding) == 0 {
+			return fmt.Errorf("empty embedding")
+		}
+		if dimension == 0 {
+			dimension = len(r.Embedding)
+		}
+		if len(r.Embedding) != dimension {
+			return fmt.Errorf("embedding dim mismatch: %d vs %d", dimension, len(r.Embedding))
+		}
+	}
+	s.dimension = dimension
+	for _, r := range records {
+		r.Embedding = append([]float32(nil), r.Embedding...)
+		s.records[r.ID] = r
+	}
+	return s.saveLocked()
+}
+
+func (s *LocalVectorStore) Delete(ctx context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for _, id := range ids {
+		if _, ok := s.records[id]; ok {
+			delete(s.records, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+func (s *LocalVectorStore) Search(ctx context.Context, filter VectorFilter, embedding []float32, topK int) ([]VectorSearchResult, error) {
+	if topK <= 0 || len(embedding) == 0 {
+		return []VectorSearchResult{}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.dimension != 0 && s.dimension != len(embedding) {
+		return nil, fmt.Errorf("embedding dim mismatch: %d vs %d", s.dimension, len(embedding))
+	}
+	results := make([]VectorSearchResult, 0)
+	for _, r := range s.records {
+		if !filter.match(r.PersonaID, r.UserID) {
+			continue
+		}
+		results = append(results, VectorSearchResult{ID: r.ID, Score: cosineSimilarity(embedding, r.Embedding)})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+func (s *LocalVectorStore) Count(ctx context.Context, filter VectorFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var count int64
+	for _, r := range s.records {
+		if filter.match(r.PersonaID, r.UserID) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *LocalVectorStore) ListIDs(ctx context.Context, filter VectorFilter) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.records))
+	for _, r := range s.records {
+		if filter.match(r.PersonaID, r.UserID) {
+			ids = append(ids, r.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *LocalVectorStore) Dimension() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dimension
+}
+
+// saveLocked 写入临时文件后替换，避免进程中途退出时留下损坏的文件
+func (s *LocalVectorStore) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	snapshot := localStoreSnapshot{
+		Dimension: s.dimension,
+		Records:   make([]localStoreRecord, 0, len(s.records)),
+	}
+	for _, r := range s.records {
+		snapshot.Records = append(snapshot.Records, localStoreRecord{
+			ID:        r.ID,
+			PersonaID: r.PersonaID,
+			UserID:    r.UserID,
+			Embedding: r.Embedding,
+		})
+	}
+	sort.Slice(snapshot.Records, func(i, j int) bool {
+		return snapshot.Records[i].ID < snapshot.Records[j].ID
+	})
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
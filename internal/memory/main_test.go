@@ -0,0 +1,16 @@
+package memory
+
+import (
+	"os"
+	"testing"
+
+	"AI_Chat/pkg/utils"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	// 测试不读取配置文件，日志统一丢弃
+	utils.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
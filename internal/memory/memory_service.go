@@ -5,18 +5,16 @@ import (
 	"AI_Chat/internal/repository"
 	"AI_Chat/pkg/ai_config"
 	"AI_Chat/pkg/utils"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
-	"sort"
 	"strings"
 	"time"
 
-	"github.com/cloudwego/eino-ext/components/model/deepseek"
 	"github.com/cloudwego/eino/schema"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
@@ -28,238 +26,309 @@ const (
 	ExtractThreshold         = 10 // 每10轮触发提取
 )
 
-type EmbeddingClient struct {
-	baseURL    string
-	apiKey     string
-	model      string
-	httpClient *http.Client
+type MemoryService struct {
+	memoryRepo  *repository.MemoryRepository
+	jobRepo     *repository.MemoryJobRepository
+	personaRepo *repository.PersonaRepository
+	redisClient *redis.Client
+	embedding   Embedder
+	vectorStore VectorStore
+	reranker    Reranker
+
+	reflectionThreshold int // 自动反思的阈值，<= 0 时不自动安排
 }
 
-func NewEmbeddingClient(config ai_config.DeepSeekEmbeddingModel) *EmbeddingClient {
-	if strings.TrimSpace(config.APIKey) == "" || strings.TrimSpace(config.Model) == "" {
-		return nil
-	}
-	baseURL := strings.TrimRight(config.BaseURL, "/")
-	if baseURL == "" {
-		baseURL = "https://api.deepseek.com"
-	}
-	return &EmbeddingClient{
-		baseURL: baseURL,
-		apiKey:  config.APIKey,
-		model:   config.Model,
-		httpClient: &http.Client{
-			Timeout: 15 * time.Second,
-		},
+func NewMemoryService(memoryRepo *repository.MemoryRepository, jobRepo *repository.MemoryJobRepository, personaRepo *repository.PersonaRepository, redisClient *redis.Client, embedder Embedder, vectorStore VectorStore, reranker Reranker) *MemoryService {
+	return &MemoryService{
+		memoryRepo:  memoryRepo,
+		jobRepo:     jobRepo,
+		personaRepo: personaRepo,
+		redisClient: redisClient,
+		embedding:   embedder,
+		vectorStore: vectorStore,
+		reranker:    reranker,
 	}
 }
 
-func (c *EmbeddingClient) Embed(ctx context.Context, input string) ([]float32, error) {
-	if c == nil {
-		return nil, fmt.Errorf("embedding client is nil")
-	}
-	if strings.TrimSpace(input) == "" {
-		return nil, fmt.Errorf("embedding input is empty")
+// AccumulateMessage 累积消息，达到阈值时取出缓存的全部轮次并写入提取任务队列。
+// 追加与取出在 Redis 中原子完成，同一会话的并发调用不会丢轮次或重复提取。
+// userMsg、aiReply 为已保存的消息，其 ID 随轮次缓存，提取后记录为记忆的来源。
+// threshold 为人格配置的提取阈值，<= 0 时使用 ExtractThreshold。
+func (s *MemoryService) AccumulateMessage(ctx context.Context, convId, personaId string, userId int64, userMsg, aiReply *model.Message, threshold int) error {
+	if threshold <= 0 {
+		threshold = ExtractThreshold
+	}
+	round := newPendingRound(userMsg.Content, aiReply.Content)
+	round.UserMsgID, round.AssistantMsgID = userMsg.ID, aiReply.ID
+	pending, err := s.appendPendingRound(ctx, convId, personaId, userId, round, threshold)
+	if err != nil || pending == nil {
+		return err
 	}
-	reqBody := map[string]interface{}{
-		"model":      c.model,
-		"input":      input,
-		"dimensions": 1536,
+	_, err = s.enqueuePending(ctx, pending)
+	return err
+}
+
+// FlushPendingMessages 立即取出会话缓存的全部轮次并写入提取任务队列，缓存为空时返回 nil
+func (s *MemoryService) FlushPendingMessages(ctx context.Context, convId string) (*model.MemoryJob, error) {
+	pending, err := s.drainPendingRounds(ctx, convId, time.Time{})
+	if err != nil || pending == nil {
+		return nil, err
 	}
-	body, err := json.Marshal(reqBody)
+	return s.enqueuePending(ctx, pending)
+}
+
+// enqueuePending 写入持久化任务队列，由后台 worker 提取；入队失败时放回缓存，之后再试
+func (s *MemoryService) enqueuePending(ctx context.Context, pending *model.PendingMessages) (*model.MemoryJob, error) {
+	job, err := s.EnqueueExtraction(pending)
 	if err != nil {
+		if restoreErr := s.restorePendingRounds(ctx, pending); restoreErr != nil {
+			return nil, fmt.Errorf("%w; 放回待提取缓存失败: %v", err, restoreErr)
+		}
 		return nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/embeddings", bytes.NewReader(body))
+	return job, nil
+}
+
+// ExtractMemoriesFromPending 从待处理消息中提取记忆。
+// 提取失败或所有写入都失败时返回错误，由任务队列重试；部分写入失败只记录日志，避免重试产生重复记忆。
+func (s *MemoryService) ExtractMemoriesFromPending(ctx context.Context, pending *model.PendingMessages) error {
+	if len(pending.Messages) == 0 {
+		return nil
+	}
+
+	// 1. 构建对话文本
+	conversationText := s.buildConversationText(pending.Messages)
+
+	// 2. 获取现有记忆，用于冲突检测；反思记忆由反思任务维护，不交给提取模型
+	existingMemories, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(pending.PersonaID, pending.UserID)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("获取现有记忆失败: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	existingMemories = withoutReflections(existingMemories)
 
-	resp, err := c.httpClient.Do(req)
+	// 3. 调用 LLM 提取并合并记忆，逐条校验，修复或跳过有问题的操作
+	actions, err := s.callLLMExtractAndMergeMemories(ctx, conversationText, existingMemories)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("提取并合并记忆失败: %w", err)
+	}
+	newMemories, validation := validateMemoryActions(actions, existingMemories, len(pending.Messages))
+	if validation.Repaired > 0 || validation.Skipped > 0 {
+		utils.Log.Warn("记忆Action校验",
+			zap.String("personaId", pending.PersonaID),
+			zap.Int("repaired", validation.Repaired),
+			zap.Int("skipped", validation.Skipped),
+			zap.Strings("issues", validation.Issues),
+		)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		bodyText := strings.TrimSpace(string(bodyBytes))
-		if len(bodyText) > 1000 {
-			bodyText = bodyText[:1000] + "..."
+	// 4. 构建新记忆；审核模式下写为待审核的候选，批准后才生成向量并取代旧记忆
+	reviewMode := s.reviewModeEnabled(pending.PersonaID)
+	status := model.MemoryStatusActive
+	if reviewMode {
+		status = model.MemoryStatusPendingReview
+	}
+	extracted := make([]extractedMemory, 0, len(newMemories))
+	for _, item := range newMemories {
+		if item.Action != "add" && (item.Action != "update" || item.OldMemoryID == "") {
+			continue
+		}
+		oldMemoryID := ""
+		if item.Action == "update" {
+			oldMemoryID = item.OldMemoryID
 		}
-		return nil, fmt.Errorf("embedding request failed: status=%d url=%s model=%s body=%s", resp.StatusCode, c.baseURL+"/v1/embeddings", c.model, bodyText)
+		mem := &model.Memory{
+			PersonaID:  pending.PersonaID,
+			UserID:     pending.UserID,
+			Type:       item.Type,
+			Content:    item.Content,
+			Keywords:   item.Keywords,
+			Importance: extractedImportance(item, existingMemories),
+			Source:     model.MemorySourceAuto,
+			Status:     status,
+		}
+		if reviewMode {
+			mem.ReplacesID = oldMemoryID
+		}
+		extracted = append(extracted, extractedMemory{
+			memory:      mem,
+			oldMemoryID: oldMemoryID,
+			sources:     s.extractionSources(pending, item),
+		})
+	}
+	if !reviewMode {
+		toEmbed := make([]*model.Memory, 0, len(extracted))
+		for _, item := range extracted {
+			toEmbed = append(toEmbed, item.memory)
+		}
+		s.PrepareMemoryEmbeddings(ctx, toEmbed)
 	}
 
-	var parsed struct {
-		Data []struct {
-			Embedding []float64 `json:"embedding"`
-		} `json:"data"`
+	// 5. 写入记忆；更新（合并冲突）时把旧记忆标记为 superseded
+	attempted, failed, lastErr := s.saveExtractedMemories(ctx, pending, extracted, reviewMode)
+	// 6. 失效和删除：只处理本人格本用户现有的活跃记忆；审核模式下进入审核队列
+	if retireFailed := s.applyRetireActions(ctx, pending, newMemories, existingMemories, reviewMode); retireFailed != nil {
+		attempted++
+		failed++
+		lastErr = retireFailed
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
-		return nil, err
+	if attempted > 0 && failed == attempted {
+		return fmt.Errorf("所有记忆写入均失败: %w", lastErr)
 	}
-	if len(parsed.Data) == 0 || len(parsed.Data[0].Embedding) == 0 {
-		return nil, fmt.Errorf("embedding response empty")
+	// 7. 新增的记忆足够多时安排一次反思
+	if len(extracted) > 0 {
+		s.maybeEnqueueReflection(pending.PersonaID, pending.UserID)
 	}
 
-	result := make([]float32, 0, len(parsed.Data[0].Embedding))
-	for _, v := range parsed.Data[0].Embedding {
-		result = append(result, float32(v))
-	}
-	return result, nil
+	return nil
 }
 
-type MemoryService struct {
-	memoryRepo  *repository.MemoryRepository
-	redisClient *redis.Client
-	embedding   *EmbeddingClient
-	milvusStore *MilvusStore
+// extractedMemory 提取得到的一条待写入记忆
+type extractedMemory struct {
+	memory      *model.Memory
+	oldMemoryID string // update 时被合并的旧记忆
+	sources     []model.MemorySourceMessage
 }
 
-func NewMemoryService(memoryRepo *repository.MemoryRepository, redisClient *redis.Client, milvusStore *MilvusStore) *MemoryService {
-	return &MemoryService{
-		memoryRepo:  memoryRepo,
-		redisClient: redisClient,
-		embedding:   NewEmbeddingClient(ai_config.DeepSeekEmbeddingConfig),
-		milvusStore: milvusStore,
+// saveExtractedMemories 写入提取得到的记忆，返回尝试和失败的条数及最后一个错误。
+// 更新时新版本的写入和旧记忆的取代在同一事务中完成：旧记忆在提取期间已被失效、删除或取代时放弃这条更新，
+// 避免已被用户要求忘记的内容随合并后的新记忆重新生效
+func (s *MemoryService) saveExtractedMemories(ctx context.Context, pending *model.PendingMessages, extracted []extractedMemory, reviewMode bool) (attempted, failed int, lastErr error) {
+	attempted = len(extracted)
+	for _, item := range extracted {
+		var err error
+		if reviewMode || item.oldMemoryID == "" {
+			err = s.memoryRepo.CreateMemoryWithSources(item.memory, item.sources)
+		} else {
+			err = s.memoryRepo.CreateMemoryVersion(item.memory, item.oldMemoryID, item.sources)
+		}
+		if errors.Is(err, repository.ErrMemoryNotActive) {
+			attempted--
+			utils.Log.Info("旧记忆已不再活跃，跳过更新", zap.String("personaId", pending.PersonaID), zap.String("memoryId", item.oldMemoryID))
+			continue
+		}
+		if err != nil {
+			failed++
+			lastErr = err
+			utils.Log.Error("存储记忆失败", zap.String("personaId", pending.PersonaID), zap.Error(err))
+			continue
+		}
+		if reviewMode {
+			continue
+		}
+		s.UpsertMemoryVector(ctx, item.memory)
+		if item.oldMemoryID != "" {
+			s.DeleteMemoryVector(ctx, item.oldMemoryID)
+		}
 	}
+	return attempted, failed, lastErr
 }
 
-// AccumulateMessage 累积消息，检查是否触发提取
-func (s *MemoryService) AccumulateMessage(ctx context.Context, convId, personaId string, userId int64, userMsg, aiReply string) error {
-	key := PendingMessagesKeyPrefix + convId
-
-	// 获取现有的待处理消息
-	pending, err := s.getPendingMessages(ctx, key)
-	if err != nil && err != redis.Nil {
-		return err
+// applyRetireActions 执行提取结果中的 invalidate/delete 操作，old_memory_id 必须是提取时列出的现有记忆。
+// 审核模式下不自动执行，而是为每条目标记忆生成待审核的请求，由用户批准后执行
+func (s *MemoryService) applyRetireActions(ctx context.Context, pending *model.PendingMessages, actions []MemoryAction, existing []model.Memory, reviewMode bool) error {
+	known := make(map[string]*model.Memory, len(existing))
+	for i := range existing {
+		known[existing[i].ID] = &existing[i]
 	}
-
-	if pending == nil {
-		pending = &model.PendingMessages{
-			ConversationID: convId,
-			PersonaID:      personaId,
-			UserID:         userId,
-			RoundCount:     0,
-			Messages:       []model.MessagePair{},
+	var invalidate, forget []string
+	for _, action := range actions {
+		if known[action.OldMemoryID] == nil {
+			continue
+		}
+		switch action.Action {
+		case "invalidate":
+			invalidate = append(invalidate, action.OldMemoryID)
+		case "delete":
+			forget = append(forget, action.OldMemoryID)
 		}
 	}
-
-	// 追加消息
-	pending.Messages = append(pending.Messages, model.MessagePair{
-		UserMsg:      userMsg,
-		AssistantMsg: aiReply,
-		Timestamp:    time.Now(),
-	})
-	pending.RoundCount++
-
-	// 检查是否达到阈值
-	if pending.RoundCount >= ExtractThreshold {
-		// 触发提取（异步）
-		go s.ExtractMemoriesFromPending(context.Background(), pending)
-		// 清空缓存
-		return s.clearPendingMessages(ctx, key)
+	if len(invalidate) == 0 && len(forget) == 0 {
+		return nil
 	}
-
-	// 保存到 Redis
-	return s.savePendingMessages(ctx, key, pending)
-}
-
-// getPendingMessages 从 Redis 获取待处理消息
-func (s *MemoryService) getPendingMessages(ctx context.Context, key string) (*model.PendingMessages, error) {
-	data, err := s.redisClient.Get(ctx, key).Bytes()
-	if err != nil {
-		return nil, err
+	if reviewMode {
+		return s.queueRetireRequests(pending, known, invalidate, forget)
 	}
-
-	var pending model.PendingMessages
-	if err := json.Unmarshal(data, &pending); err != nil {
-		return nil, err
+	var lastErr error
+	if _, err := s.InvalidateMemories(ctx, pending.PersonaID, pending.UserID, invalidate); err != nil {
+		utils.Log.Error("标记记忆失效失败", zap.String("personaId", pending.PersonaID), zap.Error(err))
+		lastErr = err
 	}
-	return &pending, nil
-}
-
-// savePendingMessages 保存待处理消息到 Redis
-func (s *MemoryService) savePendingMessages(ctx context.Context, key string, pending *model.PendingMessages) error {
-	data, err := json.Marshal(pending)
-	if err != nil {
-		return err
+	if _, err := s.ForgetMemories(ctx, pending.PersonaID, pending.UserID, forget); err != nil {
+		utils.Log.Error("删除记忆失败", zap.String("personaId", pending.PersonaID), zap.Error(err))
+		lastErr = err
 	}
-	return s.redisClient.Set(ctx, key, data, PendingMessagesTTL).Err()
-}
-
-// clearPendingMessages 清空待处理消息
-func (s *MemoryService) clearPendingMessages(ctx context.Context, key string) error {
-	return s.redisClient.Del(ctx, key).Err()
+	return lastErr
 }
 
-// ExtractMemoriesFromPending 从待处理消息中提取记忆
-func (s *MemoryService) ExtractMemoriesFromPending(ctx context.Context, pending *model.PendingMessages) error {
-	if len(pending.Messages) == 0 {
-		return nil
-	}
-
-	// 1. 构建对话文本
-	conversationText := s.buildConversationText(pending.Messages)
-
-	// 2. 获取现有记忆，用于冲突检测
-	existingMemories, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(pending.PersonaID, pending.UserID)
+// queueRetireRequests 为要失效或删除的记忆生成待审核请求，请求复制目标记忆的内容供审核时查看；
+// 同一条记忆已有待审核的失效/删除请求时不再重复生成
+func (s *MemoryService) queueRetireRequests(pending *model.PendingMessages, targets map[string]*model.Memory, invalidate, forget []string) error {
+	queued, err := s.memoryRepo.GetMemoriesByStatus(pending.PersonaID, pending.UserID, model.MemoryStatusPendingReview)
 	if err != nil {
-		fmt.Printf("获取现有记忆失败: %v\n", err)
-	}
-
-	// 3. 调用 LLM 提取并合并记忆
-	newMemories, err := s.callLLMExtractAndMergeMemories(ctx, conversationText, existingMemories)
-	if err != nil {
-		fmt.Printf("提取并合并记忆失败: %v\n", err)
 		return err
 	}
-
-	// 4. 处理提取结果
-	for _, item := range newMemories {
-		if item.Action == "add" {
-			// 新增记忆
-			memory := &model.Memory{
-				PersonaID: pending.PersonaID,
-				UserID:    pending.UserID,
-				Type:      item.Type,
-				Content:   item.Content,
-				Keywords:  item.Keywords,
-				Source:    model.MemorySourceAuto,
-				Status:    model.MemoryStatusActive,
-			}
-			s.PrepareMemoryEmbedding(ctx, memory)
-			if err := s.memoryRepo.CreateMemory(memory); err != nil {
-				fmt.Printf("存储新增记忆失败: %v\n", err)
-			} else {
-				s.UpsertMilvusEmbedding(ctx, memory)
+	requested := make(map[string]bool, len(queued))
+	for _, mem := range queued {
+		if mem.ReviewAction != "" {
+			requested[mem.ReplacesID] = true
+		}
+	}
+	var lastErr error
+	queue := func(ids []string, action string) {
+		for _, id := range ids {
+			if requested[id] {
+				continue
 			}
-		} else if item.Action == "update" && item.OldMemoryID != "" {
-			// 更新记忆（合并冲突）
-			// 1. 标记旧记忆为 superseded
-			newMemory := &model.Memory{
-				PersonaID: pending.PersonaID,
-				UserID:    pending.UserID,
-				Type:      item.Type,
-				Content:   item.Content,
-				Keywords:  item.Keywords,
-				Source:    model.MemorySourceAuto,
-				Status:    model.MemoryStatusActive,
+			requested[id] = true
+			target := targets[id]
+			request := &model.Memory{
+				PersonaID:    pending.PersonaID,
+				UserID:       pending.UserID,
+				Type:         target.Type,
+				Content:      target.Content,
+				Keywords:     target.Keywords,
+				Importance:   target.Importance,
+				Source:       model.MemorySourceAuto,
+				Status:       model.MemoryStatusPendingReview,
+				ReplacesID:   id,
+				ReviewAction: action,
 			}
-			s.PrepareMemoryEmbedding(ctx, newMemory)
-			if err := s.memoryRepo.CreateMemory(newMemory); err != nil {
-				fmt.Printf("存储更新后的记忆失败: %v\n", err)
+			if err := s.memoryRepo.CreateMemory(request); err != nil {
+				utils.Log.Error("生成待审核的失效/删除请求失败", zap.String("memoryId", id), zap.Error(err))
+				lastErr = err
 				continue
 			}
-			s.UpsertMilvusEmbedding(ctx, newMemory)
-			if err := s.memoryRepo.UpdateMemoryStatus(item.OldMemoryID, model.MemoryStatusSuperseded, newMemory.ID); err != nil {
-				fmt.Printf("更新旧记忆状态失败: %v\n", err)
-			}
+			utils.Log.Info("失效/删除请求进入审核队列",
+				zap.String("personaId", pending.PersonaID),
+				zap.String("memoryId", id),
+				zap.String("action", action),
+			)
 		}
 	}
+	queue(invalidate, model.MemoryReviewInvalidate)
+	queue(forget, model.MemoryReviewDelete)
+	return lastErr
+}
 
-	return nil
+// extractionSources 记忆的来源消息：模型给出的支撑轮次中的消息；更新时还沿用被合并的旧记忆的来源
+func (s *MemoryService) extractionSources(pending *model.PendingMessages, action MemoryAction) []model.MemorySourceMessage {
+	sources := make([]model.MemorySourceMessage, 0)
+	for _, round := range action.SourceRounds {
+		if round < 1 || round > len(pending.Messages) {
+			continue
+		}
+		for _, id := range pending.Messages[round-1].MessageIDs() {
+			sources = append(sources, model.MemorySourceMessage{MessageID: id, ConversationID: pending.ConversationID})
+		}
+	}
+	if action.Action == "update" && action.OldMemoryID != "" {
+		inherited, err := s.memoryRepo.GetMemorySources([]string{action.OldMemoryID})
+		if err != nil {
+			utils.Log.Warn("读取旧记忆来源失败", zap.String("memoryId", action.OldMemoryID), zap.Error(err))
+		}
+		sources = append(sources, inherited...)
+	}
+	return sources
 }
 
 // buildConversationText 构建对话文本
@@ -271,28 +340,41 @@ func (s *MemoryService) buildConversationText(messages []model.MessagePair) stri
 	return text
 }
 
+// MemoryAction 提取模型给出的记忆操作
 type MemoryAction struct {
-	Action      string `json:"action"` // add, update, none
+	Action      string `json:"action"` // add, update, invalidate, delete, none
 	OldMemoryID string `json:"old_memory_id,omitempty"`
 	Type        string `json:"type"`
 	Content     string `json:"content"`
 	Keywords    string `json:"keywords"`
+	Importance  int    `json:"importance"` // 1~10
+	// SourceRounds 支撑该记忆的对话轮次（从 1 开始，对应对话文本中的「第N轮」）
+	SourceRounds []int `json:"source_rounds"`
+}
+
+// extractedImportance 取模型评估的重要度；未给出时，更新沿用旧记忆的重要度，新增使用默认值
+func extractedImportance(action MemoryAction, existing []model.Memory) int {
+	if action.Importance != 0 {
+		return model.ClampImportance(action.Importance)
+	}
+	for _, mem := range existing {
+		if action.Action == "update" && mem.ID == action.OldMemoryID {
+			return model.ClampImportance(mem.Importance)
+		}
+	}
+	return model.MemoryImportanceDefault
 }
 
 // callLLMExtractAndMergeMemories 调用 LLM 提取并处理冲突
 func (s *MemoryService) callLLMExtractAndMergeMemories(ctx context.Context, conversationText string, existing []model.Memory) ([]MemoryAction, error) {
-	cm, err := deepseek.NewChatModel(ctx, &deepseek.ChatModelConfig{
-		APIKey:  ai_config.DeepSeekChatConfig.APIKey,
-		Model:   ai_config.DeepSeekChatConfig.Model,
-		BaseURL: ai_config.DeepSeekChatConfig.BaseURL,
-	})
+	cm, err := ai_config.NewChatModel(ctx, ai_config.MemoryChatProvider)
 	if err != nil {
 		return nil, err
 	}
 
 	existingText := ""
 	for _, m := range existing {
-		existingText += fmt.Sprintf("- ID: %s, 内容: %s\n", m.ID, m.Content)
+		existingText += fmt.Sprintf("- ID: %s, 内容: %s, 重要度: %d\n", m.ID, m.Content, model.ClampImportance(m.Importance))
 	}
 
 	systemPrompt := `你是记忆管理助手。请分析对话并更新用户记忆。
@@ -302,14 +384,21 @@ func (s *MemoryService) callLLMExtractAndMergeMemories(ctx context.Context, conv
 2. 对比【现有记忆】，判断新信息是：
    - 新增 (add)：现有记忆中没有相关信息。
    - 更新 (update)：新信息与某条现有记忆相关且存在冲突或补充（需合并）。
+   - 失效 (invalidate)：对话表明某条现有记忆已不再成立（如用户说已经辞职、已经搬家、已经分手），且没有可以替代它的新信息。
+   - 删除 (delete)：用户明确要求忘记某条现有记忆（如"忘掉我说过的……"）。
    - 无需操作 (none)：新信息已存在或无价值。
-3. 如果是更新，请生成合并后的精炼内容，并提供对应的 old_memory_id。
+3. 如果是更新，请生成合并后的精炼内容，并提供对应的 old_memory_id；失效和删除只需提供 old_memory_id。
+4. 在 source_rounds 中列出支撑该记忆的对话轮次编号（即【新对话】中的「第N轮」）。
+5. 为每条新增或更新的记忆评估重要度 importance（1~10 的整数）：1 表示日常琐事（如今天吃了什么），10 表示对用户影响深远的信息（如亲人离世、结婚、重大疾病）。
 
-输出格式 JSON:
+type 只能是 fact、preference、event、emotion、relationship 之一。
+请调用 submit_memory_actions 工具提交结果；无法调用工具时，只输出如下格式的 JSON，不要附加其他文字:
 {
   "actions": [
-    {"action": "add", "type": "fact", "content": "...", "keywords": "..."},
-    {"action": "update", "old_memory_id": "mem:xxx", "type": "preference", "content": "合并后的内容", "keywords": "..."}
+    {"action": "add", "type": "fact", "content": "...", "keywords": "...", "importance": 6, "source_rounds": [1, 3]},
+    {"action": "update", "old_memory_id": "mem:xxx", "type": "preference", "content": "合并后的内容", "keywords": "...", "importance": 4, "source_rounds": [2]},
+    {"action": "invalidate", "old_memory_id": "mem:yyy"},
+    {"action": "delete", "old_memory_id": "mem:zzz"}
   ]
 }`
 
@@ -320,24 +409,19 @@ func (s *MemoryService) callLLMExtractAndMergeMemories(ctx context.Context, conv
 		{Role: schema.User, Content: userPrompt},
 	}
 
-	resp, err := cm.Generate(ctx, messages)
+	resp, err := generateStructured(ctx, cm, messages, memoryActionsToolInfo())
 	if err != nil {
 		return nil, err
 	}
 
-	content := resp.Content
-	content = strings.TrimPrefix(content, "```json")
-	content = strings.TrimSuffix(content, "```")
-	content = strings.TrimSpace(content)
-
-	var result struct {
-		Actions []MemoryAction `json:"actions"`
+	actions, malformed, err := parseMemoryActions(resp)
+	if err != nil {
+		return nil, fmt.Errorf("解析记忆Action失败: %w", err)
 	}
-	if err := json.Unmarshal([]byte(content), &result); err != nil {
-		return nil, fmt.Errorf("解析记忆Action失败: %v, content: %s", err, content)
+	if malformed > 0 {
+		utils.Log.Warn("跳过格式错误的记忆Action", zap.Int("count", malformed))
 	}
-
-	return result.Actions, nil
+	return actions, nil
 }
 
 func (s *MemoryService) PrepareMemoryEmbedding(ctx context.Context, memory *model.Memory) {
@@ -348,13 +432,26 @@ func (s *MemoryService) PrepareMemoryEmbedding(ctx context.Context, memory *mode
 	if err != nil {
 		return
 	}
-	embeddingText, err := marshalEmbedding(embedding)
+	_ = setMemoryEmbedding(memory, embedding)
+}
+
+// PrepareMemoryEmbeddings 批量为尚未入库的记忆生成向量，失败时保持为空，写入向量存储时会再尝试
+func (s *MemoryService) PrepareMemoryEmbeddings(ctx context.Context, memories []*model.Memory) {
+	if s.embedding == nil || len(memories) == 0 {
+		return
+	}
+	inputs := make([]string, 0, len(memories))
+	for _, memory := range memories {
+		inputs = append(inputs, memory.Content)
+	}
+	embeddings, err := s.embedding.EmbedBatch(ctx, inputs)
 	if err != nil {
+		utils.Log.Warn("批量生成记忆向量失败", zap.Int("count", len(memories)), zap.Error(err))
 		return
 	}
-	now := time.Now()
-	memory.Embedding = embeddingText
-	memory.EmbeddingUpdatedAt = &now
+	for i, memory := range memories {
+		_ = setMemoryEmbedding(memory, embeddings[i])
+	}
 }
 
 func (s *MemoryService) EnsureMemoryEmbedding(ctx context.Context, memory *model.Memory) {
@@ -365,148 +462,98 @@ func (s *MemoryService) EnsureMemoryEmbedding(ctx context.Context, memory *model
 	if err != nil {
 		return
 	}
-	embeddingText, err := marshalEmbedding(embedding)
-	if err != nil {
+	updated := *memory
+	if err := setMemoryEmbedding(&updated, embedding); err != nil {
 		return
 	}
-	if err := s.memoryRepo.UpdateMemoryEmbedding(memory.ID, embeddingText); err != nil {
+	if err := s.memoryRepo.UpdateMemoryEmbedding(updated.ID, updated.Embedding, updated.EmbeddingChecksum); err != nil {
 		return
 	}
-	memory.Embedding = embeddingText
-	now := time.Now()
-	memory.EmbeddingUpdatedAt = &now
+	*memory = updated
 }
 
-func (s *MemoryService) UpsertMilvusEmbedding(ctx context.Context, memory *model.Memory) {
-	if s.milvusStore == nil || memory == nil || memory.ID == "" {
-		return
+// UpsertMemoryVector 把记忆向量写入向量存储，记忆没有向量或向量已过期时先重新生成。
+// 失败只记录日志，遗漏的向量可以通过 reindex 补齐。
+func (s *MemoryService) UpsertMemoryVector(ctx context.Context, memory *model.Memory) error {
+	if s.vectorStore == nil || memory == nil || memory.ID == "" {
+		return nil
 	}
-	vec, err := parseEmbedding(memory.Embedding)
-	if err != nil || len(vec) == 0 {
+	vec, fresh := s.freshEmbedding(memory)
+	if !fresh {
 		if s.embedding == nil {
-			return
+			return fmt.Errorf("embedding disabled")
 		}
 		embedding, err := s.embedding.Embed(ctx, memory.Content)
 		if err != nil {
-			return
+			utils.Log.Warn("生成记忆向量失败", zap.String("memoryId", memory.ID), zap.Error(err))
+			return err
 		}
-		memory.Embedding, err = marshalEmbedding(embedding)
-		if err != nil {
-			return
+		if err := setMemoryEmbedding(memory, embedding); err != nil {
+			return err
 		}
 		vec = embedding
-		now := time.Now()
-		memory.EmbeddingUpdatedAt = &now
-		_ = s.memoryRepo.UpdateMemoryEmbedding(memory.ID, memory.Embedding)
+		if err := s.memoryRepo.UpdateMemoryEmbedding(memory.ID, memory.Embedding, memory.EmbeddingChecksum); err != nil {
+			utils.Log.Warn("保存记忆向量失败", zap.String("memoryId", memory.ID), zap.Error(err))
+		}
 	}
-	_ = s.milvusStore.UpsertMemory(ctx, memory.ID, memory.PersonaID, memory.UserID, vec)
-}
-
-func (s *MemoryService) DeleteMilvusMemory(ctx context.Context, memoryID string) {
-	if s.milvusStore == nil || memoryID == "" {
-		return
+	err := s.vectorStore.Upsert(ctx, VectorRecord{
+		ID:        memory.ID,
+		PersonaID: memory.PersonaID,
+		UserID:    memory.UserID,
+		Embedding: vec,
+	})
+	if err != nil {
+		utils.Log.Warn("写入向量存储失败", zap.String("memoryId", memory.ID), zap.Error(err))
 	}
-	_ = s.milvusStore.DeleteMemory(ctx, memoryID)
+	return err
 }
 
-// RetrieveMemories 检索相关记忆（用于聊天时注入）
-func (s *MemoryService) RetrieveMemories(ctx context.Context, personaId string, userId int64, query string, topK int) ([]model.Memory, error) {
-	if s.embedding == nil || strings.TrimSpace(query) == "" || topK <= 0 {
-		utils.Log.Info("记忆检索来源",
-			zap.String("source", "db"),
-			zap.String("reason", "embedding_disabled_or_query_empty"),
-			zap.String("personaId", personaId),
-			zap.Int64("userId", userId),
-			zap.Int("topK", topK),
-		)
-		return s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
+// DeleteMemoryVector 从向量存储中删除记忆向量，失败只记录日志
+func (s *MemoryService) DeleteMemoryVector(ctx context.Context, memoryIDs ...string) error {
+	if s.vectorStore == nil || len(memoryIDs) == 0 {
+		return nil
 	}
-
-	queryEmbedding, err := s.embedding.Embed(ctx, query)
+	err := s.vectorStore.Delete(ctx, memoryIDs...)
 	if err != nil {
-		utils.Log.Info("记忆检索来源",
-			zap.String("source", "db"),
-			zap.String("reason", "embed_failed"),
-			zap.String("personaId", personaId),
-			zap.Int64("userId", userId),
-			zap.Int("topK", topK),
-		)
-		return s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
-	}
-
-	if s.milvusStore != nil {
-		ids, err := s.milvusStore.SearchMemories(ctx, personaId, userId, queryEmbedding, topK)
-		if err == nil && len(ids) > 0 {
-			records, err := s.memoryRepo.GetMemoriesByIDs(personaId, userId, ids)
-			if err == nil && len(records) > 0 {
-				byID := make(map[string]model.Memory, len(records))
-				for _, mem := range records {
-					byID[mem.ID] = mem
-				}
-				ordered := make([]model.Memory, 0, len(ids))
-				for _, id := range ids {
-					if mem, ok := byID[id]; ok {
-						ordered = append(ordered, mem)
-					}
-				}
-				if len(ordered) > 0 {
-					utils.Log.Info("记忆检索来源",
-						zap.String("source", "milvus"),
-						zap.String("personaId", personaId),
-						zap.Int64("userId", userId),
-						zap.Int("topK", topK),
-						zap.Int("count", len(ordered)),
-					)
-					return ordered, nil
-				}
-			}
-		}
+		utils.Log.Warn("删除向量失败", zap.Strings("memoryIds", memoryIDs), zap.Error(err))
 	}
+	return err
+}
 
-	utils.Log.Info("记忆检索来源",
-		zap.String("source", "db"),
-		zap.String("reason", "milvus_miss_or_unavailable"),
-		zap.String("personaId", personaId),
-		zap.Int64("userId", userId),
-		zap.Int("topK", topK),
-	)
-	memories, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
-	if err != nil {
-		return nil, err
+// freshEmbedding 解析记忆中保存的向量，内容已修改或维度与当前向量化不一致时视为过期
+func (s *MemoryService) freshEmbedding(memory *model.Memory) ([]float32, bool) {
+	vec, err := parseEmbedding(memory.Embedding)
+	if err != nil || len(vec) == 0 {
+		return nil, false
 	}
-
-	type scoredMemory struct {
-		memory model.Memory
-		score  float32
+	if memory.EmbeddingChecksum != embeddingChecksum(memory.Content) {
+		return nil, false
 	}
-	scored := make([]scoredMemory, 0, len(memories))
-	for _, mem := range memories {
-		if mem.Embedding == "" {
-			s.EnsureMemoryEmbedding(ctx, &mem)
-		}
-		vec, err := parseEmbedding(mem.Embedding)
-		if err != nil || len(vec) == 0 {
-			continue
-		}
-		score := cosineSimilarity(queryEmbedding, vec)
-		scored = append(scored, scoredMemory{memory: mem, score: score})
+	if s.embedding != nil && len(vec) != s.embedding.Dimension() {
+		return nil, false
 	}
+	return vec, true
+}
 
-	if len(scored) == 0 {
-		return memories, nil
+// RetrieveMemories 检索相关记忆（用于聊天时注入）并记录命中，使用配置中的检索参数；查询为空时返回全部有效记忆
+func (s *MemoryService) RetrieveMemories(ctx context.Context, personaId string, userId int64, query string, topK int) ([]model.Memory, error) {
+	if strings.TrimSpace(query) == "" || topK <= 0 {
+		return s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
 	}
-
-	sort.Slice(scored, func(i, j int) bool {
-		return scored[i].score > scored[j].score
-	})
-	if topK > len(scored) {
-		topK = len(scored)
+	scored, err := s.RecallMemories(ctx, personaId, userId, query, RetrievalOptions{TopK: topK})
+	if err != nil {
+		return nil, err
 	}
-	result := make([]model.Memory, 0, topK)
-	for i := 0; i < topK; i++ {
-		result = append(result, scored[i].memory)
+	return ScoredMemoriesToMemories(scored), nil
+}
+
+// ScoredMemoriesToMemories 去掉检索得分，只保留记忆
+func ScoredMemoriesToMemories(scored []ScoredMemory) []model.Memory {
+	memories := make([]model.Memory, 0, len(scored))
+	for _, result := range scored {
+		memories = append(memories, result.Memory)
 	}
-	return result, nil
+	return memories
 }
 
 // FormatMemoriesForPrompt 格式化记忆用于注入 Prompt
@@ -515,13 +562,47 @@ func (s *MemoryService) FormatMemoriesForPrompt(memories []model.Memory) string
 		return ""
 	}
 
-	result := "## 你对用户的了解：\n"
+	// 反思记忆是从多条记忆中归纳出的认识，单独列出
+	facts, insights := "", ""
 	for _, mem := range memories {
-		result += fmt.Sprintf("- %s\n", mem.Content)
+		if mem.Type == model.MemoryTypeReflection {
+			insights += fmt.Sprintf("- %s\n", mem.Content)
+		} else {
+			facts += fmt.Sprintf("- %s\n", mem.Content)
+		}
+	}
+	result := ""
+	if facts != "" {
+		result += "## 你对用户的了解：\n" + facts
+	}
+	if insights != "" {
+		if result != "" {
+			result += "\n"
+		}
+		result += "## 你对用户的深层理解：\n" + insights
 	}
 	return result
 }
 
+// setMemoryEmbedding 写入向量及其对应内容的校验值
+func setMemoryEmbedding(memory *model.Memory, vec []float32) error {
+	embeddingText, err := marshalEmbedding(vec)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	memory.Embedding = embeddingText
+	memory.EmbeddingChecksum = embeddingChecksum(memory.Content)
+	memory.EmbeddingUpdatedAt = &now
+	return nil
+}
+
+// embeddingChecksum 生成向量时内容的校验值，用于判断向量是否过期
+func embeddingChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
 func marshalEmbedding(vec []float32) (string, error) {
 	if len(vec) == 0 {
 		return "", fmt.Errorf("empty embedding")
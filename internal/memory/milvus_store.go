@@ -2,7 +2,9 @@ package memory
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"strings"
 
@@ -140,50 +142,63 @@ func (s *MilvusStore) verifyCollection(ctx context.Context) error {
 	return fmt.Errorf("milvus embedding field not found")
 }
 
-func (s *MilvusStore) UpsertMemory(ctx context.Context, memoryID, personaID string, userID int64, embedding []float32) error {
+func (s *MilvusStore) Upsert(ctx context.Context, records ...VectorRecord) error {
 	if s == nil || s.client == nil {
 		return fmt.Errorf("milvus client not initialized")
 	}
-	if memoryID == "" || personaID == "" || userID == 0 {
-		return fmt.Errorf("invalid memory identity")
+	if len(records) == 0 {
+		return nil
 	}
-	if len(embedding) == 0 {
-		return fmt.Errorf("empty embedding")
+	ids := make([]string, 0, len(records))
+	personaIDs := make([]string, 0, len(records))
+	userIDs := make([]int64, 0, len(records))
+	vectors := make([][]float32, 0, len(records))
+	for _, r := range records {
+		if r.ID == "" || r.PersonaID == "" || r.UserID == 0 {
+			return fmt.Errorf("invalid memory identity")
+		}
+		if len(r.Embedding) == 0 {
+			return fmt.Errorf("empty embedding")
+		}
+		ids = append(ids, r.ID)
+		personaIDs = append(personaIDs, r.PersonaID)
+		userIDs = append(userIDs, r.UserID)
+		vectors = append(vectors, r.Embedding)
 	}
-	if err := s.ensureCollection(ctx, len(embedding)); err != nil {
+	if err := s.ensureCollection(ctx, len(vectors[0])); err != nil {
 		return err
 	}
-	if s.dimension != len(embedding) {
-		return fmt.Errorf("embedding dim mismatch: %d vs %d", s.dimension, len(embedding))
+	for _, vec := range vectors {
+		if s.dimension != len(vec) {
+			return fmt.Errorf("embedding dim mismatch: %d vs %d", s.dimension, len(vec))
+		}
 	}
 
-	expr := fmt.Sprintf("id == \"%s\"", escapeExprString(memoryID))
-	_ = s.client.Delete(ctx, s.collection, "", expr)
+	_ = s.client.Delete(ctx, s.collection, "", idInExpr(ids))
 
 	columns := []entity.Column{
-		entity.NewColumnVarChar("id", []string{memoryID}),
-		entity.NewColumnVarChar("persona_id", []string{personaID}),
-		entity.NewColumnInt64("user_id", []int64{userID}),
-		entity.NewColumnFloatVector("embedding", s.dimension, [][]float32{embedding}),
+		entity.NewColumnVarChar("id", ids),
+		entity.NewColumnVarChar("persona_id", personaIDs),
+		entity.NewColumnInt64("user_id", userIDs),
+		entity.NewColumnFloatVector("embedding", s.dimension, vectors),
 	}
 	_, err := s.client.Insert(ctx, s.collection, "", columns...)
 	return err
 }
 
-func (s *MilvusStore) DeleteMemory(ctx context.Context, memoryID string) error {
-	if s == nil || s.client == nil || memoryID == "" {
+func (s *MilvusStore) Delete(ctx context.Context, ids ...string) error {
+	if s == nil || s.client == nil || len(ids) == 0 {
 		return nil
 	}
-	expr := fmt.Sprintf("id == \"%s\"", escapeExprString(memoryID))
-	return s.client.Delete(ctx, s.collection, "", expr)
+	return s.client.Delete(ctx, s.collection, "", idInExpr(ids))
 }
 
-func (s *MilvusStore) SearchMemories(ctx context.Context, personaID string, userID int64, embedding []float32, topK int) ([]string, error) {
+func (s *MilvusStore) Search(ctx context.Context, filter VectorFilter, embedding []float32, topK int) ([]VectorSearchResult, error) {
 	if s == nil || s.client == nil {
 		return nil, fmt.Errorf("milvus client not initialized")
 	}
 	if topK <= 0 || len(embedding) == 0 {
-		return []string{}, nil
+		return []VectorSearchResult{}, nil
 	}
 	if err := s.ensureCollection(ctx, len(embedding)); err != nil {
 		return nil, err
@@ -195,20 +210,140 @@ func (s *MilvusStore) SearchMemories(ctx context.Context, personaID string, user
 	if err != nil {
 		return nil, err
 	}
-	expr := fmt.Sprintf("persona_id == \"%s\" && user_id == %d", escapeExprString(personaID), userID)
 	vectors := []entity.Vector{entity.FloatVector(embedding)}
-	results, err := s.client.Search(ctx, s.collection, nil, expr, []string{}, vectors, "embedding", s.metricType, topK, sp)
+	results, err := s.client.Search(ctx, s.collection, nil, filterExpr(filter), []string{}, vectors, "embedding", s.metricType, topK, sp)
 	if err != nil {
 		return nil, err
 	}
 	if len(results) == 0 || results[0].ResultCount == 0 {
-		return []string{}, nil
+		return []VectorSearchResult{}, nil
 	}
 	idsColumn, ok := results[0].IDs.(*entity.ColumnVarChar)
 	if !ok {
 		return nil, fmt.Errorf("unexpected id column type")
 	}
-	return idsColumn.Data(), nil
+	ids := idsColumn.Data()
+	found := make([]VectorSearchResult, 0, len(ids))
+	for i, id := range ids {
+		var score float32
+		if i < len(results[0].Scores) {
+			score = results[0].Scores[i]
+		}
+		// L2 返回的是距离，取反后与其他度量一样越大越相似
+		if s.metricType == entity.L2 {
+			score = -score
+		}
+		found = append(found, VectorSearchResult{ID: id, Score: score})
+	}
+	return found, nil
+}
+
+func (s *MilvusStore) Count(ctx context.Context, filter VectorFilter) (int64, error) {
+	if s == nil || s.client == nil {
+		return 0, fmt.Errorf("milvus client not initialized")
+	}
+	has, err := s.client.HasCollection(ctx, s.collection)
+	if err != nil || !has {
+		return 0, err
+	}
+	if err := s.client.LoadCollection(ctx, s.collection, false); err != nil {
+		return 0, err
+	}
+	expr := filterExpr(filter)
+	if expr == "" {
+		expr = "id != \"\""
+	}
+	columns, err := s.client.Query(ctx, s.collection, nil, expr, []string{"count(*)"})
+	if err != nil {
+		return 0, err
+	}
+	for _, column := range columns {
+		if countColumn, ok := column.(*entity.ColumnInt64); ok && countColumn.Len() > 0 {
+			return countColumn.Data()[0], nil
+		}
+	}
+	return 0, fmt.Errorf("milvus count result missing")
+}
+
+// milvusListBatchSize 列出 ID 时每批读取的数量
+const milvusListBatchSize = 1000
+
+func (s *MilvusStore) ListIDs(ctx context.Context, filter VectorFilter) ([]string, error) {
+	if s == nil || s.client == nil {
+		return nil, fmt.Errorf("milvus client not initialized")
+	}
+	has, err := s.client.HasCollection(ctx, s.collection)
+	if err != nil || !has {
+		return []string{}, err
+	}
+	if err := s.client.LoadCollection(ctx, s.collection, false); err != nil {
+		return nil, err
+	}
+	expr := filterExpr(filter)
+	if expr == "" {
+		expr = "id != \"\""
+	}
+	iterator, err := s.client.QueryIterator(ctx, client.NewQueryIteratorOption(s.collection).
+		WithExpr(expr).
+		WithOutputFields("id").
+		WithBatchSize(milvusListBatchSize))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for {
+		rs, err := iterator.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		column, ok := rs.GetColumn("id").(*entity.ColumnVarChar)
+		if !ok {
+			return nil, fmt.Errorf("unexpected id column type")
+		}
+		ids = append(ids, column.Data()...)
+	}
+}
+
+// Dimension 配置或集合中的向量维度，集合尚未创建且未配置时为 0
+func (s *MilvusStore) Dimension() int {
+	if s == nil {
+		return 0
+	}
+	return s.dimension
+}
+
+// CheckDimension 启动时检查已有集合的维度，集合不存在时跳过
+func (s *MilvusStore) CheckDimension(ctx context.Context) error {
+	if s == nil || s.client == nil {
+		return fmt.Errorf("milvus client not initialized")
+	}
+	has, err := s.client.HasCollection(ctx, s.collection)
+	if err != nil || !has {
+		return err
+	}
+	return s.verifyCollection(ctx)
+}
+
+func filterExpr(filter VectorFilter) string {
+	conditions := make([]string, 0, 2)
+	if filter.PersonaID != "" {
+		conditions = append(conditions, fmt.Sprintf("persona_id == \"%s\"", escapeExprString(filter.PersonaID)))
+	}
+	if filter.UserID != 0 {
+		conditions = append(conditions, fmt.Sprintf("user_id == %d", filter.UserID))
+	}
+	return strings.Join(conditions, " && ")
+}
+
+func idInExpr(ids []string) string {
+	quoted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		quoted = append(quoted, "\""+escapeExprString(id)+"\"")
+	}
+	return "id in [" + strings.Join(quoted, ", ") + "]"
 }
 
 func escapeExprString(input string) string {
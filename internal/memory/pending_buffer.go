@@ -0,0 +1,211 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// 待提取的对话轮次以 Redis List 存储，每个元素是一个 MessagePair 的 JSON。
+// 追加、阈值判断和取出都在同一个 Lua 脚本里完成，同一会话并发写入时不会丢轮次，也不会重复触发提取。
+// 另外维护两个辅助 key：
+//   - PendingIndexKey：有序集合，member 为会话 ID，score 为最后一次追加的时间（毫秒），用于查找空闲会话
+//   - PendingMetaKeyPrefix+会话ID：哈希，记录会话所属的人格和用户，取出时组装 PendingMessages
+const (
+	PendingIndexKey      = "chat:pending_index"
+	PendingMetaKeyPrefix = "chat:pending_meta:"
+)
+
+// appendPendingScript 追加一轮并刷新过期时间；长度达到阈值时取出全部轮次并清理 key。
+// KEYS: 缓存列表、空闲索引、会话元信息。
+// ARGV: 本轮 JSON、阈值、过期时间（毫秒）、当前时间（毫秒）、会话 ID、人格 ID、用户 ID。
+// 返回 {当前长度, 取出的轮次...}，未达到阈值时只有长度。
+// 旧版本把整个 PendingMessages 存成一个字符串，遇到时先转换成列表。
+var appendPendingScript = redis.NewScript(`
+if redis.call('TYPE', KEYS[1]).ok == 'string' then
+	local old = cjson.decode(redis.call('GET', KEYS[1]))
+	redis.call('DEL', KEYS[1])
+	for _, pair in ipairs(old.messages or {}) do
+		redis.call('RPUSH', KEYS[1], cjson.encode(pair))
+	end
+end
+local length = redis.call('RPUSH', KEYS[1], ARGV[1])
+if length < tonumber(ARGV[2]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+	redis.call('HSET', KEYS[3], 'persona_id', ARGV[6], 'user_id', ARGV[7])
+	redis.call('PEXPIRE', KEYS[3], ARGV[3])
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[5])
+	return {length}
+end
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1], KEYS[3])
+redis.call('ZREM', KEYS[2], ARGV[5])
+local result = {length}
+for i = 1, #items do
+	result[#result + 1] = items[i]
+end
+return result
+`)
+
+// drainPendingScript 取出会话缓存的全部轮次并清理 key。
+// KEYS 同上；ARGV: 会话 ID、空闲截止时间（毫秒，为空表示不检查）。
+// 设置了截止时间且会话在此之后仍有新消息时不取出。
+// 返回 {人格 ID, 用户 ID, 轮次...}，没有可取出的轮次时返回空。
+var drainPendingScript = redis.NewScript(`
+if ARGV[2] ~= '' then
+	local score = redis.call('ZSCORE', KEYS[2], ARGV[1])
+	if score and tonumber(score) > tonumber(ARGV[2]) then
+		return {}
+	end
+end
+local items = redis.call('LRANGE', KEYS[1], 0, -1)
+local meta = redis.call('HMGET', KEYS[3], 'persona_id', 'user_id')
+redis.call('DEL', KEYS[1], KEYS[3])
+redis.call('ZREM', KEYS[2], ARGV[1])
+if #items == 0 or not meta[1] or not meta[2] then
+	return {}
+end
+local result = {meta[1], meta[2]}
+for i = 1, #items do
+	result[#result + 1] = items[i]
+end
+return result
+`)
+
+// restorePendingScript 把取出但未能入队的轮次放回列表头部，保持原有顺序。
+// KEYS 同上；ARGV: 过期时间（毫秒）、当前时间（毫秒）、会话 ID、人格 ID、用户 ID、按时间顺序排列的轮次...
+var restorePendingScript = redis.NewScript(`
+for i = #ARGV, 6, -1 do
+	redis.call('LPUSH', KEYS[1], ARGV[i])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[3], 'persona_id', ARGV[4], 'user_id', ARGV[5])
+redis.call('PEXPIRE', KEYS[3], ARGV[1])
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return redis.call('LLEN', KEYS[1])
+`)
+
+func pendingMessagesKey(convId string) string {
+	return PendingMessagesKeyPrefix + convId
+}
+
+func pendingScriptKeys(convId string) []string {
+	return []string{pendingMessagesKey(convId), PendingIndexKey, PendingMetaKeyPrefix + convId}
+}
+
+// appendPendingRound 原子地追加一轮对话。达到阈值时返回取出的全部轮次，否则返回 nil。
+func (s *MemoryService) appendPendingRound(ctx context.Context, convId, personaId string, userId int64, round model.MessagePair, threshold int) (*model.PendingMessages, error) {
+	data, err := json.Marshal(round)
+	if err != nil {
+		return nil, err
+	}
+	res, err := appendPendingScript.Run(ctx, s.redisClient, pendingScriptKeys(convId),
+		data, threshold, PendingMessagesTTL.Milliseconds(), time.Now().UnixMilli(),
+		convId, personaId, userId,
+	).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) <= 1 {
+		return nil, nil
+	}
+	rounds, err := decodePendingRounds(res[1:])
+	if err != nil {
+		return nil, err
+	}
+	return newPendingMessages(convId, personaId, userId, rounds), nil
+}
+
+// drainPendingRounds 取出会话缓存的全部轮次。idleBefore 不为零时，只有最后一轮早于该时间才取出。
+// 没有可取出的轮次时返回 nil。
+func (s *MemoryService) drainPendingRounds(ctx context.Context, convId string, idleBefore time.Time) (*model.PendingMessages, error) {
+	cutoff := ""
+	if !idleBefore.IsZero() {
+		cutoff = strconv.FormatInt(idleBefore.UnixMilli(), 10)
+	}
+	res, err := drainPendingScript.Run(ctx, s.redisClient, pendingScriptKeys(convId), convId, cutoff).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(res) <= 2 {
+		return nil, nil
+	}
+	personaId, _ := res[0].(string)
+	userIdText, _ := res[1].(string)
+	userId, err := strconv.ParseInt(userIdText, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pending user id %q: %w", userIdText, err)
+	}
+	rounds, err := decodePendingRounds(res[2:])
+	if err != nil {
+		return nil, err
+	}
+	return newPendingMessages(convId, personaId, userId, rounds), nil
+}
+
+// restorePendingRounds 把取出的轮次放回缓存头部
+func (s *MemoryService) restorePendingRounds(ctx context.Context, pending *model.PendingMessages) error {
+	if len(pending.Messages) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(pending.Messages)+5)
+	args = append(args, PendingMessagesTTL.Milliseconds(), time.Now().UnixMilli(),
+		pending.ConversationID, pending.PersonaID, pending.UserID)
+	for _, round := range pending.Messages {
+		data, err := json.Marshal(round)
+		if err != nil {
+			return err
+		}
+		args = append(args, data)
+	}
+	return restorePendingScript.Run(ctx, s.redisClient, pendingScriptKeys(pending.ConversationID), args...).Err()
+}
+
+// listIdleConversations 获取最后一轮早于 idleBefore 的会话 ID
+func (s *MemoryService) listIdleConversations(ctx context.Context, idleBefore time.Time, limit int64) ([]string, error) {
+	return s.redisClient.ZRangeByScore(ctx, PendingIndexKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(idleBefore.UnixMilli(), 10),
+		Count: limit,
+	}).Result()
+}
+
+func decodePendingRounds(items []interface{}) ([]model.MessagePair, error) {
+	rounds := make([]model.MessagePair, 0, len(items))
+	for _, item := range items {
+		raw, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected pending item type %T", item)
+		}
+		var pair model.MessagePair
+		if err := json.Unmarshal([]byte(raw), &pair); err != nil {
+			return nil, err
+		}
+		rounds = append(rounds, pair)
+	}
+	return rounds, nil
+}
+
+func newPendingMessages(convId, personaId string, userId int64, rounds []model.MessagePair) *model.PendingMessages {
+	return &model.PendingMessages{
+		ConversationID: convId,
+		PersonaID:      personaId,
+		UserID:         userId,
+		RoundCount:     len(rounds),
+		Messages:       rounds,
+	}
+}
+
+// newPendingRound 构造一轮待提取的对话
+func newPendingRound(userMsg, aiReply string) model.MessagePair {
+	return model.MessagePair{
+		UserMsg:      userMsg,
+		AssistantMsg: aiReply,
+		Timestamp:    time.Now(),
+	}
+}
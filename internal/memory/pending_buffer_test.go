@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"AI_Chat/internal/model"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestPendingService(t *testing.T) (*MemoryService, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &MemoryService{redisClient: client}, mr
+}
+
+func TestAppendPendingRoundConcurrent(t *testing.T) {
+	s, mr := newTestPendingService(t)
+	ctx := context.Background()
+
+	const (
+		goroutines = 20
+		perRoutine = 25
+		threshold  = 10
+	)
+	var (
+		mu      sync.Mutex
+		drained [][]model.MessagePair
+		wg      sync.WaitGroup
+	)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perRoutine; i++ {
+				round := newPendingRound(fmt.Sprintf("%d-%d", g, i), "ok")
+				pending, err := s.appendPendingRound(ctx, "conv:test", "per:test", 1, round, threshold)
+				if err != nil {
+					t.Errorf("append failed: %v", err)
+					return
+				}
+				if pending != nil {
+					mu.Lock()
+					drained = append(drained, pending.Messages)
+					mu.Unlock()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for _, batch := range drained {
+		if len(batch) != threshold {
+			t.Fatalf("drained batch has %d rounds, want %d", len(batch), threshold)
+		}
+		for _, round := range batch {
+			if seen[round.UserMsg] {
+				t.Fatalf("round %s drained twice", round.UserMsg)
+			}
+			seen[round.UserMsg] = true
+		}
+	}
+	remaining, err := mr.List(pendingMessagesKey("conv:test"))
+	if err != nil && err != miniredis.ErrKeyNotFound {
+		t.Fatalf("read remaining failed: %v", err)
+	}
+	for _, raw := range remaining {
+		var round model.MessagePair
+		if err := json.Unmarshal([]byte(raw), &round); err != nil {
+			t.Fatalf("decode remaining failed: %v", err)
+		}
+		if seen[round.UserMsg] {
+			t.Fatalf("round %s both drained and remaining", round.UserMsg)
+		}
+		seen[round.UserMsg] = true
+	}
+	if len(seen) != goroutines*perRoutine {
+		t.Fatalf("got %d rounds, want %d", len(seen), goroutines*perRoutine)
+	}
+	if len(drained) != goroutines*perRoutine/threshold {
+		t.Fatalf("extraction triggered %d times, want %d", len(drained), goroutines*perRoutine/threshold)
+	}
+}
+
+func TestRestorePendingRoundsKeepsOrder(t *testing.T) {
+	s, _ := newTestPendingService(t)
+	ctx := context.Background()
+
+	var drained *model.PendingMessages
+	for i := 0; i < 3; i++ {
+		pending, err := s.appendPendingRound(ctx, "conv:test", "per:test", 1, newPendingRound(fmt.Sprint(i), ""), 3)
+		if err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+		drained = pending
+	}
+	if drained == nil || len(drained.Messages) != 3 {
+		t.Fatalf("expected drain of 3 rounds, got %+v", drained)
+	}
+
+	// 入队失败：放回缓存后，新的一轮应排在它们之后并一起被取出
+	if err := s.restorePendingRounds(ctx, drained); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+	pending, err := s.appendPendingRound(ctx, "conv:test", "per:test", 1, newPendingRound("3", ""), 3)
+	if err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if pending == nil || len(pending.Messages) != 4 {
+		t.Fatalf("expected drain of 4 rounds, got %+v", pending)
+	}
+	for i, round := range pending.Messages {
+		if round.UserMsg != fmt.Sprint(i) {
+			t.Fatalf("round %d is %q, want %q", i, round.UserMsg, fmt.Sprint(i))
+		}
+	}
+}
+
+func TestDrainPendingRoundsRespectsIdleCutoff(t *testing.T) {
+	s, _ := newTestPendingService(t)
+	ctx := context.Background()
+
+	if _, err := s.appendPendingRound(ctx, "conv:test", "per:test", 7, newPendingRound("hi", "hello"), 10); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	// 最后一轮晚于截止时间，不应取出
+	pending, err := s.drainPendingRounds(ctx, "conv:test", time.Now().Add(-time.Minute))
+	if err != nil || pending != nil {
+		t.Fatalf("expected nothing drained before idle cutoff, got %+v, %v", pending, err)
+	}
+
+	idle, err := s.listIdleConversations(ctx, time.Now().Add(time.Minute), 10)
+	if err != nil || len(idle) != 1 || idle[0] != "conv:test" {
+		t.Fatalf("unexpected idle conversations: %v, %v", idle, err)
+	}
+	pending, err = s.drainPendingRounds(ctx, "conv:test", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if pending == nil || pending.PersonaID != "per:test" || pending.UserID != 7 || len(pending.Messages) != 1 {
+		t.Fatalf("unexpected drained pending: %+v", pending)
+	}
+
+	// 取出后索引和缓存都应清空
+	if idle, _ := s.listIdleConversations(ctx, time.Now().Add(time.Minute), 10); len(idle) != 0 {
+		t.Fatalf("idle index not cleared: %v", idle)
+	}
+	if pending, _ := s.drainPendingRounds(ctx, "conv:test", time.Time{}); pending != nil {
+		t.Fatalf("expected empty buffer after drain, got %+v", pending)
+	}
+}
+
+func TestAppendPendingRoundConvertsLegacyValue(t *testing.T) {
+	s, mr := newTestPendingService(t)
+	ctx := context.Background()
+
+	legacy, _ := json.Marshal(model.PendingMessages{
+		ConversationID: "conv:test",
+		RoundCount:     1,
+		Messages:       []model.MessagePair{newPendingRound("old", "reply")},
+	})
+	if err := mr.Set(pendingMessagesKey("conv:test"), string(legacy)); err != nil {
+		t.Fatalf("seed legacy value failed: %v", err)
+	}
+
+	pending, err := s.appendPendingRound(ctx, "conv:test", "per:test", 1, newPendingRound("new", "reply"), 2)
+	if err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	if pending == nil || len(pending.Messages) != 2 || pending.Messages[0].UserMsg != "old" || pending.Messages[1].UserMsg != "new" {
+		t.Fatalf("unexpected rounds after legacy conversion: %+v", pending)
+	}
+}
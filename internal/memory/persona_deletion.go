@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// ErrJobNotFound 任务不存在或不属于该用户
+var ErrJobNotFound = errors.New("job not found")
+
+// PersonaDeletionPayload 删除人格任务的参数
+type PersonaDeletionPayload struct {
+	Hard bool `json:"hard"` // true 时物理删除，false 时只标记删除
+}
+
+// EnqueuePersonaDeletion 把删除人格及其关联数据的操作写入任务队列，由后台 worker 执行，失败自动重试
+func (s *MemoryService) EnqueuePersonaDeletion(personaId string, userId int64, hard bool) (*model.MemoryJob, error) {
+	if s.jobRepo == nil {
+		return nil, fmt.Errorf("memory job repository is nil")
+	}
+	payload, err := json.Marshal(PersonaDeletionPayload{Hard: hard})
+	if err != nil {
+		return nil, err
+	}
+	job := &model.MemoryJob{
+		Type:        model.MemoryJobTypeDeletePersona,
+		PersonaID:   personaId,
+		UserID:      userId,
+		Payload:     string(payload),
+		Status:      model.MemoryJobStatusPending,
+		MaxAttempts: ExtractMaxAttempts,
+		NextRunAt:   time.Now(),
+	}
+	if err := s.jobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// GetPersonaDeletionJob 查询删除人格任务的执行状态，任务必须属于该用户
+func (s *MemoryService) GetPersonaDeletionJob(jobId string, userId int64) (*model.MemoryJob, error) {
+	job, err := s.jobRepo.GetJobById(jobId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.UserID != userId || job.Type != model.MemoryJobTypeDeletePersona {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// DeletePersona 删除人格及其关联数据：丢弃 Redis 中待提取的轮次，删除向量存储中的向量，
+// 再在同一事务中清理 MySQL 中的人格、会话、消息和记忆。每一步都可重复执行，任务失败重试时从头再来。
+func (s *MemoryService) DeletePersona(ctx context.Context, jobId, personaId string, userId int64, hard bool) error {
+	if s.personaRepo == nil {
+		return fmt.Errorf("persona repository is nil")
+	}
+	// 先隐藏人格，避免清理期间继续聊天产生新数据
+	if err := s.personaRepo.MarkPersonaDeleted(personaId); err != nil {
+		return fmt.Errorf("标记人格删除失败: %w", err)
+	}
+
+	convIds, err := s.personaRepo.GetConversationIDsByPersona(personaId, userId)
+	if err != nil {
+		return fmt.Errorf("读取人格会话失败: %w", err)
+	}
+	if s.redisClient != nil {
+		for _, convId := range convIds {
+			if _, err := s.drainPendingRounds(ctx, convId, time.Time{}); err != nil {
+				return fmt.Errorf("清理待提取轮次失败: %w", err)
+			}
+		}
+	}
+
+	if s.vectorStore != nil {
+		ids, err := s.vectorStore.ListIDs(ctx, VectorFilter{PersonaID: personaId, UserID: userId})
+		if err != nil {
+			return fmt.Errorf("列出人格向量失败: %w", err)
+		}
+		if err := s.vectorStore.Delete(ctx, ids...); err != nil {
+			return fmt.Errorf("删除人格向量失败: %w", err)
+		}
+	}
+
+	if err := s.personaRepo.PurgePersona(personaId, userId, hard, jobId); err != nil {
+		return fmt.Errorf("清理人格数据失败: %w", err)
+	}
+	utils.Log.Info("人格已删除",
+		zap.String("personaId", personaId),
+		zap.Int64("userId", userId),
+		zap.Bool("hard", hard),
+		zap.Int("conversations", len(convIds)),
+	)
+	return nil
+}
+
+// personaDeleted 人格是否已删除；读取失败时按未删除处理，由任务自身的错误处理重试
+func (s *MemoryService) personaDeleted(personaId string) bool {
+	if s.personaRepo == nil {
+		return false
+	}
+	_, err := s.personaRepo.GetPersonaById(personaId)
+	return errors.Is(err, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func TestDeletePersonaCascades(t *testing.T) {
+	for _, hard := range []bool{false, true} {
+		ctx := context.Background()
+		db := newTestDB(t, &model.Persona{}, &model.PersonaVersion{}, &model.PersonaAvatar{}, &model.Conversation{}, &model.ConversationSummary{}, &model.MemoryJob{})
+		s, _ := newTestPendingService(t)
+		s.memoryRepo = repository.NewMemoryRepository(db)
+		s.jobRepo = repository.NewMemoryJobRepository(db)
+		s.personaRepo = repository.NewPersonaRepository(db)
+		s.embedding = NewHashEmbedder(32)
+		s.vectorStore, _ = NewLocalVectorStore("", 0)
+
+		persona := &model.Persona{UserID: 1, Name: "a"}
+		other := &model.Persona{UserID: 1, Name: "b"}
+		db.Create(persona)
+		db.Create(other)
+		conv := &model.Conversation{UserID: 1, PersonaID: persona.ID}
+		db.Create(conv)
+		msg := &model.Message{ConversationID: conv.ID, Role: "user", Content: "hi"}
+		db.Create(msg)
+		db.Create(&model.ConversationSummary{ConversationID: conv.ID, Summary: "s"})
+		for _, p := range []*model.Persona{persona, other} {
+			mem := &model.Memory{PersonaID: p.ID, UserID: 1, Type: model.MemoryTypeFact, Content: "用户喜欢猫", Status: model.MemoryStatusActive}
+			s.PrepareMemoryEmbedding(ctx, mem)
+			s.memoryRepo.CreateMemoryWithSources(mem, []model.MemorySourceMessage{{MessageID: msg.ID, ConversationID: conv.ID}})
+			s.UpsertMemoryVector(ctx, mem)
+		}
+		if _, err := s.appendPendingRound(ctx, conv.ID, persona.ID, 1, newPendingRound("u", "a"), 10); err != nil {
+			t.Fatalf("append pending failed: %v", err)
+		}
+		if _, err := s.EnqueueExtraction(newPendingMessages(conv.ID, persona.ID, 1, []model.MessagePair{newPendingRound("u", "a")})); err != nil {
+			t.Fatalf("enqueue extraction failed: %v", err)
+		}
+
+		job, err := s.EnqueuePersonaDeletion(persona.ID, 1, hard)
+		if err != nil {
+			t.Fatalf("enqueue deletion failed: %v", err)
+		}
+		if err := s.DeletePersona(ctx, job.ID, persona.ID, 1, hard); err != nil {
+			t.Fatalf("delete persona failed: %v", err)
+		}
+		// 重试时可重复执行
+		if err := s.DeletePersona(ctx, job.ID, persona.ID, 1, hard); err != nil {
+			t.Fatalf("repeated delete failed: %v", err)
+		}
+
+		if _, err := s.personaRepo.GetPersonaById(persona.ID); err != gorm.ErrRecordNotFound {
+			t.Fatalf("hard=%v: persona should be hidden, got %v", hard, err)
+		}
+		if pending, _ := s.drainPendingRounds(ctx, conv.ID, time.Time{}); pending != nil {
+			t.Fatalf("hard=%v: pending rounds should be discarded", hard)
+		}
+		if ids, _ := s.vectorStore.ListIDs(ctx, VectorFilter{PersonaID: persona.ID}); len(ids) != 0 {
+			t.Fatalf("hard=%v: vectors should be deleted, got %v", hard, ids)
+		}
+		if ids, _ := s.vectorStore.ListIDs(ctx, VectorFilter{PersonaID: other.ID}); len(ids) != 1 {
+			t.Fatalf("hard=%v: other persona's vectors should be kept, got %v", hard, ids)
+		}
+		if active, _ := s.memoryRepo.GetMemoriesByPersonaAndUser(persona.ID, 1); len(active) != 0 {
+			t.Fatalf("hard=%v: memories should be deleted, got %d", hard, len(active))
+		}
+
+		var rows, jobs, messages int64
+		db.Model(&model.Memory{}).Where("persona_id = ?", persona.ID).Count(&rows)
+		db.Model(&model.Message{}).Where("conversation_id = ?", conv.ID).Count(&messages)
+		db.Model(&model.MemoryJob{}).Where("persona_id = ? AND status = ?", persona.ID, model.MemoryJobStatusPending).Count(&jobs)
+		if jobs != 1 {
+			t.Fatalf("hard=%v: pending extraction should be cancelled, only the deletion job left, got %d", hard, jobs)
+		}
+		if hard && (rows != 0 || messages != 0) {
+			t.Fatalf("hard delete should remove rows, memories=%d messages=%d", rows, messages)
+		}
+		if !hard && (rows != 1 || messages != 1) {
+			t.Fatalf("soft delete should keep rows, memories=%d messages=%d", rows, messages)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+	"go.uber.org/zap"
+)
+
+const (
+	// reflectionMaxInputs 一次反思最多交给模型的近期记忆数量
+	reflectionMaxInputs = 50
+	// reflectionMinEvidence 每条反思至少需要的支撑记忆数量
+	reflectionMinEvidence = 2
+	// submitReflectionsTool 反思时模型通过调用该工具提交归纳出的认识
+	submitReflectionsTool = "submit_reflections"
+)
+
+// ReflectionPayload 反思任务的参数
+type ReflectionPayload struct {
+	Since time.Time `json:"since"` // 只归纳该时间之后创建的记忆，即上一次反思之后的新记忆
+}
+
+// ReflectionResult 一次反思的结果
+type ReflectionResult struct {
+	Scanned     int              `json:"scanned"`     // 交给模型的近期记忆数
+	Reflections []model.Memory   `json:"reflections"` // 新写入的反思记忆
+	Superseded  []string         `json:"superseded"`  // 被更新的旧反思
+	Validation  ActionValidation `json:"validation"`
+}
+
+// reflectionDraft 模型给出的一条反思
+type reflectionDraft struct {
+	Content         string   `json:"content"`
+	Keywords        string   `json:"keywords"`
+	Importance      int      `json:"importance"`
+	Evidence        []string `json:"evidence"`
+	OldReflectionID string   `json:"old_reflection_id"`
+}
+
+// SetReflectionThreshold 设置自动反思的阈值，<= 0 时不自动安排反思（仍可手动触发）
+func (s *MemoryService) SetReflectionThreshold(threshold int) {
+	s.reflectionThreshold = threshold
+}
+
+// reflectionState 查询反思任务的状态：open 为待执行或执行中的反思任务；
+// since 为上一次反思任务的创建时间，之后创建的记忆尚未被反思
+func (s *MemoryService) reflectionState(personaId string, userId int64) (open *model.MemoryJob, since time.Time, err error) {
+	latest, err := s.jobRepo.GetLatestJob(personaId, userId, model.MemoryJobTypeReflect)
+	if err != nil || latest == nil {
+		return nil, time.Time{}, err
+	}
+	if latest.Status == model.MemoryJobStatusPending || latest.Status == model.MemoryJobStatusRunning {
+		return latest, latest.CreatedAt, nil
+	}
+	return nil, latest.CreatedAt, nil
+}
+
+// maybeEnqueueReflection 上次反思之后新增的活跃记忆达到阈值时安排一次反思；已有未完成的反思任务时不重复安排
+func (s *MemoryService) maybeEnqueueReflection(personaId string, userId int64) {
+	if s.reflectionThreshold <= 0 || s.jobRepo == nil {
+		return
+	}
+	open, since, err := s.reflectionState(personaId, userId)
+	if err != nil {
+		utils.Log.Warn("查询反思任务失败", zap.String("personaId", personaId), zap.Error(err))
+		return
+	}
+	if open != nil {
+		return
+	}
+	count, err := s.memoryRepo.CountActiveMemoriesSince(personaId, userId, since, model.MemoryTypeReflection)
+	if err != nil {
+		utils.Log.Warn("统计新增记忆失败", zap.String("personaId", personaId), zap.Error(err))
+		return
+	}
+	if count < int64(s.reflectionThreshold) {
+		return
+	}
+	if _, err := s.enqueueReflection(personaId, userId, since); err != nil {
+		utils.Log.Error("反思任务入队失败", zap.String("personaId", personaId), zap.Error(err))
+	}
+}
+
+// RequestReflection 手动安排一次反思，已有未完成的反思任务时直接返回该任务
+func (s *MemoryService) RequestReflection(personaId string, userId int64) (*model.MemoryJob, error) {
+	if s.jobRepo == nil {
+		return nil, fmt.Errorf("memory job repository is nil")
+	}
+	open, since, err := s.reflectionState(personaId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if open != nil {
+		return open, nil
+	}
+	return s.enqueueReflection(personaId, userId, since)
+}
+
+func (s *MemoryService) enqueueReflection(personaId string, userId int64, since time.Time) (*model.MemoryJob, error) {
+	payload, err := json.Marshal(ReflectionPayload{Since: since})
+	if err != nil {
+		return nil, err
+	}
+	job := &model.MemoryJob{
+		Type:        model.MemoryJobTypeReflect,
+		PersonaID:   personaId,
+		UserID:      userId,
+		Payload:     string(payload),
+		Status:      model.MemoryJobStatusPending,
+		MaxAttempts: ExtractMaxAttempts,
+		NextRunAt:   time.Now(),
+	}
+	if err := s.jobRepo.CreateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Reflect 让模型从 since 之后的新记忆中归纳更高层的认识，写为 reflection 类型的记忆并关联支撑它的原始记忆；
+// 与已有反思表达同一认识时生成新版本取代旧反思。审核模式下写为待审核的候选。
+func (s *MemoryService) Reflect(ctx context.Context, personaId string, userId int64, since time.Time) (*ReflectionResult, error) {
+	result := &ReflectionResult{Reflections: []model.Memory{}, Superseded: []string{}}
+	active, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("获取现有记忆失败: %w", err)
+	}
+	var recent, reflections []model.Memory
+	for _, mem := range active {
+		switch {
+		case mem.Type == model.MemoryTypeReflection:
+			reflections = append(reflections, mem)
+		case mem.CreatedAt.After(since) && len(recent) < reflectionMaxInputs:
+			// 按创建时间从新到旧，超过上限时只取最近的
+			recent = append(recent, mem)
+		}
+	}
+	result.Scanned = len(recent)
+	if len(recent) < reflectionMinEvidence {
+		return result, nil
+	}
+
+	drafts, err := s.callLLMReflect(ctx, recent, reflections)
+	if err != nil {
+		return nil, fmt.Errorf("反思失败: %w", err)
+	}
+	drafts, result.Validation = validateReflections(drafts, recent, reflections)
+	if result.Validation.Repaired > 0 || result.Validation.Skipped > 0 {
+		utils.Log.Warn("反思结果校验",
+			zap.String("personaId", personaId),
+			zap.Int("repaired", result.Validation.Repaired),
+			zap.Int("skipped", result.Validation.Skipped),
+			zap.Strings("issues", result.Validation.Issues),
+		)
+	}
+
+	reviewMode := s.reviewModeEnabled(personaId)
+	for _, draft := range drafts {
+		mem := &model.Memory{
+			PersonaID:  personaId,
+			UserID:     userId,
+			Type:       model.MemoryTypeReflection,
+			Content:    draft.Content,
+			Keywords:   draft.Keywords,
+			Importance: model.ClampImportance(draft.Importance),
+			Source:     model.MemorySourceReflection,
+			Status:     model.MemoryStatusActive,
+		}
+		// 更新旧反思时沿用旧反思的支撑记忆
+		evidence := draft.Evidence
+		if draft.OldReflectionID != "" {
+			inherited, err := s.memoryRepo.GetReflectionEvidence([]string{draft.OldReflectionID})
+			if err != nil {
+				utils.Log.Warn("读取旧反思的支撑记忆失败", zap.String("memoryId", draft.OldReflectionID), zap.Error(err))
+			}
+			for _, link := range inherited {
+				evidence = append(evidence, link.MemoryID)
+			}
+		}
+
+		if reviewMode {
+			mem.Status = model.MemoryStatusPendingReview
+			mem.ReplacesID = draft.OldReflectionID
+			if err := s.memoryRepo.CreateReflection(mem, "", evidence); err != nil {
+				return result, fmt.Errorf("存储反思失败: %w", err)
+			}
+			result.Reflections = append(result.Reflections, *mem)
+			continue
+		}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateReflection(mem, draft.OldReflectionID, evidence); err != nil {
+			if errors.Is(err, repository.ErrMemoryNotActive) {
+				utils.Log.Warn("旧反思已被修改，跳过更新", zap.String("memoryId", draft.OldReflectionID))
+				continue
+			}
+			return result, fmt.Errorf("存储反思失败: %w", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+		if draft.OldReflectionID != "" {
+			s.DeleteMemoryVector(ctx, draft.OldReflectionID)
+			result.Superseded = append(result.Superseded, draft.OldReflectionID)
+		}
+		result.Reflections = append(result.Reflections, *mem)
+	}
+	utils.Log.Info("记忆反思完成",
+		zap.String("personaId", personaId),
+		zap.Int64("userId", userId),
+		zap.Int("scanned", result.Scanned),
+		zap.Int("reflections", len(result.Reflections)),
+		zap.Int("superseded", len(result.Superseded)),
+	)
+	return result, nil
+}
+
+// GetReflectionEvidence 获取支撑反思记忆的原始记忆；其他类型的记忆返回空列表
+func (s *MemoryService) GetReflectionEvidence(personaId string, userId int64, memoryId string) ([]model.Memory, error) {
+	mem, err := s.getOwnedMemory(personaId, userId, memoryId)
+	if err != nil {
+		return nil, err
+	}
+	if mem.Type != model.MemoryTypeReflection {
+		return []model.Memory{}, nil
+	}
+	return s.memoryRepo.GetEvidenceMemories(memoryId)
+}
+
+// withoutReflections 去掉反思记忆；提取和整理只处理原始记忆，反思由反思任务维护
+func withoutReflections(memories []model.Memory) []model.Memory {
+	filtered := make([]model.Memory, 0, len(memories))
+	for _, mem := range memories {
+		if mem.Type != model.MemoryTypeReflection {
+			filtered = append(filtered, mem)
+		}
+	}
+	return filtered
+}
+
+// validateReflections 校验模型给出的反思：内容不能为空；支撑记忆只保留本次列出的近期记忆且至少 reflectionMinEvidence 条；
+// old_reflection_id 必须是列出的已有反思，无效或重复引用时作为新反思写入
+func validateReflections(drafts []reflectionDraft, recent, reflections []model.Memory) ([]reflectionDraft, ActionValidation) {
+	var report ActionValidation
+	recentIDs := make(map[string]bool, len(recent))
+	for _, mem := range recent {
+		recentIDs[mem.ID] = true
+	}
+	reflectionIDs := make(map[string]bool, len(reflections))
+	for _, mem := range reflections {
+		reflectionIDs[mem.ID] = true
+	}
+	referenced := make(map[string]bool)
+	valid := make([]reflectionDraft, 0, len(drafts))
+
+	for i, draft := range drafts {
+		draft.Content = strings.TrimSpace(draft.Content)
+		if draft.Content == "" {
+			report.skip("#%d reflection with empty content", i+1)
+			continue
+		}
+		evidence := make([]string, 0, len(draft.Evidence))
+		seen := make(map[string]bool, len(draft.Evidence))
+		for _, id := range draft.Evidence {
+			id = strings.TrimSpace(id)
+			if recentIDs[id] && !seen[id] {
+				seen[id] = true
+				evidence = append(evidence, id)
+			}
+		}
+		if len(evidence) < reflectionMinEvidence {
+			report.skip("#%d reflection supported by %d listed memories", i+1, len(evidence))
+			continue
+		}
+		if len(evidence) != len(draft.Evidence) {
+			report.repair("#%d unknown or duplicate evidence dropped", i+1)
+		}
+		draft.Evidence = evidence
+
+		draft.OldReflectionID = strings.TrimSpace(draft.OldReflectionID)
+		if draft.OldReflectionID != "" && (!reflectionIDs[draft.OldReflectionID] || referenced[draft.OldReflectionID]) {
+			report.repair("#%d unknown or reused reflection %q, treated as new", i+1, draft.OldReflectionID)
+			draft.OldReflectionID = ""
+		}
+		if draft.OldReflectionID != "" {
+			referenced[draft.OldReflectionID] = true
+		}
+		draft.Keywords = strings.TrimSpace(draft.Keywords)
+		if runes := []rune(draft.Keywords); len(runes) > maxKeywordsRunes {
+			draft.Keywords = string(runes[:maxKeywordsRunes])
+		}
+		draft.Importance = model.ClampImportance(draft.Importance)
+		valid = append(valid, draft)
+	}
+	return valid, report
+}
+
+// callLLMReflect 让模型从近期记忆中归纳更高层的认识
+func (s *MemoryService) callLLMReflect(ctx context.Context, recent, reflections []model.Memory) ([]reflectionDraft, error) {
+	cm, err := ai_config.NewChatModel(ctx, ai_config.MemoryChatProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	recentText := ""
+	for _, mem := range recent {
+		recentText += fmt.Sprintf("- [%s] 类型: %s, 重要度: %d, 内容: %s\n", mem.ID, mem.Type, model.ClampImportance(mem.Importance), mem.Content)
+	}
+	reflectionsText := "（无）\n"
+	if len(reflections) > 0 {
+		reflectionsText = ""
+		for _, mem := range reflections {
+			reflectionsText += fmt.Sprintf("- [%s] %s\n", mem.ID, mem.Content)
+		}
+	}
+	systemPrompt := `你是陪伴型 AI 的反思助手。下面是最近记下的关于用户的记忆，请像一位长期陪伴用户的朋友那样，从中归纳出更深层的认识。
+
+【要求】
+1. 归纳 0~3 条认识，关注用户的处境、情绪状态、压力来源、价值观以及用户需要怎样的陪伴，例如"用户最近因考试压力很大，需要被肯定和安慰"。
+2. 每条认识必须由至少两条记忆共同支撑，在 evidence 中列出这些记忆的 ID；不要复述单条记忆。
+3. 如果某条认识与【已有反思】表达的是同一件事，给出 old_reflection_id 和更新后的内容；否则不填 old_reflection_id。
+4. importance 为 1~10 的整数。没有值得归纳的认识时返回空数组。
+
+请调用 submit_reflections 工具提交结果；无法调用工具时，只输出如下格式的 JSON，不要附加其他文字:
+{"reflections": [{"content": "...", "keywords": "...", "importance": 7, "evidence": ["mem:a", "mem:b"], "old_reflection_id": ""}]}`
+
+	messages := []*schema.Message{
+		{Role: schema.System, Content: systemPrompt},
+		{Role: schema.User, Content: fmt.Sprintf("【已有反思】\n%s\n【近期记忆】\n%s", reflectionsText, recentText)},
+	}
+	resp, err := generateStructured(ctx, cm, messages, reflectionsToolInfo())
+	if err != nil {
+		return nil, err
+	}
+	return parseReflections(resp)
+}
+
+// reflectionsToolInfo 提交反思结果的工具定义
+func reflectionsToolInfo() *schema.ToolInfo {
+	reflection := &schema.ParameterInfo{
+		Type: schema.Object,
+		SubParams: map[string]*schema.ParameterInfo{
+			"content":           {Type: schema.String, Desc: "归纳出的认识", Required: true},
+			"keywords":          {Type: schema.String, Desc: "检索关键词，空格分隔"},
+			"importance":        {Type: schema.Integer, Desc: "重要度 1~10"},
+			"evidence":          {Type: schema.Array, ElemInfo: &schema.ParameterInfo{Type: schema.String}, Desc: "支撑该认识的记忆 ID，至少两条", Required: true},
+			"old_reflection_id": {Type: schema.String, Desc: "更新已有反思时对应的反思 ID"},
+		},
+	}
+	return &schema.ToolInfo{
+		Name: submitReflectionsTool,
+		Desc: "提交从近期记忆中归纳出的认识",
+		ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+			"reflections": {Type: schema.Array, ElemInfo: reflection, Desc: "认识列表，没有时为空数组", Required: true},
+		}),
+	}
+}
+
+// parseReflections 解析反思结果，支持 {"reflections": [...]} 和直接输出的数组；个别条目格式错误时跳过
+func parseReflections(resp *schema.Message) ([]reflectionDraft, error) {
+	if resp == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+	payload := strings.TrimSpace(structuredPayload(resp, submitReflectionsTool))
+	for _, candidate := range jsonCandidates(payload) {
+		var wrapped struct {
+			Reflections []json.RawMessage `json:"reflections"`
+		}
+		items := []json.RawMessage(nil)
+		if err := json.Unmarshal([]byte(candidate), &wrapped); err == nil && wrapped.Reflections != nil {
+			items = wrapped.Reflections
+		} else if err := json.Unmarshal([]byte(candidate), &items); err != nil {
+			continue
+		}
+		drafts := make([]reflectionDraft, 0, len(items))
+		for _, raw := range items {
+			var loose struct {
+				Content         string        `json:"content"`
+				Keywords        string        `json:"keywords"`
+				Importance      interface{}   `json:"importance"`
+				Evidence        []interface{} `json:"evidence"`
+				OldReflectionID string        `json:"old_reflection_id"`
+			}
+			if err := json.Unmarshal(raw, &loose); err != nil {
+				continue
+			}
+			draft := reflectionDraft{
+				Content:         loose.Content,
+				Keywords:        loose.Keywords,
+				Importance:      looseInt(loose.Importance),
+				OldReflectionID: loose.OldReflectionID,
+			}
+			for _, id := range loose.Evidence {
+				if s, ok := id.(string); ok {
+					draft.Evidence = append(draft.Evidence, s)
+				}
+			}
+			drafts = append(drafts, draft)
+		}
+		return drafts, nil
+	}
+	return nil, fmt.Errorf("no valid reflections JSON in output: %.200s", payload)
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+)
+
+func TestValidateReflections(t *testing.T) {
+	recent := []model.Memory{{ID: "mem:a"}, {ID: "mem:b"}, {ID: "mem:c"}}
+	reflections := []model.Memory{{ID: "mem:r1", Type: model.MemoryTypeReflection}}
+	drafts := []reflectionDraft{
+		{Content: "用户考试压力大，需要安慰", Evidence: []string{"mem:a", "mem:b", "mem:x", "mem:a"}, OldReflectionID: "mem:r1", Importance: 15},
+		{Content: "只有一条支撑", Evidence: []string{"mem:c"}},
+		{Content: "  ", Evidence: []string{"mem:a", "mem:b"}},
+		{Content: "重复引用旧反思", Evidence: []string{"mem:b", "mem:c"}, OldReflectionID: "mem:r1"},
+	}
+
+	valid, report := validateReflections(drafts, recent, reflections)
+	if len(valid) != 2 || report.Skipped != 2 {
+		t.Fatalf("unexpected validation: %+v, %+v", valid, report)
+	}
+	if got := valid[0]; len(got.Evidence) != 2 || got.OldReflectionID != "mem:r1" || got.Importance != model.MemoryImportanceMax {
+		t.Fatalf("unexpected first reflection: %+v", got)
+	}
+	if valid[1].OldReflectionID != "" {
+		t.Fatalf("reused reflection should be treated as new: %+v", valid[1])
+	}
+}
+
+func TestReflectLinksEvidenceAndSupersedes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &model.MemoryJob{})
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:          repository.NewMemoryRepository(db),
+		jobRepo:             repository.NewMemoryJobRepository(db),
+		embedding:           NewHashEmbedder(64),
+		vectorStore:         store,
+		reflectionThreshold: 2,
+	}
+	create := func(mem *model.Memory) *model.Memory {
+		mem.PersonaID, mem.UserID, mem.Status = "per:a", 1, model.MemoryStatusActive
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		return mem
+	}
+	oldFact := create(&model.Memory{Type: model.MemoryTypeFact, Content: "用户上个月说过很怕挂科"})
+	oldReflection := create(&model.Memory{Type: model.MemoryTypeReflection, Content: "用户对学业有些焦虑", Source: model.MemorySourceReflection})
+	db.Create(&model.MemoryEvidence{ReflectionID: oldReflection.ID, MemoryID: oldFact.ID})
+	since := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	exam := create(&model.Memory{Type: model.MemoryTypeEvent, Content: "用户下周期末考试"})
+	sleep := create(&model.Memory{Type: model.MemoryTypeEmotion, Content: "用户最近失眠，很焦虑"})
+
+	// 新增记忆达到阈值时安排反思，已有未完成的任务时不重复安排
+	s.maybeEnqueueReflection("per:a", 1)
+	s.maybeEnqueueReflection("per:a", 1)
+	jobs, _ := s.jobRepo.GetJobsByPersonaAndUser("per:a", 1, "", 10)
+	if len(jobs) != 1 || jobs[0].Type != model.MemoryJobTypeReflect {
+		t.Fatalf("expected one reflect job, got %+v", jobs)
+	}
+
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+		Name:   "reflection-test",
+		Type:   ai_config.ProviderFake,
+		Script: []string{`{"reflections": [{"content": "用户正为期末考试焦虑，需要被肯定和安慰", "importance": 8, "evidence": ["` + exam.ID + `", "` + sleep.ID + `"], "old_reflection_id": "` + oldReflection.ID + `"}]}`},
+	})
+	previous := ai_config.MemoryChatProvider
+	ai_config.MemoryChatProvider = "reflection-test"
+	t.Cleanup(func() { ai_config.MemoryChatProvider = previous })
+
+	result, err := s.Reflect(ctx, "per:a", 1, since)
+	if err != nil || result.Scanned != 2 || len(result.Reflections) != 1 || len(result.Superseded) != 1 {
+		t.Fatalf("unexpected reflection result: %+v, %v", result, err)
+	}
+	reflection := result.Reflections[0]
+	if reflection.Type != model.MemoryTypeReflection || reflection.Status != model.MemoryStatusActive {
+		t.Fatalf("unexpected reflection: %+v", reflection)
+	}
+	old, _ := s.memoryRepo.GetMemoryById(oldReflection.ID)
+	if old.Status != model.MemoryStatusSuperseded || old.SupersededBy != reflection.ID {
+		t.Fatalf("old reflection should be superseded: %+v", old)
+	}
+
+	// 支撑记忆包括本次的两条和旧反思的支撑记忆
+	evidence, err := s.GetReflectionEvidence("per:a", 1, reflection.ID)
+	if err != nil || len(evidence) != 3 {
+		t.Fatalf("unexpected evidence: %+v, %v", evidence, err)
+	}
+
+	// 检索返回原始记忆和反思，注入时反思单独列出
+	memories, err := s.RetrieveMemories(ctx, "per:a", 1, "", 0)
+	if err != nil || len(memories) != 4 {
+		t.Fatalf("unexpected retrieved memories: %d, %v", len(memories), err)
+	}
+	prompt := s.FormatMemoriesForPrompt(memories)
+	if !strings.Contains(prompt, "## 你对用户的深层理解：\n- 用户正为期末考试焦虑") {
+		t.Fatalf("reflection not formatted separately:\n%s", prompt)
+	}
+}
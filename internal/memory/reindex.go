@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/utils"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	reindexSampleLimit = 100 // 报告中每类差异最多列出的 ID 数量
+	reindexBatchSize   = 256 // 每批重新生成、写入或删除的向量数量
+)
+
+// ReindexOptions 核对范围；PersonaID 为空或 UserID 为 0 时不过滤该字段
+type ReindexOptions struct {
+	PersonaID string `json:"personaId"`
+	UserID    int64  `json:"userId"`
+	DryRun    bool   `json:"dryRun"` // 只报告差异，不做修改
+}
+
+// ReindexDrift 一类差异：数量和部分 ID
+type ReindexDrift struct {
+	Count int      `json:"count"`
+	IDs   []string `json:"ids"`
+}
+
+func (d *ReindexDrift) add(id string) {
+	d.Count++
+	if len(d.IDs) < reindexSampleLimit {
+		d.IDs = append(d.IDs, id)
+	}
+}
+
+// ReindexReport 数据库与向量存储的核对结果
+type ReindexReport struct {
+	DryRun        bool `json:"dryRun"`
+	ActiveRows    int  `json:"activeRows"`    // 数据库中的活跃记忆数
+	VectorsBefore int  `json:"vectorsBefore"` // 核对前向量存储中的向量数
+
+	Missing    ReindexDrift `json:"missing"`    // 活跃记忆在向量存储中没有向量
+	Stale      ReindexDrift `json:"stale"`      // 活跃记忆的向量已过期（内容修改过或维度不一致）
+	Orphaned   ReindexDrift `json:"orphaned"`   // 向量存储中的 ID 在数据库中不存在
+	Superseded ReindexDrift `json:"superseded"` // 向量对应的记忆已被取代或已删除
+
+	Reembedded int      `json:"reembedded"` // 重新生成向量的记忆数
+	Upserted   int      `json:"upserted"`   // 写入向量存储的数量
+	Removed    int      `json:"removed"`    // 从向量存储删除的数量
+	Errors     []string `json:"errors"`
+
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+func (r *ReindexReport) addError(format string, args ...interface{}) {
+	if len(r.Errors) < reindexSampleLimit {
+		r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// Reindex 核对数据库中的记忆与向量存储：为缺失或过期的活跃记忆重新生成并写入向量，
+// 删除孤立的、已被取代或已删除记忆的向量，并报告差异。
+func (s *MemoryService) Reindex(ctx context.Context, opts ReindexOptions) (*ReindexReport, error) {
+	if s.vectorStore == nil {
+		return nil, fmt.Errorf("vector store not configured")
+	}
+	report := &ReindexReport{DryRun: opts.DryRun, StartedAt: time.Now()}
+
+	rows, err := s.memoryRepo.GetMemoriesForReindex(opts.PersonaID, opts.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("读取记忆失败: %w", err)
+	}
+	vectorIDs, err := s.vectorStore.ListIDs(ctx, VectorFilter{PersonaID: opts.PersonaID, UserID: opts.UserID})
+	if err != nil {
+		return nil, fmt.Errorf("读取向量 ID 失败: %w", err)
+	}
+	report.VectorsBefore = len(vectorIDs)
+
+	indexed := make(map[string]bool, len(vectorIDs))
+	for _, id := range vectorIDs {
+		indexed[id] = true
+	}
+	rowsByID := make(map[string]*model.Memory, len(rows))
+
+	// 1. 活跃记忆：找出缺失和过期的向量
+	var toEmbed []*model.Memory
+	var toUpsert []VectorRecord
+	for i := range rows {
+		mem := &rows[i]
+		rowsByID[mem.ID] = mem
+		if mem.IsDeleted || mem.Status != model.MemoryStatusActive {
+			continue
+		}
+		report.ActiveRows++
+		if !indexed[mem.ID] {
+			report.Missing.add(mem.ID)
+		}
+		vec, fresh := s.freshEmbedding(mem)
+		if !fresh {
+			report.Stale.add(mem.ID)
+			toEmbed = append(toEmbed, mem)
+		} else if !indexed[mem.ID] {
+			toUpsert = append(toUpsert, VectorRecord{ID: mem.ID, PersonaID: mem.PersonaID, UserID: mem.UserID, Embedding: vec})
+		}
+	}
+
+	// 2. 向量存储：找出孤立的和已失效记忆的向量
+	var toRemove []string
+	for _, id := range vectorIDs {
+		mem, ok := rowsByID[id]
+		if !ok {
+			report.Orphaned.add(id)
+			toRemove = append(toRemove, id)
+		} else if mem.IsDeleted || mem.Status != model.MemoryStatusActive {
+			report.Superseded.add(id)
+			toRemove = append(toRemove, id)
+		}
+	}
+
+	if opts.DryRun {
+		report.FinishedAt = time.Now()
+		return report, nil
+	}
+
+	// 3. 重新生成过期的向量
+	if len(toEmbed) > 0 {
+		if s.embedding == nil {
+			report.addError("embedding disabled, %d stale memories skipped", len(toEmbed))
+		} else {
+			toUpsert = append(toUpsert, s.reembedForReindex(ctx, toEmbed, report)...)
+		}
+	}
+
+	// 4. 写入和删除向量
+	for start := 0; start < len(toUpsert); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(toUpsert))
+		if err := s.vectorStore.Upsert(ctx, toUpsert[start:end]...); err != nil {
+			report.addError("upsert vectors: %v", err)
+			continue
+		}
+		report.Upserted += end - start
+	}
+	for start := 0; start < len(toRemove); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(toRemove))
+		if err := s.vectorStore.Delete(ctx, toRemove[start:end]...); err != nil {
+			report.addError("delete vectors: %v", err)
+			continue
+		}
+		report.Removed += end - start
+	}
+
+	report.FinishedAt = time.Now()
+	utils.Log.Info("记忆向量核对完成",
+		zap.String("personaId", opts.PersonaID),
+		zap.Int64("userId", opts.UserID),
+		zap.Int("missing", report.Missing.Count),
+		zap.Int("stale", report.Stale.Count),
+		zap.Int("orphaned", report.Orphaned.Count),
+		zap.Int("superseded", report.Superseded.Count),
+		zap.Int("upserted", report.Upserted),
+		zap.Int("removed", report.Removed),
+		zap.Int("errors", len(report.Errors)),
+	)
+	return report, nil
+}
+
+// reembedForReindex 批量重新生成向量并保存到数据库，返回需要写入向量存储的记录
+func (s *MemoryService) reembedForReindex(ctx context.Context, memories []*model.Memory, report *ReindexReport) []VectorRecord {
+	records := make([]VectorRecord, 0, len(memories))
+	for start := 0; start < len(memories); start += reindexBatchSize {
+		end := min(start+reindexBatchSize, len(memories))
+		batch := memories[start:end]
+		inputs := make([]string, 0, len(batch))
+		for _, mem := range batch {
+			inputs = append(inputs, mem.Content)
+		}
+		embeddings, err := s.embedding.EmbedBatch(ctx, inputs)
+		if err != nil {
+			report.addError("embed %d memories: %v", len(batch), err)
+			continue
+		}
+		for i, mem := range batch {
+			if err := setMemoryEmbedding(mem, embeddings[i]); err != nil {
+				report.addError("memory %s: %v", mem.ID, err)
+				continue
+			}
+			if err := s.memoryRepo.UpdateMemoryEmbedding(mem.ID, mem.Embedding, mem.EmbeddingChecksum); err != nil {
+				report.addError("save embedding %s: %v", mem.ID, err)
+				continue
+			}
+			report.Reembedded++
+			records = append(records, VectorRecord{ID: mem.ID, PersonaID: mem.PersonaID, UserID: mem.UserID, Embedding: embeddings[i]})
+		}
+	}
+	return records
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"AI_Chat/pkg/ai_config"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Reranker 对候选文档按与查询的相关度重新打分
+type Reranker interface {
+	// Rerank 返回与 documents 一一对应的相关度得分，范围 0~1，越大越相关
+	Rerank(ctx context.Context, query string, documents []string) ([]float64, error)
+}
+
+// NewReranker 按配置创建重排序客户端，未启用时返回 nil
+func NewReranker(cfg ai_config.RerankProviderConfig) Reranker {
+	if !cfg.Enabled || strings.TrimSpace(cfg.BaseURL) == "" {
+		return nil
+	}
+	return &RerankClient{
+		baseURL: strings.TrimRight(cfg.BaseURL, "/"),
+		apiKey:  cfg.APIKey,
+		model:   cfg.Model,
+		httpClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// RerankClient Cohere/Jina 兼容的 /v1/rerank 接口
+type RerankClient struct {
+	baseURL    string
+	apiKey     string
+	model      string
+	httpClient *http.Client
+}
+
+func (c *RerankClient) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	if len(documents) == 0 {
+		return []float64{}, nil
+	}
+	reqBody := map[string]interface{}{
+		"model":     c.model,
+		"query":     query,
+		"documents": documents,
+		"top_n":     len(documents),
+	}
+	var parsed struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := postModelJSON(ctx, c.httpClient, c.baseURL+"/v1/rerank", c.apiKey, c.model, reqBody, &parsed); err != nil {
+		return nil, err
+	}
+	scores := make([]float64, len(documents))
+	for _, result := range parsed.Results {
+		if result.Index < 0 || result.Index >= len(documents) {
+			return nil, fmt.Errorf("rerank response index out of range: %d", result.Index)
+		}
+		scores[result.Index] = result.RelevanceScore
+	}
+	return scores, nil
+}
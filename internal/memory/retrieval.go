@@ -0,0 +1,391 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultRetrieveTopK 未指定 topK 时返回的记忆数量
+const DefaultRetrieveTopK = 5
+
+// RetrievalOptions 单次检索参数，为空的字段使用 chat.yaml 中 retrieval 段的配置
+type RetrievalOptions struct {
+	TopK          int      `json:"topK,omitempty"`
+	KeywordWeight *float64 `json:"keywordWeight,omitempty"` // 关键词（BM25）排名的融合权重
+	VectorWeight  *float64 `json:"vectorWeight,omitempty"`  // 向量相似度排名的融合权重
+	MinScore      *float64 `json:"minScore,omitempty"`      // 相关度低于该值的记忆不返回，范围 0~1
+	Rerank        *bool    `json:"rerank,omitempty"`        // 是否经过重排序，未配置重排序接口时忽略
+
+	// 综合排序权重
+	RelevanceWeight  *float64 `json:"relevanceWeight,omitempty"`
+	ImportanceWeight *float64 `json:"importanceWeight,omitempty"`
+	RecencyWeight    *float64 `json:"recencyWeight,omitempty"`
+	FrequencyWeight  *float64 `json:"frequencyWeight,omitempty"`
+}
+
+// Validate 检查请求中的检索参数
+func (o *RetrievalOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if o.TopK < 0 || o.TopK > 50 {
+		return fmt.Errorf("topK must be between 0 and 50")
+	}
+	for _, weight := range []*float64{o.KeywordWeight, o.VectorWeight, o.RelevanceWeight, o.ImportanceWeight, o.RecencyWeight, o.FrequencyWeight} {
+		if weight != nil && *weight < 0 {
+			return fmt.Errorf("weights must not be negative")
+		}
+	}
+	if o.MinScore != nil && (*o.MinScore < 0 || *o.MinScore > 1) {
+		return fmt.Errorf("minScore must be between 0 and 1")
+	}
+	return nil
+}
+
+// retrievalSettings 合并请求参数和配置后的检索参数
+type retrievalSettings struct {
+	topK          int
+	keywordWeight float64
+	vectorWeight  float64
+	minScore      float64
+	rerank        bool
+	rrfK          int
+	candidates    int
+	scoring       ai_config.ScoringSettings
+}
+
+func resolveRetrievalOptions(opts RetrievalOptions) retrievalSettings {
+	config := ai_config.RetrievalConfig
+	settings := retrievalSettings{
+		topK:          opts.TopK,
+		keywordWeight: config.KeywordWeight,
+		vectorWeight:  config.VectorWeight,
+		minScore:      config.MinScore,
+		rerank:        config.Rerank.Enabled,
+		rrfK:          config.GetRRFK(),
+	}
+	if settings.topK <= 0 {
+		settings.topK = DefaultRetrieveTopK
+	}
+	if opts.KeywordWeight != nil {
+		settings.keywordWeight = *opts.KeywordWeight
+	}
+	if opts.VectorWeight != nil {
+		settings.vectorWeight = *opts.VectorWeight
+	}
+	if settings.keywordWeight+settings.vectorWeight <= 0 {
+		settings.keywordWeight, settings.vectorWeight = ai_config.DefaultKeywordWeight, ai_config.DefaultVectorWeight
+	}
+	if opts.MinScore != nil {
+		settings.minScore = *opts.MinScore
+	}
+	if opts.Rerank != nil {
+		settings.rerank = *opts.Rerank
+	}
+	settings.candidates = config.GetCandidates(settings.topK)
+
+	settings.scoring = config.Scoring
+	scoring := &settings.scoring
+	for _, override := range []struct {
+		value  *float64
+		target *float64
+	}{
+		{opts.RelevanceWeight, &scoring.RelevanceWeight},
+		{opts.ImportanceWeight, &scoring.ImportanceWeight},
+		{opts.RecencyWeight, &scoring.RecencyWeight},
+		{opts.FrequencyWeight, &scoring.FrequencyWeight},
+	} {
+		if override.value != nil {
+			*override.target = *override.value
+		}
+	}
+	if scoring.RelevanceWeight+scoring.ImportanceWeight+scoring.RecencyWeight+scoring.FrequencyWeight <= 0 {
+		scoring.RelevanceWeight = 1
+	}
+	return settings
+}
+
+// ScoredMemory 检索结果及得分明细
+type ScoredMemory struct {
+	model.Memory
+	Score          float64 `json:"score"` // 综合得分，用于排序
+	ScoreBreakdown `json:"breakdown"`
+}
+
+// ScoreBreakdown 检索得分明细，各项得分都在 0~1（BM25 得分和排名除外）
+type ScoreBreakdown struct {
+	Relevance       float64  `json:"relevance"`     // 相关度，经过重排序时为重排序得分，否则为融合得分
+	FusedScore      float64  `json:"fused_score"`   // 倒数排名融合得分，按各路都排第一时的得分归一化到 0~1
+	KeywordScore    float64  `json:"keyword_score"` // BM25 得分
+	KeywordRank     int      `json:"keyword_rank"`  // 关键词召回中的排名，从 1 开始，0 表示未召回
+	VectorScore     float64  `json:"vector_score"`  // 向量相似度
+	VectorRank      int      `json:"vector_rank"`   // 向量召回中的排名，从 1 开始，0 表示未召回
+	RerankScore     *float64 `json:"rerank_score,omitempty"`
+	ImportanceScore float64  `json:"importance"` // 重要度 / 10
+	RecencyScore    float64  `json:"recency"`    // 0.5 ^ (距上次命中或创建的时长 / 半衰期)
+	FrequencyScore  float64  `json:"frequency"`  // ln(1 + 命中次数) / ln(1 + 饱和次数)，最大为 1
+}
+
+// rankedMemory 单路召回的结果，index 为记忆在候选列表中的下标
+type rankedMemory struct {
+	index int
+	score float64
+}
+
+// SearchMemories 混合检索：对正文和关键词做 BM25 召回、对向量做相似度召回，
+// 用倒数排名融合（RRF）合并两路排名，可选经过重排序，得到相关度并按最低得分过滤；
+// 再与重要度、新近度和命中频率加权得到综合得分，排序后截取 topK。只读，不记录命中。
+func (s *MemoryService) SearchMemories(ctx context.Context, personaId string, userId int64, query string, opts RetrievalOptions) ([]ScoredMemory, error) {
+	settings := resolveRetrievalOptions(opts)
+	if strings.TrimSpace(query) == "" {
+		return []ScoredMemory{}, nil
+	}
+	memories, err := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(personaId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(memories) == 0 {
+		return []ScoredMemory{}, nil
+	}
+
+	// 1. 两路召回
+	var keywordRanking []rankedMemory
+	if settings.keywordWeight > 0 {
+		keywordRanking = keywordRank(query, memories, settings.candidates)
+	}
+	var vectorRanking []rankedMemory
+	vectorAvailable := false
+	if settings.vectorWeight > 0 {
+		vectorRanking, vectorAvailable = s.vectorRank(ctx, personaId, userId, query, memories, settings.candidates)
+	}
+
+	// 2. 倒数排名融合，按参与融合的各路都排第一时的得分归一化
+	results := make(map[int]*ScoredMemory)
+	entry := func(index int) *ScoredMemory {
+		if result, ok := results[index]; ok {
+			return result
+		}
+		result := &ScoredMemory{Memory: memories[index]}
+		results[index] = result
+		return result
+	}
+	k := float64(settings.rrfK)
+	maxFused := 0.0
+	if settings.keywordWeight > 0 {
+		maxFused += settings.keywordWeight / (k + 1)
+		for rank, hit := range keywordRanking {
+			result := entry(hit.index)
+			result.KeywordRank = rank + 1
+			result.KeywordScore = hit.score
+			result.FusedScore += settings.keywordWeight / (k + float64(rank+1))
+		}
+	}
+	if vectorAvailable {
+		maxFused += settings.vectorWeight / (k + 1)
+		for rank, hit := range vectorRanking {
+			result := entry(hit.index)
+			result.VectorRank = rank + 1
+			result.VectorScore = hit.score
+			result.FusedScore += settings.vectorWeight / (k + float64(rank+1))
+		}
+	}
+	fused := make([]ScoredMemory, 0, len(results))
+	for _, result := range results {
+		if maxFused > 0 {
+			result.FusedScore /= maxFused
+		}
+		result.Relevance = result.FusedScore
+		fused = append(fused, *result)
+	}
+	sort.Slice(fused, func(i, j int) bool {
+		if fused[i].Relevance != fused[j].Relevance {
+			return fused[i].Relevance > fused[j].Relevance
+		}
+		return fused[i].ID < fused[j].ID
+	})
+	if len(fused) > settings.candidates {
+		fused = fused[:settings.candidates]
+	}
+
+	// 3. 重排序
+	reranked := false
+	if settings.rerank && s.reranker != nil && len(fused) > 0 {
+		reranked = s.rerank(ctx, query, fused)
+	}
+
+	// 4. 按相关度过滤，计算综合得分
+	now := time.Now()
+	final := make([]ScoredMemory, 0, len(fused))
+	for _, result := range fused {
+		if result.Relevance < settings.minScore {
+			continue
+		}
+		applyScoring(&result, settings.scoring, now)
+		final = append(final, result)
+	}
+	sortScoredMemories(final)
+	if len(final) > settings.topK {
+		final = final[:settings.topK]
+	}
+	utils.Log.Info("记忆检索来源",
+		zap.String("source", "hybrid"),
+		zap.String("personaId", personaId),
+		zap.Int64("userId", userId),
+		zap.Int("topK", settings.topK),
+		zap.Int("keywordHits", len(keywordRanking)),
+		zap.Int("vectorHits", len(vectorRanking)),
+		zap.Bool("vectorAvailable", vectorAvailable),
+		zap.Bool("reranked", reranked),
+		zap.Float64("minScore", settings.minScore),
+		zap.Int("count", len(final)),
+	)
+	return final, nil
+}
+
+// keywordRank 按 BM25 得分召回，只保留与查询有共同检索词的记忆
+func keywordRank(query string, memories []model.Memory, limit int) []rankedMemory {
+	scores := bm25Scores(query, memories)
+	ranking := make([]rankedMemory, 0)
+	for i, score := range scores {
+		if score > 0 {
+			ranking = append(ranking, rankedMemory{index: i, score: score})
+		}
+	}
+	sortRanking(ranking)
+	if len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	return ranking
+}
+
+// vectorRank 按向量相似度召回。优先使用向量存储，不可用时在数据库中的记忆上逐条计算；
+// 查询无法向量化时返回 false，该路不参与融合。
+func (s *MemoryService) vectorRank(ctx context.Context, personaId string, userId int64, query string, memories []model.Memory, limit int) ([]rankedMemory, bool) {
+	if s.embedding == nil {
+		return nil, false
+	}
+	queryEmbedding, err := s.embedding.Embed(ctx, query)
+	if err != nil {
+		utils.Log.Warn("查询向量化失败，仅使用关键词检索", zap.String("personaId", personaId), zap.Error(err))
+		return nil, false
+	}
+
+	if s.vectorStore != nil {
+		hits, err := s.vectorStore.Search(ctx, VectorFilter{PersonaID: personaId, UserID: userId}, queryEmbedding, limit)
+		if err == nil {
+			indexByID := make(map[string]int, len(memories))
+			for i := range memories {
+				indexByID[memories[i].ID] = i
+			}
+			ranking := make([]rankedMemory, 0, len(hits))
+			for _, hit := range hits {
+				// 向量存储中可能残留已取代的记忆，只保留仍然有效的
+				if index, ok := indexByID[hit.ID]; ok {
+					ranking = append(ranking, rankedMemory{index: index, score: float64(hit.Score)})
+				}
+			}
+			return ranking, true
+		}
+		utils.Log.Warn("向量存储检索失败，回退数据库", zap.String("personaId", personaId), zap.Error(err))
+	}
+
+	ranking := make([]rankedMemory, 0, len(memories))
+	for i := range memories {
+		if memories[i].Embedding == "" {
+			s.EnsureMemoryEmbedding(ctx, &memories[i])
+		}
+		vec, err := parseEmbedding(memories[i].Embedding)
+		if err != nil || len(vec) != len(queryEmbedding) {
+			continue
+		}
+		ranking = append(ranking, rankedMemory{index: i, score: float64(cosineSimilarity(queryEmbedding, vec))})
+	}
+	sortRanking(ranking)
+	if len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	return ranking, true
+}
+
+// RecallMemories 检索记忆用于注入对话，并为返回的每条记忆记录一次命中
+func (s *MemoryService) RecallMemories(ctx context.Context, personaId string, userId int64, query string, opts RetrievalOptions) ([]ScoredMemory, error) {
+	results, err := s.SearchMemories(ctx, personaId, userId, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.ID)
+	}
+	if err := s.memoryRepo.IncrementHitCounts(ids); err != nil {
+		utils.Log.Warn("记录记忆命中失败", zap.Strings("memoryIds", ids), zap.Error(err))
+	}
+	return results, nil
+}
+
+// applyScoring 计算重要度、新近度、命中频率，与相关度加权平均得到综合得分
+func applyScoring(result *ScoredMemory, scoring ai_config.ScoringSettings, now time.Time) {
+	result.ImportanceScore = float64(model.ClampImportance(result.Importance)) / model.MemoryImportanceMax
+
+	lastAccess := result.CreatedAt
+	if result.LastHitAt != nil && result.LastHitAt.After(lastAccess) {
+		lastAccess = *result.LastHitAt
+	}
+	elapsed := max(now.Sub(lastAccess), 0)
+	result.RecencyScore = math.Pow(0.5, float64(elapsed)/float64(scoring.GetRecencyHalfLife()))
+
+	saturation := float64(scoring.GetFrequencySaturation())
+	result.FrequencyScore = math.Min(1, math.Log1p(float64(max(result.HitCount, 0)))/math.Log1p(saturation))
+
+	totalWeight := scoring.RelevanceWeight + scoring.ImportanceWeight + scoring.RecencyWeight + scoring.FrequencyWeight
+	result.Score = (scoring.RelevanceWeight*result.Relevance +
+		scoring.ImportanceWeight*result.ImportanceScore +
+		scoring.RecencyWeight*result.RecencyScore +
+		scoring.FrequencyWeight*result.FrequencyScore) / totalWeight
+}
+
+// rerank 用重排序得分作为相关度并重新排序，失败时保留融合得分
+func (s *MemoryService) rerank(ctx context.Context, query string, results []ScoredMemory) bool {
+	documents := make([]string, 0, len(results))
+	for _, result := range results {
+		documents = append(documents, result.Content)
+	}
+	scores, err := s.reranker.Rerank(ctx, query, documents)
+	if err != nil || len(scores) != len(results) {
+		utils.Log.Warn("记忆重排序失败，使用融合得分", zap.Error(err))
+		return false
+	}
+	for i := range results {
+		score := scores[i]
+		results[i].RerankScore = &score
+		results[i].Relevance = score
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Relevance > results[j].Relevance
+	})
+	return true
+}
+
+func sortRanking(ranking []rankedMemory) {
+	sort.SliceStable(ranking, func(i, j int) bool {
+		return ranking[i].score > ranking[j].score
+	})
+}
+
+func sortScoredMemories(results []ScoredMemory) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+}
@@ -0,0 +1,152 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/ai_config"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("喜欢Go语言, 2024!")
+	want := []string{"喜", "喜欢", "欢", "go", "语", "语言", "言", "2024"}
+	if len(got) != len(want) {
+		t.Fatalf("tokenize = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("tokenize = %v, want %v", got, want)
+		}
+	}
+}
+
+// fixedReranker 按文档是否包含指定词返回固定得分
+type fixedReranker struct {
+	term string
+}
+
+func (r fixedReranker) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	scores := make([]float64, len(documents))
+	for i, doc := range documents {
+		if containsTerm(doc, r.term) {
+			scores[i] = 0.9
+		} else {
+			scores[i] = 0.1
+		}
+	}
+	return scores, nil
+}
+
+func containsTerm(doc, term string) bool {
+	for _, token := range tokenize(doc) {
+		if token == term {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSearchMemoriesHybrid(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   newTestEmbeddingServer(t),
+		vectorStore: store,
+	}
+	seed := []model.Memory{
+		{Content: "用户养了一只猫", Keywords: "宠物,橘猫"},
+		{Content: "用户每天早上喝咖啡", Keywords: "拿铁"},
+		{Content: "用户周末去跑步", Keywords: "马拉松"},
+	}
+	for i := range seed {
+		mem := &seed[i]
+		mem.PersonaID, mem.UserID, mem.Type, mem.Status = "per:a", 1, model.MemoryTypeFact, model.MemoryStatusActive
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+	}
+
+	// 只有关键词命中：向量全为 0，靠提取时的关键词召回
+	zero := 0.0
+	results, err := s.SearchMemories(ctx, "per:a", 1, "我在准备马拉松", RetrievalOptions{TopK: 3, VectorWeight: &zero})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != seed[2].ID || results[0].FusedScore != 1 {
+		t.Fatalf("unexpected keyword results: %+v", results)
+	}
+
+	// 两路都排第一的记忆得分为 1，最低得分过滤掉只被一路召回的记忆
+	minScore := 0.9
+	results, err = s.SearchMemories(ctx, "per:a", 1, "猫", RetrievalOptions{TopK: 3, MinScore: &minScore})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != seed[0].ID || results[0].KeywordRank != 1 || results[0].VectorRank != 1 {
+		t.Fatalf("unexpected hybrid results: %+v", results)
+	}
+
+	// 重排序得分作为相关度
+	s.reranker = fixedReranker{term: "咖啡"}
+	rerank := true
+	results, err = s.SearchMemories(ctx, "per:a", 1, "猫", RetrievalOptions{TopK: 1, Rerank: &rerank})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != seed[1].ID || results[0].RerankScore == nil || results[0].Relevance != 0.9 {
+		t.Fatalf("unexpected reranked results: %+v", results)
+	}
+}
+
+func TestApplyScoring(t *testing.T) {
+	now := time.Now()
+	result := ScoredMemory{
+		Memory:         model.Memory{Importance: 10, HitCount: 20, CreatedAt: now.Add(-2 * time.Hour)},
+		ScoreBreakdown: ScoreBreakdown{Relevance: 0.5},
+	}
+	lastHit := now.Add(-time.Hour)
+	result.LastHitAt = &lastHit
+	applyScoring(&result, ai_config.ScoringSettings{
+		RelevanceWeight:     1,
+		ImportanceWeight:    1,
+		RecencyWeight:       1,
+		FrequencyWeight:     1,
+		RecencyHalfLife:     time.Hour,
+		FrequencySaturation: 20,
+	}, now)
+	if result.ImportanceScore != 1 || math.Abs(result.RecencyScore-0.5) > 1e-9 || result.FrequencyScore != 1 {
+		t.Fatalf("unexpected breakdown: %+v", result.ScoreBreakdown)
+	}
+	if math.Abs(result.Score-0.75) > 1e-9 {
+		t.Fatalf("score = %v, want 0.75", result.Score)
+	}
+}
+
+func TestRecallMemoriesRecordsHits(t *testing.T) {
+	ctx := context.Background()
+	s := &MemoryService{memoryRepo: newTestMemoryRepository(t)}
+	for _, content := range []string{"用户养了一只猫", "用户周末去跑步"} {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: model.MemoryStatusActive}
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+	}
+
+	results, err := s.RecallMemories(ctx, "per:a", 1, "猫", RetrievalOptions{TopK: 5})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("unexpected results: %+v, %v", results, err)
+	}
+	memories, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1)
+	for _, mem := range memories {
+		hit := mem.ID == results[0].ID
+		if (hit && (mem.HitCount != 1 || mem.LastHitAt == nil)) || (!hit && mem.HitCount != 0) {
+			t.Fatalf("unexpected hit count: %+v", mem)
+		}
+	}
+}
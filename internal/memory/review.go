@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/utils"
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ErrMemoryNotPending 记忆不是待审核状态
+var ErrMemoryNotPending = repository.ErrMemoryNotPending
+
+// MemoryReviewEdit 审核时对候选的修改，未填写的字段保持提取结果
+type MemoryReviewEdit struct {
+	Type       string  `json:"type"`
+	Content    string  `json:"content"`
+	Keywords   *string `json:"keywords"`
+	Importance *int    `json:"importance"`
+}
+
+// ReviewResult 批量审核结果
+type ReviewResult struct {
+	Approved   []model.Memory `json:"approved"`
+	Superseded []string       `json:"superseded"` // 被批准的更新/合并候选取代的旧记忆
+	Retired    []string       `json:"retired"`    // 被批准的失效/删除请求失效或删除的记忆
+	Skipped    []string       `json:"skipped"`    // 不存在、不属于该人格或已不是待审核状态的 ID
+}
+
+// reviewModeEnabled 人格是否开启了记忆审核模式；读取人格失败时按未开启处理
+func (s *MemoryService) reviewModeEnabled(personaId string) bool {
+	if s.personaRepo == nil {
+		return false
+	}
+	persona, err := s.personaRepo.GetPersonaById(personaId)
+	if err != nil {
+		utils.Log.Warn("读取人格失败，按未开启审核处理", zap.String("personaId", personaId), zap.Error(err))
+		return false
+	}
+	return persona.MemoryReviewMode
+}
+
+// ListPendingReview 获取待审核的候选记忆
+func (s *MemoryService) ListPendingReview(personaId string, userId int64) ([]model.Memory, error) {
+	return s.memoryRepo.GetMemoriesByStatus(personaId, userId, model.MemoryStatusPendingReview)
+}
+
+// ApproveMemories 批量批准候选：生成向量并写入向量存储，更新候选同时取代旧记忆
+func (s *MemoryService) ApproveMemories(ctx context.Context, personaId string, userId int64, memoryIds []string) (*ReviewResult, error) {
+	result := &ReviewResult{Approved: []model.Memory{}, Superseded: []string{}, Retired: []string{}, Skipped: []string{}}
+	for _, id := range uniqueTerms(memoryIds) {
+		mem, superseded, err := s.approveMemory(ctx, personaId, userId, id, nil)
+		if errors.Is(err, ErrMemoryNotFound) || errors.Is(err, ErrMemoryNotPending) {
+			result.Skipped = append(result.Skipped, id)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		if mem.ReviewAction != "" {
+			result.Retired = append(result.Retired, superseded...)
+			continue
+		}
+		result.Approved = append(result.Approved, *mem)
+		result.Superseded = append(result.Superseded, superseded...)
+	}
+	return result, nil
+}
+
+// EditAndApproveMemory 按审核时的修改更新候选后批准；失效/删除请求没有可修改的内容，忽略修改直接批准
+func (s *MemoryService) EditAndApproveMemory(ctx context.Context, personaId string, userId int64, memoryId string, edit MemoryReviewEdit) (*model.Memory, error) {
+	mem, _, err := s.approveMemory(ctx, personaId, userId, memoryId, &edit)
+	return mem, err
+}
+
+// RejectMemories 批量拒绝候选，返回实际拒绝的 ID；候选没有向量，无需清理向量存储
+func (s *MemoryService) RejectMemories(personaId string, userId int64, memoryIds []string) ([]string, error) {
+	return s.memoryRepo.RejectMemories(personaId, userId, uniqueTerms(memoryIds))
+}
+
+// approveMemory 批准一条候选，返回被取代的旧记忆（失效/删除请求为被失效或删除的目标记忆）
+func (s *MemoryService) approveMemory(ctx context.Context, personaId string, userId int64, memoryId string, edit *MemoryReviewEdit) (*model.Memory, []string, error) {
+	mem, err := s.getOwnedMemory(personaId, userId, memoryId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mem.Status != model.MemoryStatusPendingReview {
+		return nil, nil, ErrMemoryNotPending
+	}
+	if mem.ReviewAction != "" {
+		return s.approveRetirement(ctx, mem)
+	}
+	if edit != nil {
+		if edit.Type != "" {
+			mem.Type = edit.Type
+		}
+		if edit.Content != "" {
+			mem.Content = edit.Content
+		}
+		if edit.Keywords != nil {
+			mem.Keywords = *edit.Keywords
+		}
+		if edit.Importance != nil {
+			mem.Importance = model.ClampImportance(*edit.Importance)
+		}
+	}
+	if _, fresh := s.freshEmbedding(mem); !fresh {
+		s.PrepareMemoryEmbedding(ctx, mem)
+	}
+
+	superseded, err := s.memoryRepo.ApproveMemory(mem)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.UpsertMemoryVector(ctx, mem)
+	if len(superseded) > 0 {
+		s.DeleteMemoryVector(ctx, superseded...)
+	}
+	utils.Log.Info("候选记忆已批准",
+		zap.String("personaId", personaId),
+		zap.String("memoryId", mem.ID),
+		zap.Strings("replacedIds", mem.ReplacedIDs()),
+		zap.Strings("superseded", superseded),
+	)
+	return mem, superseded, nil
+}
+
+// approveRetirement 批准失效/删除请求：使目标记忆失效或软删除并删除其向量
+func (s *MemoryService) approveRetirement(ctx context.Context, request *model.Memory) (*model.Memory, []string, error) {
+	retired, err := s.memoryRepo.ApproveRetirement(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	var retiredIds []string
+	if retired {
+		retiredIds = []string{request.ReplacesID}
+		s.DeleteMemoryVector(ctx, request.ReplacesID)
+	}
+	utils.Log.Info("失效/删除请求已批准",
+		zap.String("personaId", request.PersonaID),
+		zap.String("memoryId", request.ReplacesID),
+		zap.String("action", request.ReviewAction),
+		zap.Bool("retired", retired),
+	)
+	return request, retiredIds, nil
+}
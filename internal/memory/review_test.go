@@ -0,0 +1,145 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"AI_Chat/internal/model"
+)
+
+func TestReviewQueue(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	create := func(content, status, replaces string) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: status, ReplacesID: replaces}
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		return mem
+	}
+	old := create("用户住在北京", model.MemoryStatusActive, "")
+	s.UpsertMemoryVector(ctx, old)
+	moved := create("用户搬到了上海", model.MemoryStatusPendingReview, old.ID)
+	pet := create("用户养了一只猫", model.MemoryStatusPendingReview, "")
+	wrong := create("用户是外星人", model.MemoryStatusPendingReview, "")
+
+	pending, err := s.ListPendingReview("per:a", 1)
+	if err != nil || len(pending) != 3 {
+		t.Fatalf("unexpected pending list: %d, %v", len(pending), err)
+	}
+
+	result, err := s.ApproveMemories(ctx, "per:a", 1, []string{moved.ID, old.ID, "mem:missing"})
+	if err != nil || len(result.Approved) != 1 || len(result.Skipped) != 2 {
+		t.Fatalf("unexpected approve result: %+v, %v", result, err)
+	}
+	if len(result.Superseded) != 1 || result.Superseded[0] != old.ID {
+		t.Fatalf("old memory not superseded: %+v", result)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(old.ID); got.Status != model.MemoryStatusSuperseded || got.SupersededBy != moved.ID {
+		t.Fatalf("unexpected old memory: %+v", got)
+	}
+
+	importance := 8
+	edited, err := s.EditAndApproveMemory(ctx, "per:a", 1, pet.ID, MemoryReviewEdit{Content: "用户养了一只橘猫", Importance: &importance})
+	if err != nil || edited.Content != "用户养了一只橘猫" || edited.Importance != 8 || edited.Status != model.MemoryStatusActive {
+		t.Fatalf("unexpected edited memory: %+v, %v", edited, err)
+	}
+	if _, fresh := s.freshEmbedding(edited); !fresh {
+		t.Fatalf("edited memory should be embedded with the new content")
+	}
+	if _, err := s.EditAndApproveMemory(ctx, "per:a", 1, pet.ID, MemoryReviewEdit{}); err != ErrMemoryNotPending {
+		t.Fatalf("expected not pending, got %v", err)
+	}
+
+	rejected, err := s.RejectMemories("per:a", 1, []string{wrong.ID, moved.ID})
+	if err != nil || len(rejected) != 1 || rejected[0] != wrong.ID {
+		t.Fatalf("unexpected rejected: %v, %v", rejected, err)
+	}
+
+	// 只有批准的记忆进入向量存储
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	indexed := map[string]bool{}
+	for _, id := range ids {
+		indexed[id] = true
+	}
+	if len(ids) != 2 || !indexed[moved.ID] || !indexed[pet.ID] {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+}
+
+func TestReviewRetireRequests(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+	create := func(content string) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: model.MemoryStatusActive}
+		s.memoryRepo.CreateMemory(mem)
+		s.UpsertMemoryVector(ctx, mem)
+		return mem
+	}
+	job := create("用户在字节跳动工作")
+	secret := create("用户的银行卡密码是 1234")
+	hobby := create("用户喜欢爬山")
+	existing, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1)
+	pending := &model.PendingMessages{PersonaID: "per:a", UserID: 1}
+	actions := []MemoryAction{
+		{Action: "invalidate", OldMemoryID: job.ID},
+		{Action: "delete", OldMemoryID: secret.ID},
+		{Action: "delete", OldMemoryID: hobby.ID},
+	}
+
+	// 审核模式下失效和删除进入审核队列，重复提取不会重复排队
+	for i := 0; i < 2; i++ {
+		if err := s.applyRetireActions(ctx, pending, actions, existing, true); err != nil {
+			t.Fatalf("apply in review mode failed: %v", err)
+		}
+	}
+	queued, _ := s.ListPendingReview("per:a", 1)
+	requests := map[string]model.Memory{}
+	for _, mem := range queued {
+		requests[mem.ReplacesID] = mem
+	}
+	if len(queued) != 3 || requests[job.ID].ReviewAction != model.MemoryReviewInvalidate || requests[secret.ID].ReviewAction != model.MemoryReviewDelete {
+		t.Fatalf("unexpected review queue: %+v", queued)
+	}
+	if requests[job.ID].Content != job.Content {
+		t.Fatalf("request should show the target memory: %+v", requests[job.ID])
+	}
+	if active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser("per:a", 1); len(active) != 3 {
+		t.Fatalf("review mode should not retire memories, active = %d", len(active))
+	}
+
+	result, err := s.ApproveMemories(ctx, "per:a", 1, []string{requests[job.ID].ID, requests[secret.ID].ID})
+	if err != nil || len(result.Retired) != 2 || len(result.Approved) != 0 || len(result.Skipped) != 0 {
+		t.Fatalf("unexpected approve result: %+v, %v", result, err)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(job.ID); got.Status != model.MemoryStatusInvalidated {
+		t.Fatalf("job memory should be invalidated: %+v", got)
+	}
+	if _, err := s.memoryRepo.GetMemoryById(secret.ID); err == nil {
+		t.Fatalf("secret memory should be deleted")
+	}
+	// 拒绝删除请求时记忆保留
+	if rejected, err := s.RejectMemories("per:a", 1, []string{requests[hobby.ID].ID}); err != nil || len(rejected) != 1 {
+		t.Fatalf("reject failed: %v, %v", rejected, err)
+	}
+	if got, _ := s.memoryRepo.GetMemoryById(hobby.ID); got.Status != model.MemoryStatusActive {
+		t.Fatalf("hobby memory should stay active: %+v", got)
+	}
+	if left, _ := s.ListPendingReview("per:a", 1); len(left) != 0 {
+		t.Fatalf("review queue should be empty, got %+v", left)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: "per:a"})
+	if len(ids) != 1 || ids[0] != hobby.ID {
+		t.Fatalf("unexpected vector ids: %v", ids)
+	}
+}
@@ -0,0 +1,438 @@
+package memory
+
+import (
+	"AI_Chat/internal/character_card"
+	"AI_Chat/internal/chat_log"
+	"AI_Chat/internal/model"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/cloudwego/eino/schema"
+	"go.uber.org/zap"
+)
+
+const (
+	// simulationMinMessages 生成模拟人格至少需要的目标发言人消息数
+	simulationMinMessages = 10
+	// simulationMaxExamples 写入人格的示例对话数量上限
+	simulationMaxExamples = 5
+	// simulationMaxExampleRunes 单段示例对话中一方的字数上限，过长的对话不适合作示例
+	simulationMaxExampleRunes = 200
+	// simulationSampleRunes 交给模型的聊天记录片段的字数上限，取最近的部分
+	simulationSampleRunes = 8000
+	// simulationMaxMemories 初始记忆的数量上限
+	simulationMaxMemories = 30
+	// submitSimulationTool 生成模拟人格时模型通过调用该工具提交提示词和初始记忆
+	submitSimulationTool = "submit_simulation_persona"
+)
+
+var (
+	// ErrSpeakerNotFound 聊天记录中没有指定的发言人
+	ErrSpeakerNotFound = errors.New("speaker not found in chat log")
+	// ErrNotEnoughMessages 目标发言人的消息太少，无法模仿
+	ErrNotEnoughMessages = errors.New("not enough messages from speaker")
+)
+
+// StyleProfile 从聊天记录统计出的说话风格
+type StyleProfile struct {
+	Messages        int      `json:"messages"`         // 目标发言人的消息数
+	AvgLength       float64  `json:"avg_length"`       // 平均每条消息的字数
+	AvgBurst        float64  `json:"avg_burst"`        // 平均每次连续发送的消息条数
+	EmojiRate       float64  `json:"emoji_rate"`       // 含表情的消息占比
+	PunctuationRate float64  `json:"punctuation_rate"` // 以句号、感叹号、问号等结尾的消息占比
+	QuestionRate    float64  `json:"question_rate"`    // 含问句的消息占比
+	Catchphrases    []string `json:"catchphrases"`     // 反复出现的短句
+	Particles       []string `json:"particles"`        // 常用的句尾语气词
+}
+
+// SimulationResult 由聊天记录生成模拟人格的结果
+type SimulationResult struct {
+	Persona    *model.Persona            `json:"persona"`
+	Style      StyleProfile              `json:"style"`
+	Examples   []character_card.Exchange `json:"examples"`
+	Memories   []model.Memory            `json:"memories"`
+	Validation ActionValidation          `json:"validation"`
+}
+
+// simulationDraft 模型给出的提示词和初始记忆
+type simulationDraft struct {
+	SystemPrompt string
+	Memories     []MemoryAction
+}
+
+// chatTurn 同一发言人连续发送的一组消息
+type chatTurn struct {
+	Target   bool
+	Messages []string
+}
+
+// CreateSimulationPersona 由聊天记录生成模拟真人（mode 2）的人格：挑出 target 的发言统计说话风格并选取示例对话，
+// 再让模型根据聊天片段写出系统提示词和初始记忆。人格的示例对话写入 MessageExamples，初始记忆直接生效。
+// 其他发言人都视为用户一方。
+func (s *MemoryService) CreateSimulationPersona(ctx context.Context, userId int64, name, target string, utterances []chat_log.Utterance) (*SimulationResult, error) {
+	if s.personaRepo == nil {
+		return nil, fmt.Errorf("persona repository is nil")
+	}
+	target = strings.TrimSpace(target)
+	turns, count := splitTurns(utterances, target)
+	if count == 0 {
+		return nil, ErrSpeakerNotFound
+	}
+	if count < simulationMinMessages {
+		return nil, ErrNotEnoughMessages
+	}
+	if strings.TrimSpace(name) == "" {
+		name = target
+	}
+
+	result := &SimulationResult{
+		Style:    buildStyleProfile(turns),
+		Examples: selectExamples(turns, simulationMaxExamples),
+		Memories: []model.Memory{},
+	}
+	draft, err := s.callLLMSimulation(ctx, target, result.Style, result.Examples, sampleTranscript(turns, target, simulationSampleRunes))
+	if err != nil {
+		return nil, fmt.Errorf("生成模拟人格失败: %w", err)
+	}
+	actions, report := validateMemoryActions(draft.Memories, nil, 0)
+	if len(actions) > simulationMaxMemories {
+		report.skip("%d memories over the limit of %d", len(actions)-simulationMaxMemories, simulationMaxMemories)
+		actions = actions[:simulationMaxMemories]
+	}
+	result.Validation = report
+	if draft.SystemPrompt == "" {
+		draft.SystemPrompt = defaultSimulationPrompt(target, result.Style)
+	}
+
+	persona := &model.Persona{
+		UserID:          userId,
+		Name:            name,
+		Description:     fmt.Sprintf("根据聊天记录模拟「%s」（%d 条消息）", target, count),
+		SystemPrompt:    draft.SystemPrompt,
+		Mode:            2,
+		MessageExamples: character_card.FormatExamples(result.Examples),
+	}
+	if err := s.personaRepo.CreatePersona(persona); err != nil {
+		return nil, fmt.Errorf("创建人格失败: %w", err)
+	}
+	result.Persona = persona
+
+	memories := make([]model.Memory, 0, len(actions))
+	for _, action := range actions {
+		memories = append(memories, model.Memory{
+			PersonaID:  persona.ID,
+			UserID:     userId,
+			Type:       action.Type,
+			Content:    action.Content,
+			Keywords:   action.Keywords,
+			Importance: model.ClampImportance(action.Importance),
+			Source:     model.MemorySourceImported,
+			Status:     model.MemoryStatusActive,
+		})
+	}
+	pointers := make([]*model.Memory, 0, len(memories))
+	for i := range memories {
+		pointers = append(pointers, &memories[i])
+	}
+	s.PrepareMemoryEmbeddings(ctx, pointers)
+	if err := s.memoryRepo.BatchCreateMemories(memories); err != nil {
+		// 初始记忆写入失败时隐藏刚创建的人格，由用户重新导入
+		if markErr := s.personaRepo.MarkPersonaDeleted(persona.ID); markErr != nil {
+			utils.Log.Error("隐藏导入失败的人格失败", zap.String("personaId", persona.ID), zap.Error(markErr))
+		}
+		return nil, fmt.Errorf("写入初始记忆失败: %w", err)
+	}
+	for i := range memories {
+		s.UpsertMemoryVector(ctx, &memories[i])
+	}
+	result.Memories = memories
+
+	utils.Log.Info("模拟人格已生成",
+		zap.String("personaId", persona.ID),
+		zap.Int64("userId", userId),
+		zap.Int("messages", count),
+		zap.Int("examples", len(result.Examples)),
+		zap.Int("memories", len(memories)),
+	)
+	return result, nil
+}
+
+// splitTurns 把消息按发言人合并为轮次，target 的消息标记为目标轮次，其他发言人的相邻消息合并为用户轮次；返回 target 的消息数
+func splitTurns(utterances []chat_log.Utterance, target string) ([]chatTurn, int) {
+	var turns []chatTurn
+	count := 0
+	for _, u := range utterances {
+		isTarget := u.Speaker == target
+		if isTarget {
+			count++
+		}
+		if n := len(turns); n > 0 && turns[n-1].Target == isTarget {
+			turns[n-1].Messages = append(turns[n-1].Messages, u.Content)
+			continue
+		}
+		turns = append(turns, chatTurn{Target: isTarget, Messages: []string{u.Content}})
+	}
+	return turns, count
+}
+
+var (
+	// wechatEmoji 微信表情的文字形式，如 [微笑]
+	wechatEmoji = regexp.MustCompile(`\[[\p{Han}A-Za-z]{1,4}\]`)
+	// sentencePunctuation 句尾标点
+	sentencePunctuation = "。！？!?.…~～"
+	// particleRunes 常见的句尾语气词
+	particleRunes = "啊呀呢吧哈嘛啦哦噢嗯哇咯呗诶嘞喔"
+)
+
+// buildStyleProfile 统计目标发言人的消息长度、连发条数、表情和标点习惯、口头禅和语气词
+func buildStyleProfile(turns []chatTurn) StyleProfile {
+	var profile StyleProfile
+	var runes, bursts, emoji, punctuated, questions int
+	phrases := make(map[string]int)
+	particles := make(map[string]int)
+	for _, turn := range turns {
+		if !turn.Target {
+			continue
+		}
+		bursts++
+		for _, msg := range turn.Messages {
+			profile.Messages++
+			text := []rune(strings.TrimSpace(msg))
+			runes += len(text)
+			if wechatEmoji.MatchString(msg) || strings.IndexFunc(msg, isEmoji) >= 0 {
+				emoji++
+			}
+			if strings.ContainsAny(msg, "？?") {
+				questions++
+			}
+			stripped := []rune(strings.TrimRightFunc(wechatEmoji.ReplaceAllString(string(text), ""), func(r rune) bool {
+				return unicode.IsSpace(r) || isEmoji(r)
+			}))
+			if len(stripped) == 0 {
+				continue
+			}
+			last := stripped[len(stripped)-1]
+			if strings.ContainsRune(sentencePunctuation, last) {
+				punctuated++
+			}
+			// 去掉句尾标点后的最后一个字
+			trimmed := []rune(strings.TrimRight(string(stripped), sentencePunctuation))
+			if len(trimmed) > 0 && strings.ContainsRune(particleRunes, trimmed[len(trimmed)-1]) {
+				particles[string(trimmed[len(trimmed)-1])]++
+			}
+			if len(stripped) <= 8 {
+				phrases[string(stripped)]++
+			}
+		}
+	}
+	if profile.Messages == 0 {
+		return profile
+	}
+	total := float64(profile.Messages)
+	profile.AvgLength = round1(float64(runes) / total)
+	profile.AvgBurst = round1(total / float64(bursts))
+	profile.EmojiRate = round2(float64(emoji) / total)
+	profile.PunctuationRate = round2(float64(punctuated) / total)
+	profile.QuestionRate = round2(float64(questions) / total)
+	profile.Catchphrases = topKeys(phrases, 8, 2)
+	profile.Particles = topKeys(particles, 5, max(2, profile.Messages/30))
+	return profile
+}
+
+// isEmoji 是否为 Unicode 表情符号
+func isEmoji(r rune) bool {
+	return (r >= 0x1F300 && r <= 0x1FAFF) || (r >= 0x2600 && r <= 0x27BF)
+}
+
+// topKeys 取出现次数不少于 minCount 的前 n 个键，次数相同时按字典序
+func topKeys(counts map[string]int, n, minCount int) []string {
+	keys := make([]string, 0, len(counts))
+	for key, count := range counts {
+		if count >= minCount {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func round1(v float64) float64 { return float64(int(v*10+0.5)) / 10 }
+func round2(v float64) float64 { return float64(int(v*100+0.5)) / 100 }
+
+// selectExamples 选取用户轮次之后紧跟目标回复的对话作为示例，双方都不过长，在整份记录中均匀取 n 段
+func selectExamples(turns []chatTurn, n int) []character_card.Exchange {
+	var candidates []character_card.Exchange
+	for i := 1; i < len(turns); i++ {
+		if !turns[i].Target || turns[i-1].Target {
+			continue
+		}
+		user := strings.Join(turns[i-1].Messages, "\n")
+		reply := strings.Join(turns[i].Messages, "\n")
+		if len([]rune(user)) > simulationMaxExampleRunes || len([]rune(reply)) > simulationMaxExampleRunes || len([]rune(reply)) < 2 {
+			continue
+		}
+		candidates = append(candidates, character_card.Exchange{User: user, Char: reply})
+	}
+	if len(candidates) <= n {
+		return candidates
+	}
+	examples := make([]character_card.Exchange, 0, n)
+	for i := 0; i < n; i++ {
+		examples = append(examples, candidates[i*len(candidates)/n])
+	}
+	return examples
+}
+
+// sampleTranscript 取最近的聊天片段，总字数不超过 limit；目标发言人显示为其名字，其他人显示为“用户”
+func sampleTranscript(turns []chatTurn, target string, limit int) string {
+	var lines []string
+	size := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		speaker := "用户"
+		if turns[i].Target {
+			speaker = target
+		}
+		line := speaker + ": " + strings.Join(turns[i].Messages, "\n")
+		size += len([]rune(line))
+		if size > limit && len(lines) > 0 {
+			break
+		}
+		lines = append(lines, line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeStyle 把说话风格写成提示词中的文字
+func describeStyle(style StyleProfile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "- 每条消息平均 %.1f 个字，每次通常连发 %.1f 条\n", style.AvgLength, style.AvgBurst)
+	fmt.Fprintf(&b, "- %.0f%% 的消息带表情，%.0f%% 的消息以标点结尾，%.0f%% 的消息是问句\n", style.EmojiRate*100, style.PunctuationRate*100, style.QuestionRate*100)
+	if len(style.Catchphrases) > 0 {
+		fmt.Fprintf(&b, "- 常说：%s\n", strings.Join(style.Catchphrases, "、"))
+	}
+	if len(style.Particles) > 0 {
+		fmt.Fprintf(&b, "- 常用语气词：%s\n", strings.Join(style.Particles, "、"))
+	}
+	return b.String()
+}
+
+// defaultSimulationPrompt 模型没有给出提示词时，根据说话风格生成一个基础提示词供用户修改
+func defaultSimulationPrompt(target string, style StyleProfile) string {
+	return fmt.Sprintf("你是%s，正在和老朋友用微信聊天。请完全以%s的身份和口吻回复，不要承认自己是 AI。\n\n说话风格：\n%s", target, target, describeStyle(style))
+}
+
+// callLLMSimulation 让模型根据说话风格、示例对话和聊天片段写出系统提示词和初始记忆
+func (s *MemoryService) callLLMSimulation(ctx context.Context, target string, style StyleProfile, examples []character_card.Exchange, transcript string) (*simulationDraft, error) {
+	cm, err := ai_config.NewChatModel(ctx, ai_config.MemoryChatProvider)
+	if err != nil {
+		return nil, err
+	}
+	systemPrompt := `你是角色设定助手。用户上传了与一位真人的聊天记录，希望 AI 模仿这个人和用户聊天。请根据统计出的说话风格和聊天片段完成两件事：
+
+【system_prompt】以第二人称写给 AI 的系统提示词（"你是……"），包括：此人的身份、性格和说话方式（用词、语气、句子长短、表情和标点习惯、口头禅），
+与用户的关系和相处方式，以及"始终以此人的身份回复，不要承认自己是 AI"。只根据聊天记录中能看出的内容写，不要编造经历。
+
+【memories】此人与用户相处中值得记住的信息，作为初始记忆：用户的情况和喜好、两人的关系和共同经历、近期正在发生的事。
+内容以第三人称描述用户，例如"用户在杭州工作"、"你和用户是大学室友"。type 为 fact/preference/event/emotion/relationship 之一，importance 为 1~10 的整数，最多 30 条。
+
+请调用 submit_simulation_persona 工具提交结果；无法调用工具时，只输出如下格式的 JSON，不要附加其他文字:
+{"system_prompt": "...", "memories": [{"type": "fact", "content": "...", "keywords": "...", "importance": 6}]}`
+
+	examplesText := "（无）\n"
+	if len(examples) > 0 {
+		examplesText = ""
+		for _, example := range examples {
+			examplesText += fmt.Sprintf("用户: %s\n%s: %s\n\n", example.User, target, example.Char)
+		}
+	}
+	messages := []*schema.Message{
+		{Role: schema.System, Content: systemPrompt},
+		{Role: schema.User, Content: fmt.Sprintf("【模仿对象】%s\n\n【说话风格】\n%s\n【示例对话】\n%s【聊天片段】\n%s", target, describeStyle(style), examplesText, transcript)},
+	}
+	resp, err := generateStructured(ctx, cm, messages, simulationToolInfo())
+	if err != nil {
+		return nil, err
+	}
+	return parseSimulation(resp)
+}
+
+// simulationToolInfo 提交模拟人格的工具定义
+func simulationToolInfo() *schema.ToolInfo {
+	memory := &schema.ParameterInfo{
+		Type: schema.Object,
+		SubParams: map[string]*schema.ParameterInfo{
+			"type":       {Type: schema.String, Enum: model.MemoryTypes, Desc: "记忆类型", Required: true},
+			"content":    {Type: schema.String, Desc: "记忆内容", Required: true},
+			"keywords":   {Type: schema.String, Desc: "检索关键词，空格分隔"},
+			"importance": {Type: schema.Integer, Desc: "重要度 1~10"},
+		},
+	}
+	return &schema.ToolInfo{
+		Name: submitSimulationTool,
+		Desc: "提交模拟真人的系统提示词和初始记忆",
+		ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+			"system_prompt": {Type: schema.String, Desc: "给 AI 的系统提示词", Required: true},
+			"memories":      {Type: schema.Array, ElemInfo: memory, Desc: "初始记忆，没有时为空数组", Required: true},
+		}),
+	}
+}
+
+// parseSimulation 解析模型给出的提示词和初始记忆，个别记忆格式错误时跳过
+func parseSimulation(resp *schema.Message) (*simulationDraft, error) {
+	if resp == nil {
+		return nil, fmt.Errorf("empty response")
+	}
+	payload := strings.TrimSpace(structuredPayload(resp, submitSimulationTool))
+	for _, candidate := range jsonCandidates(payload) {
+		var wrapped struct {
+			SystemPrompt string            `json:"system_prompt"`
+			Memories     []json.RawMessage `json:"memories"`
+		}
+		if err := json.Unmarshal([]byte(candidate), &wrapped); err != nil {
+			continue
+		}
+		if wrapped.SystemPrompt == "" && wrapped.Memories == nil {
+			continue
+		}
+		draft := &simulationDraft{SystemPrompt: strings.TrimSpace(wrapped.SystemPrompt)}
+		for _, raw := range wrapped.Memories {
+			var loose struct {
+				Type       string      `json:"type"`
+				Content    string      `json:"content"`
+				Keywords   string      `json:"keywords"`
+				Importance interface{} `json:"importance"`
+			}
+			if err := json.Unmarshal(raw, &loose); err != nil {
+				continue
+			}
+			draft.Memories = append(draft.Memories, MemoryAction{
+				Action:     "add",
+				Type:       loose.Type,
+				Content:    loose.Content,
+				Keywords:   loose.Keywords,
+				Importance: looseInt(loose.Importance),
+			})
+		}
+		return draft, nil
+	}
+	return nil, fmt.Errorf("no simulation persona found in response")
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"AI_Chat/internal/chat_log"
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+)
+
+func TestCreateSimulationPersona(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t, &model.Persona{}, &model.PersonaVersion{})
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  repository.NewMemoryRepository(db),
+		personaRepo: repository.NewPersonaRepository(db),
+		embedding:   NewHashEmbedder(32),
+		vectorStore: store,
+	}
+
+	var log strings.Builder
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&log, "我: 今天第%d节课上完了吗\n小雪: 上完啦\n小雪: 累死了哈哈哈\n", i+1)
+	}
+	utterances, err := chat_log.Parse([]byte(log.String()), "")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := s.CreateSimulationPersona(ctx, 1, "", "小明", utterances); err != ErrSpeakerNotFound {
+		t.Fatalf("unknown speaker should be rejected, got %v", err)
+	}
+
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+		Name:   "simulation-test",
+		Type:   ai_config.ProviderFake,
+		Script: []string{`{"system_prompt": "你是小雪，一名高中老师。", "memories": [{"type": "relationship", "content": "你和用户是大学同学", "importance": 8}, {"type": "unknown", "content": "用户在杭州工作"}, {"type": "fact", "content": " "}]}`},
+	})
+	previous := ai_config.MemoryChatProvider
+	ai_config.MemoryChatProvider = "simulation-test"
+	t.Cleanup(func() { ai_config.MemoryChatProvider = previous })
+
+	result, err := s.CreateSimulationPersona(ctx, 1, "", "小雪", utterances)
+	if err != nil {
+		t.Fatalf("create simulation persona failed: %v", err)
+	}
+	persona := result.Persona
+	if persona.Mode != 2 || persona.Name != "小雪" || persona.SystemPrompt != "你是小雪，一名高中老师。" || persona.CurrentVersionID == "" {
+		t.Fatalf("unexpected persona: %+v", persona)
+	}
+	if result.Style.Messages != 24 || result.Style.AvgBurst != 2 || len(result.Style.Catchphrases) != 2 {
+		t.Fatalf("unexpected style: %+v", result.Style)
+	}
+	if len(result.Examples) != simulationMaxExamples || !strings.HasPrefix(persona.MessageExamples, "<START>\n{{user}}: 今天第1节课上完了吗\n{{char}}: 上完啦\n累死了哈哈哈") {
+		t.Fatalf("unexpected examples:\n%s", persona.MessageExamples)
+	}
+
+	// 类型无效的记忆修复为 fact，内容为空的跳过
+	if len(result.Memories) != 2 || result.Validation.Skipped != 1 || result.Memories[1].Type != model.MemoryTypeFact {
+		t.Fatalf("unexpected memories: %+v, %+v", result.Memories, result.Validation)
+	}
+	active, _ := s.memoryRepo.GetActiveMemoriesByPersonaAndUser(persona.ID, 1)
+	if len(active) != 2 || active[0].Source != model.MemorySourceImported {
+		t.Fatalf("memories not stored: %+v", active)
+	}
+	if ids, _ := store.ListIDs(ctx, VectorFilter{PersonaID: persona.ID}); len(ids) != 2 {
+		t.Fatalf("memory vectors not stored: %v", ids)
+	}
+}
@@ -0,0 +1,216 @@
+package memory
+
+import (
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+	"AI_Chat/pkg/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// SummaryTriggerRatio 未摘要的历史超过输入预算的该比例时触发摘要
+	SummaryTriggerRatio = 0.6
+	// SummaryKeepRatio 摘要后保留原文的最近历史占输入预算的比例
+	SummaryKeepRatio = 0.3
+	// SummaryMaxRunes 摘要的最大字数
+	SummaryMaxRunes = 1000
+)
+
+// SummaryService 维护每个会话的滚动摘要：
+// 即将放不进上下文窗口的旧轮次会被合并进摘要，聊天时摘要以系统消息注入。
+type SummaryService struct {
+	conversationRepo *repository.ConversationRepository
+	running          sync.Map // conversationId -> struct{}，避免同一会话并发刷新
+}
+
+func NewSummaryService(conversationRepo *repository.ConversationRepository) *SummaryService {
+	return &SummaryService{conversationRepo: conversationRepo}
+}
+
+// GetSummary 获取会话摘要，不存在时返回 nil
+func (s *SummaryService) GetSummary(conversationId string) (*model.ConversationSummary, error) {
+	summary, err := s.conversationRepo.GetSummaryByConversationId(conversationId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return summary, err
+}
+
+// UpdateSummary 用户手动修改摘要内容，后续的自动刷新会在此基础上继续合并，并保留用户写入的内容；
+// 正在进行的自动刷新发现摘要被修改后放弃结果，不会覆盖用户的修改
+func (s *SummaryService) UpdateSummary(conversationId, content string) (*model.ConversationSummary, error) {
+	content = strings.TrimSpace(content)
+	summary := &model.ConversationSummary{
+		ConversationID: conversationId,
+		Summary:        content,
+		TokenCount:     utils.CountTokens(content),
+	}
+	if err := s.conversationRepo.SaveUserSummary(summary); err != nil {
+		return nil, err
+	}
+	return s.GetSummary(conversationId)
+}
+
+// UnsummarizedMessages 过滤掉已经被摘要覆盖的消息
+func UnsummarizedMessages(messages []model.Message, summary *model.ConversationSummary) []model.Message {
+	if summary == nil || summary.CoveredOrderID == 0 {
+		return messages
+	}
+	for i, msg := range messages {
+		if msg.OrderID > summary.CoveredOrderID {
+			return messages[i:]
+		}
+	}
+	return []model.Message{}
+}
+
+// RefreshSummary 检查未摘要的历史是否即将超出输入预算，是则把最旧的若干轮合并进摘要。
+// budget 为聊天时可用的输入 token。
+func (s *SummaryService) RefreshSummary(ctx context.Context, conversationId string, budget int) error {
+	if _, loaded := s.running.LoadOrStore(conversationId, struct{}{}); loaded {
+		return nil
+	}
+	defer s.running.Delete(conversationId)
+
+	summary, folded, err := s.foldCandidates(conversationId, budget)
+	if err != nil || len(folded) == 0 {
+		return err
+	}
+	previous, edited := "", false
+	if summary != nil {
+		previous, edited = summary.Summary, summary.EditedByUser
+	}
+	content, err := s.callLLMSummarize(ctx, previous, edited, folded)
+	if err != nil {
+		return err
+	}
+	saved, err := s.saveFoldedSummary(conversationId, summary, content, folded)
+	if err != nil {
+		return err
+	}
+	if !saved {
+		// 调用模型期间摘要被用户修改，放弃本次结果；这些消息仍未被覆盖，下次刷新时在修改后的摘要上重新合并
+		utils.Log.Info("会话摘要在刷新期间被修改，放弃本次结果", zap.String("conversationId", conversationId))
+		return nil
+	}
+	utils.Log.Info("会话摘要已更新",
+		zap.String("conversationId", conversationId),
+		zap.Int("foldedMessages", len(folded)),
+		zap.Int64("coveredOrderId", folded[len(folded)-1].OrderID),
+		zap.Int("summaryTokens", utils.CountTokens(content)),
+	)
+	return nil
+}
+
+// foldCandidates 读取当前摘要，未摘要的历史超过输入预算的触发比例时，返回要合并进摘要的最旧的若干条消息：
+// 从最旧的消息开始折叠，直到剩余部分不超过保留比例，并且停在完整轮次的边界（下一条是用户消息）
+func (s *SummaryService) foldCandidates(conversationId string, budget int) (*model.ConversationSummary, []model.Message, error) {
+	summary, err := s.GetSummary(conversationId)
+	if err != nil {
+		return nil, nil, err
+	}
+	messages, err := s.conversationRepo.GetMessagesByConversationId(conversationId)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := UnsummarizedMessages(messages, summary)
+
+	total := 0
+	for i := range pending {
+		total += summaryMessageTokens(&pending[i])
+	}
+	if float64(total) <= float64(budget)*SummaryTriggerRatio {
+		return summary, nil, nil
+	}
+	keep := int(float64(budget) * SummaryKeepRatio)
+	cut := 0
+	for cut < len(pending) && (total > keep || pending[cut].Role != "user") {
+		total -= summaryMessageTokens(&pending[cut])
+		cut++
+	}
+	return summary, pending[:cut], nil
+}
+
+// saveFoldedSummary 保存合并后的摘要，previous 为刷新开始时读取的摘要；摘要在此期间被修改过时不写入，返回 false。
+// 用户修改过的摘要在后续刷新中保持 EditedByUser，继续要求模型保留其中的内容
+func (s *SummaryService) saveFoldedSummary(conversationId string, previous *model.ConversationSummary, content string, folded []model.Message) (bool, error) {
+	summary := &model.ConversationSummary{
+		ConversationID: conversationId,
+		Summary:        content,
+		TokenCount:     utils.CountTokens(content),
+		CoveredOrderID: folded[len(folded)-1].OrderID,
+	}
+	if previous != nil {
+		summary.EditedByUser = previous.EditedByUser
+	}
+	return s.conversationRepo.ReplaceSummaryIfUnchanged(summary, previous)
+}
+
+func summaryMessageTokens(message *model.Message) int {
+	if message.TokenCount > 0 {
+		return message.TokenCount
+	}
+	return utils.CountTokens(message.Content)
+}
+
+// callLLMSummarize 把新的对话合并进已有摘要，edited 表示已有摘要经用户修改过
+func (s *SummaryService) callLLMSummarize(ctx context.Context, previous string, edited bool, messages []model.Message) (string, error) {
+	cm, err := ai_config.NewChatModel(ctx, ai_config.MemoryChatProvider)
+	if err != nil {
+		return "", err
+	}
+
+	var conversation strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			conversation.WriteString("用户: ")
+		case "assistant":
+			conversation.WriteString("AI: ")
+		default:
+			continue
+		}
+		conversation.WriteString(msg.Content)
+		conversation.WriteString("\n")
+	}
+	if previous == "" {
+		previous = "（暂无）"
+	}
+
+	systemPrompt := fmt.Sprintf(`你是对话摘要助手。请把【新对话】合并进【已有摘要】，输出更新后的完整摘要。
+
+【要求】
+1. 保留对后续聊天有用的信息：发生过的事件、双方的约定、用户的情绪变化、未结束的话题。
+2. 按时间顺序叙述，用第三人称称呼“用户”和“AI”。
+3. 已有摘要中的内容除非被新对话推翻，否则不要丢弃。
+4. 不超过 %d 字，只输出摘要正文，不要输出其他内容。`, SummaryMaxRunes)
+	if edited {
+		systemPrompt += "\n5. 已有摘要经用户手动修改过，其中的内容即使与新对话不一致也必须保留，只在此基础上补充新对话的信息。"
+	}
+	userPrompt := fmt.Sprintf("【已有摘要】\n%s\n\n【新对话】\n%s", previous, conversation.String())
+
+	resp, err := cm.Generate(ctx, []*schema.Message{
+		{Role: schema.System, Content: systemPrompt},
+		{Role: schema.User, Content: userPrompt},
+	})
+	if err != nil {
+		return "", err
+	}
+	content := strings.TrimSpace(resp.Content)
+	if content == "" {
+		return "", fmt.Errorf("summary response empty")
+	}
+	if runes := []rune(content); len(runes) > SummaryMaxRunes*2 {
+		content = string(runes[:SummaryMaxRunes*2])
+	}
+	return content, nil
+}
@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+)
+
+// newTestSummaryService 创建使用 sqlite 的摘要服务，并按 tokens 依次写入一问一答交替的消息
+func newTestSummaryService(t *testing.T, conversationId string, tokens ...int) *SummaryService {
+	t.Helper()
+	gdb := newTestDB(t, &model.Conversation{}, &model.ConversationSummary{})
+	for i, count := range tokens {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		msg := &model.Message{OrderID: int64(i + 1), ConversationID: conversationId, Role: role, Content: role + " message", TokenCount: count}
+		if err := gdb.Create(msg).Error; err != nil {
+			t.Fatalf("create message failed: %v", err)
+		}
+	}
+	return NewSummaryService(repository.NewConversationRepository(gdb))
+}
+
+func TestSummaryFoldRatios(t *testing.T) {
+	s := newTestSummaryService(t, "conv:a", 35, 10, 10, 10)
+
+	// 未摘要的历史（65）不超过预算的触发比例时不折叠
+	if _, folded, err := s.foldCandidates("conv:a", 200); err != nil || len(folded) != 0 {
+		t.Fatalf("should not fold below trigger ratio: %d, %v", len(folded), err)
+	}
+
+	// 预算 100：超过 60 触发，折叠到剩余不超过 30；折叠第一条后已满足保留比例，但下一条是回复，继续折叠到下一轮的用户消息
+	_, folded, err := s.foldCandidates("conv:a", 100)
+	if err != nil || len(folded) != 2 {
+		t.Fatalf("should fold the whole first round: %d, %v", len(folded), err)
+	}
+	if folded[0].Role != "user" || folded[1].Role != "assistant" || folded[1].OrderID != 2 {
+		t.Fatalf("unexpected folded messages: %+v", folded)
+	}
+
+	// 已被摘要覆盖的消息不再计入
+	if _, err := s.conversationRepo.ReplaceSummaryIfUnchanged(&model.ConversationSummary{ConversationID: "conv:a", Summary: "旧摘要", CoveredOrderID: 2}, nil); err != nil {
+		t.Fatalf("save summary failed: %v", err)
+	}
+	if _, folded, err := s.foldCandidates("conv:a", 100); err != nil || len(folded) != 0 {
+		t.Fatalf("covered messages should not count: %d, %v", len(folded), err)
+	}
+}
+
+func TestSummaryRefreshKeepsUserEdit(t *testing.T) {
+	// 脚本为空时假模型原样返回最后一条用户消息，即包含已有摘要和新对话的提示
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{Name: "summary-test", Type: ai_config.ProviderFake})
+	previous := ai_config.MemoryChatProvider
+	ai_config.MemoryChatProvider = "summary-test"
+	t.Cleanup(func() { ai_config.MemoryChatProvider = previous })
+
+	ctx := context.Background()
+	s := newTestSummaryService(t, "conv:a", 35, 10, 10, 10)
+
+	// 刷新读取摘要并调用模型期间，用户修改了摘要
+	summary, folded, err := s.foldCandidates("conv:a", 100)
+	if err != nil || summary != nil || len(folded) != 2 {
+		t.Fatalf("unexpected fold candidates: %+v, %d, %v", summary, len(folded), err)
+	}
+	if _, err := s.UpdateSummary("conv:a", "用户写的摘要"); err != nil {
+		t.Fatalf("update summary failed: %v", err)
+	}
+	saved, err := s.saveFoldedSummary("conv:a", summary, "模型生成的摘要", folded)
+	if err != nil || saved {
+		t.Fatalf("stale refresh should be discarded: %v, %v", saved, err)
+	}
+	edited, _ := s.GetSummary("conv:a")
+	if edited.Summary != "用户写的摘要" || !edited.EditedByUser || edited.CoveredOrderID != 0 {
+		t.Fatalf("user edit should be kept: %+v", edited)
+	}
+
+	// 下次刷新在用户修改的摘要上合并，覆盖范围前进，仍标记为用户修改过
+	if err := s.RefreshSummary(ctx, "conv:a", 100); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	refreshed, _ := s.GetSummary("conv:a")
+	if !strings.Contains(refreshed.Summary, "用户写的摘要") || refreshed.CoveredOrderID != 2 || !refreshed.EditedByUser {
+		t.Fatalf("refresh should fold on top of the user edit: %+v", refreshed)
+	}
+
+	// 刷新之后的修改只改内容，不影响已覆盖的范围
+	if updated, err := s.UpdateSummary("conv:a", "再次修改"); err != nil || updated.Summary != "再次修改" || updated.CoveredOrderID != 2 {
+		t.Fatalf("update should keep covered range: %+v, %v", updated, err)
+	}
+}
@@ -0,0 +1,55 @@
+package memory
+
+import "context"
+
+// 向量存储后端
+const (
+	VectorStoreMilvus = "milvus" // Milvus 集合
+	VectorStoreLocal  = "local"  // 进程内暴力检索，可选落盘，用于开发、测试和小规模部署
+)
+
+// VectorRecord 一条记忆向量
+type VectorRecord struct {
+	ID        string
+	PersonaID string
+	UserID    int64
+	Embedding []float32
+}
+
+// VectorFilter 检索和计数的过滤条件，PersonaID 为空或 UserID 为 0 时不过滤该字段
+type VectorFilter struct {
+	PersonaID string
+	UserID    int64
+}
+
+// VectorSearchResult 检索结果，Score 越大越相似
+type VectorSearchResult struct {
+	ID    string
+	Score float32
+}
+
+// VectorStore 记忆向量存储
+type VectorStore interface {
+	// Upsert 写入向量，ID 已存在时覆盖
+	Upsert(ctx context.Context, records ...VectorRecord) error
+	// Delete 删除向量，ID 不存在时忽略
+	Delete(ctx context.Context, ids ...string) error
+	// Search 在过滤条件内检索最相似的 topK 条，按相似度从高到低排列
+	Search(ctx context.Context, filter VectorFilter, embedding []float32, topK int) ([]VectorSearchResult, error)
+	// Count 统计过滤条件内的向量数量
+	Count(ctx context.Context, filter VectorFilter) (int64, error)
+	// ListIDs 列出过滤条件内的全部向量 ID，用于与数据库核对
+	ListIDs(ctx context.Context, filter VectorFilter) ([]string, error)
+	// Dimension 向量维度，尚未确定时为 0
+	Dimension() int
+}
+
+func (f VectorFilter) match(personaID string, userID int64) bool {
+	if f.PersonaID != "" && f.PersonaID != personaID {
+		return false
+	}
+	if f.UserID != 0 && f.UserID != userID {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"AI_Chat/internal/model"
+	"AI_Chat/internal/repository"
+	"AI_Chat/pkg/ai_config"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestLocalVectorStorePersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vectors.json")
+
+	store, err := NewLocalVectorStore(path, 0)
+	if err != nil {
+		t.Fatalf("create store failed: %v", err)
+	}
+	records := []VectorRecord{
+		{ID: "mem:1", PersonaID: "per:a", UserID: 1, Embedding: []float32{1, 0}},
+		{ID: "mem:2", PersonaID: "per:a", UserID: 1, Embedding: []float32{0, 1}},
+		{ID: "mem:3", PersonaID: "per:b", UserID: 1, Embedding: []float32{1, 0}},
+	}
+	if err := store.Upsert(ctx, records...); err != nil {
+		t.Fatalf("upsert failed: %v", err)
+	}
+	if err := store.Upsert(ctx, VectorRecord{ID: "mem:4", PersonaID: "per:a", UserID: 1, Embedding: []float32{1, 0, 0}}); err == nil {
+		t.Fatalf("expected dimension mismatch error")
+	}
+	if err := store.Delete(ctx, "mem:2"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	reopened, err := NewLocalVectorStore(path, 0)
+	if err != nil {
+		t.Fatalf("reopen store failed: %v", err)
+	}
+	if reopened.Dimension() != 2 {
+		t.Fatalf("dimension = %d, want 2", reopened.Dimension())
+	}
+	count, _ := reopened.Count(ctx, VectorFilter{PersonaID: "per:a", UserID: 1})
+	if count != 1 {
+		t.Fatalf("count = %d, want 1", count)
+	}
+	hits, err := reopened.Search(ctx, VectorFilter{PersonaID: "per:a", UserID: 1}, []float32{1, 0}, 5)
+	if err != nil || len(hits) != 1 || hits[0].ID != "mem:1" {
+		t.Fatalf("unexpected hits: %+v, %v", hits, err)
+	}
+}
+
+// newTestEmbeddingServer 按关键词返回固定向量的 embeddings 接口
+func newTestEmbeddingServer(t *testing.T) *EmbeddingClient {
+	t.Helper()
+	axes := []string{"猫", "咖啡", "跑步"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		data := make([]map[string]interface{}, 0, len(req.Input))
+		for index, input := range req.Input {
+			vec := make([]float64, len(axes))
+			for i, axis := range axes {
+				if strings.Contains(input, axis) {
+					vec[i] = 1
+				}
+			}
+			data = append(data, map[string]interface{}{"index": index, "embedding": vec})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(server.Close)
+	return newOpenAIEmbedder(ai_config.EmbeddingProviderConfig{BaseURL: server.URL, APIKey: "test", Model: "test", Dimension: len(axes)})
+}
+
+// newTestDB 创建 sqlite 内存数据库并建好记忆相关的表，models 为测试额外需要的表
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite failed: %v", err)
+	}
+	// 内存数据库每个连接相互独立，所有查询共用一个连接才能看到同一份数据
+	sqlDB, _ := gdb.DB()
+	sqlDB.SetMaxOpenConns(1)
+	models = append([]interface{}{&model.Memory{}, &model.Message{}, &model.MemorySourceMessage{}, &model.MemoryEvidence{}}, models...)
+	if err := gdb.AutoMigrate(models...); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	return gdb
+}
+
+func newTestMemoryRepository(t *testing.T) *repository.MemoryRepository {
+	t.Helper()
+	return repository.NewMemoryRepository(newTestDB(t))
+}
+
+func TestRetrieveMemoriesWithLocalVectorStore(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   newTestEmbeddingServer(t),
+		vectorStore: store,
+	}
+
+	contents := []string{"用户养了一只猫", "用户每天早上喝咖啡", "用户周末去跑步"}
+	for _, content := range contents {
+		mem := &model.Memory{
+			PersonaID: "per:a",
+			UserID:    1,
+			Type:      model.MemoryTypeFact,
+			Content:   content,
+			Source:    model.MemorySourceManual,
+			Status:    model.MemoryStatusActive,
+		}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		s.UpsertMemoryVector(ctx, mem)
+	}
+	// 其他人格的记忆不应被检索到
+	other := &model.Memory{PersonaID: "per:b", UserID: 1, Type: model.MemoryTypeFact, Content: "另一只猫", Status: model.MemoryStatusActive}
+	s.PrepareMemoryEmbedding(ctx, other)
+	if err := s.memoryRepo.CreateMemory(other); err != nil {
+		t.Fatalf("create memory failed: %v", err)
+	}
+	s.UpsertMemoryVector(ctx, other)
+
+	memories, err := s.RetrieveMemories(ctx, "per:a", 1, "我家的猫今天生病了", 1)
+	if err != nil {
+		t.Fatalf("retrieve failed: %v", err)
+	}
+	if len(memories) != 1 || memories[0].Content != "用户养了一只猫" {
+		t.Fatalf("unexpected memories: %+v", memories)
+	}
+
+	// 删除向量后不再由向量召回，只能通过关键词命中
+	s.DeleteMemoryVector(ctx, memories[0].ID)
+	results, err := s.SearchMemories(ctx, "per:a", 1, "我家的猫今天生病了", RetrievalOptions{TopK: 3})
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	for _, result := range results {
+		if result.ID == memories[0].ID && (result.VectorRank != 0 || result.KeywordRank != 1) {
+			t.Fatalf("deleted vector still recalled: %+v", result)
+		}
+	}
+}
+
+func TestReindexReconcilesVectorStore(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLocalVectorStore("", 0)
+	s := &MemoryService{
+		memoryRepo:  newTestMemoryRepository(t),
+		embedding:   NewHashEmbedder(64),
+		vectorStore: store,
+	}
+	create := func(content, status string) *model.Memory {
+		mem := &model.Memory{PersonaID: "per:a", UserID: 1, Type: model.MemoryTypeFact, Content: content, Status: status}
+		s.PrepareMemoryEmbedding(ctx, mem)
+		if err := s.memoryRepo.CreateMemory(mem); err != nil {
+			t.Fatalf("create memory failed: %v", err)
+		}
+		return mem
+	}
+	synced := create("用户养了一只猫", model.MemoryStatusActive)
+	s.UpsertMemoryVector(ctx, synced)
+	missing := create("用户每天早上喝咖啡", model.MemoryStatusActive)
+	stale := create("用户周末去跑步", model.MemoryStatusActive)
+	s.UpsertMemoryVector(ctx, stale)
+	stale.Content = "用户周末去游泳"
+	if err := s.memoryRepo.UpdateMemory(stale); err != nil {
+		t.Fatalf("update memory failed: %v", err)
+	}
+	superseded := create("用户喜欢喝茶", model.MemoryStatusSuperseded)
+	_ = store.Upsert(ctx,
+		VectorRecord{ID: superseded.ID, PersonaID: "per:a", UserID: 1, Embedding: make([]float32, 64)},
+		VectorRecord{ID: "mem:orphan", PersonaID: "per:a", UserID: 1, Embedding: make([]float32, 64)},
+	)
+
+	report, err := s.Reindex(ctx, ReindexOptions{PersonaID: "per:a", UserID: 1, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if report.Missing.Count != 1 || report.Missing.IDs[0] != missing.ID || report.Stale.Count != 1 ||
+		report.Orphaned.Count != 1 || report.Superseded.Count != 1 {
+		t.Fatalf("unexpected dry run report: %+v", report)
+	}
+	if count, _ := store.Count(ctx, VectorFilter{}); count != 4 {
+		t.Fatalf("dry run changed store: count = %d", count)
+	}
+
+	report, err = s.Reindex(ctx, ReindexOptions{PersonaID: "per:a", UserID: 1})
+	if err != nil || len(report.Errors) > 0 {
+		t.Fatalf("reindex failed: %v, %+v", err, report.Errors)
+	}
+	if report.Reembedded != 1 || report.Upserted != 2 || report.Removed != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	ids, _ := store.ListIDs(ctx, VectorFilter{})
+	if len(ids) != 3 {
+		t.Fatalf("unexpected ids after reindex: %v", ids)
+	}
+
+	report, _ = s.Reindex(ctx, ReindexOptions{DryRun: true})
+	if report.Missing.Count+report.Stale.Count+report.Orphaned.Count+report.Superseded.Count != 0 {
+		t.Fatalf("drift remains after reindex: %+v", report)
+	}
+}
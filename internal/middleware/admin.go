@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"AI_Chat/internal/common"
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth 校验请求头 X-Admin-Token 与配置的管理令牌是否一致
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := c.GetHeader("X-Admin-Token")
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			common.Fail(c, common.AdminDeniedCode)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -26,14 +26,17 @@ func (c *Conversation) BeforeCreate(tx *gorm.DB) (err error) {
 }
 
 type Message struct {
-	OrderID        int64     `gorm:"autoIncrement;not null;index" json:"orderId"`
-	ID             string    `gorm:"primaryKey" json:"id"`
-	ConversationID string    `gorm:"not null;index" json:"conversationId"`
-	Role           string    `gorm:"type:varchar(20);not null" json:"role"` // user, assistant, system
-	Content        string    `gorm:"type:text;not null" json:"content"`
-	Model          string    `gorm:"size:50" json:"model"`
-	TokenCount     int       `json:"tokenCount"`
-	CreatedAt      time.Time `json:"createdAt"`
+	OrderID          int64     `gorm:"autoIncrement;not null;index" json:"orderId"`
+	ID               string    `gorm:"primaryKey" json:"id"`
+	ConversationID   string    `gorm:"not null;index" json:"conversationId"`
+	Role             string    `gorm:"type:varchar(20);not null" json:"role"` // user, assistant, system
+	Content          string    `gorm:"type:text;not null" json:"content"`
+	Model            string    `gorm:"size:50" json:"model"`
+	TokenCount       int       `json:"tokenCount"`       // 消息内容本身的 token 数，用于裁剪上下文
+	PromptTokens     int       `json:"promptTokens"`     // 生成该回复时的输入 token（仅 assistant）
+	CompletionTokens int       `json:"completionTokens"` // 生成该回复时的输出 token（仅 assistant）
+	CreatedAt        time.Time `json:"createdAt"`
+	PersonaVersionID string    `gorm:"type:varchar(64);index" json:"personaVersionId,omitempty"` // 生成该回复时的人格提示词版本（仅 assistant）
 }
 
 func (m *Message) TableName() string {
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// ConversationSummary 会话的滚动摘要，覆盖 order_id <= CoveredOrderID 的历史消息
+type ConversationSummary struct {
+	ConversationID string    `gorm:"primaryKey;type:varchar(64)" json:"conversationId"`
+	Summary        string    `gorm:"type:text" json:"summary"`
+	CoveredOrderID int64     `gorm:"default:0" json:"coveredOrderId"`
+	TokenCount     int       `json:"tokenCount"`
+	EditedByUser   bool      `gorm:"default:false" json:"editedByUser"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+func (s *ConversationSummary) TableName() string {
+	return "conversation_summaries"
+}
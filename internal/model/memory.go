@@ -14,20 +14,27 @@ type Memory struct {
 	UserID    int64  `gorm:"index;not null" json:"user_id"`
 
 	// 记忆内容
-	Type     string `gorm:"type:varchar(20);not null" json:"type"` // fact/preference/event/emotion/relationship
+	Type     string `gorm:"type:varchar(20);not null" json:"type"` // fact/preference/event/emotion/relationship/reflection
 	Content  string `gorm:"type:text;not null" json:"content"`
 	Keywords string `gorm:"type:varchar(500)" json:"keywords"`
+	// 重要度 1~10，提取时由模型评估，手动创建默认 5；检索排序时参与加权
+	Importance int `gorm:"default:5" json:"importance"`
 
 	// 向量嵌入
 	Embedding          string     `gorm:"type:longtext" json:"-"`
+	EmbeddingChecksum  string     `gorm:"type:varchar(64)" json:"-"` // 生成向量时内容的 sha256，与当前内容不一致说明向量已过期
 	EmbeddingUpdatedAt *time.Time `json:"embedding_updated_at,omitempty"`
 
 	// 来源
-	Source string `gorm:"type:varchar(20);default:'manual'" json:"source"` // manual/auto
+	Source string `gorm:"type:varchar(20);default:'manual'" json:"source"` // manual/auto/rollback/consolidated/reflection/imported
 
 	// 冲突处理
-	Status       string `gorm:"type:varchar(20);default:'active'" json:"status"` // active/superseded
-	SupersededBy string `gorm:"type:varchar(64)" json:"superseded_by"`
+	Status       string   `gorm:"type:varchar(20);default:'active'" json:"status"` // active/superseded/pending_review/rejected/invalidated
+	SupersededBy string   `gorm:"type:varchar(64);index" json:"superseded_by"`
+	RestoredFrom string   `gorm:"type:varchar(64)" json:"restored_from,omitempty"`       // 回滚生成的版本记录恢复自哪个旧版本
+	ReplacesID   string   `gorm:"type:varchar(64)" json:"replaces_id,omitempty"`         // 待审核的更新候选，批准后取代该旧记忆
+	ReviewAction string   `gorm:"type:varchar(20)" json:"review_action,omitempty"`       // 待审核的失效/删除请求：invalidate/delete，批准后对 ReplacesID 执行；新增和更新候选为空
+	MergedIDs    []string `gorm:"type:text;serializer:json" json:"merged_ids,omitempty"` // 待审核的合并候选，批准后取代其中所有记忆；ReplacesID 为其中第一条
 
 	// 统计
 	HitCount  int        `gorm:"default:0" json:"hit_count"`
@@ -47,6 +54,17 @@ func (m *Memory) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
+// ReplacedIDs 待审核候选批准后要取代或处理的记忆：合并候选为组内所有记忆，其他候选为 ReplacesID
+func (m *Memory) ReplacedIDs() []string {
+	if len(m.MergedIDs) > 0 {
+		return m.MergedIDs
+	}
+	if m.ReplacesID != "" {
+		return []string{m.ReplacesID}
+	}
+	return nil
+}
+
 // MemoryType 常量
 const (
 	MemoryTypeFact         = "fact"
@@ -54,16 +72,59 @@ const (
 	MemoryTypeEvent        = "event"
 	MemoryTypeEmotion      = "emotion"
 	MemoryTypeRelationship = "relationship"
+	// MemoryTypeReflection 由反思从多条记忆中归纳出的深层认识，只由反思任务生成，不在 MemoryTypes 中
+	MemoryTypeReflection = "reflection"
 )
 
+// MemoryTypes 提取和手动创建可用的记忆类型
+var MemoryTypes = []string{MemoryTypeFact, MemoryTypePreference, MemoryTypeEvent, MemoryTypeEmotion, MemoryTypeRelationship}
+
+// IsValidMemoryType 是否为合法的记忆类型
+func IsValidMemoryType(memoryType string) bool {
+	for _, t := range MemoryTypes {
+		if t == memoryType {
+			return true
+		}
+	}
+	return false
+}
+
+// Importance 取值范围
+const (
+	MemoryImportanceMin     = 1
+	MemoryImportanceMax     = 10
+	MemoryImportanceDefault = 5
+)
+
+// ClampImportance 把重要度限制在 1~10，未设置（0）时取默认值
+func ClampImportance(importance int) int {
+	if importance == 0 {
+		return MemoryImportanceDefault
+	}
+	return max(MemoryImportanceMin, min(importance, MemoryImportanceMax))
+}
+
 // MemorySource 常量
 const (
-	MemorySourceManual = "manual"
-	MemorySourceAuto   = "auto"
+	MemorySourceManual       = "manual"
+	MemorySourceAuto         = "auto"
+	MemorySourceRollback     = "rollback"     // 回滚到旧版本时生成
+	MemorySourceConsolidated = "consolidated" // 整理时由多条相近记忆合并生成
+	MemorySourceReflection   = "reflection"   // 反思时由多条记忆归纳生成
+	MemorySourceImported     = "imported"     // 由导入的聊天记录生成模拟人格时写入
 )
 
 // MemoryStatus 常量
 const (
-	MemoryStatusActive     = "active"
-	MemoryStatusSuperseded = "superseded"
+	MemoryStatusActive        = "active"
+	MemoryStatusSuperseded    = "superseded"
+	MemoryStatusPendingReview = "pending_review" // 审核模式下自动提取的候选，批准前不参与检索
+	MemoryStatusRejected      = "rejected"       // 审核时被拒绝的候选
+	MemoryStatusInvalidated   = "invalidated"    // 对话表明已不再成立（如用户已辞职），保留在版本历史中但不再参与检索
+)
+
+// MemoryReviewAction 常量：审核模式下由提取生成的失效/删除请求
+const (
+	MemoryReviewInvalidate = "invalidate"
+	MemoryReviewDelete     = "delete"
 )
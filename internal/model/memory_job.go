@@ -0,0 +1,58 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MemoryJob 持久化的记忆任务（存储在 MySQL），由后台 worker 领取执行
+type MemoryJob struct {
+	ID             string `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	Type           string `gorm:"type:varchar(20);not null" json:"type"` // extract/reflect/consolidate/delete_persona
+	ConversationID string `gorm:"type:varchar(64);index" json:"conversation_id"`
+	PersonaID      string `gorm:"type:varchar(64);index:idx_job_persona_user" json:"persona_id"`
+	UserID         int64  `gorm:"index:idx_job_persona_user" json:"user_id"`
+	Payload        string `gorm:"type:longtext" json:"-"`
+
+	// 执行状态
+	Status      string          `gorm:"type:varchar(20);not null;index:idx_job_status_next" json:"status"` // pending/running/succeeded/dead
+	Attempts    int             `gorm:"default:0" json:"attempts"`
+	MaxAttempts int             `gorm:"default:5" json:"max_attempts"`
+	LastError   string          `gorm:"type:text" json:"last_error"`
+	NextRunAt   time.Time       `gorm:"index:idx_job_status_next" json:"next_run_at"`
+	LockedBy    string          `gorm:"type:varchar(100)" json:"locked_by"`
+	LockedAt    *time.Time      `json:"locked_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+	Result      json.RawMessage `gorm:"type:longtext" json:"result,omitempty"` // 成功后的执行结果，目前只有整理任务写入整理报告
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (j *MemoryJob) TableName() string {
+	return "memory_jobs"
+}
+
+func (j *MemoryJob) BeforeCreate(tx *gorm.DB) (err error) {
+	j.ID = "job:" + uuid.New().String()
+	return
+}
+
+// MemoryJobType 常量
+const (
+	MemoryJobTypeExtract       = "extract"
+	MemoryJobTypeReflect       = "reflect"        // 从近期记忆中归纳反思记忆
+	MemoryJobTypeConsolidate   = "consolidate"    // 合并相近的重复记忆
+	MemoryJobTypeDeletePersona = "delete_persona" // 删除人格及其会话、消息、记忆和向量
+)
+
+// MemoryJobStatus 常量
+const (
+	MemoryJobStatusPending   = "pending"
+	MemoryJobStatusRunning   = "running"
+	MemoryJobStatusSucceeded = "succeeded"
+	MemoryJobStatusDead      = "dead" // 超过最大重试次数，进入死信
+)
@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// MemorySourceMessage 记忆与支撑它的对话消息的关联
+type MemorySourceMessage struct {
+	ID             uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	MemoryID       string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_memory_message" json:"memory_id"`
+	MessageID      string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_memory_message;index" json:"message_id"`
+	ConversationID string    `gorm:"type:varchar(64);index" json:"conversation_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (m *MemorySourceMessage) TableName() string {
+	return "memory_sources"
+}
+
+// MemoryEvidence 反思记忆与支撑它的原始记忆的关联
+type MemoryEvidence struct {
+	ID           uint64    `gorm:"primaryKey;autoIncrement" json:"-"`
+	ReflectionID string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_reflection_evidence" json:"reflection_id"`
+	MemoryID     string    `gorm:"type:varchar(64);not null;uniqueIndex:idx_reflection_evidence;index" json:"memory_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (m *MemoryEvidence) TableName() string {
+	return "memory_evidence"
+}
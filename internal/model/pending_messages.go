@@ -16,4 +16,18 @@ type MessagePair struct {
 	UserMsg      string    `json:"user_msg"`
 	AssistantMsg string    `json:"assistant_msg"`
 	Timestamp    time.Time `json:"timestamp"`
+	// 对应 messages 表中的消息 ID，用于记录记忆来源；旧版本缓存的轮次没有
+	UserMsgID      string `json:"user_msg_id,omitempty"`
+	AssistantMsgID string `json:"assistant_msg_id,omitempty"`
+}
+
+// MessageIDs 返回这一轮中已保存的消息 ID
+func (p MessagePair) MessageIDs() []string {
+	ids := make([]string, 0, 2)
+	for _, id := range []string{p.UserMsgID, p.AssistantMsgID} {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
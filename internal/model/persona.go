@@ -9,15 +9,32 @@ import (
 
 // Persona AI角色人格模型
 type Persona struct {
-	ID           string    `gorm:"primaryKey;column:id;type:varchar(64)" json:"id"`
-	UserID       int64     `gorm:"column:user_id;index:idx_user_id" json:"user_id"`
-	Name         string    `gorm:"column:name;type:varchar(100);not null" json:"name"`
-	Description  string    `gorm:"column:description;type:text" json:"description"`
-	SystemPrompt string    `gorm:"column:system_prompt;type:text" json:"system_prompt"`
-	Mode         int       `gorm:"column:mode;type:tinyint;default:1" json:"mode"` // 1:自定义, 2:模拟
-	Avatar       string    `gorm:"column:avatar;type:varchar(255)" json:"avatar"`
-	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
-	UpdatedAt    time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+	ID           string `gorm:"primaryKey;column:id;type:varchar(64)" json:"id"`
+	UserID       int64  `gorm:"column:user_id;index:idx_user_id" json:"user_id"`
+	Name         string `gorm:"column:name;type:varchar(100);not null" json:"name"`
+	Description  string `gorm:"column:description;type:text" json:"description"`
+	SystemPrompt string `gorm:"column:system_prompt;type:text" json:"system_prompt"`
+	Mode         int    `gorm:"column:mode;type:tinyint;default:1" json:"mode"` // 1:自定义, 2:模拟
+	Avatar       string `gorm:"column:avatar;type:varchar(255)" json:"avatar"`
+
+	// 角色卡（Character Card V2）中的角色设定，聊天时拼入系统提示词
+	Personality     string `gorm:"column:personality;type:text" json:"personality"`
+	Scenario        string `gorm:"column:scenario;type:text" json:"scenario"`
+	MessageExamples string `gorm:"column:message_examples;type:text" json:"message_examples"` // 角色卡的 mes_example，<START> 分隔多段示例对话，聊天时作为示例轮次注入
+	CardExtensions  string `gorm:"column:card_extensions;type:longtext" json:"-"`             // 导入角色卡时人格没有对应字段的内容，导出时原样写回
+
+	// 开场白，创建会话时选一条作为第一条 assistant 消息；第一条对应角色卡的 first_mes，其余对应 alternate_greetings
+	Greetings []string `gorm:"column:greetings;type:text;serializer:json" json:"greetings"`
+
+	// 当前生效的提示词版本，修改影响提示词的字段（见 PersonaVersion）时生成新版本
+	CurrentVersionID string `gorm:"column:current_version_id;type:varchar(64)" json:"current_version_id"`
+
+	// 生成参数
+	PersonaModelSettings `gorm:"embedded"`
+
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+	IsDeleted bool      `gorm:"column:is_deleted;default:false" json:"-"` // 删除后立即隐藏，关联数据由后台任务清理
 }
 
 // TableName 指定表名
@@ -29,3 +46,93 @@ func (p *Persona) BeforeCreate(tx *gorm.DB) (err error) {
 	p.ID = "per:" + uuid.New().String()
 	return
 }
+
+// PersonaAvatar 导入 PNG 角色卡时保存的头像图片（已去掉其中的角色卡数据），导出 PNG 时重新写入角色卡
+type PersonaAvatar struct {
+	PersonaID string    `gorm:"primaryKey;column:persona_id;type:varchar(64)"`
+	Data      []byte    `gorm:"column:data;type:mediumblob"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (PersonaAvatar) TableName() string {
+	return "persona_avatars"
+}
+
+// PersonaVersion 人格提示词的不可变版本，创建后不再修改；回滚时复制旧版本生成新版本。
+// 保存人格中所有影响发给模型内容的字段：名称（替换 {{char}}）、系统提示词、角色描述、性格、场景和示例对话
+type PersonaVersion struct {
+	ID              string    `gorm:"primaryKey;column:id;type:varchar(64)" json:"id"`
+	PersonaID       string    `gorm:"column:persona_id;type:varchar(64);not null;uniqueIndex:idx_persona_version" json:"persona_id"`
+	Version         int       `gorm:"column:version;not null;uniqueIndex:idx_persona_version" json:"version"` // 同一人格内从 1 递增
+	Name            string    `gorm:"column:name;type:varchar(100)" json:"name"`
+	SystemPrompt    string    `gorm:"column:system_prompt;type:text" json:"system_prompt"`
+	Description     string    `gorm:"column:description;type:text" json:"description"`
+	Personality     string    `gorm:"column:personality;type:text" json:"personality"`
+	Scenario        string    `gorm:"column:scenario;type:text" json:"scenario"`
+	MessageExamples string    `gorm:"column:message_examples;type:text" json:"message_examples"`
+	RestoredFrom    string    `gorm:"column:restored_from;type:varchar(64)" json:"restored_from,omitempty"` // 回滚生成的版本记录恢复自哪个旧版本
+	CreatedAt       time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+}
+
+func (PersonaVersion) TableName() string {
+	return "persona_versions"
+}
+
+func (v *PersonaVersion) BeforeCreate(tx *gorm.DB) (err error) {
+	v.ID = "pv:" + uuid.New().String()
+	return
+}
+
+// NewPersonaVersion 以人格当前的提示词字段生成版本（未保存）
+func NewPersonaVersion(p *Persona) *PersonaVersion {
+	return &PersonaVersion{
+		PersonaID:       p.ID,
+		Name:            p.Name,
+		SystemPrompt:    p.SystemPrompt,
+		Description:     p.Description,
+		Personality:     p.Personality,
+		Scenario:        p.Scenario,
+		MessageExamples: p.MessageExamples,
+	}
+}
+
+// SamePrompt 人格当前的提示词字段是否与该版本一致
+func (v *PersonaVersion) SamePrompt(p *Persona) bool {
+	return v.Name == p.Name && v.SystemPrompt == p.SystemPrompt && v.Description == p.Description &&
+		v.Personality == p.Personality && v.Scenario == p.Scenario && v.MessageExamples == p.MessageExamples
+}
+
+// ApplyTo 把该版本的提示词字段写回人格，用于回滚
+func (v *PersonaVersion) ApplyTo(p *Persona) {
+	p.Name = v.Name
+	p.SystemPrompt = v.SystemPrompt
+	p.Description = v.Description
+	p.Personality = v.Personality
+	p.Scenario = v.Scenario
+	p.MessageExamples = v.MessageExamples
+}
+
+// DefaultMaxSteps ReAct 默认最大步数
+const DefaultMaxSteps = 5
+
+// PersonaModelSettings 人格的生成参数，零值表示使用全局默认
+type PersonaModelSettings struct {
+	ModelProvider string   `gorm:"column:model_provider;type:varchar(50)" json:"model_provider"` // chat.providers 中的实例名
+	ModelName     string   `gorm:"column:model_name;type:varchar(100)" json:"model_name"`        // 覆盖实例默认的模型名
+	Temperature   *float32 `gorm:"column:temperature" json:"temperature"`
+	TopP          *float32 `gorm:"column:top_p" json:"top_p"`
+	MaxTokens     int      `gorm:"column:max_tokens;default:0" json:"max_tokens"`
+	MaxSteps      int      `gorm:"column:max_steps;default:0" json:"max_steps"`
+	HistoryRounds int      `gorm:"column:history_rounds;default:0" json:"history_rounds"` // 历史轮数上限，0 表示只受 token 预算约束
+
+	MemoryExtractThreshold int  `gorm:"column:memory_extract_threshold;default:0" json:"memory_extract_threshold"` // 累积多少轮触发记忆提取，0 表示使用默认值
+	MemoryReviewMode       bool `gorm:"column:memory_review_mode;default:false" json:"memory_review_mode"`         // 自动提取的记忆需用户审核后才生效
+}
+
+// GetMaxSteps 获取 ReAct 最大步数，未设置时使用默认值
+func (s PersonaModelSettings) GetMaxSteps() int {
+	if s.MaxSteps <= 0 {
+		return DefaultMaxSteps
+	}
+	return s.MaxSteps
+}
@@ -4,6 +4,7 @@ import (
 	"AI_Chat/internal/model"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ConversationRepository struct {
@@ -16,9 +17,23 @@ func NewConversationRepository(db *gorm.DB) *ConversationRepository {
 func (r *ConversationRepository) CreateConversation(conversation *model.Conversation) error {
 	return r.db.Create(conversation).Error
 }
+
+// CreateConversationWithGreeting 在同一事务中创建会话和作为第一条消息的开场白，greeting 为 nil 时只创建会话
+func (r *ConversationRepository) CreateConversationWithGreeting(conversation *model.Conversation, greeting *model.Message) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(conversation).Error; err != nil {
+			return err
+		}
+		if greeting == nil {
+			return nil
+		}
+		greeting.ConversationID = conversation.ID
+		return tx.Create(greeting).Error
+	})
+}
 func (r *ConversationRepository) GetConversationById(id string) (*model.Conversation, error) {
 	var conversation model.Conversation
-	if err := r.db.Where("id = ?", id).First(&conversation).Error; err != nil {
+	if err := r.db.Where("id = ? AND is_deleted = false", id).First(&conversation).Error; err != nil {
 		return nil, err
 	}
 	return &conversation, nil
@@ -26,14 +41,14 @@ func (r *ConversationRepository) GetConversationById(id string) (*model.Conversa
 
 func (r *ConversationRepository) GetConversationByPersonaAndUser(personaId string, userId int64) (*model.Conversation, error) {
 	var conversation model.Conversation
-	if err := r.db.Where("persona_id = ? AND user_id = ?", personaId, userId).First(&conversation).Error; err != nil {
+	if err := r.db.Where("persona_id = ? AND user_id = ? AND is_deleted = false", personaId, userId).First(&conversation).Error; err != nil {
 		return nil, err
 	}
 	return &conversation, nil
 }
 func (r *ConversationRepository) GetConversationsByUserId(userId int64) ([]model.Conversation, error) {
 	var conversations []model.Conversation
-	if err := r.db.Where("user_id = ?", userId).Order("created_at DESC").Find(&conversations).Error; err != nil {
+	if err := r.db.Where("user_id = ? AND is_deleted = false", userId).Order("created_at DESC").Find(&conversations).Error; err != nil {
 		return nil, err
 	}
 	return conversations, nil
@@ -53,3 +68,40 @@ func (r *ConversationRepository) AddMessageToConversation(message *model.Message
 func (r *ConversationRepository) UpdateConversation(conversation *model.Conversation) error {
 	return r.db.Save(conversation).Error
 }
+
+// GetSummaryByConversationId 获取会话摘要
+func (r *ConversationRepository) GetSummaryByConversationId(conversationId string) (*model.ConversationSummary, error) {
+	var summary model.ConversationSummary
+	if err := r.db.Where("conversation_id = ?", conversationId).First(&summary).Error; err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// SaveUserSummary 保存用户修改的摘要内容：不存在时创建，存在时只更新内容和 token 数，不影响已覆盖的消息范围
+func (r *ConversationRepository) SaveUserSummary(summary *model.ConversationSummary) error {
+	summary.EditedByUser = true
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"summary", "token_count", "edited_by_user", "updated_at"}),
+	}).Create(summary).Error
+}
+
+// ReplaceSummaryIfUnchanged 用 summary 覆盖会话摘要，前提是摘要仍与读取时的 previous 一致（previous 为 nil 表示读取时还没有摘要）；
+// 期间被修改过（如用户编辑了摘要）时不写入，返回 false
+func (r *ConversationRepository) ReplaceSummaryIfUnchanged(summary, previous *model.ConversationSummary) (bool, error) {
+	if previous == nil {
+		result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(summary)
+		return result.RowsAffected > 0, result.Error
+	}
+	result := r.db.Model(&model.ConversationSummary{}).
+		Where("conversation_id = ? AND covered_order_id = ? AND edited_by_user = ? AND summary = ?",
+			previous.ConversationID, previous.CoveredOrderID, previous.EditedByUser, previous.Summary).
+		Updates(map[string]interface{}{
+			"summary":          summary.Summary,
+			"token_count":      summary.TokenCount,
+			"covered_order_id": summary.CoveredOrderID,
+			"edited_by_user":   summary.EditedByUser,
+		})
+	return result.RowsAffected > 0, result.Error
+}
@@ -2,11 +2,21 @@ package repository
 
 import (
 	"AI_Chat/internal/model"
+	"errors"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+var (
+	// ErrMemoryNotActive 要取代的记忆已被取代或删除
+	ErrMemoryNotActive = errors.New("memory is not active")
+	// ErrMemoryNotPending 记忆不是待审核状态
+	ErrMemoryNotPending = errors.New("memory is not pending review")
+	// ErrLineageChanged 版本链的最新版本已被其他操作取代
+	ErrLineageChanged = errors.New("memory lineage has changed")
+)
+
 type MemoryRepository struct {
 	db *gorm.DB
 }
@@ -39,6 +49,42 @@ func (r *MemoryRepository) GetActiveMemoriesByPersonaAndUser(personaId string, u
 	return memories, err
 }
 
+// GetMemoriesByStatus 获取某人格某用户指定状态的记忆，按创建时间从新到旧
+func (r *MemoryRepository) GetMemoriesByStatus(personaId string, userId int64, status string) ([]model.Memory, error) {
+	var memories []model.Memory
+	err := r.db.Where("persona_id = ? AND user_id = ? AND status = ? AND is_deleted = false",
+		personaId, userId, status).
+		Order("created_at DESC").
+		Find(&memories).Error
+	return memories, err
+}
+
+// GetActiveMemoryOwners 获取有活跃记忆的人格和用户组合（只填充 PersonaID、UserID）；personaId 为空或 userId 为 0 时不过滤该字段
+func (r *MemoryRepository) GetActiveMemoryOwners(personaId string, userId int64) ([]model.Memory, error) {
+	var owners []model.Memory
+	query := r.db.Model(&model.Memory{}).
+		Distinct("persona_id", "user_id").
+		Where("status = ? AND is_deleted = false", model.MemoryStatusActive)
+	if personaId != "" {
+		query = query.Where("persona_id = ?", personaId)
+	}
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	err := query.Order("persona_id, user_id").Find(&owners).Error
+	return owners, err
+}
+
+// CountActiveMemoriesSince 统计 since 之后创建的活跃记忆数量，不包括 excludeType 类型
+func (r *MemoryRepository) CountActiveMemoriesSince(personaId string, userId int64, since time.Time, excludeType string) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Memory{}).
+		Where("persona_id = ? AND user_id = ? AND status = ? AND is_deleted = false AND created_at > ? AND type <> ?",
+			personaId, userId, model.MemoryStatusActive, since, excludeType).
+		Count(&count).Error
+	return count, err
+}
+
 // GetMemoriesByPersonaAndUser 获取某人格某用户的所有记忆（包括已被取代的）
 func (r *MemoryRepository) GetMemoriesByPersonaAndUser(personaId string, userId int64) ([]model.Memory, error) {
 	var memories []model.Memory
@@ -61,31 +107,346 @@ func (r *MemoryRepository) GetMemoriesByIDs(personaId string, userId int64, ids
 	return memories, err
 }
 
-// UpdateMemory 更新记忆
-func (r *MemoryRepository) UpdateMemory(memory *model.Memory) error {
-	return r.db.Save(memory).Error
+// GetMemoriesSupersededBy 获取被指定记忆取代的旧版本
+func (r *MemoryRepository) GetMemoriesSupersededBy(personaId string, userId int64, ids []string) ([]model.Memory, error) {
+	if len(ids) == 0 {
+		return []model.Memory{}, nil
+	}
+	var memories []model.Memory
+	err := r.db.Where("persona_id = ? AND user_id = ? AND superseded_by IN ? AND is_deleted = false",
+		personaId, userId, ids).
+		Order("created_at ASC").
+		Find(&memories).Error
+	return memories, err
 }
 
-// UpdateMemoryStatus 更新记忆状态
-func (r *MemoryRepository) UpdateMemoryStatus(id string, status string, supersededBy string) error {
-	return r.db.Model(&model.Memory{}).
-		Where("id = ?", id).
+// CreateMemoryWithSources 在同一事务中创建记忆及其来源消息关联
+func (r *MemoryRepository) CreateMemoryWithSources(memory *model.Memory, sources []model.MemorySourceMessage) error {
+	return r.CreateMemoryVersion(memory, "", sources)
+}
+
+// CreateMemoryVersion 在同一事务中创建新版本及其来源消息关联，并把 supersedeId 对应的活跃记忆标记为被其取代；
+// supersedeId 为空时只创建。旧记忆已不是活跃状态时返回 ErrMemoryNotActive
+func (r *MemoryRepository) CreateMemoryVersion(memory *model.Memory, supersedeId string, sources []model.MemorySourceMessage) error {
+	var supersedeIds []string
+	if supersedeId != "" {
+		supersedeIds = []string{supersedeId}
+	}
+	return r.CreateMergedMemory(memory, supersedeIds, sources)
+}
+
+// CreateMemoryAfter 在同一事务中创建新版本及其来源消息关联，并把已失效的最新版本 head 的 superseded_by 指向新版本，
+// 使新版本接在版本链末尾；head 的状态保持不变。head 已被其他版本接上（superseded_by 已变化）或重新生效时返回 ErrLineageChanged
+func (r *MemoryRepository) CreateMemoryAfter(memory *model.Memory, head *model.Memory, sources []model.MemorySourceMessage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(memory).Error; err != nil {
+			return err
+		}
+		if err := createMemorySources(tx, memory.ID, sources); err != nil {
+			return err
+		}
+		result := tx.Model(&model.Memory{}).
+			Where("id = ? AND status <> ? AND superseded_by = ? AND is_deleted = false",
+				head.ID, model.MemoryStatusActive, head.SupersededBy).
+			Update("superseded_by", memory.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrLineageChanged
+		}
+		return nil
+	})
+}
+
+// CreateMergedMemory 在同一事务中创建合并后的记忆及其来源消息关联，并把 supersedeIds 对应的活跃记忆都标记为被其取代；
+// 任何一条旧记忆已不是活跃状态时整体回滚并返回 ErrMemoryNotActive
+func (r *MemoryRepository) CreateMergedMemory(memory *model.Memory, supersedeIds []string, sources []model.MemorySourceMessage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(memory).Error; err != nil {
+			return err
+		}
+		if err := createMemorySources(tx, memory.ID, sources); err != nil {
+			return err
+		}
+		return supersedeMemories(tx, supersedeIds, memory.ID)
+	})
+}
+
+// CreateReflection 在同一事务中创建反思记忆及其与原始记忆的关联，supersedeId 不为空时取代该活跃的旧反思；
+// 旧反思已不是活跃状态时返回 ErrMemoryNotActive
+func (r *MemoryRepository) CreateReflection(memory *model.Memory, supersedeId string, evidenceIds []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(memory).Error; err != nil {
+			return err
+		}
+		seen := make(map[string]bool, len(evidenceIds))
+		links := make([]model.MemoryEvidence, 0, len(evidenceIds))
+		for _, id := range evidenceIds {
+			if id == "" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			links = append(links, model.MemoryEvidence{ReflectionID: memory.ID, MemoryID: id})
+		}
+		if len(links) > 0 {
+			if err := tx.Create(&links).Error; err != nil {
+				return err
+			}
+		}
+		if supersedeId == "" {
+			return nil
+		}
+		return supersedeMemories(tx, []string{supersedeId}, memory.ID)
+	})
+}
+
+// supersedeMemories 把 ids 对应的活跃记忆标记为被 supersededBy 取代，任何一条已不是活跃状态时返回 ErrMemoryNotActive
+func supersedeMemories(tx *gorm.DB, ids []string, supersededBy string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := tx.Model(&model.Memory{}).
+		Where("id IN ? AND status = ? AND is_deleted = false", ids, model.MemoryStatusActive).
 		Updates(map[string]interface{}{
-			"status":        status,
+			"status":        model.MemoryStatusSuperseded,
 			"superseded_by": supersededBy,
-		}).Error
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected != int64(len(ids)) {
+		return ErrMemoryNotActive
+	}
+	return nil
+}
+
+// GetReflectionEvidence 获取反思记忆与原始记忆的关联
+func (r *MemoryRepository) GetReflectionEvidence(reflectionIds []string) ([]model.MemoryEvidence, error) {
+	if len(reflectionIds) == 0 {
+		return []model.MemoryEvidence{}, nil
+	}
+	var evidence []model.MemoryEvidence
+	err := r.db.Where("reflection_id IN ?", reflectionIds).Order("id ASC").Find(&evidence).Error
+	return evidence, err
+}
+
+// GetEvidenceMemories 获取支撑该反思的原始记忆（包括之后被取代或失效的），已删除的不返回
+func (r *MemoryRepository) GetEvidenceMemories(reflectionId string) ([]model.Memory, error) {
+	var memories []model.Memory
+	err := r.db.Model(&model.Memory{}).
+		Joins("JOIN memory_evidence ON memory_evidence.memory_id = memories.id").
+		Where("memory_evidence.reflection_id = ? AND memories.is_deleted = false", reflectionId).
+		Order("memories.created_at ASC").
+		Find(&memories).Error
+	return memories, err
+}
+
+// createMemorySources 写入记忆的来源消息关联，按消息去重
+func createMemorySources(tx *gorm.DB, memoryId string, sources []model.MemorySourceMessage) error {
+	if len(sources) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(sources))
+	links := make([]model.MemorySourceMessage, 0, len(sources))
+	for _, source := range sources {
+		if source.MessageID == "" || seen[source.MessageID] {
+			continue
+		}
+		seen[source.MessageID] = true
+		links = append(links, model.MemorySourceMessage{
+			MemoryID:       memoryId,
+			MessageID:      source.MessageID,
+			ConversationID: source.ConversationID,
+		})
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	return tx.Create(&links).Error
+}
+
+// GetMemorySources 获取记忆的来源消息关联
+func (r *MemoryRepository) GetMemorySources(memoryIds []string) ([]model.MemorySourceMessage, error) {
+	if len(memoryIds) == 0 {
+		return []model.MemorySourceMessage{}, nil
+	}
+	var sources []model.MemorySourceMessage
+	err := r.db.Where("memory_id IN ?", memoryIds).Order("id ASC").Find(&sources).Error
+	return sources, err
+}
+
+// GetMemorySourceMessages 获取支撑该记忆的对话消息，按消息顺序排列
+func (r *MemoryRepository) GetMemorySourceMessages(memoryId string) ([]model.Message, error) {
+	var messages []model.Message
+	err := r.db.Model(&model.Message{}).
+		Joins("JOIN memory_sources ON memory_sources.message_id = messages.id").
+		Where("memory_sources.memory_id = ?", memoryId).
+		Order("messages.order_id ASC").
+		Find(&messages).Error
+	return messages, err
+}
+
+// ApproveMemory 在同一事务中把待审核的候选（含审核时的修改）设为活跃，并取代 ReplacedIDs 中仍活跃的旧记忆，返回被取代的 ID。
+// 候选已不是待审核状态时返回 ErrMemoryNotPending；旧记忆都已不再活跃时只批准候选
+func (r *MemoryRepository) ApproveMemory(memory *model.Memory) (superseded []string, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Memory{}).
+			Where("id = ? AND status = ? AND is_deleted = false", memory.ID, model.MemoryStatusPendingReview).
+			Updates(map[string]interface{}{
+				"type":                 memory.Type,
+				"content":              memory.Content,
+				"keywords":             memory.Keywords,
+				"importance":           memory.Importance,
+				"embedding":            memory.Embedding,
+				"embedding_checksum":   memory.EmbeddingChecksum,
+				"embedding_updated_at": memory.EmbeddingUpdatedAt,
+				"status":               model.MemoryStatusActive,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMemoryNotPending
+		}
+		replaced := memory.ReplacedIDs()
+		if len(replaced) == 0 {
+			return nil
+		}
+		active := tx.Model(&model.Memory{}).
+			Where("id IN ? AND persona_id = ? AND user_id = ? AND status = ? AND is_deleted = false",
+				replaced, memory.PersonaID, memory.UserID, model.MemoryStatusActive)
+		if err := active.Pluck("id", &superseded).Error; err != nil {
+			return err
+		}
+		if len(superseded) == 0 {
+			return nil
+		}
+		return tx.Model(&model.Memory{}).
+			Where("id IN ? AND status = ?", superseded, model.MemoryStatusActive).
+			Updates(map[string]interface{}{
+				"status":        model.MemoryStatusSuperseded,
+				"superseded_by": memory.ID,
+			}).Error
+	})
+	if err == nil {
+		memory.Status = model.MemoryStatusActive
+	}
+	return superseded, err
+}
+
+// ApproveRetirement 在同一事务中批准待审核的失效/删除请求：使 ReplacesID 对应的活跃记忆失效或软删除，并移除该请求。
+// 请求已不是待审核状态时返回 ErrMemoryNotPending；目标记忆已不再活跃时只移除请求，返回的 retired 为 false
+func (r *MemoryRepository) ApproveRetirement(request *model.Memory) (retired bool, err error) {
+	updates := map[string]interface{}{"status": model.MemoryStatusInvalidated}
+	if request.ReviewAction == model.MemoryReviewDelete {
+		updates = map[string]interface{}{"is_deleted": true}
+	}
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		// 请求中保存的是目标记忆内容的副本，批准后一并删除
+		result := tx.Model(&model.Memory{}).
+			Where("id = ? AND status = ? AND is_deleted = false", request.ID, model.MemoryStatusPendingReview).
+			Update("is_deleted", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrMemoryNotPending
+		}
+		result = tx.Model(&model.Memory{}).
+			Where("id = ? AND persona_id = ? AND user_id = ? AND status = ? AND is_deleted = false",
+				request.ReplacesID, request.PersonaID, request.UserID, model.MemoryStatusActive).
+			Updates(updates)
+		retired = result.RowsAffected > 0
+		return result.Error
+	})
+	return retired, err
+}
+
+// RejectMemories 把属于该人格和用户的待审核候选标记为已拒绝，返回实际拒绝的 ID
+func (r *MemoryRepository) RejectMemories(personaId string, userId int64, ids []string) ([]string, error) {
+	rejected := make([]string, 0, len(ids))
+	if len(ids) == 0 {
+		return rejected, nil
+	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Memory{}).
+			Where("persona_id = ? AND user_id = ? AND id IN ? AND status = ? AND is_deleted = false",
+				personaId, userId, ids, model.MemoryStatusPendingReview).
+			Pluck("id", &rejected).Error; err != nil {
+			return err
+		}
+		if len(rejected) == 0 {
+			return nil
+		}
+		return tx.Model(&model.Memory{}).
+			Where("id IN ?", rejected).
+			Update("status", model.MemoryStatusRejected).Error
+	})
+	return rejected, err
+}
+
+// UpdateMemory 更新记忆
+func (r *MemoryRepository) UpdateMemory(memory *model.Memory) error {
+	return r.db.Save(memory).Error
 }
 
-// UpdateMemoryEmbedding 更新记忆向量
-func (r *MemoryRepository) UpdateMemoryEmbedding(id string, embedding string) error {
+// UpdateMemoryEmbedding 更新记忆向量及对应内容的校验值
+func (r *MemoryRepository) UpdateMemoryEmbedding(id string, embedding string, checksum string) error {
 	return r.db.Model(&model.Memory{}).
 		Where("id = ?", id).
 		Updates(map[string]interface{}{
 			"embedding":            embedding,
+			"embedding_checksum":   checksum,
 			"embedding_updated_at": time.Now(),
 		}).Error
 }
 
+// GetMemoriesForReindex 获取需要与向量存储核对的记忆，包括已被取代和已删除的；personaId 为空或 userId 为 0 时不过滤该字段
+func (r *MemoryRepository) GetMemoriesForReindex(personaId string, userId int64) ([]model.Memory, error) {
+	var memories []model.Memory
+	query := r.db.Model(&model.Memory{})
+	if personaId != "" {
+		query = query.Where("persona_id = ?", personaId)
+	}
+	if userId != 0 {
+		query = query.Where("user_id = ?", userId)
+	}
+	err := query.Order("id").Find(&memories).Error
+	return memories, err
+}
+
+// InvalidateMemories 把属于该人格和用户的活跃记忆标记为已失效，返回实际失效的 ID
+func (r *MemoryRepository) InvalidateMemories(personaId string, userId int64, ids []string) ([]string, error) {
+	return r.retireActiveMemories(personaId, userId, ids, map[string]interface{}{"status": model.MemoryStatusInvalidated})
+}
+
+// DeleteActiveMemories 软删除属于该人格和用户的活跃记忆，返回实际删除的 ID
+func (r *MemoryRepository) DeleteActiveMemories(personaId string, userId int64, ids []string) ([]string, error) {
+	return r.retireActiveMemories(personaId, userId, ids, map[string]interface{}{"is_deleted": true})
+}
+
+// retireActiveMemories 在同一事务中找出属于该人格和用户的活跃记忆并更新
+func (r *MemoryRepository) retireActiveMemories(personaId string, userId int64, ids []string, updates map[string]interface{}) ([]string, error) {
+	retired := make([]string, 0, len(ids))
+	if len(ids) == 0 {
+		return retired, nil
+	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.Memory{}).
+			Where("persona_id = ? AND user_id = ? AND id IN ? AND status = ? AND is_deleted = false",
+				personaId, userId, ids, model.MemoryStatusActive).
+			Pluck("id", &retired).Error; err != nil {
+			return err
+		}
+		if len(retired) == 0 {
+			return nil
+		}
+		return tx.Model(&model.Memory{}).Where("id IN ?", retired).Updates(updates).Error
+	})
+	return retired, err
+}
+
 // DeleteMemory 软删除记忆
 func (r *MemoryRepository) DeleteMemory(id string) error {
 	return r.db.Model(&model.Memory{}).
@@ -95,11 +456,19 @@ func (r *MemoryRepository) DeleteMemory(id string) error {
 
 // IncrementHitCount 增加命中次数
 func (r *MemoryRepository) IncrementHitCount(id string) error {
+	return r.IncrementHitCounts([]string{id})
+}
+
+// IncrementHitCounts 批量增加命中次数并记录命中时间
+func (r *MemoryRepository) IncrementHitCounts(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
 	return r.db.Model(&model.Memory{}).
-		Where("id = ?", id).
-		Updates(map[string]interface{}{
+		Where("id IN ?", ids).
+		UpdateColumns(map[string]interface{}{
 			"hit_count":   gorm.Expr("hit_count + 1"),
-			"last_hit_at": gorm.Expr("NOW()"),
+			"last_hit_at": time.Now(),
 		}).Error
 }
 
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"AI_Chat/internal/model"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type MemoryJobRepository struct {
+	db *gorm.DB
+}
+
+func NewMemoryJobRepository(db *gorm.DB) *MemoryJobRepository {
+	return &MemoryJobRepository{db: db}
+}
+
+// CreateJob 创建任务
+func (r *MemoryJobRepository) CreateJob(job *model.MemoryJob) error {
+	return r.db.Create(job).Error
+}
+
+// GetJobById 根据ID获取任务
+func (r *MemoryJobRepository) GetJobById(id string) (*model.MemoryJob, error) {
+	var job model.MemoryJob
+	if err := r.db.Where("id = ?", id).First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ClaimNextJob 领取一个到期的待执行任务，没有可领取的任务时返回 nil。
+// 通过带状态条件的 UPDATE 抢占，多个 worker（包括多个进程）并发领取时只有一个会成功。
+func (r *MemoryJobRepository) ClaimNextJob(workerId string) (*model.MemoryJob, error) {
+	for i := 0; i < 3; i++ {
+		var job model.MemoryJob
+		err := r.db.Where("status = ? AND next_run_at <= ?", model.MemoryJobStatusPending, time.Now()).
+			Order("next_run_at ASC").
+			First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		result := r.db.Model(&model.MemoryJob{}).
+			Where("id = ? AND status = ?", job.ID, model.MemoryJobStatusPending).
+			Updates(map[string]interface{}{
+				"status":    model.MemoryJobStatusRunning,
+				"locked_by": workerId,
+				"locked_at": now,
+				"attempts":  gorm.Expr("attempts + 1"),
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			job.Status = model.MemoryJobStatusRunning
+			job.LockedBy = workerId
+			job.LockedAt = &now
+			job.Attempts++
+			return &job, nil
+		}
+		// 被其他 worker 抢先领取，重新查找
+	}
+	return nil, nil
+}
+
+// MarkSucceeded 标记任务成功，result 为执行结果（JSON），没有时传 nil
+func (r *MemoryJobRepository) MarkSucceeded(id string, result []byte) error {
+	return r.db.Model(&model.MemoryJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      model.MemoryJobStatusSucceeded,
+			"last_error":  "",
+			"result":      result,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// MarkRetry 记录失败原因，并安排在 nextRunAt 重试
+func (r *MemoryJobRepository) MarkRetry(id string, lastError string, nextRunAt time.Time) error {
+	return r.db.Model(&model.MemoryJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      model.MemoryJobStatusPending,
+			"last_error":  lastError,
+			"next_run_at": nextRunAt,
+			"locked_by":   "",
+		}).Error
+}
+
+// MarkDead 任务重试耗尽，进入死信
+func (r *MemoryJobRepository) MarkDead(id string, lastError string) error {
+	return r.db.Model(&model.MemoryJob{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":      model.MemoryJobStatusDead,
+			"last_error":  lastError,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// RequeueStaleJobs 把锁定超时的运行中任务放回队列（进程崩溃或重启后恢复）
+func (r *MemoryJobRepository) RequeueStaleJobs(lockTimeout time.Duration) (int64, error) {
+	result := r.db.Model(&model.MemoryJob{}).
+		Where("status = ? AND locked_at < ?", model.MemoryJobStatusRunning, time.Now().Add(-lockTimeout)).
+		Updates(map[string]interface{}{
+			"status":      model.MemoryJobStatusPending,
+			"locked_by":   "",
+			"next_run_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// RetryDeadJob 把死信任务重新放回队列，并重置重试次数
+func (r *MemoryJobRepository) RetryDeadJob(id string) error {
+	return r.db.Model(&model.MemoryJob{}).
+		Where("id = ? AND status = ?", id, model.MemoryJobStatusDead).
+		Updates(map[string]interface{}{
+			"status":      model.MemoryJobStatusPending,
+			"attempts":    0,
+			"next_run_at": time.Now(),
+			"finished_at": nil,
+		}).Error
+}
+
+// GetJobsByPersonaAndUser 获取某人格某用户的任务，status 为空时不过滤
+func (r *MemoryJobRepository) GetJobsByPersonaAndUser(personaId string, userId int64, status string, limit int) ([]model.MemoryJob, error) {
+	var jobs []model.MemoryJob
+	query := r.db.Where("persona_id = ? AND user_id = ?", personaId, userId)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Order("created_at DESC").Limit(limit).Find(&jobs).Error
+	return jobs, err
+}
+
+// GetLatestJob 获取某人格某用户最近创建的指定类型任务，没有时返回 nil
+func (r *MemoryJobRepository) GetLatestJob(personaId string, userId int64, jobType string) (*model.MemoryJob, error) {
+	var job model.MemoryJob
+	err := r.db.Where("persona_id = ? AND user_id = ? AND type = ?", personaId, userId, jobType).
+		Order("created_at DESC").
+		First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
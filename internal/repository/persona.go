@@ -2,6 +2,8 @@ package repository
 
 import (
 	"AI_Chat/internal/model"
+	"errors"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -14,21 +16,303 @@ func NewPersonaRepository(db *gorm.DB) *PersonaRepository {
 	return &PersonaRepository{db: db}
 }
 
+// CreatePersona 在同一事务中创建人格及其第一个提示词版本
 func (r *PersonaRepository) CreatePersona(persona *model.Persona) error {
-	return r.db.Create(persona).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(persona).Error; err != nil {
+			return err
+		}
+		_, err := savePromptVersion(tx, persona, "")
+		return err
+	})
+}
+
+// ImportPersona 在同一事务中创建导入的人格及其第一个提示词版本，avatar 不为空时一并保存头像图片
+func (r *PersonaRepository) ImportPersona(persona *model.Persona, avatar []byte) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(persona).Error; err != nil {
+			return err
+		}
+		if _, err := savePromptVersion(tx, persona, ""); err != nil {
+			return err
+		}
+		if len(avatar) == 0 {
+			return nil
+		}
+		return tx.Save(&model.PersonaAvatar{PersonaID: persona.ID, Data: avatar}).Error
+	})
+}
+
+// GetPersonaAvatar 获取人格保存的头像图片，没有时返回 nil
+func (r *PersonaRepository) GetPersonaAvatar(personaId string) ([]byte, error) {
+	var avatar model.PersonaAvatar
+	err := r.db.Where("persona_id = ?", personaId).First(&avatar).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return avatar.Data, nil
+}
+
+// SavePersonaPrompt 在同一事务中为人格当前的提示词字段生成新版本并保存人格，restoredFrom 为回滚时恢复的旧版本
+func (r *PersonaRepository) SavePersonaPrompt(persona *model.Persona, restoredFrom string) (*model.PersonaVersion, error) {
+	var version *model.PersonaVersion
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		version, err = savePromptVersion(tx, persona, restoredFrom)
+		return err
+	})
+	return version, err
+}
+
+// EnsurePersonaVersion 返回人格当前的提示词版本；早期创建的人格还没有版本时，以当前提示词生成第一个版本
+func (r *PersonaRepository) EnsurePersonaVersion(persona *model.Persona) (string, error) {
+	if persona.CurrentVersionID != "" {
+		return persona.CurrentVersionID, nil
+	}
+	if _, err := r.SavePersonaPrompt(persona, ""); err != nil {
+		// 并发请求可能已经生成了版本
+		latest, getErr := r.GetPersonaById(persona.ID)
+		if getErr == nil && latest.CurrentVersionID != "" {
+			persona.CurrentVersionID = latest.CurrentVersionID
+			return latest.CurrentVersionID, nil
+		}
+		return "", err
+	}
+	return persona.CurrentVersionID, nil
+}
+
+// savePromptVersion 生成下一个版本号的提示词版本，并把它设为人格的当前版本
+func savePromptVersion(tx *gorm.DB, persona *model.Persona, restoredFrom string) (*model.PersonaVersion, error) {
+	var latest int
+	if err := tx.Model(&model.PersonaVersion{}).
+		Where("persona_id = ?", persona.ID).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error; err != nil {
+		return nil, err
+	}
+	version := model.NewPersonaVersion(persona)
+	version.Version = latest + 1
+	version.RestoredFrom = restoredFrom
+	if err := tx.Create(version).Error; err != nil {
+		return nil, err
+	}
+	persona.CurrentVersionID = version.ID
+	if err := updatePersonaColumns(tx, persona); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// updatePersonaColumns 保存人格的可修改字段，不写 is_deleted，已删除的人格不会被写回；人格已删除时返回 gorm.ErrRecordNotFound
+func updatePersonaColumns(tx *gorm.DB, persona *model.Persona) error {
+	result := tx.Model(persona).
+		Where("is_deleted = ?", false).
+		Select("*").
+		Omit("id", "user_id", "created_at", "is_deleted").
+		Updates(persona)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+	// MySQL 在内容未变化时也返回 0 行，再确认人格是否仍然存在
+	var count int64
+	if err := tx.Model(&model.Persona{}).Where("id = ? AND is_deleted = ?", persona.ID, false).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetPersonaVersions 获取人格的全部提示词版本，按版本号从新到旧
+func (r *PersonaRepository) GetPersonaVersions(personaId string) ([]model.PersonaVersion, error) {
+	var versions []model.PersonaVersion
+	err := r.db.Where("persona_id = ?", personaId).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+// GetPersonaVersion 获取人格的指定版本
+func (r *PersonaRepository) GetPersonaVersion(personaId, versionId string) (*model.PersonaVersion, error) {
+	var version model.PersonaVersion
+	if err := r.db.Where("id = ? AND persona_id = ?", versionId, personaId).First(&version).Error; err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetPersonaVersionPair 获取要比较的两个版本：fromId 为空时取 toId 的上一个版本，toId 已是第一个版本时 from 为 nil；
+// 任一指定的版本不存在时返回 gorm.ErrRecordNotFound
+func (r *PersonaRepository) GetPersonaVersionPair(personaId, fromId, toId string) (from, to *model.PersonaVersion, err error) {
+	if to, err = r.GetPersonaVersion(personaId, toId); err != nil {
+		return nil, nil, err
+	}
+	if fromId != "" {
+		if from, err = r.GetPersonaVersion(personaId, fromId); err != nil {
+			return nil, nil, err
+		}
+		return from, to, nil
+	}
+	var previous model.PersonaVersion
+	err = r.db.Where("persona_id = ? AND version < ?", personaId, to.Version).Order("version DESC").First(&previous).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, to, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &previous, to, nil
+}
+
+// CountMessagesByPersonaVersion 统计各提示词版本生成的回复数量
+func (r *PersonaRepository) CountMessagesByPersonaVersion(versionIds []string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(versionIds))
+	if len(versionIds) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		PersonaVersionID string
+		Count            int64
+	}
+	err := r.db.Model(&model.Message{}).
+		Select("persona_version_id, COUNT(*) AS count").
+		Where("persona_version_id IN ?", versionIds).
+		Group("persona_version_id").
+		Scan(&rows).Error
+	for _, row := range rows {
+		counts[row.PersonaVersionID] = row.Count
+	}
+	return counts, err
 }
 
 func (r *PersonaRepository) GetPersonaById(id string) (*model.Persona, error) {
+	var persona model.Persona
+	if err := r.db.Where("id = ? AND is_deleted = false", id).First(&persona).Error; err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// GetPersonaIncludingDeleted 获取人格，包括已软删除的，用于在软删除后再次彻底删除
+func (r *PersonaRepository) GetPersonaIncludingDeleted(id string) (*model.Persona, error) {
 	var persona model.Persona
 	if err := r.db.Where("id = ?", id).First(&persona).Error; err != nil {
 		return nil, err
 	}
 	return &persona, nil
 }
+
 func (r *PersonaRepository) GetPersonasByUserId(userId int64) ([]model.Persona, error) {
 	var personas []model.Persona
-	if err := r.db.Where("user_id = ?", userId).Find(&personas).Error; err != nil {
+	if err := r.db.Where("user_id = ? AND is_deleted = false", userId).Find(&personas).Error; err != nil {
 		return nil, err
 	}
 	return personas, nil
 }
+
+// UpdatePersona 保存人格（已删除的人格不会被写回，返回 gorm.ErrRecordNotFound）；影响提示词的字段与当前版本不一致（或还没有版本）时，在同一事务中生成新版本
+func (r *PersonaRepository) UpdatePersona(persona *model.Persona) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if persona.CurrentVersionID != "" {
+			var current model.PersonaVersion
+			err := tx.Where("id = ?", persona.CurrentVersionID).First(&current).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if err == nil && current.SamePrompt(persona) {
+				return updatePersonaColumns(tx, persona)
+			}
+		}
+		_, err := savePromptVersion(tx, persona, "")
+		return err
+	})
+}
+
+// MarkPersonaDeleted 把人格标记为已删除，之后查询不再返回该人格
+func (r *PersonaRepository) MarkPersonaDeleted(id string) error {
+	return r.db.Model(&model.Persona{}).Where("id = ?", id).Update("is_deleted", true).Error
+}
+
+// GetConversationIDsByPersona 获取人格的全部会话 ID（包括已删除的）
+func (r *PersonaRepository) GetConversationIDsByPersona(personaId string, userId int64) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&model.Conversation{}).
+		Where("persona_id = ? AND user_id = ?", personaId, userId).
+		Pluck("id", &ids).Error
+	return ids, err
+}
+
+// PurgePersona 在同一事务中清理人格及其关联数据，可重复执行。
+// 软删除：人格、会话和记忆标记为已删除，消息随会话隐藏；硬删除：物理删除人格及其提示词版本、头像、会话、消息、摘要、记忆及其关联，
+// 以及该人格的其他记忆任务（任务载荷包含对话内容）。两种方式都会取消尚未执行的记忆任务；keepJobId 为执行清理的任务本身，不受影响。
+func (r *PersonaRepository) PurgePersona(personaId string, userId int64, hard bool, keepJobId string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var convIds []string
+		if err := tx.Model(&model.Conversation{}).
+			Where("persona_id = ? AND user_id = ?", personaId, userId).
+			Pluck("id", &convIds).Error; err != nil {
+			return err
+		}
+		jobs := tx.Where("persona_id = ? AND user_id = ? AND id <> ?", personaId, userId, keepJobId)
+
+		if !hard {
+			if err := jobs.Model(&model.MemoryJob{}).
+				Where("status = ?", model.MemoryJobStatusPending).
+				Updates(map[string]interface{}{
+					"status":      model.MemoryJobStatusDead,
+					"last_error":  "persona deleted",
+					"finished_at": time.Now(),
+				}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.Memory{}).
+				Where("persona_id = ? AND user_id = ?", personaId, userId).
+				Update("is_deleted", true).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&model.Conversation{}).
+				Where("persona_id = ? AND user_id = ?", personaId, userId).
+				Update("is_deleted", true).Error; err != nil {
+				return err
+			}
+			return tx.Model(&model.Persona{}).Where("id = ?", personaId).Update("is_deleted", true).Error
+		}
+
+		if err := jobs.Delete(&model.MemoryJob{}).Error; err != nil {
+			return err
+		}
+		memoryIds := tx.Model(&model.Memory{}).Select("id").Where("persona_id = ? AND user_id = ?", personaId, userId)
+		if err := tx.Where("memory_id IN (?)", memoryIds).Delete(&model.MemorySourceMessage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("reflection_id IN (?) OR memory_id IN (?)", memoryIds, memoryIds).Delete(&model.MemoryEvidence{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("persona_id = ? AND user_id = ?", personaId, userId).Delete(&model.Memory{}).Error; err != nil {
+			return err
+		}
+		if len(convIds) > 0 {
+			if err := tx.Where("conversation_id IN ?", convIds).Delete(&model.Message{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("conversation_id IN ?", convIds).Delete(&model.ConversationSummary{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ?", convIds).Delete(&model.Conversation{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("persona_id = ?", personaId).Delete(&model.PersonaVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("persona_id = ?", personaId).Delete(&model.PersonaAvatar{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ? AND user_id = ?", personaId, userId).Delete(&model.Persona{}).Error
+	})
+}
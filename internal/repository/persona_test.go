@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"AI_Chat/internal/model"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestPersonaRepository(t *testing.T) (*PersonaRepository, *gorm.DB) {
+	t.Helper()
+	gdb, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite failed: %v", err)
+	}
+	if err := gdb.AutoMigrate(&model.Persona{}, &model.PersonaVersion{}, &model.PersonaAvatar{}, &model.Conversation{}, &model.Message{},
+		&model.ConversationSummary{}, &model.Memory{}, &model.MemorySourceMessage{}, &model.MemoryEvidence{}, &model.MemoryJob{}); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	return NewPersonaRepository(gdb), gdb
+}
+
+func TestPersonaVersionTracksPromptFields(t *testing.T) {
+	repo, _ := newTestPersonaRepository(t)
+	persona := &model.Persona{UserID: 1, Name: "小雪", SystemPrompt: "你是小雪", Personality: "温柔"}
+	if err := repo.CreatePersona(persona); err != nil {
+		t.Fatalf("create persona failed: %v", err)
+	}
+	first := persona.CurrentVersionID
+
+	// 只改生成参数不生成新版本
+	persona.MaxTokens = 512
+	if err := repo.UpdatePersona(persona); err != nil || persona.CurrentVersionID != first {
+		t.Fatalf("settings change should keep the version: %s, %v", persona.CurrentVersionID, err)
+	}
+
+	// 修改系统提示词以外影响提示词的字段也生成新版本
+	persona.Personality = "傲娇"
+	persona.MessageExamples = "<START>\n{{user}}: 在吗\n{{char}}: 干嘛"
+	if err := repo.UpdatePersona(persona); err != nil || persona.CurrentVersionID == first {
+		t.Fatalf("personality change should create a version: %s, %v", persona.CurrentVersionID, err)
+	}
+	second, err := repo.GetPersonaVersion(persona.ID, persona.CurrentVersionID)
+	if err != nil || second.Version != 2 || second.Personality != "傲娇" || second.MessageExamples != persona.MessageExamples {
+		t.Fatalf("unexpected second version: %+v, %v", second, err)
+	}
+
+	// 回滚恢复旧版本的全部提示词字段
+	target, _ := repo.GetPersonaVersion(persona.ID, first)
+	target.ApplyTo(persona)
+	restored, err := repo.SavePersonaPrompt(persona, target.ID)
+	if err != nil || restored.Version != 3 || restored.RestoredFrom != first {
+		t.Fatalf("rollback failed: %+v, %v", restored, err)
+	}
+	saved, _ := repo.GetPersonaById(persona.ID)
+	if saved.Personality != "温柔" || saved.MessageExamples != "" || saved.MaxTokens != 512 || saved.CurrentVersionID != restored.ID {
+		t.Fatalf("rollback should restore every prompt field: %+v", saved)
+	}
+}
+
+func TestPersonaVersionNumberingAndDiffPair(t *testing.T) {
+	repo, _ := newTestPersonaRepository(t)
+	persona := &model.Persona{UserID: 1, Name: "小雪", SystemPrompt: "v1"}
+	if err := repo.CreatePersona(persona); err != nil {
+		t.Fatalf("create persona failed: %v", err)
+	}
+	other := &model.Persona{UserID: 1, Name: "阿明", SystemPrompt: "other"}
+	repo.CreatePersona(other)
+	ids := []string{persona.CurrentVersionID}
+	for _, prompt := range []string{"v2", "v3"} {
+		persona.SystemPrompt = prompt
+		if err := repo.UpdatePersona(persona); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+		ids = append(ids, persona.CurrentVersionID)
+	}
+
+	// 版本号在同一人格内从 1 递增，列表按版本号从新到旧
+	versions, err := repo.GetPersonaVersions(persona.ID)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("unexpected versions: %+v, %v", versions, err)
+	}
+	for i, version := range versions {
+		if version.Version != 3-i || version.ID != ids[2-i] {
+			t.Fatalf("unexpected version #%d: %+v", i, version)
+		}
+	}
+	if otherVersions, _ := repo.GetPersonaVersions(other.ID); len(otherVersions) != 1 || otherVersions[0].Version != 1 {
+		t.Fatalf("numbering should be per persona: %+v", otherVersions)
+	}
+
+	// from 不传时与上一个版本比较，第一个版本没有上一个版本
+	from, to, err := repo.GetPersonaVersionPair(persona.ID, "", ids[2])
+	if err != nil || from.ID != ids[1] || to.ID != ids[2] {
+		t.Fatalf("default from should be the previous version: %+v, %+v, %v", from, to, err)
+	}
+	if from, to, err = repo.GetPersonaVersionPair(persona.ID, ids[0], ids[2]); err != nil || from.ID != ids[0] || to.ID != ids[2] {
+		t.Fatalf("explicit from ignored: %+v, %+v, %v", from, to, err)
+	}
+	if from, _, err = repo.GetPersonaVersionPair(persona.ID, "", ids[0]); err != nil || from != nil {
+		t.Fatalf("first version should have no previous version: %+v, %v", from, err)
+	}
+	if _, _, err := repo.GetPersonaVersionPair(persona.ID, other.CurrentVersionID, ids[2]); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("version of another persona should not be found, got %v", err)
+	}
+}
+
+func TestEnsurePersonaVersionBackfill(t *testing.T) {
+	repo, gdb := newTestPersonaRepository(t)
+	// 早期创建的人格没有提示词版本
+	legacy := &model.Persona{UserID: 1, Name: "小雪", SystemPrompt: "旧的提示词", Scenario: "教室"}
+	if err := gdb.Create(legacy).Error; err != nil {
+		t.Fatalf("create legacy persona failed: %v", err)
+	}
+	id, err := repo.EnsurePersonaVersion(legacy)
+	if err != nil || id == "" {
+		t.Fatalf("backfill failed: %s, %v", id, err)
+	}
+	version, err := repo.GetPersonaVersion(legacy.ID, id)
+	if err != nil || version.Version != 1 || !version.SamePrompt(legacy) {
+		t.Fatalf("unexpected backfilled version: %+v, %v", version, err)
+	}
+	if saved, _ := repo.GetPersonaById(legacy.ID); saved.CurrentVersionID != id {
+		t.Fatalf("current version not saved: %+v", saved)
+	}
+	// 已有版本时直接返回，不再生成
+	if again, err := repo.EnsurePersonaVersion(legacy); err != nil || again != id {
+		t.Fatalf("ensure should be idempotent: %s, %v", again, err)
+	}
+	if versions, _ := repo.GetPersonaVersions(legacy.ID); len(versions) != 1 {
+		t.Fatalf("expected one version, got %d", len(versions))
+	}
+}
+
+func TestPurgePersonaRemovesVersions(t *testing.T) {
+	repo, gdb := newTestPersonaRepository(t)
+	persona := &model.Persona{UserID: 1, Name: "小雪", SystemPrompt: "v1"}
+	repo.CreatePersona(persona)
+	persona.SystemPrompt = "v2"
+	repo.UpdatePersona(persona)
+	keep := &model.Persona{UserID: 1, Name: "阿明", SystemPrompt: "other"}
+	repo.CreatePersona(keep)
+
+	// 软删除保留版本
+	if err := repo.PurgePersona(persona.ID, 1, false, ""); err != nil {
+		t.Fatalf("soft purge failed: %v", err)
+	}
+	if versions, _ := repo.GetPersonaVersions(persona.ID); len(versions) != 2 {
+		t.Fatalf("soft delete should keep versions, got %d", len(versions))
+	}
+	if err := repo.PurgePersona(persona.ID, 1, true, ""); err != nil {
+		t.Fatalf("hard purge failed: %v", err)
+	}
+	if versions, _ := repo.GetPersonaVersions(persona.ID); len(versions) != 0 {
+		t.Fatalf("hard delete should remove versions, got %d", len(versions))
+	}
+	var count int64
+	gdb.Model(&model.Persona{}).Where("id = ?", persona.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("persona row should be removed")
+	}
+	if versions, _ := repo.GetPersonaVersions(keep.ID); len(versions) != 1 {
+		t.Fatalf("other persona's versions should be kept, got %d", len(versions))
+	}
+}
+
+func TestUpdatePersonaKeepsDeletion(t *testing.T) {
+	repo, _ := newTestPersonaRepository(t)
+	persona := &model.Persona{UserID: 1, Name: "小雪", SystemPrompt: "v1", PersonaModelSettings: model.PersonaModelSettings{MaxTokens: 512}}
+	if err := repo.CreatePersona(persona); err != nil {
+		t.Fatalf("create persona failed: %v", err)
+	}
+
+	// 零值字段也会写入
+	persona.MaxTokens = 0
+	if err := repo.UpdatePersona(persona); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if saved, _ := repo.GetPersonaById(persona.ID); saved.MaxTokens != 0 {
+		t.Fatalf("zero value should be saved: %+v", saved)
+	}
+
+	// 删除前读取的人格在删除后保存，不会把人格恢复
+	stale, _ := repo.GetPersonaById(persona.ID)
+	if err := repo.MarkPersonaDeleted(persona.ID); err != nil {
+		t.Fatalf("mark deleted failed: %v", err)
+	}
+	stale.MaxTokens = 256
+	if err := repo.UpdatePersona(stale); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("update of deleted persona should fail, got %v", err)
+	}
+	stale.SystemPrompt = "v2"
+	if err := repo.UpdatePersona(stale); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("prompt change of deleted persona should fail, got %v", err)
+	}
+	if versions, _ := repo.GetPersonaVersions(persona.ID); len(versions) != 1 {
+		t.Fatalf("failed update should not leave a version, got %d", len(versions))
+	}
+	if _, err := repo.GetPersonaById(persona.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted persona should stay hidden, got %v", err)
+	}
+
+	// 软删除后仍能取到人格，用于彻底删除
+	deleted, err := repo.GetPersonaIncludingDeleted(persona.ID)
+	if err != nil || !deleted.IsDeleted || deleted.MaxTokens != 0 {
+		t.Fatalf("unexpected deleted persona: %+v, %v", deleted, err)
+	}
+}
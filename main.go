@@ -2,8 +2,17 @@ package main
 
 import (
 	"AI_Chat/internal/app"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "reindex":
+			os.Exit(app.RunReindex(os.Args[2:]))
+		case "consolidate":
+			os.Exit(app.RunConsolidate(os.Args[2:]))
+		}
+	}
 	app.Run()
 }
@@ -0,0 +1,147 @@
+package ai_config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino-ext/components/model/deepseek"
+	"github.com/cloudwego/eino-ext/components/model/ollama"
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/components/model"
+)
+
+// 内置的聊天模型提供方类型
+const (
+	ProviderDeepSeek = "deepseek"
+	ProviderOpenAI   = "openai" // 任意 OpenAI 兼容接口（vLLM、通义、Moonshot 等）
+	ProviderOllama   = "ollama"
+	ProviderFake     = "fake" // 按脚本返回固定回复，用于离线运行和测试
+)
+
+// ChatProviderConfig 一个命名的聊天模型实例配置（对应 chat.yaml 中 chat.providers 下的一项）
+type ChatProviderConfig struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Model   string   `json:"model"`
+	BaseURL string   `json:"base_url"`
+	APIKey  string   `json:"api_key"`
+	Script  []string `json:"script"` // 仅 fake 使用
+
+	// ContextWindow 模型上下文窗口（token），为 0 时使用 DefaultContextWindow
+	ContextWindow int `json:"context_window"`
+}
+
+// DefaultContextWindow 未配置 context_window 时使用的上下文窗口
+const DefaultContextWindow = 32768
+
+// GetContextWindow 获取模型上下文窗口
+func (c ChatProviderConfig) GetContextWindow() int {
+	if c.ContextWindow <= 0 {
+		return DefaultContextWindow
+	}
+	return c.ContextWindow
+}
+
+// ChatModelBuilder 根据配置构建一个支持工具调用的聊天模型
+type ChatModelBuilder func(ctx context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error)
+
+var (
+	builderMu     sync.RWMutex
+	chatBuilders  = map[string]ChatModelBuilder{}
+	providerMu    sync.RWMutex
+	chatProviders = map[string]ChatProviderConfig{}
+)
+
+// DefaultChatProvider 聊天默认使用的提供方名称
+var DefaultChatProvider = ProviderDeepSeek
+
+// MemoryChatProvider 记忆提取使用的提供方名称，为空时使用 DefaultChatProvider
+var MemoryChatProvider string
+
+func init() {
+	RegisterChatModelBuilder(ProviderDeepSeek, buildDeepSeekChatModel)
+	RegisterChatModelBuilder(ProviderOpenAI, buildOpenAIChatModel)
+	RegisterChatModelBuilder(ProviderOllama, buildOllamaChatModel)
+	RegisterChatModelBuilder(ProviderFake, buildFakeChatModel)
+}
+
+// RegisterChatModelBuilder 注册（或覆盖）一种提供方类型的构建函数
+func RegisterChatModelBuilder(providerType string, builder ChatModelBuilder) {
+	builderMu.Lock()
+	defer builderMu.Unlock()
+	chatBuilders[strings.ToLower(providerType)] = builder
+}
+
+// SetChatProvider 注册（或覆盖）一个命名的提供方配置
+func SetChatProvider(cfg ChatProviderConfig) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	chatProviders[cfg.Name] = cfg
+}
+
+// GetChatProvider 获取命名的提供方配置，name 为空时返回默认提供方
+func GetChatProvider(name string) (ChatProviderConfig, error) {
+	if strings.TrimSpace(name) == "" {
+		name = DefaultChatProvider
+	}
+	providerMu.RLock()
+	defer providerMu.RUnlock()
+	cfg, ok := chatProviders[name]
+	if !ok {
+		return ChatProviderConfig{}, fmt.Errorf("chat provider %q not configured", name)
+	}
+	return cfg, nil
+}
+
+// NewChatModel 按提供方名称构建聊天模型，name 为空时使用默认提供方
+func NewChatModel(ctx context.Context, name string) (model.ToolCallingChatModel, error) {
+	cfg, err := GetChatProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewChatModelFromConfig(ctx, cfg)
+}
+
+// NewChatModelFromConfig 直接按配置构建聊天模型
+func NewChatModelFromConfig(ctx context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error) {
+	builderMu.RLock()
+	builder, ok := chatBuilders[strings.ToLower(cfg.Type)]
+	builderMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown chat provider type %q", cfg.Type)
+	}
+	return builder(ctx, cfg)
+}
+
+func buildDeepSeekChatModel(ctx context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error) {
+	return deepseek.NewChatModel(ctx, &deepseek.ChatModelConfig{
+		APIKey:  cfg.APIKey,
+		Model:   cfg.Model,
+		BaseURL: cfg.BaseURL,
+	})
+}
+
+func buildOpenAIChatModel(ctx context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error) {
+	return openai.NewChatModel(ctx, &openai.ChatModelConfig{
+		APIKey:  cfg.APIKey,
+		Model:   cfg.Model,
+		BaseURL: cfg.BaseURL,
+	})
+}
+
+func buildOllamaChatModel(ctx context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error) {
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return ollama.NewChatModel(ctx, &ollama.ChatModelConfig{
+		BaseURL: baseURL,
+		Model:   cfg.Model,
+	})
+}
+
+func buildFakeChatModel(_ context.Context, cfg ChatProviderConfig) (model.ToolCallingChatModel, error) {
+	return NewFakeChatModel(cfg.Script...), nil
+}
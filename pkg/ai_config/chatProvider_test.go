@@ -0,0 +1,55 @@
+package ai_config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestFakeChatProvider(t *testing.T) {
+	SetChatProvider(ChatProviderConfig{
+		Name:   "test-fake",
+		Type:   ProviderFake,
+		Script: []string{"第一句\n第二句", "第三句"},
+	})
+	cm, err := NewChatModel(context.Background(), "test-fake")
+	if err != nil {
+		t.Fatalf("build fake chat model failed: %v", err)
+	}
+	input := []*schema.Message{schema.UserMessage("你好")}
+
+	resp, err := cm.Generate(context.Background(), input)
+	if err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	if resp.Content != "第一句\n第二句" {
+		t.Fatalf("unexpected reply: %q", resp.Content)
+	}
+
+	stream, err := cm.Stream(context.Background(), input)
+	if err != nil {
+		t.Fatalf("stream failed: %v", err)
+	}
+	var sb strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("recv failed: %v", err)
+		}
+		sb.WriteString(chunk.Content)
+	}
+	if sb.String() != "第三句" {
+		t.Fatalf("unexpected streamed reply: %q", sb.String())
+	}
+
+	if _, err := NewChatModel(context.Background(), "not-exist"); err == nil {
+		t.Fatalf("expected error for unknown provider")
+	}
+}
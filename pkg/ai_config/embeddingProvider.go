@@ -0,0 +1,43 @@
+package ai_config
+
+// 内置的向量化提供方类型
+const (
+	EmbeddingOpenAI = "openai" // OpenAI 兼容的 /v1/embeddings 接口（DeepSeek、通义等）
+	EmbeddingOllama = "ollama"
+	EmbeddingHash   = "hash" // 本地哈希 n-gram 向量，不需要联网，结果确定，用于离线运行和测试
+)
+
+// DefaultEmbeddingDimension 未配置 embedding.dimension 时使用的向量维度
+const DefaultEmbeddingDimension = 1536
+
+// DefaultEmbeddingBatchSize 批量向量化时每次请求的最大条数
+const DefaultEmbeddingBatchSize = 16
+
+// EmbeddingProviderConfig 向量化配置（对应 chat.yaml 中的 embedding 段）
+type EmbeddingProviderConfig struct {
+	Type      string `json:"type"`
+	Model     string `json:"model"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"`
+	Dimension int    `json:"dimension"`
+	BatchSize int    `json:"batch_size"`
+}
+
+// GetDimension 获取向量维度
+func (c EmbeddingProviderConfig) GetDimension() int {
+	if c.Dimension <= 0 {
+		return DefaultEmbeddingDimension
+	}
+	return c.Dimension
+}
+
+// GetBatchSize 获取批量大小
+func (c EmbeddingProviderConfig) GetBatchSize() int {
+	if c.BatchSize <= 0 {
+		return DefaultEmbeddingBatchSize
+	}
+	return c.BatchSize
+}
+
+// EmbeddingConfig 记忆向量化使用的配置
+var EmbeddingConfig EmbeddingProviderConfig
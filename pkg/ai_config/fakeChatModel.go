@@ -0,0 +1,78 @@
+package ai_config
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// fakeStreamChunkRunes 流式输出时每个分片包含的字符数
+const fakeStreamChunkRunes = 4
+
+// FakeChatModel 确定性的脚本聊天模型：按顺序循环返回 script 中的回复，
+// script 为空时回显最后一条用户消息。不访问网络，用于离线运行和测试。
+type FakeChatModel struct {
+	mu     *sync.Mutex
+	next   *int
+	script []string
+	tools  []*schema.ToolInfo
+}
+
+var _ model.ToolCallingChatModel = (*FakeChatModel)(nil)
+
+func NewFakeChatModel(script ...string) *FakeChatModel {
+	return &FakeChatModel{
+		mu:     &sync.Mutex{},
+		next:   new(int),
+		script: script,
+	}
+}
+
+func (m *FakeChatModel) Generate(_ context.Context, input []*schema.Message, _ ...model.Option) (*schema.Message, error) {
+	return schema.AssistantMessage(m.reply(input), nil), nil
+}
+
+func (m *FakeChatModel) Stream(_ context.Context, input []*schema.Message, _ ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	runes := []rune(m.reply(input))
+	chunks := make([]*schema.Message, 0, len(runes)/fakeStreamChunkRunes+1)
+	for start := 0; start < len(runes); start += fakeStreamChunkRunes {
+		end := start + fakeStreamChunkRunes
+		if end > len(runes) {
+			end = len(runes)
+		}
+		chunks = append(chunks, schema.AssistantMessage(string(runes[start:end]), nil))
+	}
+	return schema.StreamReaderFromArray(chunks), nil
+}
+
+// WithTools 返回绑定了工具的副本，脚本进度与原模型共享
+func (m *FakeChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return &FakeChatModel{
+		mu:     m.mu,
+		next:   m.next,
+		script: m.script,
+		tools:  tools,
+	}, nil
+}
+
+func (m *FakeChatModel) GetType() string {
+	return "Fake"
+}
+
+func (m *FakeChatModel) reply(input []*schema.Message) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.script) == 0 {
+		for i := len(input) - 1; i >= 0; i-- {
+			if input[i].Role == schema.User {
+				return input[i].Content
+			}
+		}
+		return ""
+	}
+	reply := m.script[*m.next%len(m.script)]
+	*m.next++
+	return reply
+}
@@ -0,0 +1,102 @@
+package ai_config
+
+import "time"
+
+// 混合检索的默认参数
+const (
+	DefaultKeywordWeight       = 1.0
+	DefaultVectorWeight        = 1.0
+	DefaultRRFK                = 60 // 倒数排名融合的平滑常数
+	DefaultRetrievalCandidates = 20 // 每路召回的候选数量下限
+)
+
+// 综合排序的默认参数
+const (
+	DefaultRelevanceWeight     = 0.6
+	DefaultImportanceWeight    = 0.2
+	DefaultRecencyWeight       = 0.1
+	DefaultFrequencyWeight     = 0.1
+	DefaultRecencyHalfLife     = 7 * 24 * time.Hour // 距上次命中（或创建）经过该时长后新近度减半
+	DefaultFrequencySaturation = 20                 // 命中次数达到该值时频率得分为 1
+)
+
+// RerankProviderConfig 重排序接口配置（Cohere/Jina 兼容的 /v1/rerank 接口）
+type RerankProviderConfig struct {
+	Enabled bool   `json:"enabled"`
+	Model   string `json:"model"`
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+}
+
+// ScoringSettings 综合排序配置：综合得分 = 各项得分按权重加权平均，各项得分都在 0~1
+type ScoringSettings struct {
+	RelevanceWeight     float64       `json:"relevance_weight"`  // 相关度（重排序或融合得分）
+	ImportanceWeight    float64       `json:"importance_weight"` // 重要度（提取时评估的 1~10）
+	RecencyWeight       float64       `json:"recency_weight"`    // 新近度（按半衰期指数衰减）
+	FrequencyWeight     float64       `json:"frequency_weight"`  // 命中频率（对数增长）
+	RecencyHalfLife     time.Duration `json:"recency_half_life"`
+	FrequencySaturation int           `json:"frequency_saturation"`
+}
+
+// GetRecencyHalfLife 获取新近度半衰期
+func (c ScoringSettings) GetRecencyHalfLife() time.Duration {
+	if c.RecencyHalfLife <= 0 {
+		return DefaultRecencyHalfLife
+	}
+	return c.RecencyHalfLife
+}
+
+// GetFrequencySaturation 获取频率得分饱和的命中次数
+func (c ScoringSettings) GetFrequencySaturation() int {
+	if c.FrequencySaturation <= 0 {
+		return DefaultFrequencySaturation
+	}
+	return c.FrequencySaturation
+}
+
+// RetrievalSettings 记忆检索配置（对应 chat.yaml 中的 retrieval 段），可被单次请求覆盖
+type RetrievalSettings struct {
+	KeywordWeight float64              `json:"keyword_weight"` // 关键词（BM25）排名的融合权重
+	VectorWeight  float64              `json:"vector_weight"`  // 向量相似度排名的融合权重
+	RRFK          int                  `json:"rrf_k"`
+	MinScore      float64              `json:"min_score"`  // 相关度低于该值的记忆不返回，范围 0~1
+	Candidates    int                  `json:"candidates"` // 每路召回的候选数量，不足 topK 的 4 倍时按 4 倍召回
+	Rerank        RerankProviderConfig `json:"rerank"`
+	Scoring       ScoringSettings      `json:"scoring"`
+}
+
+// GetRRFK 获取倒数排名融合常数
+func (c RetrievalSettings) GetRRFK() int {
+	if c.RRFK <= 0 {
+		return DefaultRRFK
+	}
+	return c.RRFK
+}
+
+// GetCandidates 获取每路召回的候选数量
+func (c RetrievalSettings) GetCandidates(topK int) int {
+	candidates := c.Candidates
+	if candidates <= 0 {
+		candidates = DefaultRetrievalCandidates
+	}
+	if candidates < topK*4 {
+		candidates = topK * 4
+	}
+	return candidates
+}
+
+// RetrievalConfig 记忆检索使用的配置
+var RetrievalConfig = RetrievalSettings{
+	KeywordWeight: DefaultKeywordWeight,
+	VectorWeight:  DefaultVectorWeight,
+	RRFK:          DefaultRRFK,
+	Candidates:    DefaultRetrievalCandidates,
+	Scoring: ScoringSettings{
+		RelevanceWeight:     DefaultRelevanceWeight,
+		ImportanceWeight:    DefaultImportanceWeight,
+		RecencyWeight:       DefaultRecencyWeight,
+		FrequencyWeight:     DefaultFrequencyWeight,
+		RecencyHalfLife:     DefaultRecencyHalfLife,
+		FrequencySaturation: DefaultFrequencySaturation,
+	},
+}
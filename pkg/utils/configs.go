@@ -5,6 +5,8 @@ import (
 	"errors"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -36,10 +38,30 @@ type MilvusConfig struct {
 	MetricType string
 }
 
+// MemoryConfig 记忆提取相关配置（chat.yaml 中的 memory 段）
+type MemoryConfig struct {
+	IdleExtractAfter time.Duration // 会话空闲多久后提取未满阈值的轮次，0 表示关闭
+	IdleScanInterval time.Duration // 空闲会话的扫描间隔
+	VectorStore      string        // 向量存储后端：milvus/local
+	VectorStorePath  string        // local 后端的落盘文件，为空时只保存在内存中
+
+	ConsolidateInterval  time.Duration // 定期整理（合并相近记忆）的间隔，0 表示关闭
+	ConsolidateThreshold float64       // 整理时两条记忆可被合并的最低向量相似度
+
+	ReflectionThreshold int // 上次反思之后新增多少条记忆时自动反思，0 表示关闭
+}
+
+// AdminConfig 管理接口配置（chat.yaml 中的 admin 段）
+type AdminConfig struct {
+	Token string // 管理接口令牌，为空时不注册管理接口
+}
+
 type Config struct {
 	Mysql  MysqlConfig
 	Redis  RedisConfig
 	Milvus MilvusConfig
+	Memory MemoryConfig
+	Admin  AdminConfig
 }
 
 func (c *Config) GetMysqlConfig() MysqlConfig {
@@ -61,6 +83,20 @@ func (c *Config) SetMilvusConfig(milvusConfig MilvusConfig) {
 	c.Milvus = milvusConfig
 }
 
+func (c *Config) GetMemoryConfig() MemoryConfig {
+	return c.Memory
+}
+func (c *Config) SetMemoryConfig(memoryConfig MemoryConfig) {
+	c.Memory = memoryConfig
+}
+
+func (c *Config) GetAdminConfig() AdminConfig {
+	return c.Admin
+}
+func (c *Config) SetAdminConfig(adminConfig AdminConfig) {
+	c.Admin = adminConfig
+}
+
 // 全局变量声明
 var config_names []string = []string{
 	"mysql",
@@ -88,6 +124,10 @@ func InitConfig() error {
 		viper.SetConfigName(config_name)
 		viper.SetConfigType("yaml")
 		err := viper.ReadInConfig()
+		if err != nil && config_name == "milvus" && Config_Instance.Memory.VectorStore != "milvus" {
+			// 不使用 Milvus 时可以没有 milvus 配置
+			continue
+		}
 		if err != nil {
 			return errors.New("必要配置文件错误:" + err.Error() + ",请检查配置文件是否正确\n")
 		}
@@ -124,6 +164,7 @@ func InitConfig() error {
 		case "chat":
 			viper.SetDefault("DeepSeek.base_url", "https://api.deepseek.com")
 			viper.SetDefault("DeepSeek.model", "deepseek-chat")
+			viper.SetDefault("DeepSeek.context_window", 65536)
 			viper.SetDefault("DeepSeek.embedding_base_url", viper.GetString("DeepSeek.base_url"))
 			viper.SetDefault("DeepSeek.embedding_model", "deepseek-embedding")
 			viper.SetDefault("DeepSeek.embedding_api_key", viper.GetString("DeepSeek.api_key"))
@@ -137,6 +178,40 @@ func InitConfig() error {
 				BaseURL: viper.GetString("DeepSeek.embedding_base_url"),
 				APIKey:  viper.GetString("DeepSeek.embedding_api_key"),
 			}
+			if err := loadChatProviders(); err != nil {
+				return err
+			}
+			if err := loadEmbeddingConfig(); err != nil {
+				return err
+			}
+			if err := loadRetrievalConfig(); err != nil {
+				return err
+			}
+			viper.SetDefault("memory.idle_extract_after", "30m")
+			viper.SetDefault("memory.idle_scan_interval", "1m")
+			viper.SetDefault("memory.vector_store", "milvus")
+			viper.SetDefault("memory.consolidate_interval", "0")
+			viper.SetDefault("memory.consolidate_threshold", 0.85)
+			viper.SetDefault("memory.reflection_threshold", 20)
+			memoryConfig := MemoryConfig{
+				IdleExtractAfter: viper.GetDuration("memory.idle_extract_after"),
+				IdleScanInterval: viper.GetDuration("memory.idle_scan_interval"),
+				VectorStore:      strings.ToLower(viper.GetString("memory.vector_store")),
+				VectorStorePath:  viper.GetString("memory.vector_store_path"),
+
+				ConsolidateInterval:  viper.GetDuration("memory.consolidate_interval"),
+				ConsolidateThreshold: viper.GetFloat64("memory.consolidate_threshold"),
+
+				ReflectionThreshold: viper.GetInt("memory.reflection_threshold"),
+			}
+			if memoryConfig.VectorStore != "milvus" && memoryConfig.VectorStore != "local" {
+				return errors.New("chat配置文件错误: memory.vector_store 只支持 milvus 或 local\n")
+			}
+			if memoryConfig.ConsolidateThreshold <= 0 || memoryConfig.ConsolidateThreshold > 1 {
+				return errors.New("chat配置文件错误: memory.consolidate_threshold 必须在 (0, 1] 之间\n")
+			}
+			Config_Instance.SetMemoryConfig(memoryConfig)
+			Config_Instance.SetAdminConfig(AdminConfig{Token: viper.GetString("admin.token")})
 		case "milvus":
 			viper.SetDefault("milvus.collection", "memories")
 			viper.SetDefault("milvus.metric_type", "COSINE")
@@ -157,3 +232,124 @@ func InitConfig() error {
 	}
 	return nil
 }
+
+// loadEmbeddingConfig 读取 embedding 段，未配置时沿用 DeepSeek 段中的 embedding_* 配置（OpenAI 兼容接口）
+func loadEmbeddingConfig() error {
+	viper.SetDefault("embedding.type", ai_config.EmbeddingOpenAI)
+	viper.SetDefault("embedding.model", ai_config.DeepSeekEmbeddingConfig.Model)
+	viper.SetDefault("embedding.base_url", ai_config.DeepSeekEmbeddingConfig.BaseURL)
+	viper.SetDefault("embedding.api_key", ai_config.DeepSeekEmbeddingConfig.APIKey)
+	viper.SetDefault("embedding.dimension", ai_config.DefaultEmbeddingDimension)
+	viper.SetDefault("embedding.batch_size", ai_config.DefaultEmbeddingBatchSize)
+	ai_config.EmbeddingConfig = ai_config.EmbeddingProviderConfig{
+		Type:      strings.ToLower(viper.GetString("embedding.type")),
+		Model:     viper.GetString("embedding.model"),
+		BaseURL:   viper.GetString("embedding.base_url"),
+		APIKey:    viper.GetString("embedding.api_key"),
+		Dimension: viper.GetInt("embedding.dimension"),
+		BatchSize: viper.GetInt("embedding.batch_size"),
+	}
+	switch ai_config.EmbeddingConfig.Type {
+	case ai_config.EmbeddingOpenAI, ai_config.EmbeddingOllama, ai_config.EmbeddingHash:
+	default:
+		return errors.New("chat配置文件错误: embedding.type 只支持 openai、ollama 或 hash\n")
+	}
+	if ai_config.EmbeddingConfig.Dimension <= 0 {
+		return errors.New("chat配置文件错误: embedding.dimension 必须大于 0\n")
+	}
+	return nil
+}
+
+// loadRetrievalConfig 读取 retrieval 段（混合检索权重、阈值、重排序接口和综合排序权重）
+func loadRetrievalConfig() error {
+	viper.SetDefault("retrieval.keyword_weight", ai_config.DefaultKeywordWeight)
+	viper.SetDefault("retrieval.vector_weight", ai_config.DefaultVectorWeight)
+	viper.SetDefault("retrieval.rrf_k", ai_config.DefaultRRFK)
+	viper.SetDefault("retrieval.candidates", ai_config.DefaultRetrievalCandidates)
+	viper.SetDefault("retrieval.scoring.relevance_weight", ai_config.DefaultRelevanceWeight)
+	viper.SetDefault("retrieval.scoring.importance_weight", ai_config.DefaultImportanceWeight)
+	viper.SetDefault("retrieval.scoring.recency_weight", ai_config.DefaultRecencyWeight)
+	viper.SetDefault("retrieval.scoring.frequency_weight", ai_config.DefaultFrequencyWeight)
+	viper.SetDefault("retrieval.scoring.recency_half_life", ai_config.DefaultRecencyHalfLife)
+	viper.SetDefault("retrieval.scoring.frequency_saturation", ai_config.DefaultFrequencySaturation)
+	ai_config.RetrievalConfig = ai_config.RetrievalSettings{
+		KeywordWeight: viper.GetFloat64("retrieval.keyword_weight"),
+		VectorWeight:  viper.GetFloat64("retrieval.vector_weight"),
+		RRFK:          viper.GetInt("retrieval.rrf_k"),
+		MinScore:      viper.GetFloat64("retrieval.min_score"),
+		Candidates:    viper.GetInt("retrieval.candidates"),
+		Rerank: ai_config.RerankProviderConfig{
+			Enabled: viper.GetBool("retrieval.rerank.enabled"),
+			Model:   viper.GetString("retrieval.rerank.model"),
+			BaseURL: viper.GetString("retrieval.rerank.base_url"),
+			APIKey:  viper.GetString("retrieval.rerank.api_key"),
+		},
+		Scoring: ai_config.ScoringSettings{
+			RelevanceWeight:     viper.GetFloat64("retrieval.scoring.relevance_weight"),
+			ImportanceWeight:    viper.GetFloat64("retrieval.scoring.importance_weight"),
+			RecencyWeight:       viper.GetFloat64("retrieval.scoring.recency_weight"),
+			FrequencyWeight:     viper.GetFloat64("retrieval.scoring.frequency_weight"),
+			RecencyHalfLife:     viper.GetDuration("retrieval.scoring.recency_half_life"),
+			FrequencySaturation: viper.GetInt("retrieval.scoring.frequency_saturation"),
+		},
+	}
+	config := ai_config.RetrievalConfig
+	if config.KeywordWeight < 0 || config.VectorWeight < 0 || config.KeywordWeight+config.VectorWeight == 0 {
+		return errors.New("chat配置文件错误: retrieval.keyword_weight 和 retrieval.vector_weight 不能为负且不能同时为 0\n")
+	}
+	if config.MinScore < 0 || config.MinScore > 1 {
+		return errors.New("chat配置文件错误: retrieval.min_score 取值范围为 0~1\n")
+	}
+	scoring := config.Scoring
+	if scoring.RelevanceWeight < 0 || scoring.ImportanceWeight < 0 || scoring.RecencyWeight < 0 || scoring.FrequencyWeight < 0 ||
+		scoring.RelevanceWeight+scoring.ImportanceWeight+scoring.RecencyWeight+scoring.FrequencyWeight == 0 {
+		return errors.New("chat配置文件错误: retrieval.scoring 的权重不能为负且不能全为 0\n")
+	}
+	if config.Rerank.Enabled && config.Rerank.BaseURL == "" {
+		return errors.New("chat配置文件错误: 启用 retrieval.rerank 时 base_url 不能为空\n")
+	}
+	return nil
+}
+
+// loadChatProviders 读取 chat.providers 下的命名模型实例。
+// DeepSeek 段始终注册为名为 deepseek 的实例，chat.providers 中的同名配置会覆盖它。
+func loadChatProviders() error {
+	ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+		Name:    ai_config.ProviderDeepSeek,
+		Type:    ai_config.ProviderDeepSeek,
+		Model:   ai_config.DeepSeekChatConfig.Model,
+		BaseURL: ai_config.DeepSeekChatConfig.BaseURL,
+		APIKey:  ai_config.DeepSeekChatConfig.APIKey,
+
+		ContextWindow: viper.GetInt("DeepSeek.context_window"),
+	})
+	for name := range viper.GetStringMap("chat.providers") {
+		prefix := "chat.providers." + name + "."
+		providerType := viper.GetString(prefix + "type")
+		if providerType == "" {
+			providerType = name
+		}
+		ai_config.SetChatProvider(ai_config.ChatProviderConfig{
+			Name:    name,
+			Type:    providerType,
+			Model:   viper.GetString(prefix + "model"),
+			BaseURL: viper.GetString(prefix + "base_url"),
+			APIKey:  viper.GetString(prefix + "api_key"),
+			Script:  viper.GetStringSlice(prefix + "script"),
+
+			ContextWindow: viper.GetInt(prefix + "context_window"),
+		})
+	}
+	viper.SetDefault("chat.provider", ai_config.ProviderDeepSeek)
+	ai_config.DefaultChatProvider = viper.GetString("chat.provider")
+	ai_config.MemoryChatProvider = viper.GetString("chat.memory_provider")
+	if _, err := ai_config.GetChatProvider(ai_config.DefaultChatProvider); err != nil {
+		return errors.New("chat配置文件错误: chat.provider 指定的模型实例不存在\n")
+	}
+	if ai_config.MemoryChatProvider != "" {
+		if _, err := ai_config.GetChatProvider(ai_config.MemoryChatProvider); err != nil {
+			return errors.New("chat配置文件错误: chat.memory_provider 指定的模型实例不存在\n")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"sync"
+	"unicode"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// Tokenizer 文本 token 计数器
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+var (
+	tokenizerOnce sync.Once
+	tokenizer     Tokenizer
+)
+
+// GetTokenizer 获取全局 Tokenizer。
+// 默认使用内嵌词表的 cl100k_base（不需要联网），加载失败时退化为按字符估算。
+func GetTokenizer() Tokenizer {
+	tokenizerOnce.Do(func() {
+		tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+		encoding, err := tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE)
+		if err != nil {
+			tokenizer = estimateTokenizer{}
+			return
+		}
+		tokenizer = &tiktokenTokenizer{encoding: encoding}
+	})
+	return tokenizer
+}
+
+// CountTokens 使用全局 Tokenizer 计算 token 数
+func CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return GetTokenizer().CountTokens(text)
+}
+
+type tiktokenTokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+func (t *tiktokenTokenizer) CountTokens(text string) int {
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+// estimateTokenizer 估算：每个中日韩字符约 1 个 token，其余字符约 4 个折算 1 个 token
+type estimateTokenizer struct{}
+
+func (estimateTokenizer) CountTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}